@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/phil-mansfield/shellfish/cosmo"
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+	"github.com/phil-mansfield/shellfish/math/grav"
+)
+
+const (
+	// gravConst is G in units of Mpc (km/s)^2 / Msun.
+	gravConst = cosmo.GMks * cosmo.MSunMks / cosmo.MpcMks / 1e6
+	// unbindTheta is the tree opening angle used during unbinding.
+	unbindTheta = 0.5
+	// autoSofteningMult is the softening length used during unbinding as a
+	// multiple of R_200m when no softening is specified.
+	autoSofteningMult = 0.01
+)
+
+// haloParticles holds all the particles around a single halo. Particles are
+// collected across every block of a snapshot so that the halo can be unbound
+// as a whole once the snapshot has been read.
+type haloParticles struct {
+	// xs are positions relative to the halo center in cMpc/h and vs are
+	// peculiar velocities in km/s.
+	xs, vs [][3]float32
+	ms     []float32
+	ids    []int64
+}
+
+// appendParticles adds every particle within rMax of the center of s to h.
+func (h *haloParticles) appendParticles(
+	hd *io.Header, s geom.Sphere, rMax float64,
+	xs, vs [][3]float32, ms []float32, ids []int64,
+) {
+	tw2 := float32(hd.TotalWidth) / 2
+	rMax2 := float32(rMax * rMax)
+
+	for i := range xs {
+		dx := wrap(xs[i][0] - s.C[0], tw2)
+		dy := wrap(xs[i][1] - s.C[1], tw2)
+		dz := wrap(xs[i][2] - s.C[2], tw2)
+		if dx*dx + dy*dy + dz*dz >= rMax2 { continue }
+
+		h.xs = append(h.xs, [3]float32{dx, dy, dz})
+		h.vs = append(h.vs, vs[i])
+		h.ms = append(h.ms, ms[i])
+		h.ids = append(h.ids, ids[i])
+	}
+}
+
+// gravUnits returns G in units where positions are in cMpc/h, masses are in
+// Msun/h and potentials are in physical (km/s)^2. Since masses and lengths
+// both carry a factor of 1/h, the physical potential is (G/a) * m/x.
+func gravUnits(hd *io.Header) float64 {
+	return gravConst * (1 + hd.Cosmo.Z)
+}
+
+// physicalVelocities returns the velocities of the particles in h with the
+// Hubble flow, 100 E(z) a x km/s in these units, added in.
+func (h *haloParticles) physicalVelocities(hd *io.Header) [][3]float32 {
+	z := hd.Cosmo.Z
+	hFlow := float32(100 * cosmo.HubbleFrac(hd.Cosmo.OmegaM,
+		hd.Cosmo.OmegaL, z) / (1 + z))
+
+	vs := make([][3]float32, len(h.vs))
+	for i := range vs {
+		for k := 0; k < 3; k++ {
+			vs[i][k] = h.vs[i][k] + hFlow*h.xs[i][k]
+		}
+	}
+	return vs
+}
+
+// unbind returns a slice indicating which of the particles in h are
+// gravitationally bound to the halo. r200m is used to choose the region
+// that the halo's bulk velocity is measured in and, if eps <= 0, the
+// softening length.
+func (h *haloParticles) unbind(
+	hd *io.Header, r200m, eps float64, iters int64,
+) []bool {
+	if len(h.xs) == 0 { return nil }
+	if eps <= 0 { eps = autoSofteningMult * r200m }
+
+	return grav.Unbind(
+		h.xs, h.physicalVelocities(hd), h.ms, gravUnits(hd),
+		eps, unbindTheta, r200m, int(iters),
+	)
+}
+
+// energies computes the potential and the binding energy of every particle in
+// h in physical (km/s)^2. Only the particles for which source is true
+// contribute to the potential and their masses are multiplied by
+// massMult. Kinetic energies are measured relative to the bulk velocity of
+// the particles within r200m. The tree used to compute potentials is also
+// returned.
+func (h *haloParticles) energies(
+	hd *io.Header, r200m, eps, theta float64, source []bool, massMult float32,
+) (phis, es []float64, tree *grav.Tree) {
+	if eps <= 0 { eps = autoSofteningMult * r200m }
+	g := gravUnits(hd)
+
+	ms := make([]float32, len(h.ms))
+	for i := range ms {
+		if source[i] { ms[i] = h.ms[i] * massMult }
+	}
+
+	tree = grav.NewTree(h.xs, ms, eps, theta)
+	phis = make([]float64, len(h.xs))
+	tree.Potentials(phis)
+	for i := range phis {
+		// Particles which aren't in the tree don't need self-exclusion.
+		if ms[i] == 0 { phis[i] = tree.Potential(h.xs[i]) }
+		phis[i] *= g
+	}
+
+	vs := h.physicalVelocities(hd)
+	vBulk := grav.BulkVelocity(h.xs, vs, h.ms, float32(r200m*r200m))
+	es = make([]float64, len(h.xs))
+	for i := range es {
+		dvx := float64(vs[i][0]) - vBulk[0]
+		dvy := float64(vs[i][1]) - vBulk[1]
+		dvz := float64(vs[i][2]) - vBulk[2]
+		es[i] = (dvx*dvx + dvy*dvy + dvz*dvz)/2 + phis[i]
+	}
+
+	return phis, es, tree
+}
+
+// boundFromEnergies returns a slice indicating which particles in h have
+// negative binding energies according to es, a map from particle IDs to
+// energies. Particles which aren't in es are considered unbound.
+func (h *haloParticles) boundFromEnergies(es map[int64]float32) []bool {
+	bound := make([]bool, len(h.ids))
+	for i, id := range h.ids {
+		e, ok := es[id]
+		bound[i] = ok && e < 0
+	}
+	return bound
+}
+
+// boundMass returns the total mass of the bound particles in h which lie
+// within r of the halo center.
+func (h *haloParticles) boundMass(bound []bool, r float64) float64 {
+	r2 := float32(r * r)
+	sum := 0.0
+	for i, x := range h.xs {
+		if !bound[i] { continue }
+		if x[0]*x[0] + x[1]*x[1] + x[2]*x[2] < r2 {
+			sum += float64(h.ms[i])
+		}
+	}
+	return sum
+}
+
+// radius returns the distance of the ith particle in h from the halo center.
+func (h *haloParticles) radius(i int) float64 {
+	x := h.xs[i]
+	return math.Sqrt(float64(x[0]*x[0] + x[1]*x[1] + x[2]*x[2]))
+}
+
+type haloKey struct {
+	id, snap int64
+}
+
+// readPotentialEnergies reads a file written by potential mode and returns
+// maps from particle IDs to binding energies for each halo.
+func readPotentialEnergies(fname string) (map[haloKey]map[int64]float32, error) {
+	f, err := os.Open(fname)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	data, err := io.ReadPotential(f)
+	if err != nil { return nil, err }
+
+	out := make(map[haloKey]map[int64]float32, len(data.IDs))
+	for i := range data.IDs {
+		es := make(map[int64]float32, len(data.Particles[i]))
+		for j, id := range data.Particles[i] {
+			es[id] = data.Energies[i][j]
+		}
+		out[haloKey{data.IDs[i], data.Snaps[i]}] = es
+	}
+
+	return out, nil
+}
+
+// lookupEnergies returns the energy map for the given halo or an error if the
+// halo isn't in the potential file.
+func lookupEnergies(
+	energies map[haloKey]map[int64]float32, id, snap int, fname string,
+) (map[int64]float32, error) {
+	es, ok := energies[haloKey{int64(id), int64(snap)}]
+	if !ok {
+		return nil, fmt.Errorf("Halo %d in snapshot %d is not in the " +
+			"potential file '%s'.", id, snap, fname)
+	}
+	return es, nil
+}
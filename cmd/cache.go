@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/phil-mansfield/shellfish/cmd/env"
+	"github.com/phil-mansfield/shellfish/cmd/halo"
+	"github.com/phil-mansfield/shellfish/cmd/memo"
+	"github.com/phil-mansfield/shellfish/logging"
+	"github.com/phil-mansfield/shellfish/parse"
+)
+
+type CacheConfig struct {
+	action           string
+	entries          []string
+	snapMin, snapMax int64
+	onlyInvalid      bool
+
+	types [4]bool
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
+}
+
+var _ Mode = &CacheConfig{}
+
+func (config *CacheConfig) ExampleConfig() string {
+	return `[cache.config]
+
+#####################
+## Optional Fields ##
+#####################
+
+# Action is what the cache tool does to MemoDir. It can be set to:
+#
+# list   - Print every file cached in MemoDir.
+# verify - Print every file cached in MemoDir along with whether it's still
+#          valid for the current global config file and snapshot and halo
+#          files. Invalid files are rebuilt automatically the next time
+#          they're needed, so this is just informational.
+# prune  - Delete cached files. Temporary files left behind by killed jobs are
+#          always deleted, so don't prune while other jobs are using MemoDir.
+# build  - Build the cached files for a range of snapshots ahead of time, so
+#          that the first job that needs them doesn't have to. Snapshots are
+#          built in parallel using the number of threads set by Threads in the
+#          global config file. Each thread holds one block of particles in
+#          memory while building spatial indexes.
+#
+# Action = list
+
+# Entries is a list of the types of cached files to act on. It can contain
+# any of:
+#
+# headers        - The headers of each snapshot's particle files.
+# rockstar       - Binary copies of each snapshot's halo catalog.
+# rockstar-short - Binary copies of the largest halos in each snapshot's halo
+#                  catalog. These are always built along with rockstar.
+# index          - Spatial indexes of each particle file. These are only built
+#                  if SpatialIndexCells is set in the global config file, and
+#                  they're invalid if SpatialIndexCells has changed since they
+#                  were built.
+#
+# Entries = headers, rockstar, rockstar-short, index
+
+# SnapMin and SnapMax are the range of snapshots to act on. By default, list,
+# verify, and prune act on every snapshot and build acts on the snapshots
+# between the global config file's SnapMin and SnapMax.
+#
+# SnapMin = 0
+# SnapMax = 100
+
+# OnlyInvalid makes prune only delete files which aren't valid for the current
+# global config file and snapshot and halo files.
+#
+# OnlyInvalid = false`
+}
+
+func (config *CacheConfig) ReadConfig(fname string, flags []string) error {
+	vars := parse.NewConfigVars("cache.config")
+	config.vars = vars
+	vars.String(&config.action, "Action", "list").
+		Enum("list", "verify", "prune", "build")
+	vars.Strings(&config.entries, "Entries", memo.EntryTypeNames).
+		Enum(memo.EntryTypeNames...)
+	vars.Int(&config.snapMin, "SnapMin", -1)
+	vars.Int(&config.snapMax, "SnapMax", -1)
+	vars.Bool(&config.onlyInvalid, "OnlyInvalid", false)
+
+	if fname == "" {
+		if len(flags) == 0 {
+			return config.validate()
+		}
+		err := parse.ReadFlags(flags, vars)
+		if err != nil {
+			return err
+		}
+
+		return config.validate()
+	}
+	if err := parse.ReadConfig(fname, vars); err != nil {
+		return err
+	}
+	if err := parse.ReadFlags(flags, vars); err != nil {
+		return err
+	}
+
+	return config.validate()
+}
+
+func (config *CacheConfig) validate() error {
+	if err := config.vars.Validate(); err != nil { return err }
+
+	config.types = [4]bool{}
+	for _, name := range config.entries {
+		for typ := range memo.EntryTypeNames {
+			if memo.EntryTypeNames[typ] == name { config.types[typ] = true }
+		}
+	}
+
+	if config.snapMin != -1 && config.snapMax != -1 &&
+		config.snapMin > config.snapMax {
+		return fmt.Errorf("'SnapMin' is larger than 'SnapMax'.")
+	}
+
+	return nil
+}
+
+func (config *CacheConfig) Run(
+	gConfig *GlobalConfig, e *env.Environment, stdin []byte,
+) ([]string, error) {
+	if logging.Mode != logging.Nil {
+		log.Println(`
+#####################
+## shellfish cache ##
+#####################`,
+		)
+	}
+
+	switch config.action {
+	case "list":
+		return config.list(gConfig, e, false)
+	case "verify":
+		return config.list(gConfig, e, true)
+	case "prune":
+		return config.prune(gConfig, e)
+	case "build":
+		if err := config.build(gConfig, e); err != nil { return nil, err }
+		return config.list(gConfig, e, true)
+	}
+
+	panic("Impossible")
+}
+
+// selectedEntries returns the entries in MemoDir with the requested types
+// and snapshots.
+func (config *CacheConfig) selectedEntries(
+	e *env.Environment,
+) ([]memo.Entry, error) {
+	entries, err := memo.ListEntries(e)
+	if err != nil { return nil, err }
+
+	out := []memo.Entry{}
+	for _, entry := range entries {
+		if !config.types[entry.Type] ||
+			(config.snapMin != -1 && entry.Snap < int(config.snapMin)) ||
+			(config.snapMax != -1 && entry.Snap > int(config.snapMax)) {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// list returns a table of the selected entries. If check is true, the table
+// also says whether each entry is valid.
+func (config *CacheConfig) list(
+	gConfig *GlobalConfig, e *env.Environment, check bool,
+) ([]string, error) {
+	entries, err := config.selectedEntries(e)
+	if err != nil { return nil, err }
+
+	lines := []string{}
+	if check {
+		lines = append(lines,
+			"# Column 0 - Type\n# Column 1 - Snap\n# Column 2 - Block\n"+
+				"# Column 3 - Size (bytes)\n# Column 4 - Status\n"+
+				"# Column 5 - File",
+		)
+	} else {
+		lines = append(lines,
+			"# Column 0 - Type\n# Column 1 - Snap\n# Column 2 - Block\n"+
+				"# Column 3 - Size (bytes)\n# Column 4 - File",
+		)
+	}
+
+	for _, entry := range entries {
+		line := fmt.Sprintf("%-14s %5d %5d %12d", entry.Type, entry.Snap,
+			entry.Block, entry.Size)
+		if check {
+			ok, err := entry.Check(e, int(gConfig.SpatialIndexCells))
+			if err != nil { return nil, err }
+			status := "valid"
+			if !ok { status = "invalid" }
+			line = fmt.Sprintf("%s %-7s", line, status)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", line, entry.File))
+	}
+
+	temps, err := memo.TempFiles(e)
+	if err != nil { return nil, err }
+	for _, file := range temps {
+		lines = append(lines, fmt.Sprintf("# Leftover temporary file: %s",
+			file))
+	}
+
+	return lines, nil
+}
+
+// prune deletes the selected entries and any leftover temporary files and
+// returns the names of the deleted files.
+func (config *CacheConfig) prune(
+	gConfig *GlobalConfig, e *env.Environment,
+) ([]string, error) {
+	entries, err := config.selectedEntries(e)
+	if err != nil { return nil, err }
+
+	lines := []string{}
+	for _, entry := range entries {
+		if config.onlyInvalid {
+			ok, err := entry.Check(e, int(gConfig.SpatialIndexCells))
+			if err != nil { return nil, err }
+			if ok { continue }
+		}
+
+		if err = entry.Remove(); err != nil { return nil, err }
+		lines = append(lines, fmt.Sprintf("Deleted %s", entry.File))
+	}
+
+	temps, err := memo.TempFiles(e)
+	if err != nil { return nil, err }
+	for _, file := range temps {
+		if err = os.Remove(file); err != nil { return nil, err }
+		lines = append(lines, fmt.Sprintf("Deleted %s", file))
+	}
+
+	return lines, nil
+}
+
+// build builds the selected entries for every snapshot in the requested
+// range.
+func (config *CacheConfig) build(
+	gConfig *GlobalConfig, e *env.Environment,
+) error {
+	snapMin, snapMax := int(gConfig.SnapMin), int(gConfig.SnapMax)
+	if config.snapMin != -1 { snapMin = int(config.snapMin) }
+	if config.snapMax != -1 { snapMax = int(config.snapMax) }
+
+	for snap := snapMin; snap <= snapMax; snap++ {
+		if !e.HasParticleCatalog(snap) {
+			return fmt.Errorf("Snapshot %d is outside the range of "+
+				"snapshots given in the global config file.", snap)
+		}
+	}
+
+	workers := runtime.NumCPU()
+	if gConfig.Threads > 0 { workers = int(gConfig.Threads) }
+	runtime.GOMAXPROCS(workers)
+
+	snaps := make(chan int, snapMax - snapMin + 1)
+	for snap := snapMin; snap <= snapMax; snap++ { snaps <- snap }
+	close(snaps)
+
+	errs := make([]error, workers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for snap := range snaps {
+				errs[i] = config.buildSnap(snap, gConfig, e)
+				if errs[i] != nil {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// buildSnap builds the selected entries for a single snapshot.
+func (config *CacheConfig) buildSnap(
+	snap int, gConfig *GlobalConfig, e *env.Environment,
+) error {
+	if logging.Mode == logging.Debug {
+		log.Printf("Building cached files for snapshot %d", snap)
+	}
+
+	buf, err := getVectorBuffer(e.ParticleCatalogs(snap), gConfig)
+	if err != nil { return err }
+
+	// Everything else needs the headers, so they're always built.
+	hds, _, err := memo.ReadHeaders(snap, buf, e)
+	if err != nil { return err }
+
+	if (config.types[memo.RockstarEntry] ||
+		config.types[memo.RockstarShortEntry]) && e.HasHaloCatalog(snap) {
+		vars := halo.NewVarColumns(
+			gConfig.HaloValueNames, gConfig.HaloValueColumns,
+			gConfig.HaloRadiusUnits,
+		)
+		if err = memo.BuildRockstar(snap, vars, buf, e); err != nil {
+			return err
+		}
+	}
+
+	if config.types[memo.IndexEntry] && gConfig.SpatialIndexCells > 0 &&
+		gConfig.SnapshotType != "nil" {
+		for block := range hds {
+			_, err = memo.BuildIndex(
+				snap, block, int(gConfig.SpatialIndexCells), buf, e,
+				&hds[block],
+			)
+			if err != nil { return err }
+		}
+	}
+
+	return nil
+}
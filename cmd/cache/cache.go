@@ -0,0 +1,223 @@
+/*package cache stores intermediate results in MemoDir.
+
+Every cache file starts with a header containing a format version, a key
+identifying the inputs that the result was computed from, and a checksum of
+its contents. Files whose header doesn't match the current inputs, or whose
+contents are truncated or corrupted, are treated as missing and are rebuilt.
+Files are written to a temporary file which is renamed into place, so a job
+which is killed partway through a write can't leave a partial file behind.*/
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Version is the version of the cache file format. Increment it whenever
+// the format of any cache file changes.
+const Version = 2
+
+var magic = [8]byte{'S', 'H', 'F', 'C', 'A', 'C', 'H', 'E'}
+
+// header is the header at the start of every cache file. Cache files are
+// always little endian.
+type header struct {
+	Magic    [8]byte
+	Version  uint32
+	Checksum uint32
+	Key      [sha256.Size]byte
+	Size     uint64
+}
+
+var headerSize = binary.Size(header{})
+
+// Key identifies the inputs that a cache entry was computed from.
+type Key struct {
+	h hash.Hash
+}
+
+// NewKey returns a Key identifying the given values.
+func NewKey(values ...interface{}) *Key {
+	k := &Key{ sha256.New() }
+	return k.Add(values...)
+}
+
+// Add adds values to the key.
+func (k *Key) Add(values ...interface{}) *Key {
+	for _, v := range values { fmt.Fprintf(k.h, "%#v\n", v) }
+	return k
+}
+
+// AddFiles adds the names, sizes, and modification times of files to the
+// key, so that the cache entry will be rebuilt if any of them change.
+func (k *Key) AddFiles(fnames ...string) error {
+	for _, fname := range fnames {
+		info, err := os.Stat(fname)
+		if err != nil { return err }
+		k.Add(fname, info.Size(), info.ModTime().UnixNano())
+	}
+	return nil
+}
+
+func (k *Key) sum() [sha256.Size]byte {
+	var out [sha256.Size]byte
+	copy(out[:], k.h.Sum(nil))
+	return out
+}
+
+// Read returns the contents of a cache file. ok is false if the file
+// doesn't exist or isn't valid for the given key.
+func Read(fname string, key *Key) (data []byte, ok bool, err error) {
+	raw, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if len(raw) < headerSize { return nil, false, nil }
+	hd := header{}
+	err = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &hd)
+	if err != nil { return nil, false, err }
+
+	data = raw[headerSize:]
+	if hd.Magic != magic || hd.Version != Version || hd.Key != key.sum() ||
+		hd.Size != uint64(len(data)) ||
+		hd.Checksum != crc32.ChecksumIEEE(data) {
+		return nil, false, nil
+	}
+
+	return data, true, nil
+}
+
+// Write atomically writes data to a cache file.
+func Write(fname string, key *Key, data []byte) error {
+	hd := header{
+		Magic: magic, Version: Version, Key: key.sum(),
+		Size: uint64(len(data)), Checksum: crc32.ChecksumIEEE(data),
+	}
+
+	return writeAtomic(fname, func(f *os.File) error {
+		if err := binary.Write(f, binary.LittleEndian, hd); err != nil {
+			return err
+		}
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// Load returns the contents of a cache file. If the file doesn't exist or
+// isn't valid for the given key, build is called to compute the contents and
+// the result is written to the file.
+func Load(
+	fname string, key *Key, build func() ([]byte, error),
+) ([]byte, error) {
+	data, ok, err := Read(fname, key)
+	if err != nil { return nil, err }
+	if ok { return data, nil }
+
+	if data, err = build(); err != nil { return nil, err }
+	if err = Write(fname, key, data); err != nil { return nil, err }
+	return data, nil
+}
+
+// WriteFile atomically creates a large cache file which is read without the
+// cache package, e.g. one which is only read a piece at a time. write
+// creates the file at the path it's given. A small stamp file is written
+// next to it so that CheckFile can tell whether it's valid. The contents of
+// these files aren't checksummed, but truncated files are detected.
+func WriteFile(fname string, key *Key, write func(fname string) error) error {
+	tmp, err := tempName(fname)
+	if err != nil { return err }
+	if err = write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The stamp is removed first and written last so that a job which is
+	// killed partway through never leaves a valid stamp next to an invalid
+	// file.
+	stamp := fname + ".stamp"
+	if err = os.Remove(stamp); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, fname); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(info.Size()))
+	return Write(stamp, key, size)
+}
+
+// CheckFile returns true if a file written by WriteFile exists and is valid
+// for the given key.
+func CheckFile(fname string, key *Key) (bool, error) {
+	size, ok, err := Read(fname + ".stamp", key)
+	if err != nil || !ok || len(size) != 8 { return false, err }
+
+	info, err := os.Stat(fname)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return uint64(info.Size()) == binary.LittleEndian.Uint64(size), nil
+}
+
+// writeAtomic writes a file by calling write on a temporary file in the same
+// directory and then renaming it.
+func writeAtomic(fname string, write func(f *os.File) error) error {
+	tmp, err := tempName(fname)
+	if err != nil { return err }
+	f, err := os.OpenFile(tmp, os.O_WRONLY, 0)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = write(f)
+	if err == nil { err = f.Sync() }
+	if closeErr := f.Close(); err == nil { err = closeErr }
+	if err == nil { err = os.Rename(tmp, fname) }
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// tempName returns the name of a new, empty temporary file in the same
+// directory as fname.
+func tempName(fname string) (string, error) {
+	dir, base := filepath.Dir(fname), filepath.Base(fname)
+	f, err := ioutil.TempFile(dir, base + ".tmp")
+	if err != nil { return "", err }
+	name := f.Name()
+
+	// TempFile only lets the current user read the file.
+	err = f.Chmod(0644)
+	if closeErr := f.Close(); err == nil { err = closeErr }
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkNoTempFiles fails the test if any temporary files were left in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if err != nil { t.Fatal(err.Error()) }
+	if len(matches) > 0 { t.Errorf("Temporary files were left: %v", matches) }
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_cache")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "entry.dat")
+
+	builds := 0
+	contents := []byte("halo catalog")
+	build := func() ([]byte, error) {
+		builds++
+		return contents, nil
+	}
+
+	tests := []struct {
+		desc    string
+		key     *Key
+		corrupt func(raw []byte) []byte
+		build   bool
+	}{
+		{"Missing file", NewKey("a", 1), nil, true},
+		{"Valid file", NewKey("a", 1), nil, false},
+		{"Key mismatch", NewKey("a", 2), nil, true},
+		{"Truncated header", NewKey("a", 2),
+			func(raw []byte) []byte { return raw[:headerSize - 1] }, true},
+		{"Truncated contents", NewKey("a", 2),
+			func(raw []byte) []byte { return raw[:len(raw) - 1] }, true},
+		{"Checksum mismatch", NewKey("a", 2), func(raw []byte) []byte {
+			raw[len(raw) - 1]++
+			return raw
+		}, true},
+		{"Version mismatch", NewKey("a", 2), func(raw []byte) []byte {
+			binary.LittleEndian.PutUint32(raw[len(magic):], Version + 1)
+			return raw
+		}, true},
+		{"Rebuilt file", NewKey("a", 2), nil, false},
+	}
+
+	for i, test := range tests {
+		if test.corrupt != nil {
+			raw, err := ioutil.ReadFile(fname)
+			if err != nil { t.Fatal(err.Error()) }
+			err = ioutil.WriteFile(fname, test.corrupt(raw), 0644)
+			if err != nil { t.Fatal(err.Error()) }
+		}
+
+		prevBuilds := builds
+		data, err := Load(fname, test.key, build)
+		if err != nil {
+			t.Errorf("%d) %s: %s", i, test.desc, err.Error())
+			continue
+		}
+		if !bytes.Equal(data, contents) {
+			t.Errorf("%d) %s: Expected contents '%s', got '%s'.",
+				i, test.desc, contents, data)
+		}
+		if rebuilt := builds > prevBuilds; rebuilt != test.build {
+			t.Errorf("%d) %s: Expected rebuild = %v, got %v.",
+				i, test.desc, test.build, rebuilt)
+		}
+	}
+	checkNoTempFiles(t, dir)
+
+	// A failed build leaves the old file in place.
+	buildErr := errors.New("build failed")
+	_, err = Load(fname, NewKey("b"), func() ([]byte, error) {
+		return nil, buildErr
+	})
+	if err != buildErr { t.Errorf("Expected the build error, got %v.", err) }
+	if _, ok, _ := Read(fname, NewKey("a", 2)); !ok {
+		t.Errorf("A failed build replaced the old file.")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_cache")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "index.dat")
+	key := NewKey("index", 3)
+
+	if ok, err := CheckFile(fname, key); err != nil || ok {
+		t.Errorf("Expected a missing file to be invalid, got %v, %v.", ok, err)
+	}
+
+	err = WriteFile(fname, key, func(tmp string) error {
+		if tmp == fname { t.Errorf("The file wasn't written to a temp file.") }
+		return ioutil.WriteFile(tmp, []byte("0123456789"), 0644)
+	})
+	if err != nil { t.Fatal(err.Error()) }
+	checkNoTempFiles(t, dir)
+
+	if ok, err := CheckFile(fname, key); err != nil || !ok {
+		t.Errorf("Expected a valid file, got %v, %v.", ok, err)
+	}
+	if ok, _ := CheckFile(fname, NewKey("index", 4)); ok {
+		t.Errorf("Expected a key mismatch to invalidate the file.")
+	}
+
+	// A write which fails partway through leaves the old file untouched.
+	writeErr := errors.New("write failed")
+	err = WriteFile(fname, key, func(tmp string) error {
+		ioutil.WriteFile(tmp, []byte("01"), 0644)
+		return writeErr
+	})
+	if err != writeErr { t.Errorf("Expected the write error, got %v.", err) }
+	checkNoTempFiles(t, dir)
+	if ok, err := CheckFile(fname, key); err != nil || !ok {
+		t.Errorf("Expected the old file to still be valid, got %v, %v.",
+			ok, err)
+	}
+
+	if err = os.Truncate(fname, 5); err != nil { t.Fatal(err.Error()) }
+	if ok, err := CheckFile(fname, key); err != nil || ok {
+		t.Errorf("Expected a truncated file to be invalid, got %v, %v.",
+			ok, err)
+	}
+}
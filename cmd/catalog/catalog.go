@@ -3,12 +3,13 @@ package catalog
 import (
 	"fmt"
 	"os"
-	"io"
 	"io/ioutil"
 	"strconv"
 	"bytes"
 	"strings"
 	"runtime"
+
+	"github.com/phil-mansfield/shellfish/io"
 )
 
 func CommentString(
@@ -139,27 +140,25 @@ func formatFloatCol(col []float64) []string {
 	return out
 }
 
-// Parse parses the specified columns in a byte block.
+// Parse parses the specified columns in a byte block. The block can be
+// a text catalog, a Parquet catalog, or an HDF5 catalog.
 func Parse(data []byte, icolIdxs, fcolIdxs []int) (
 [][]int, [][]float64, error,
 ) {
+	if IsParquet(data) { return parseParquet(data, icolIdxs, fcolIdxs) }
+	if IsHDF5(data) { return parseHDF5(data, icolIdxs, fcolIdxs) }
 	lines, nComm := split(data, '\n', '#')
 	lines = uncomment(lines, '#', nComm)
 	lines = trim(lines, ' ')
 	return parse(lines, ' ', icolIdxs, fcolIdxs)
 }
 
+// ReadFile reads the specified columns from a catalog file. Compressed
+// files are decompressed transparently.
 func ReadFile(fname string, icolIdxs, fcolIdxs []int) (
 	[][]int, [][]float64, error,
 ) {
-	f, err := os.Open(fname)
-	defer f.Close()
-	if err != nil { return nil, nil, err }
-	info, err := f.Stat()
-	if err != nil { return nil, nil, err }
-	
-	data := make([]byte, int(info.Size()))
-	io.ReadAtLeast(f, data, len(data))
+	data, err := io.ReadFile(fname)
 	if err != nil { return nil, nil, err }
 
 	icols, fcols, err := Parse(data, icolIdxs, fcolIdxs)
@@ -370,3 +369,96 @@ func MemString() string {
 		ms.Alloc >> 20, ms.Sys >> 20, ms.TotalAlloc >> 20,
 	)
 }
+
+// columnNames returns the names and units of the n columns used to store a
+// catalog in a Parquet or HDF5 file.
+func columnNames(hd *Header, n int) (names, units []string) {
+	names, units = make([]string, n), make([]string, n)
+	for i := range names { names[i] = fmt.Sprintf("column_%d", i) }
+	if hd == nil { return names, units }
+
+	for _, col := range hd.Columns {
+		for i := 0; i < col.Width && col.Start + i < n; i++ {
+			if col.Width == 1 {
+				names[col.Start] = col.Name
+			} else {
+				names[col.Start + i] = fmt.Sprintf("%s_%d", col.Name, i)
+			}
+			units[col.Start + i] = col.Units
+		}
+	}
+	return names, units
+}
+
+// numRows returns the number of rows in a set of catalog columns.
+func numRows(intCols [][]int, floatCols [][]float64) int {
+	if len(intCols) > 0 {
+		return len(intCols[0])
+	} else if len(floatCols) > 0 {
+		return len(floatCols[0])
+	}
+	return 0
+}
+
+func toInt64s(xs []int) []int64 {
+	out := make([]int64, len(xs))
+	for i := range xs { out[i] = int64(xs[i]) }
+	return out
+}
+
+// columnFile is a decoded Parquet or HDF5 catalog.
+type columnFile struct {
+	header  *Header
+	rows    int
+	columns []fileColumn
+}
+
+// fileColumn is a decoded column. Integer columns are stored in ints and
+// floating point columns are stored in floats.
+type fileColumn struct {
+	isInt  bool
+	ints   []int
+	floats []float64
+}
+
+func (col *fileColumn) len() int {
+	if col.isInt { return len(col.ints) }
+	return len(col.floats)
+}
+
+// parse returns the specified columns of a decoded catalog.
+func (f *columnFile) parse(icolIdxs, fcolIdxs []int) (
+	[][]int, [][]float64, error,
+) {
+	for _, idxs := range [][]int{ icolIdxs, fcolIdxs } {
+		for _, i := range idxs {
+			if i >= len(f.columns) {
+				return nil, nil, fmt.Errorf("Data has %d columns, but "+
+					"column %d was requested.", len(f.columns), i)
+			}
+		}
+	}
+
+	icols := make([][]int, len(icolIdxs))
+	for j, i := range icolIdxs {
+		if !f.columns[i].isInt {
+			return nil, nil, fmt.Errorf("Column %d contains floats, but "+
+				"integers were expected.", i)
+		}
+		icols[j] = f.columns[i].ints
+	}
+
+	fcols := make([][]float64, len(fcolIdxs))
+	for j, i := range fcolIdxs {
+		col := &f.columns[i]
+		if !col.isInt {
+			fcols[j] = col.floats
+			continue
+		}
+		fcols[j] = make([]float64, len(col.ints))
+		for k := range col.ints { fcols[j][k] = float64(col.ints[k]) }
+	}
+
+	return icols, fcols, nil
+}
+
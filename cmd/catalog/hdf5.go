@@ -0,0 +1,764 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// HDF5 catalogs store each column of a catalog as a one-dimensional dataset
+// of 64-bit little-endian integers or doubles in the file's root group.
+// Columns are named and split the same way as in Parquet catalogs. The
+// catalog's Header is stored in a scalar string dataset named "shellfish",
+// and each column's units are stored in a string attribute of its dataset
+// named "units".
+//
+// Files are written with a version 0 superblock, version 1 object headers,
+// and a symbol table for the root group, which every version of the HDF5
+// library can read. Only the parts of the format needed to read these files
+// are implemented: files written by other programs can be read if they use
+// the same structures and store their datasets contiguously.
+
+var hdf5Magic = []byte("\x89HDF\r\n\x1a\n")
+
+// hdf5Undef is the undefined address.
+const hdf5Undef = math.MaxUint64
+
+// hdf5FreeNull is the local heap free-list offset which marks an empty free
+// list.
+const hdf5FreeNull = 1
+
+// Sizes of fixed-size HDF5 structures. All offsets and lengths are 8 bytes.
+const (
+	hdf5SuperblockSize = 96
+	hdf5EntrySize = 40
+	hdf5HeapHeaderSize = 32
+	hdf5ObjectPrefixSize = 16
+	hdf5MessagePrefixSize = 8
+	hdf5BTreeHeaderSize = 24
+
+	// hdf5InternalK is the group internal node K written to the
+	// superblock. Only one B-tree node is ever written.
+	hdf5InternalK = 16
+)
+
+// HDF5 object header message types.
+const (
+	hdf5Dataspace = 0x01
+	hdf5Datatype = 0x03
+	hdf5FillValue = 0x05
+	hdf5Layout = 0x08
+	hdf5Attribute = 0x0c
+	hdf5Continuation = 0x10
+	hdf5SymbolTable = 0x11
+)
+
+// HDF5 datatype classes.
+const (
+	hdf5FixedPoint = 0
+	hdf5FloatingPoint = 1
+	hdf5String = 3
+)
+
+// IsHDF5 returns true if data is an HDF5 file.
+func IsHDF5(data []byte) bool {
+	return bytes.HasPrefix(data, hdf5Magic)
+}
+
+// IsBinary returns true if data is a binary catalog, i.e. a Parquet or HDF5
+// file, rather than a text catalog.
+func IsBinary(data []byte) bool {
+	return IsParquet(data) || IsHDF5(data)
+}
+
+// EncodeHDF5 returns a catalog as an HDF5 file. The arguments are the same as
+// FormatCols.
+func EncodeHDF5(
+	hd *Header, intCols [][]int, floatCols [][]float64, order []int,
+) []byte {
+	rows := numRows(intCols, floatCols)
+	names, units := columnNames(hd, len(order))
+
+	// Object headers are written before the data they describe.
+	objects := make([]hdf5Object, len(order))
+	for k, idx := range order {
+		if idx >= len(intCols)+len(floatCols) {
+			panic("Column ordering out of range.")
+		}
+
+		obj := &objects[k]
+		obj.name, obj.units = names[k], units[k]
+		obj.space, obj.typ = hdf5SimpleSpace(rows), hdf5NumericType(false)
+		data := &bytes.Buffer{}
+		if idx < len(intCols) {
+			obj.typ = hdf5NumericType(true)
+			binary.Write(data, binary.LittleEndian, toInt64s(intCols[idx]))
+		} else {
+			binary.Write(data, binary.LittleEndian, floatCols[idx-len(intCols)])
+		}
+		obj.data = data.Bytes()
+	}
+
+	// The Header can be larger than the 64 kB limit on attributes, so it's
+	// stored as a scalar string dataset.
+	if hd != nil {
+		hdJSON := hd.json()
+		objects = append(objects, hdf5Object{
+			name: hdf5HeaderName, space: hdf5ScalarSpace(),
+			typ: hdf5StringType(len(hdJSON)), data: []byte(hdJSON),
+		})
+	}
+
+	// The symbol table lists datasets in name order. Names are stored in
+	// the local heap, after the empty name at offset 0.
+	sorted := make([]int, len(objects))
+	for i := range sorted { sorted[i] = i }
+	sort.Slice(sorted, func(i, j int) bool {
+		return objects[sorted[i]].name < objects[sorted[j]].name
+	})
+
+	heap := &hdf5Writer{}
+	heap.pad(8)
+	nameOffsets := make([]uint64, len(objects))
+	for _, k := range sorted {
+		nameOffsets[k] = uint64(heap.Len())
+		heap.WriteString(objects[k].name)
+		heap.WriteByte(0)
+		heap.align()
+	}
+
+	// A symbol table node holds up to 2*leafK entries.
+	leafK := (len(objects) + 1) / 2
+	if leafK < 4 { leafK = 4 }
+	bTreeSize := hdf5BTreeHeaderSize + (4*hdf5InternalK + 1)*8
+	snodSize := 8 + 2*leafK*hdf5EntrySize
+
+	rootSize := len(hdf5RootHeader(0, 0))
+	rootAddr := uint64(hdf5SuperblockSize)
+	heapAddr := rootAddr + uint64(rootSize)
+	bTreeAddr := heapAddr + hdf5HeapHeaderSize + uint64(heap.Len())
+	snodAddr := bTreeAddr + uint64(bTreeSize)
+
+	headerAddrs := make([]uint64, len(objects))
+	addr := snodAddr + uint64(snodSize)
+	for k := range objects {
+		headerAddrs[k] = addr
+		addr += uint64(len(objects[k].header(0)))
+		objects[k].addr = addr
+		addr += uint64(len(objects[k].data))
+	}
+
+	w := &hdf5Writer{}
+
+	// Superblock
+	w.Write(hdf5Magic)
+	w.Write([]byte{ 0, 0, 0, 0, 0, 8, 8, 0 })
+	w.u16(uint16(leafK))
+	w.u16(hdf5InternalK)
+	w.u32(0)
+	w.u64(0)
+	w.u64(hdf5Undef)
+	w.u64(addr)
+	w.u64(hdf5Undef)
+	w.entry(0, rootAddr, 1, bTreeAddr, heapAddr)
+
+	w.Write(hdf5RootHeader(bTreeAddr, heapAddr))
+
+	// Local heap
+	w.WriteString("HEAP")
+	w.Write([]byte{ 0, 0, 0, 0 })
+	w.u64(uint64(heap.Len()))
+	w.u64(hdf5FreeNull)
+	w.u64(heapAddr + hdf5HeapHeaderSize)
+	w.Write(heap.Bytes())
+
+	// B-tree
+	entries := 0
+	if len(objects) > 0 { entries = 1 }
+	w.WriteString("TREE")
+	w.Write([]byte{ 0, 0 })
+	w.u16(uint16(entries))
+	w.u64(hdf5Undef)
+	w.u64(hdf5Undef)
+	w.u64(0)
+	if entries > 0 {
+		w.u64(snodAddr)
+		w.u64(nameOffsets[sorted[len(sorted) - 1]])
+	}
+	w.pad(int(snodAddr) - w.Len())
+
+	// Symbol table node
+	w.WriteString("SNOD")
+	w.Write([]byte{ 1, 0 })
+	w.u16(uint16(len(objects)))
+	for _, k := range sorted {
+		w.entry(nameOffsets[k], headerAddrs[k], 0, 0, 0)
+	}
+	w.pad(int(snodAddr) + snodSize - w.Len())
+
+	// Datasets
+	for k := range objects {
+		w.Write(objects[k].header(objects[k].addr))
+		w.Write(objects[k].data)
+	}
+
+	return w.Bytes()
+}
+
+// hdf5HeaderName is the name of the dataset which stores the Header.
+const hdf5HeaderName = parquetHeaderKey
+
+// hdf5Object is a contiguous dataset which is being written.
+type hdf5Object struct {
+	name, units string
+	space, typ  []byte
+	data        []byte
+	// addr is the address of data.
+	addr uint64
+}
+
+// hdf5RootHeader returns the object header of the root group.
+func hdf5RootHeader(bTreeAddr, heapAddr uint64) []byte {
+	symTab := &hdf5Writer{}
+	symTab.u64(bTreeAddr)
+	symTab.u64(heapAddr)
+	return hdf5ObjectHeader([]hdf5Message{ {hdf5SymbolTable, symTab.Bytes()} })
+}
+
+// header returns the object header of the dataset when its data is stored
+// at dataAddr.
+func (obj *hdf5Object) header(dataAddr uint64) []byte {
+	// Version 2, late allocation, write fill values if set, and no fill
+	// value.
+	fill := []byte{ 2, 2, 2, 0 }
+
+	layout := &hdf5Writer{}
+	layout.Write([]byte{ 3, 1 })
+	if len(obj.data) == 0 {
+		layout.u64(hdf5Undef)
+	} else {
+		layout.u64(dataAddr)
+	}
+	layout.u64(uint64(len(obj.data)))
+
+	msgs := []hdf5Message{
+		{hdf5Dataspace, obj.space}, {hdf5Datatype, obj.typ},
+		{hdf5FillValue, fill}, {hdf5Layout, layout.Bytes()},
+	}
+	if obj.units != "" {
+		msgs = append(msgs, hdf5StringAttribute("units", obj.units))
+	}
+	return hdf5ObjectHeader(msgs)
+}
+
+// hdf5SimpleSpace returns a version 1 dataspace message for a
+// one-dimensional dataset.
+func hdf5SimpleSpace(rows int) []byte {
+	w := &hdf5Writer{}
+	w.Write([]byte{ 1, 1, 0, 0, 0, 0, 0, 0 })
+	w.u64(uint64(rows))
+	return w.Bytes()
+}
+
+// hdf5ScalarSpace returns a version 1 dataspace message for a scalar.
+func hdf5ScalarSpace() []byte {
+	return []byte{ 1, 0, 0, 0, 0, 0, 0, 0 }
+}
+
+// hdf5NumericType returns the datatype message for 64-bit little-endian
+// integers or doubles.
+func hdf5NumericType(isInt bool) []byte {
+	w := &hdf5Writer{}
+	if isInt {
+		// Signed, little-endian, 64 bits starting at bit 0.
+		w.Write([]byte{ 0x10 | hdf5FixedPoint, 0x08, 0, 0 })
+		w.u32(8)
+		w.u16(0)
+		w.u16(64)
+	} else {
+		// IEEE little-endian double: implied leading mantissa bit, sign at
+		// bit 63, 11 exponent bits at bit 52, 52 mantissa bits at bit 0.
+		w.Write([]byte{ 0x10 | hdf5FloatingPoint, 0x20, 63, 0 })
+		w.u32(8)
+		w.u16(0)
+		w.u16(64)
+		w.Write([]byte{ 52, 11, 0, 52 })
+		w.u32(1023)
+	}
+	return w.Bytes()
+}
+
+// hdf5StringType returns the datatype message for a null-padded UTF-8
+// string of length n.
+func hdf5StringType(n int) []byte {
+	w := &hdf5Writer{}
+	w.Write([]byte{ 0x10 | hdf5String, 0x11, 0, 0 })
+	w.u32(uint32(n))
+	return w.Bytes()
+}
+
+// hdf5StringAttribute returns a version 1 attribute message for a scalar,
+// fixed-length UTF-8 string.
+func hdf5StringAttribute(name, value string) hdf5Message {
+	w := &hdf5Writer{}
+	w.Write([]byte{ 1, 0 })
+	w.u16(uint16(len(name) + 1))
+	w.u16(8)
+	w.u16(8)
+	w.WriteString(name)
+	w.WriteByte(0)
+	w.align()
+	w.Write(hdf5StringType(len(value)))
+	w.Write(hdf5ScalarSpace())
+	w.WriteString(value)
+	return hdf5Message{ hdf5Attribute, w.Bytes() }
+}
+
+// hdf5Message is an object header message.
+type hdf5Message struct {
+	typ  uint16
+	data []byte
+}
+
+// hdf5ObjectHeader returns a version 1 object header containing msgs.
+func hdf5ObjectHeader(msgs []hdf5Message) []byte {
+	body := &hdf5Writer{}
+	for _, msg := range msgs {
+		size := (len(msg.data) + 7) / 8 * 8
+		if size > math.MaxUint16 {
+			panic(fmt.Sprintf("HDF5 message of type %d is %d bytes, but "+
+				"messages can't be larger than %d bytes.", msg.typ, size,
+				math.MaxUint16))
+		}
+		body.u16(msg.typ)
+		body.u16(uint16(size))
+		body.Write([]byte{ 0, 0, 0, 0 })
+		body.Write(msg.data)
+		body.pad(size - len(msg.data))
+	}
+
+	w := &hdf5Writer{}
+	w.Write([]byte{ 1, 0 })
+	w.u16(uint16(len(msgs)))
+	w.u32(1)
+	w.u32(uint32(body.Len()))
+	w.u32(0)
+	w.Write(body.Bytes())
+	return w.Bytes()
+}
+
+// hdf5Writer writes little-endian HDF5 structures.
+type hdf5Writer struct {
+	bytes.Buffer
+}
+
+func (w *hdf5Writer) u16(x uint16) {
+	binary.Write(w, binary.LittleEndian, x)
+}
+
+func (w *hdf5Writer) u32(x uint32) {
+	binary.Write(w, binary.LittleEndian, x)
+}
+
+func (w *hdf5Writer) u64(x uint64) {
+	binary.Write(w, binary.LittleEndian, x)
+}
+
+// pad writes n zero bytes.
+func (w *hdf5Writer) pad(n int) {
+	w.Write(make([]byte, n))
+}
+
+// align pads the buffer to a multiple of eight bytes.
+func (w *hdf5Writer) align() {
+	w.pad((8 - w.Len()%8) % 8)
+}
+
+// entry writes a symbol table entry. The B-tree and heap addresses are only
+// used by entries with cache type 1.
+func (w *hdf5Writer) entry(
+	nameOffset, headerAddr uint64, cacheType uint32, bTreeAddr, heapAddr uint64,
+) {
+	w.u64(nameOffset)
+	w.u64(headerAddr)
+	w.u32(cacheType)
+	w.u32(0)
+	w.u64(bTreeAddr)
+	w.u64(heapAddr)
+}
+
+//////////////
+// Reading //
+//////////////
+
+// hdf5File is an HDF5 file which is being decoded.
+type hdf5File struct {
+	data []byte
+}
+
+// bytes returns the n bytes at addr.
+func (f *hdf5File) bytes(addr uint64, n int) ([]byte, error) {
+	if addr > uint64(len(f.data)) || n < 0 ||
+		uint64(n) > uint64(len(f.data)) - addr {
+		return nil, fmt.Errorf("HDF5 file is truncated or corrupted: "+
+			"%d bytes at address %d are past the end of the file.", n, addr)
+	}
+	return f.data[addr: addr + uint64(n)], nil
+}
+
+// signature checks that the structure at addr starts with sig.
+func (f *hdf5File) signature(addr uint64, sig string) error {
+	b, err := f.bytes(addr, len(sig))
+	if err != nil { return err }
+	if string(b) != sig {
+		return fmt.Errorf("Expected HDF5 structure '%s' at address %d, "+
+			"but found '%s'.", sig, addr, b)
+	}
+	return nil
+}
+
+// root returns the address of the root group's object header.
+func (f *hdf5File) root() (uint64, error) {
+	b, err := f.bytes(0, hdf5SuperblockSize + 4)
+	if err != nil { return 0, err }
+
+	entry := 56
+	switch b[8] {
+	case 0:
+	case 1:
+		entry += 4
+	default:
+		return 0, fmt.Errorf("HDF5 file has a version %d superblock, but "+
+			"only versions 0 and 1 are supported.", b[8])
+	}
+	if b[13] != 8 || b[14] != 8 {
+		return 0, fmt.Errorf("HDF5 file uses %d-byte offsets and %d-byte "+
+			"lengths, but only 8-byte offsets and lengths are supported.",
+			b[13], b[14])
+	}
+	return binary.LittleEndian.Uint64(b[entry + 8:]), nil
+}
+
+// messages returns the messages in the version 1 object header at addr.
+func (f *hdf5File) messages(addr uint64) ([]hdf5Message, error) {
+	b, err := f.bytes(addr, hdf5ObjectPrefixSize)
+	if err != nil { return nil, err }
+	if b[0] != 1 {
+		return nil, fmt.Errorf("HDF5 object header at address %d has "+
+			"version %d, but only version 1 is supported.", addr, b[0])
+	}
+	n := int(binary.LittleEndian.Uint16(b[2:]))
+	size := int(binary.LittleEndian.Uint32(b[8:]))
+
+	msgs := []hdf5Message{}
+	blocks := []struct{ addr uint64; size int }{
+		{addr + hdf5ObjectPrefixSize, size},
+	}
+	for len(blocks) > 0 && len(msgs) < n {
+		block, err := f.bytes(blocks[0].addr, blocks[0].size)
+		if err != nil { return nil, err }
+		blocks = blocks[1:]
+
+		for len(block) >= hdf5MessagePrefixSize && len(msgs) < n {
+			typ := binary.LittleEndian.Uint16(block)
+			size := int(binary.LittleEndian.Uint16(block[2:]))
+			if hdf5MessagePrefixSize + size > len(block) {
+				return nil, fmt.Errorf("HDF5 message at address %d runs "+
+					"past the end of its object header.", addr)
+			}
+			data := block[hdf5MessagePrefixSize: hdf5MessagePrefixSize + size]
+			block = block[hdf5MessagePrefixSize + size:]
+
+			msgs = append(msgs, hdf5Message{ typ, data })
+			if typ == hdf5Continuation && len(data) >= 16 {
+				blocks = append(blocks, struct{ addr uint64; size int }{
+					binary.LittleEndian.Uint64(data),
+					int(binary.LittleEndian.Uint64(data[8:])),
+				})
+			}
+		}
+	}
+
+	return msgs, nil
+}
+
+// hdf5Link is a named object in a group.
+type hdf5Link struct {
+	name       string
+	headerAddr uint64
+}
+
+// links returns the objects in the group with the given symbol table.
+func (f *hdf5File) links(symTab []byte) ([]hdf5Link, error) {
+	if len(symTab) < 16 {
+		return nil, fmt.Errorf("HDF5 symbol table message is too short.")
+	}
+	bTreeAddr := binary.LittleEndian.Uint64(symTab)
+	heapAddr := binary.LittleEndian.Uint64(symTab[8:])
+
+	if err := f.signature(heapAddr, "HEAP"); err != nil { return nil, err }
+	b, err := f.bytes(heapAddr, hdf5HeapHeaderSize)
+	if err != nil { return nil, err }
+	heap, err := f.bytes(
+		binary.LittleEndian.Uint64(b[24:]),
+		int(binary.LittleEndian.Uint64(b[8:])),
+	)
+	if err != nil { return nil, err }
+
+	links := []hdf5Link{}
+	err = f.walkBTree(bTreeAddr, func(snodAddr uint64) error {
+		if err := f.signature(snodAddr, "SNOD"); err != nil { return err }
+		b, err := f.bytes(snodAddr, 8)
+		if err != nil { return err }
+		n := int(binary.LittleEndian.Uint16(b[6:]))
+
+		entries, err := f.bytes(snodAddr + 8, n*hdf5EntrySize)
+		if err != nil { return err }
+		for i := 0; i < n; i++ {
+			entry := entries[i*hdf5EntrySize:]
+			offset := binary.LittleEndian.Uint64(entry)
+			if offset >= uint64(len(heap)) {
+				return fmt.Errorf("HDF5 link name is outside the local heap.")
+			}
+			name := heap[offset:]
+			if end := bytes.IndexByte(name, 0); end != -1 {
+				name = name[:end]
+			}
+			links = append(links, hdf5Link{
+				string(name), binary.LittleEndian.Uint64(entry[8:]),
+			})
+		}
+		return nil
+	})
+
+	return links, err
+}
+
+// walkBTree calls leaf on each symbol table node in a group's B-tree.
+func (f *hdf5File) walkBTree(addr uint64, leaf func(uint64) error) error {
+	if err := f.signature(addr, "TREE"); err != nil { return err }
+	b, err := f.bytes(addr, hdf5BTreeHeaderSize)
+	if err != nil { return err }
+	if b[4] != 0 {
+		return fmt.Errorf("HDF5 B-tree at address %d has type %d, but "+
+			"group B-trees have type 0.", addr, b[4])
+	}
+	level, n := b[5], int(binary.LittleEndian.Uint16(b[6:]))
+
+	children, err := f.bytes(addr + hdf5BTreeHeaderSize, (2*n + 1)*8)
+	if err != nil { return err }
+	for i := 0; i < n; i++ {
+		child := binary.LittleEndian.Uint64(children[8 + 16*i:])
+		if level > 0 {
+			err = f.walkBTree(child, leaf)
+		} else {
+			err = leaf(child)
+		}
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// rootLinks returns the objects in the root group.
+func (f *hdf5File) rootLinks() ([]hdf5Link, error) {
+	root, err := f.root()
+	if err != nil { return nil, err }
+	msgs, err := f.messages(root)
+	if err != nil { return nil, err }
+
+	for _, msg := range msgs {
+		if msg.typ == hdf5SymbolTable { return f.links(msg.data) }
+	}
+	return nil, fmt.Errorf("The root group of the HDF5 file doesn't have "+
+		"a symbol table, which is the only kind of group supported.")
+}
+
+// hdf5Dataset describes a contiguous dataset which is being read.
+type hdf5Dataset struct {
+	// rows is the length of a one-dimensional dataset, or -1 for a scalar.
+	rows     int
+	typ      []byte
+	size     int
+	dataAddr uint64
+}
+
+// dataset reads the object header of the contiguous, scalar or
+// one-dimensional dataset at addr.
+func (f *hdf5File) dataset(addr uint64, name string) (*hdf5Dataset, error) {
+	msgs, err := f.messages(addr)
+	if err != nil { return nil, err }
+
+	ds := &hdf5Dataset{ rows: -2 }
+	for _, msg := range msgs {
+		b := msg.data
+		switch msg.typ {
+		case hdf5Dataspace:
+			if len(b) < 2 { break }
+			start := 8
+			if b[0] >= 2 { start = 4 }
+			switch {
+			case b[1] == 0:
+				ds.rows = -1
+			case b[1] == 1 && len(b) >= start + 8:
+				ds.rows = int(binary.LittleEndian.Uint64(b[start:]))
+			default:
+				return nil, fmt.Errorf("HDF5 dataset '%s' has %d "+
+					"dimensions, but only one-dimensional datasets are "+
+					"supported.", name, b[1])
+			}
+		case hdf5Datatype:
+			if len(b) >= 8 {
+				ds.typ, ds.size = b, int(binary.LittleEndian.Uint32(b[4:]))
+			}
+		case hdf5Layout:
+			if len(b) < 18 || b[0] != 3 || b[1] != 1 {
+				return nil, fmt.Errorf("HDF5 dataset '%s' isn't stored "+
+					"contiguously, which isn't supported.", name)
+			}
+			ds.dataAddr = binary.LittleEndian.Uint64(b[2:])
+		}
+	}
+
+	if ds.rows == -2 || ds.typ == nil {
+		return nil, fmt.Errorf("HDF5 object '%s' isn't a dataset.", name)
+	}
+	return ds, nil
+}
+
+// readString reads the scalar string dataset at addr.
+func (f *hdf5File) readString(addr uint64, name string) (string, error) {
+	ds, err := f.dataset(addr, name)
+	if err != nil { return "", err }
+	if ds.rows != -1 || ds.typ[0] & 0xf != hdf5String {
+		return "", fmt.Errorf("HDF5 dataset '%s' isn't a scalar string.",
+			name)
+	}
+	b, err := f.bytes(ds.dataAddr, ds.size)
+	if err != nil { return "", err }
+	return string(bytes.TrimRight(b, "\x00 ")), nil
+}
+
+// hdf5Header returns the Header stored in an HDF5 file, or nil if there
+// isn't one.
+func hdf5Header(data []byte) (*Header, error) {
+	f := &hdf5File{ data }
+	links, err := f.rootLinks()
+	if err != nil { return nil, err }
+	return f.header(links)
+}
+
+// header reads the Header dataset from a list of links, if there is one.
+func (f *hdf5File) header(links []hdf5Link) (*Header, error) {
+	for _, link := range links {
+		if link.name != hdf5HeaderName { continue }
+		val, err := f.readString(link.headerAddr, link.name)
+		if err != nil { return nil, err }
+		return decodeHeader([]byte(val))
+	}
+	return nil, nil
+}
+
+// decodeHDF5 decodes an HDF5 catalog.
+func decodeHDF5(data []byte) (*columnFile, error) {
+	f := &hdf5File{ data }
+	links, err := f.rootLinks()
+	if err != nil { return nil, err }
+
+	out := &columnFile{}
+	if out.header, err = f.header(links); err != nil { return nil, err }
+
+	// Datasets are stored in name order, so they're put back into the
+	// order given by the header. Files without a header keep name order.
+	addrs, linkNames := map[string]uint64{}, []string{}
+	for _, link := range links {
+		if out.header != nil && link.name == hdf5HeaderName { continue }
+		addrs[link.name] = link.headerAddr
+		linkNames = append(linkNames, link.name)
+	}
+	names, _ := columnNames(out.header, len(linkNames))
+	for _, name := range names {
+		if _, ok := addrs[name]; !ok {
+			names = linkNames
+			break
+		}
+	}
+
+	out.columns = make([]fileColumn, len(names))
+	for i, name := range names {
+		err = f.readDataset(addrs[name], name, &out.columns[i])
+		if err != nil { return nil, err }
+	}
+
+	if len(out.columns) > 0 { out.rows = out.columns[0].len() }
+	for i := range out.columns {
+		if out.columns[i].len() != out.rows {
+			return nil, fmt.Errorf("HDF5 dataset '%s' has %d rows, but "+
+				"dataset '%s' has %d.", names[i], out.columns[i].len(),
+				names[0], out.rows)
+		}
+	}
+
+	return out, nil
+}
+
+// readDataset reads the one-dimensional, contiguous dataset whose object
+// header is at addr into col.
+func (f *hdf5File) readDataset(
+	addr uint64, name string, col *fileColumn,
+) error {
+	ds, err := f.dataset(addr, name)
+	if err != nil { return err }
+	if ds.rows == -1 {
+		return fmt.Errorf("HDF5 dataset '%s' is a scalar, not a column.",
+			name)
+	}
+
+	rows, size, typ := ds.rows, ds.size, ds.typ
+	class, bigEndian := typ[0] & 0xf, typ[1] & 1 == 1
+	col.isInt = class == hdf5FixedPoint
+	if (class != hdf5FixedPoint && class != hdf5FloatingPoint) ||
+		(size != 4 && size != 8) {
+		return fmt.Errorf("HDF5 dataset '%s' has a type with class %d and "+
+			"size %d, but only 4- and 8-byte integers and floats are "+
+			"supported.", name, class, size)
+	}
+	signed := typ[1] & 0x8 != 0
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian { order = binary.BigEndian }
+
+	b := []byte{}
+	if rows > 0 {
+		if b, err = f.bytes(ds.dataAddr, rows*size); err != nil { return err }
+	}
+	for i := 0; i < rows; i++ {
+		x := b[i*size:]
+		switch {
+		case col.isInt && size == 4 && signed:
+			col.ints = append(col.ints, int(int32(order.Uint32(x))))
+		case col.isInt && size == 4:
+			col.ints = append(col.ints, int(order.Uint32(x)))
+		case col.isInt:
+			col.ints = append(col.ints, int(order.Uint64(x)))
+		case size == 4:
+			col.floats = append(col.floats,
+				float64(math.Float32frombits(order.Uint32(x))))
+		default:
+			col.floats = append(col.floats,
+				math.Float64frombits(order.Uint64(x)))
+		}
+	}
+
+	return nil
+}
+
+// parseHDF5 is the HDF5 equivalent of parse.
+func parseHDF5(data []byte, icolIdxs, fcolIdxs []int) (
+	[][]int, [][]float64, error,
+) {
+	f, err := decodeHDF5(data)
+	if err != nil { return nil, nil, err }
+	return f.parse(icolIdxs, fcolIdxs)
+}
@@ -0,0 +1,264 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestHDF5(t *testing.T) {
+	hd, intCols, floatCols := testCatalog()
+	ids, snaps := intCols[0], intCols[1]
+	xs, p0, p1 := floatCols[0], floatCols[1], floatCols[2]
+	data := EncodeHDF5(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+	if !IsHDF5(data) || !IsBinary(data) {
+		t.Fatalf("EncodeHDF5 didn't return an HDF5 file.")
+	}
+
+	ints := []Request{ {"ID", 1}, {"Snapshot", 1} }
+	floats := []Request{ {"P_ijk", 2}, {"X", 1} }
+	icols, fcols, out, err := ParseColumns(data, ints, floats)
+	if err != nil {
+		t.Fatalf("Expected successful parse, but got '%s'.", err.Error())
+	}
+
+	if out == nil || out.Mode != "shell" || out.Columns[2].Units != "cMpc/h" ||
+		out.CheckPenna(1) != nil {
+		t.Errorf("Header was parsed as %v.", out)
+	}
+	for i := range ids {
+		if icols[0][i] != ids[i] || icols[1][i] != snaps[i] {
+			t.Errorf("Expected int columns %v %v, got %v.", ids, snaps, icols)
+		}
+		if fcols[0][i] != p0[i] || fcols[1][i] != p1[i] || fcols[2][i] != xs[i] {
+			t.Errorf("Expected float columns %v %v %v, got %v.",
+				p0, p1, xs, fcols)
+		}
+	}
+
+	f, err := decodeHDF5(data)
+	if err != nil { t.Fatal(err.Error()) }
+	msgs, _ := (&hdf5File{ data }).messages(hdf5DatasetAddr(t, data, "X"))
+	if units, ok := stringAttribute(msgs, "units"); !ok || units != "cMpc/h" {
+		t.Errorf("Expected X to have units 'cMpc/h', got '%s'.", units)
+	}
+	if f.rows != 3 || len(f.columns) != 5 {
+		t.Errorf("Expected 3 rows and 5 columns, got %d and %d.",
+			f.rows, len(f.columns))
+	}
+
+	// Headers can be much larger than the 64 kB limit on object header
+	// messages, e.g. when id is given a long list of IDs.
+	ids2 := make([]interface{}, 20000)
+	for i := range ids2 { ids2[i] = 1000000 + i }
+	hd.Config = map[string]interface{}{ "IDs": ids2 }
+	big := EncodeHDF5(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+	if bigHd, err := ParseHeader(big); err != nil ||
+		len(bigHd.Config["IDs"].([]interface{})) != len(ids2) {
+		t.Errorf("Large header was parsed as %v, %v.", bigHd, err)
+	} else if _, fcols, err := Parse(big, nil, []int{2}); err != nil ||
+		fcols[0][1] != xs[1] {
+		t.Errorf("Expected X column %v, got %v, %v.", xs, fcols, err)
+	}
+
+	if _, _, err = Parse(data, []int{2}, nil); err == nil {
+		t.Errorf("Expected float column to be rejected as an int column.")
+	}
+	if _, _, err = Parse(data, nil, []int{5}); err == nil {
+		t.Errorf("Expected out of range column to be rejected.")
+	}
+	if _, _, err = Parse(data[:len(data) - 8], nil, []int{4}); err == nil {
+		t.Errorf("Expected truncated file to be rejected.")
+	}
+
+	empty := EncodeHDF5(hd, [][]int{ {}, {} }, [][]float64{ {}, {}, {} },
+		[]int{0, 1, 2, 3, 4})
+	if icols, _, err := Parse(empty, []int{0}, nil); err != nil ||
+		len(icols[0]) != 0 {
+		t.Errorf("Expected empty catalog to parse, got %v and %v.", icols, err)
+	}
+}
+
+// TestHDF5Layout checks the fixed-layout structures which the HDF5 library
+// validates when it opens a file.
+func TestHDF5Layout(t *testing.T) {
+	hd, intCols, floatCols := testCatalog()
+	data := EncodeHDF5(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+
+	// The end-of-file address must match the file size.
+	if eof := binary.LittleEndian.Uint64(data[40:]); eof != uint64(len(data)) {
+		t.Errorf("End-of-file address is %d, but the file is %d bytes.",
+			eof, len(data))
+	}
+
+	// Every object header message must be padded to eight bytes and the
+	// messages must fill the header exactly.
+	f := &hdf5File{ data }
+	root, _ := f.root()
+	for _, addr := range []uint64{ root, hdf5DatasetAddr(t, data, "ID") } {
+		n := int(binary.LittleEndian.Uint16(data[addr + 2:]))
+		size := int(binary.LittleEndian.Uint32(data[addr + 8:]))
+		msgs, err := f.messages(addr)
+		if err != nil { t.Fatal(err.Error()) }
+
+		total := 0
+		for _, msg := range msgs {
+			if len(msg.data) % 8 != 0 {
+				t.Errorf("Message of type %d has %d bytes.",
+					msg.typ, len(msg.data))
+			}
+			total += hdf5MessagePrefixSize + len(msg.data)
+		}
+		if len(msgs) != n || total != size {
+			t.Errorf("Object header at %d has %d messages in %d bytes, "+
+				"but claims %d messages in %d bytes.",
+				addr, len(msgs), total, n, size)
+		}
+	}
+
+	// Datatypes are the standard H5T_STD_I64LE and H5T_IEEE_F64LE types.
+	i64 := []byte{ 0x10, 0x08, 0, 0, 8, 0, 0, 0, 0, 0, 64, 0 }
+	f64 := []byte{ 0x11, 0x20, 63, 0, 8, 0, 0, 0, 0, 0, 64, 0,
+		52, 11, 0, 52, 0xff, 3, 0, 0 }
+	for _, name := range []string{ "ID", "X" } {
+		msgs, _ := f.messages(hdf5DatasetAddr(t, data, name))
+		exp := i64
+		if name == "X" { exp = f64 }
+		if !bytes.HasPrefix(msgs[1].data, exp) {
+			t.Errorf("Datatype of %s is % x, expected % x.",
+				name, msgs[1].data, exp)
+		}
+	}
+
+	// Symbol table entries must be sorted by name.
+	msgs, _ := f.messages(root)
+	links, err := f.links(msgs[0].data)
+	if err != nil { t.Fatal(err.Error()) }
+	names := []string{}
+	for _, link := range links { names = append(names, link.name) }
+	exp := []string{ "ID", "P_ijk_0", "P_ijk_1", "Snapshot", "X", "shellfish" }
+	if len(names) != len(exp) {
+		t.Fatalf("Expected links %v, got %v.", exp, names)
+	}
+	for i := range names {
+		if names[i] != exp[i] {
+			t.Errorf("Expected links %v, got %v.", exp, names)
+			break
+		}
+	}
+}
+
+// TestHDF5h5py reads EncodeHDF5's output with h5py, which uses the HDF5
+// library. The test is skipped if h5py isn't installed.
+func TestHDF5h5py(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil { t.Skip("python3 isn't available.") }
+	if err = exec.Command(python, "-c", "import h5py").Run(); err != nil {
+		t.Skip("h5py isn't installed.")
+	}
+
+	tmp, err := ioutil.TempDir("", "shellfish_hdf5")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(tmp)
+	fname := filepath.Join(tmp, "shell.h5")
+
+	hd, intCols, floatCols := testCatalog()
+	data := EncodeHDF5(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+	if err = ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	script := `
+import h5py, json, sys
+f = h5py.File(sys.argv[1], "r")
+out = {"header": f["shellfish"][()].decode(), "columns": {}, "units": {}}
+for name in f:
+    if name == "shellfish": continue
+    out["columns"][name] = f[name][()].tolist()
+    if "units" in f[name].attrs:
+        out["units"][name] = f[name].attrs["units"].decode()
+print(json.dumps(out))
+`
+	stderr := &bytes.Buffer{}
+	check := exec.Command(python, "-c", script, fname)
+	check.Stderr = stderr
+	out, err := check.Output()
+	if err != nil {
+		t.Fatalf("h5py couldn't read the file: %s", stderr.String())
+	}
+
+	res := struct {
+		Header  string
+		Columns map[string][]float64
+		Units   map[string]string
+	}{}
+	if err = json.Unmarshal(out, &res); err != nil { t.Fatal(err.Error()) }
+
+	if res.Header != hd.json() {
+		t.Errorf("h5py read the header '%s'.", res.Header)
+	}
+	if len(res.Units) != 1 || res.Units["X"] != "cMpc/h" {
+		t.Errorf("h5py read the units %v.", res.Units)
+	}
+	exp := map[string][]float64{
+		"ID": {7, 8, 1 << 60}, "Snapshot": {5, 6, 100},
+		"X": floatCols[0], "P_ijk_0": floatCols[1], "P_ijk_1": floatCols[2],
+	}
+	if len(res.Columns) != len(exp) {
+		t.Errorf("h5py read the datasets %v.", res.Columns)
+	}
+	for name, col := range exp {
+		if !floatsEqualTest(res.Columns[name], col) {
+			t.Errorf("h5py read %s as %v, expected %v.",
+				name, res.Columns[name], col)
+		}
+	}
+}
+
+// stringAttribute returns the value of a version 1 string attribute in msgs.
+// ok is false if there isn't a string attribute with that name.
+func stringAttribute(msgs []hdf5Message, name string) (val string, ok bool) {
+	for _, msg := range msgs {
+		if msg.typ != hdf5Attribute { continue }
+		b := msg.data
+		if len(b) < 8 || b[0] != 1 { continue }
+
+		nameSize := int(binary.LittleEndian.Uint16(b[2:]))
+		typeSize := int(binary.LittleEndian.Uint16(b[4:]))
+		spaceSize := int(binary.LittleEndian.Uint16(b[6:]))
+		align := func(n int) int { return (n + 7) / 8 * 8 }
+
+		start := 8 + align(nameSize) + align(typeSize) + align(spaceSize)
+		if start > len(b) || nameSize < 1 || typeSize < 8 { continue }
+		attrName := b[8: 8 + nameSize - 1]
+		typ := b[8 + align(nameSize):]
+		if string(attrName) != name || typ[0] & 0xf != hdf5String { continue }
+
+		size := int(binary.LittleEndian.Uint32(typ[4:]))
+		if start + size > len(b) { continue }
+		return string(bytes.TrimRight(b[start: start + size], "\x00 ")), true
+	}
+	return "", false
+}
+
+// hdf5DatasetAddr returns the address of the object header of the named
+// dataset.
+func hdf5DatasetAddr(t *testing.T, data []byte, name string) uint64 {
+	f := &hdf5File{ data }
+	root, err := f.root()
+	if err != nil { t.Fatal(err.Error()) }
+	msgs, err := f.messages(root)
+	if err != nil { t.Fatal(err.Error()) }
+	links, err := f.links(msgs[0].data)
+	if err != nil { t.Fatal(err.Error()) }
+	for _, link := range links {
+		if link.name == name { return link.headerAddr }
+	}
+	t.Fatalf("No dataset named '%s'.", name)
+	return 0
+}
@@ -0,0 +1,277 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// HeaderPrefix starts the line of a catalog which contains its Header. The
+// line is a comment, so tools which don't understand it will skip it.
+const HeaderPrefix = "#json "
+
+// Header is a machine-readable description of the columns of a catalog
+// and of how the catalog was made.
+type Header struct {
+	// Version is the version of Shellfish which wrote the catalog.
+	Version string `json:"version"`
+	// Mode is the mode which wrote the catalog.
+	Mode string `json:"mode"`
+	// Config contains the variables of the mode's config file.
+	Config map[string]interface{} `json:"config,omitempty"`
+	// Penna describes any Penna-Dines coefficients in the catalog.
+	Penna *Penna `json:"penna,omitempty"`
+	// Provenance describes how the catalog was made.
+	Provenance *Provenance `json:"provenance,omitempty"`
+	Columns []Column `json:"columns"`
+}
+
+// Column is a named column, or range of columns, in a catalog.
+type Column struct {
+	Name  string `json:"name"`
+	Units string `json:"units,omitempty"`
+	// Type is "int" or "float".
+	Type string `json:"type"`
+	// Start is the index of the first column and Width is the number of
+	// columns.
+	Start int `json:"start"`
+	Width int `json:"width"`
+}
+
+// Penna describes the Penna-Dines coefficients of the splashback shells in a
+// catalog.
+type Penna struct {
+	Order int    `json:"order"`
+	Basis string `json:"basis"`
+}
+
+// Provenance records everything needed to remake a catalog.
+type Provenance struct {
+	// Command is the command line arguments that Shellfish was run with,
+	// starting with the mode.
+	Command []string `json:"command"`
+	// Revision is the git revision that Shellfish was built from, if known.
+	Revision string `json:"revision,omitempty"`
+	// GlobalConfigFile is the global config file and GlobalConfig contains
+	// its variables.
+	GlobalConfigFile string                 `json:"global_config_file"`
+	GlobalConfig     map[string]interface{} `json:"global_config"`
+	// ConfigFile is the mode's config file, if one was used. The values of
+	// its variables after flags are applied are stored in Header.Config.
+	ConfigFile string `json:"config_file,omitempty"`
+	// Seed is the seed of the random number generator.
+	Seed uint64 `json:"seed"`
+	// Input describes the catalog read from stdin, if there was one.
+	Input *Input `json:"input,omitempty"`
+	// Snapshots describes the files of every snapshot in the catalog.
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+}
+
+// Input describes a catalog read from stdin.
+type Input struct {
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// Snapshot describes the files of a snapshot. The names of the remaining
+// blocks can be found from SnapshotFormat in the global config.
+type Snapshot struct {
+	Snap        int    `json:"snap"`
+	Blocks      int    `json:"blocks"`
+	FirstBlock  string `json:"first_block"`
+	HaloCatalog string `json:"halo_catalog,omitempty"`
+}
+
+// PennaBasis describes the ordering of Penna-Dines coefficients written by
+// Shellfish.
+const PennaBasis = "P_ijk at index i + j*P + k*P^2, k in {0, 1}"
+
+// NewHeader returns a Header with the same columns as the comment returned by
+// CommentString. Units are taken from the end of column names written as
+// "Name [units]".
+func NewHeader(
+	mode string, intNames, floatNames []string, order, sizes []int,
+) *Header {
+	hd := &Header{ Mode: mode, Columns: []Column{} }
+
+	start := 0
+	for i, idx := range order {
+		if idx >= len(intNames)+len(floatNames) {
+			panic("Column ordering out of range.")
+		}
+
+		col := Column{ Type: "int", Start: start, Width: sizes[i] }
+		if idx < len(intNames) {
+			col.Name = intNames[idx]
+		} else {
+			col.Name, col.Type = floatNames[idx - len(intNames)], "float"
+		}
+		col.Name, col.Units = splitUnits(col.Name)
+
+		hd.Columns = append(hd.Columns, col)
+		start += sizes[i]
+	}
+
+	return hd
+}
+
+// CommentString returns the same comment that CommentString returns for the
+// columns which were used to make the Header.
+func (hd *Header) CommentString() string {
+	names := make([]string, len(hd.Columns))
+	order, sizes := make([]int, len(hd.Columns)), make([]int, len(hd.Columns))
+	for i, col := range hd.Columns {
+		names[i], order[i], sizes[i] = col.Name, i, col.Width
+		if col.Units != "" {
+			names[i] = fmt.Sprintf("%s [%s]", col.Name, col.Units)
+		}
+	}
+	return CommentString(nil, names, order, sizes)
+}
+
+// splitUnits splits a name of the form "Name [units]".
+func splitUnits(name string) (string, string) {
+	start := strings.LastIndex(name, " [")
+	if start == -1 || !strings.HasSuffix(name, "]") { return name, "" }
+	return name[:start], name[start + 2: len(name) - 1]
+}
+
+// String returns the Header as a catalog comment line.
+func (hd *Header) String() string {
+	return HeaderPrefix + hd.json()
+}
+
+func (hd *Header) json() string {
+	b, err := json.Marshal(hd)
+	if err != nil { panic(err.Error()) }
+	return string(b)
+}
+
+// ParseHeader returns the Header of a catalog. If the catalog doesn't have a
+// header, e.g. because it was written by hand, nil is returned.
+func ParseHeader(data []byte) (*Header, error) {
+	if IsParquet(data) {
+		meta, err := parquetFooter(data)
+		if err != nil { return nil, err }
+		return parquetHeader(meta)
+	} else if IsHDF5(data) {
+		return hdf5Header(data)
+	}
+
+	prefix := []byte(HeaderPrefix)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end == -1 { end = len(data) }
+		line := bytes.TrimSpace(data[:end])
+
+		if bytes.HasPrefix(line, prefix) {
+			return decodeHeader(line[len(prefix):])
+		} else if len(line) > 0 && line[0] != '#' {
+			// Headers must come before any data.
+			return nil, nil
+		}
+
+		if end == len(data) { break }
+		data = data[end + 1:]
+	}
+	return nil, nil
+}
+
+// decodeHeader decodes a Header from JSON. Numbers in Config are decoded as
+// json.Numbers so that large integers aren't rounded.
+func decodeHeader(data []byte) (*Header, error) {
+	hd := &Header{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(hd); err != nil {
+		return nil, fmt.Errorf("Could not parse catalog header: %s",
+			err.Error())
+	}
+	return hd, nil
+}
+
+// Column returns the column with the given name.
+func (hd *Header) Column(name string) (*Column, error) {
+	for i := range hd.Columns {
+		if hd.Columns[i].Name == name { return &hd.Columns[i], nil }
+	}
+
+	names := make([]string, len(hd.Columns))
+	for i := range hd.Columns { names[i] = hd.Columns[i].Name }
+	return nil, fmt.Errorf("The input catalog, which was written by the %s "+
+		"mode, doesn't have a '%s' column. Its columns are: %s.",
+		hd.Mode, name, strings.Join(names, ", "))
+}
+
+// Request is a named column which is read from a catalog. Width is the
+// number of columns that it's expected to span.
+type Request struct {
+	Name  string
+	Width int
+}
+
+// Indices returns the indices of every column spanned by the requested
+// columns, in order. If hd is nil, the catalog doesn't have a header and the
+// requested columns are assumed to be the first columns of the catalog, in
+// order.
+func (hd *Header) Indices(reqs ...Request) ([]int, error) {
+	idxs := []int{}
+
+	start := 0
+	for _, req := range reqs {
+		if hd != nil {
+			col, err := hd.Column(req.Name)
+			if err != nil { return nil, err }
+			if col.Width != req.Width {
+				return nil, fmt.Errorf("The '%s' column of the input "+
+					"catalog, which was written by the %s mode, spans %d "+
+					"columns, but %d were expected.", req.Name, hd.Mode,
+					col.Width, req.Width)
+			}
+			start = col.Start
+		}
+
+		for i := 0; i < req.Width; i++ { idxs = append(idxs, start + i) }
+		start += req.Width
+	}
+
+	return idxs, nil
+}
+
+// CheckPenna returns an error if the catalog's Penna-Dines coefficients
+// don't have the given order. Catalogs without headers aren't checked.
+func (hd *Header) CheckPenna(order int) error {
+	if hd == nil { return nil }
+	if hd.Penna == nil {
+		return fmt.Errorf("The input catalog, which was written by the %s "+
+			"mode, doesn't contain Penna-Dines coefficients.", hd.Mode)
+	} else if hd.Penna.Order != order {
+		return fmt.Errorf("The input catalog's Penna-Dines coefficients "+
+			"have order %d, but Order is set to %d.", hd.Penna.Order, order)
+	} else if hd.Penna.Basis != PennaBasis {
+		return fmt.Errorf("The input catalog's Penna-Dines coefficients "+
+			"use the basis '%s', not '%s'.", hd.Penna.Basis, PennaBasis)
+	}
+	return nil
+}
+
+// ParseColumns parses the requested int and float columns of a catalog,
+// looking them up by name in the catalog's header. Catalogs without headers
+// are assumed to contain the int columns followed by the float columns.
+func ParseColumns(data []byte, ints, floats []Request) (
+	[][]int, [][]float64, *Header, error,
+) {
+	hd, err := ParseHeader(data)
+	if err != nil { return nil, nil, nil, err }
+
+	idxs, err := hd.Indices(append(append([]Request{}, ints...), floats...)...)
+	if err != nil { return nil, nil, nil, err }
+
+	nInts := 0
+	for _, req := range ints { nInts += req.Width }
+
+	icols, fcols, err := Parse(data, idxs[:nInts], idxs[nInts:])
+	if err != nil { return nil, nil, nil, err }
+	return icols, fcols, hd, nil
+}
@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseColumns(t *testing.T) {
+	hd := NewHeader(
+		"shell", []string{"ID", "Snapshot"}, []string{"X [cMpc/h]", "P_ijk"},
+		[]int{1, 0, 2, 3}, []int{1, 1, 1, 2},
+	)
+	hd.Penna = &Penna{ Order: 1, Basis: PennaBasis }
+	data := []byte(hd.String() + "\n# comment\n7 5 1.5 2 3\n8 6 2.5 4 5\n")
+
+	ints := []Request{ {"ID", 1}, {"Snapshot", 1} }
+	floats := []Request{ {"P_ijk", 2}, {"X", 1} }
+	icols, fcols, out, err := ParseColumns(data, ints, floats)
+	if err != nil {
+		t.Fatalf("Expected successful parse, but got '%s'.", err.Error())
+	}
+
+	if out.Mode != "shell" || out.Columns[2].Units != "cMpc/h" {
+		t.Errorf("Header was parsed as %v.", out)
+	}
+	if icols[0][1] != 6 || icols[1][1] != 8 {
+		t.Errorf("Expected int columns [[5 6] [7 8]], got %v.", icols)
+	}
+	if fcols[0][1] != 4 || fcols[1][1] != 5 || fcols[2][1] != 2.5 {
+		t.Errorf("Expected float columns [[2 4] [3 5] [1.5 2.5]], got %v.",
+			fcols)
+	}
+
+	if err = out.CheckPenna(1); err != nil {
+		t.Errorf("Penna order 1 rejected: %s", err.Error())
+	}
+	if err = out.CheckPenna(2); err == nil {
+		t.Errorf("Penna order 2 accepted.")
+	}
+
+	for _, reqs := range [][]Request{ {{"Y", 1}}, {{"P_ijk", 8}} } {
+		if _, _, _, err = ParseColumns(data, nil, reqs); err == nil {
+			t.Errorf("Expected %v to be rejected.", reqs)
+		}
+	}
+}
+
+func TestParseColumnsNoHeader(t *testing.T) {
+	data := []byte("# Column contents: ID(0) X(1-2)\n1 2 3\n")
+	icols, fcols, hd, err := ParseColumns(
+		data, []Request{ {"ID", 1} }, []Request{ {"X", 2} },
+	)
+	if err != nil {
+		t.Fatalf("Expected successful parse, but got '%s'.", err.Error())
+	}
+	if hd != nil || hd.CheckPenna(4) != nil {
+		t.Errorf("Expected no header, got %v.", hd)
+	}
+	if icols[0][0] != 1 || fcols[0][0] != 2 || fcols[1][0] != 3 {
+		t.Errorf("Expected columns [[1]] [[2] [3]], got %v %v.", icols, fcols)
+	}
+}
+
+func TestHeaderProvenance(t *testing.T) {
+	hd := NewHeader("id", []string{"ID", "Snapshot"}, nil,
+		[]int{0, 1}, []int{1, 1})
+	hd.Config = map[string]interface{}{ "IDs": []int64{1 << 60} }
+	hd.Provenance = &Provenance{
+		Command: []string{"id", "--IDs", "1152921504606846976"},
+		Seed: 1<<64 - 1, Input: &Input{ SHA256: "abc", Size: 3 },
+	}
+
+	out, err := ParseHeader([]byte(hd.String() + "\n0 1\n"))
+	if err != nil {
+		t.Fatalf("Expected successful parse, but got '%s'.", err.Error())
+	}
+
+	p := out.Provenance
+	if p == nil || p.Seed != hd.Provenance.Seed || p.Input.Size != 3 ||
+		len(p.Command) != 3 {
+		t.Errorf("Expected provenance %v, got %v.", hd.Provenance, p)
+	}
+	ids, ok := out.Config["IDs"].([]interface{})
+	if !ok || len(ids) != 1 || fmt.Sprint(ids[0]) != "1152921504606846976" {
+		t.Errorf("Expected IDs = [1152921504606846976], got %v.",
+			out.Config["IDs"])
+	}
+}
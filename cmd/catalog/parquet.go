@@ -0,0 +1,588 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Parquet catalogs store each column of a catalog as a required INT64 or
+// DOUBLE column with a single uncompressed, PLAIN-encoded data page. Columns
+// which span several catalog columns, like P_ijk, are split into columns
+// named P_ijk_0, P_ijk_1, etc. The catalog's Header is stored in the file's
+// key-value metadata under the key "shellfish", and each column's units are
+// stored in its own key-value metadata under the key "units".
+//
+// Only the parts of the format needed to read these files are implemented:
+// catalogs written by other programs can be read if they're uncompressed,
+// PLAIN-encoded, and don't contain any optional or nested columns.
+
+var parquetMagic = []byte("PAR1")
+
+// parquetHeaderKey is the metadata key which the Header is stored under.
+const parquetHeaderKey = "shellfish"
+
+// Parquet physical types, page types, encodings, and repetition types.
+const (
+	parquetInt32 = 1
+	parquetInt64 = 2
+	parquetFloat = 4
+	parquetDouble = 5
+
+	parquetDataPage = 0
+	parquetDataPageV2 = 3
+
+	parquetPlain = 0
+	parquetRLE = 3
+
+	parquetRequired = 0
+)
+
+// IsParquet returns true if data is a Parquet file.
+func IsParquet(data []byte) bool {
+	return len(data) >= 2*len(parquetMagic) &&
+		bytes.HasPrefix(data, parquetMagic) &&
+		bytes.HasSuffix(data, parquetMagic)
+}
+
+// EncodeParquet returns a catalog as a Parquet file. The arguments are the
+// same as FormatCols.
+func EncodeParquet(
+	hd *Header, intCols [][]int, floatCols [][]float64, order []int,
+) []byte {
+	rows := numRows(intCols, floatCols)
+	names, units := columnNames(hd, len(order))
+
+	buf := &bytes.Buffer{}
+	buf.Write(parquetMagic)
+
+	chunks := make([]*thriftWriter, len(order))
+	for k, idx := range order {
+		if idx >= len(intCols)+len(floatCols) {
+			panic("Column ordering out of range.")
+		}
+
+		data := &bytes.Buffer{}
+		typ := parquetDouble
+		if idx < len(intCols) {
+			typ = parquetInt64
+			binary.Write(data, binary.LittleEndian, toInt64s(intCols[idx]))
+		} else {
+			binary.Write(data, binary.LittleEndian, floatCols[idx-len(intCols)])
+		}
+
+		page := &thriftWriter{}
+		page.i32(1, parquetDataPage)
+		page.i32(2, int32(data.Len()))
+		page.i32(3, int32(data.Len()))
+		page.beginStruct(5)
+		page.i32(1, int32(rows))
+		page.i32(2, parquetPlain)
+		page.i32(3, parquetRLE)
+		page.i32(4, parquetRLE)
+		page.endStruct()
+		page.stop()
+
+		offset := int64(buf.Len())
+		buf.Write(page.Bytes())
+		buf.Write(data.Bytes())
+		size := int64(buf.Len()) - offset
+
+		// ColumnChunk
+		chunk := &thriftWriter{}
+		chunk.i64(2, offset)
+		chunk.beginStruct(3)
+		chunk.i32(1, int32(typ))
+		chunk.listHeader(2, thriftI32, 2)
+		chunk.varint(parquetPlain)
+		chunk.varint(parquetRLE)
+		chunk.listHeader(3, thriftBinary, 1)
+		chunk.rawString(names[k])
+		chunk.i32(4, 0)
+		chunk.i64(5, int64(rows))
+		chunk.i64(6, size)
+		chunk.i64(7, size)
+		if units[k] != "" {
+			chunk.listHeader(8, thriftStructType, 1)
+			chunk.keyValue("units", units[k])
+		}
+		chunk.i64(9, offset)
+		chunk.endStruct()
+		chunk.stop()
+		chunks[k] = chunk
+	}
+	dataSize := int64(buf.Len() - len(parquetMagic))
+
+	// FileMetaData
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+	meta.listHeader(2, thriftStructType, len(order) + 1)
+	meta.pushStruct()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(order)))
+	meta.stop()
+	for k, idx := range order {
+		typ := parquetDouble
+		if idx < len(intCols) { typ = parquetInt64 }
+		meta.pushStruct()
+		meta.i32(1, int32(typ))
+		meta.i32(3, parquetRequired)
+		meta.binary(4, names[k])
+		meta.stop()
+	}
+	meta.i64(3, int64(rows))
+	meta.listHeader(4, thriftStructType, 1)
+	meta.pushStruct()
+	meta.listHeader(1, thriftStructType, len(chunks))
+	for _, chunk := range chunks { meta.Write(chunk.Bytes()) }
+	meta.i64(2, dataSize)
+	meta.i64(3, int64(rows))
+	meta.stop()
+	meta.listHeader(5, thriftStructType, 1)
+	meta.keyValue(parquetHeaderKey, hd.json())
+	meta.binary(6, fmt.Sprintf("shellfish version %s", hd.Version))
+	meta.stop()
+
+	buf.Write(meta.Bytes())
+	binary.Write(buf, binary.LittleEndian, uint32(meta.Len()))
+	buf.Write(parquetMagic)
+
+	return buf.Bytes()
+}
+
+// parquetFooter returns the FileMetaData of a Parquet file.
+func parquetFooter(data []byte) (thriftStruct, error) {
+	if !IsParquet(data) {
+		return nil, fmt.Errorf("Not a Parquet file.")
+	}
+	end := len(data) - len(parquetMagic) - 4
+	size := int(binary.LittleEndian.Uint32(data[end:]))
+	if size > end - len(parquetMagic) {
+		return nil, fmt.Errorf("Parquet footer is larger than the file.")
+	}
+
+	rd := &thriftReader{ data: data[end - size: end] }
+	return rd.readStruct()
+}
+
+// parquetHeader returns the Header stored in a Parquet file's metadata, or
+// nil if there isn't one.
+func parquetHeader(meta thriftStruct) (*Header, error) {
+	for _, kv := range meta.list(5) {
+		kv, _ := kv.(thriftStruct)
+		if string(kv.bytes(1)) != parquetHeaderKey { continue }
+		return decodeHeader(kv.bytes(2))
+	}
+	return nil, nil
+}
+
+// decodeParquet decodes a Parquet catalog.
+func decodeParquet(data []byte) (*columnFile, error) {
+	meta, err := parquetFooter(data)
+	if err != nil { return nil, err }
+
+	f := &columnFile{ rows: int(meta.int(3)) }
+	if f.header, err = parquetHeader(meta); err != nil { return nil, err }
+
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("Parquet file has no schema.")
+	}
+	for _, elem := range schema[1:] {
+		elem, _ := elem.(thriftStruct)
+		if elem.int(5) != 0 || elem.int(3) != parquetRequired {
+			return nil, fmt.Errorf("Parquet column '%s' is nested or "+
+				"optional, which isn't supported.", elem.bytes(4))
+		}
+	}
+	f.columns = make([]fileColumn, len(schema) - 1)
+
+	for _, group := range meta.list(4) {
+		grp, _ := group.(thriftStruct)
+		chunks := grp.list(1)
+		if len(chunks) != len(f.columns) {
+			return nil, fmt.Errorf("Parquet row group has %d columns, but "+
+				"the schema has %d.", len(chunks), len(f.columns))
+		}
+
+		for i, chunk := range chunks {
+			cc, _ := chunk.(thriftStruct)
+			cmeta := cc.strct(3)
+			if cmeta.int(4) != 0 {
+				return nil, fmt.Errorf("Parquet column '%s' is compressed, "+
+					"which isn't supported.", parquetPath(cmeta))
+			}
+			err = readParquetChunk(data, cmeta, &f.columns[i])
+			if err != nil { return nil, err }
+		}
+	}
+
+	for i := range f.columns {
+		if f.columns[i].len() != f.rows {
+			return nil, fmt.Errorf("Parquet file has %d rows, but column "+
+				"%d has %d values.", f.rows, i, f.columns[i].len())
+		}
+	}
+
+	return f, nil
+}
+
+func parquetPath(cmeta thriftStruct) string {
+	path := cmeta.list(3)
+	if len(path) == 0 { return "" }
+	name, _ := path[len(path) - 1].([]byte)
+	return string(name)
+}
+
+// readParquetChunk appends the values in a column chunk to col.
+func readParquetChunk(
+	data []byte, cmeta thriftStruct, col *fileColumn,
+) error {
+	typ, n := cmeta.int(1), int(cmeta.int(5))
+	offset := cmeta.int(9)
+
+	var size int
+	switch typ {
+	case parquetInt32, parquetFloat: size = 4
+	case parquetInt64, parquetDouble: size = 8
+	default:
+		return fmt.Errorf("Parquet column '%s' has physical type %d, "+
+			"which isn't supported.", parquetPath(cmeta), typ)
+	}
+	col.isInt = typ == parquetInt32 || typ == parquetInt64
+
+	chunkEnd := offset + cmeta.int(7)
+	if chunkEnd > int64(len(data)) { chunkEnd = int64(len(data)) }
+
+	for read := 0; read < n; {
+		if offset < 0 || offset >= chunkEnd {
+			return fmt.Errorf("Parquet column '%s' ended after %d of its "+
+				"%d values.", parquetPath(cmeta), read, n)
+		}
+		rd := &thriftReader{ data: data[offset:] }
+		page, err := rd.readStruct()
+		if err != nil { return err }
+
+		pageSize := page.int(3)
+		start := offset + int64(rd.pos)
+		end := start + pageSize
+		if end > int64(len(data)) {
+			return fmt.Errorf("Parquet page runs past the end of the "+
+				"file.")
+		}
+		offset = end
+
+		var count int
+		var encoding int64
+		switch page.int(1) {
+		case parquetDataPage:
+			dph := page.strct(5)
+			count, encoding = int(dph.int(1)), dph.int(2)
+		case parquetDataPageV2:
+			// Required columns have empty level data, but it's skipped
+			// anyway in case a writer includes it.
+			dph := page.strct(8)
+			count, encoding = int(dph.int(1)), dph.int(4)
+			start += dph.int(5) + dph.int(6)
+			pageSize -= dph.int(5) + dph.int(6)
+		default:
+			// Skip dictionary and index pages.
+			continue
+		}
+
+		if encoding != parquetPlain {
+			return fmt.Errorf("Parquet column '%s' uses encoding %d, "+
+				"but only PLAIN encoding is supported.",
+				parquetPath(cmeta), encoding)
+		} else if count < 0 || int64(count*size) > pageSize {
+			return fmt.Errorf("Parquet page has %d values, but is "+
+				"only %d bytes.", count, pageSize)
+		}
+
+		b := data[start:end]
+		for i := 0; i < count; i++ {
+			x := b[i*size:]
+			switch typ {
+			case parquetInt32:
+				col.ints = append(col.ints,
+					int(int32(binary.LittleEndian.Uint32(x))))
+			case parquetInt64:
+				col.ints = append(col.ints,
+					int(int64(binary.LittleEndian.Uint64(x))))
+			case parquetFloat:
+				col.floats = append(col.floats, float64(
+					math.Float32frombits(binary.LittleEndian.Uint32(x)),
+				))
+			case parquetDouble:
+				col.floats = append(col.floats, math.Float64frombits(
+					binary.LittleEndian.Uint64(x),
+				))
+			}
+		}
+		read += count
+	}
+
+	return nil
+}
+
+// parseParquet is the Parquet equivalent of parse.
+func parseParquet(data []byte, icolIdxs, fcolIdxs []int) (
+	[][]int, [][]float64, error,
+) {
+	f, err := decodeParquet(data)
+	if err != nil { return nil, nil, err }
+	return f.parse(icolIdxs, fcolIdxs)
+}
+
+///////////////////////////////
+// Thrift compact protocol //
+///////////////////////////////
+
+// Thrift compact protocol types.
+const (
+	thriftTrue       = 1
+	thriftFalse      = 2
+	thriftByte       = 3
+	thriftI16        = 4
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftDouble     = 7
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftSet        = 10
+	thriftMap        = 11
+	thriftStructType = 12
+)
+
+// thriftWriter writes structs in Thrift's compact protocol.
+type thriftWriter struct {
+	bytes.Buffer
+	lastID []int16
+}
+
+func (w *thriftWriter) last() int16 {
+	if len(w.lastID) == 0 { w.lastID = []int16{0} }
+	return w.lastID[len(w.lastID) - 1]
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	delta := id - w.last()
+	if delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta) << 4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	w.lastID[len(w.lastID) - 1] = id
+}
+
+func (w *thriftWriter) varint(x int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.Write(b[:binary.PutVarint(b, x)])
+}
+
+func (w *thriftWriter) uvarint(x uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.Write(b[:binary.PutUvarint(b, x)])
+}
+
+func (w *thriftWriter) i32(id int16, x int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(x))
+}
+
+func (w *thriftWriter) i64(id int16, x int64) {
+	w.field(id, thriftI64)
+	w.varint(x)
+}
+
+func (w *thriftWriter) rawString(s string) {
+	w.uvarint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.rawString(s)
+}
+
+func (w *thriftWriter) listHeader(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.WriteByte(byte(n) << 4 | elemType)
+	} else {
+		w.WriteByte(0xf0 | elemType)
+		w.uvarint(uint64(n))
+	}
+}
+
+// beginStruct starts a struct-valued field.
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStructType)
+	w.pushStruct()
+}
+
+// pushStruct starts a struct which is a list element.
+func (w *thriftWriter) pushStruct() {
+	w.last()
+	w.lastID = append(w.lastID, 0)
+}
+
+// endStruct ends a struct started by beginStruct or pushStruct.
+func (w *thriftWriter) endStruct() {
+	w.WriteByte(0)
+	w.lastID = w.lastID[:len(w.lastID) - 1]
+}
+
+// stop ends the outermost struct or a struct started by pushStruct.
+func (w *thriftWriter) stop() {
+	w.WriteByte(0)
+	if len(w.lastID) > 1 { w.lastID = w.lastID[:len(w.lastID) - 1] }
+}
+
+// keyValue writes a Parquet KeyValue struct as a list element.
+func (w *thriftWriter) keyValue(key, value string) {
+	w.pushStruct()
+	w.binary(1, key)
+	w.binary(2, value)
+	w.stop()
+}
+
+// thriftStruct is a decoded Thrift struct. Integers are stored as int64s,
+// binary fields as []byte, lists as []interface{}, and structs as
+// thriftStructs.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) int(id int16) int64 {
+	x, _ := s[id].(int64)
+	return x
+}
+
+func (s thriftStruct) bytes(id int16) []byte {
+	x, _ := s[id].([]byte)
+	return x
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	x, _ := s[id].([]interface{})
+	return x
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	x, _ := s[id].(thriftStruct)
+	if x == nil { return thriftStruct{} }
+	return x
+}
+
+// thriftReader reads structs in Thrift's compact protocol.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+var errThriftEOF = fmt.Errorf("Parquet metadata is truncated.")
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) { return 0, errThriftEOF }
+	r.pos++
+	return r.data[r.pos - 1], nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 { return 0, errThriftEOF }
+	r.pos += n
+	return x, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	x, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 { return 0, errThriftEOF }
+	r.pos += n
+	return x, nil
+}
+
+func (r *thriftReader) readStruct() (thriftStruct, error) {
+	s := thriftStruct{}
+	id := int16(0)
+	for {
+		b, err := r.byte()
+		if err != nil { return nil, err }
+		if b == 0 { return s, nil }
+
+		typ := b & 0x0f
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			x, err := r.varint()
+			if err != nil { return nil, err }
+			id = int16(x)
+		}
+
+		switch typ {
+		case thriftTrue:
+			s[id] = true
+		case thriftFalse:
+			s[id] = false
+		default:
+			s[id], err = r.readValue(typ)
+			if err != nil { return nil, err }
+		}
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// Booleans in lists are stored as a full byte.
+		b, err := r.byte()
+		return b == thriftTrue, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftDouble:
+		if r.pos + 8 > len(r.data) { return nil, errThriftEOF }
+		r.pos += 8
+		return math.Float64frombits(
+			binary.LittleEndian.Uint64(r.data[r.pos - 8:]),
+		), nil
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil { return nil, err }
+		if uint64(len(r.data) - r.pos) < n { return nil, errThriftEOF }
+		r.pos += int(n)
+		return r.data[r.pos - int(n): r.pos], nil
+	case thriftList, thriftSet:
+		b, err := r.byte()
+		if err != nil { return nil, err }
+		n, elemType := uint64(b >> 4), b & 0x0f
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil { return nil, err }
+		}
+		if n > uint64(len(r.data) - r.pos) { return nil, errThriftEOF }
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = r.readValue(elemType); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftMap:
+		n, err := r.uvarint()
+		if err != nil || n == 0 { return nil, err }
+		types, err := r.byte()
+		if err != nil { return nil, err }
+		for i := uint64(0); i < 2*n; i++ {
+			typ := types >> 4
+			if i % 2 == 1 { typ = types & 0x0f }
+			if _, err = r.readValue(typ); err != nil { return nil, err }
+		}
+		return nil, nil
+	case thriftStructType:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("Unknown Thrift type %d in Parquet metadata.", typ)
+}
@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testCatalog returns a small catalog used by the Parquet and HDF5 tests.
+func testCatalog() (hd *Header, icols [][]int, fcols [][]float64) {
+	hd = NewHeader(
+		"shell", []string{"ID", "Snapshot"}, []string{"X [cMpc/h]", "P_ijk"},
+		[]int{0, 1, 2, 3}, []int{1, 1, 1, 2},
+	)
+	hd.Version = "1.0.0"
+	hd.Penna = &Penna{ Order: 1, Basis: PennaBasis }
+
+	icols = [][]int{ {7, 8, 1 << 60}, {5, 6, 100} }
+	fcols = [][]float64{ {1.5, 2.5, -1}, {2, 4, 6}, {3, 5, 7} }
+	return hd, icols, fcols
+}
+
+func TestParquet(t *testing.T) {
+	hd, intCols, floatCols := testCatalog()
+	ids, snaps := intCols[0], intCols[1]
+	xs, p0, p1 := floatCols[0], floatCols[1], floatCols[2]
+	data := EncodeParquet(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+	if !IsParquet(data) {
+		t.Fatalf("EncodeParquet didn't return a Parquet file.")
+	}
+
+	ints := []Request{ {"ID", 1}, {"Snapshot", 1} }
+	floats := []Request{ {"P_ijk", 2}, {"X", 1} }
+	icols, fcols, out, err := ParseColumns(data, ints, floats)
+	if err != nil {
+		t.Fatalf("Expected successful parse, but got '%s'.", err.Error())
+	}
+
+	if out == nil || out.Mode != "shell" || out.Columns[2].Units != "cMpc/h" ||
+		out.CheckPenna(1) != nil {
+		t.Errorf("Header was parsed as %v.", out)
+	}
+	for i := range ids {
+		if icols[0][i] != ids[i] || icols[1][i] != snaps[i] {
+			t.Errorf("Expected int columns %v %v, got %v.", ids, snaps, icols)
+		}
+		if fcols[0][i] != p0[i] || fcols[1][i] != p1[i] || fcols[2][i] != xs[i] {
+			t.Errorf("Expected float columns %v %v %v, got %v.",
+				p0, p1, xs, fcols)
+		}
+	}
+
+	if _, _, err = Parse(data, []int{2}, nil); err == nil {
+		t.Errorf("Expected float column to be rejected as an int column.")
+	}
+	if _, _, err = Parse(data, nil, []int{5}); err == nil {
+		t.Errorf("Expected out of range column to be rejected.")
+	}
+	cut := append(append([]byte{}, data[:len(data)/2]...), data[len(data)-8:]...)
+	if _, _, err = Parse(cut, nil, []int{0}); err == nil {
+		t.Errorf("Expected truncated file to be rejected.")
+	}
+}
+
+// TestParquetReader reads EncodeParquet's output with parquet-go, an
+// independent Parquet implementation. It's run from testdata/parquetcheck, a
+// separate module, and the test is skipped if that module's dependencies
+// can't be downloaded.
+func TestParquetReader(t *testing.T) {
+	dir := filepath.Join("testdata", "parquetcheck")
+	goBin, err := exec.LookPath("go")
+	if err != nil { t.Skip("The go command isn't available.") }
+	download := exec.Command(goBin, "mod", "download")
+	download.Dir = dir
+	if out, err := download.CombinedOutput(); err != nil {
+		t.Skipf("parquet-go isn't available: %s", out)
+	}
+
+	tmp, err := ioutil.TempDir("", "shellfish_parquet")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(tmp)
+	fname := filepath.Join(tmp, "shell.parquet")
+
+	hd, intCols, floatCols := testCatalog()
+	data := EncodeParquet(hd, intCols, floatCols, []int{0, 1, 2, 3, 4})
+	if err = ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	check := exec.Command(goBin, "run", ".", fname)
+	check.Dir = dir
+	stderr := &bytes.Buffer{}
+	check.Stderr = stderr
+	out, err := check.Output()
+	if err != nil {
+		t.Fatalf("parquet-go couldn't read the file: %s", stderr.String())
+	}
+
+	res := struct {
+		Rows    int
+		Header  string
+		Columns []struct {
+			Name, Units string
+			Ints        []int
+			Floats      []float64
+		}
+	}{}
+	if err = json.Unmarshal(out, &res); err != nil { t.Fatal(err.Error()) }
+
+	if res.Rows != 3 || res.Header != hd.json() || len(res.Columns) != 5 {
+		t.Fatalf("parquet-go read %d rows, %d columns, and the header "+
+			"'%s'.", res.Rows, len(res.Columns), res.Header)
+	}
+	names := []string{ "ID", "Snapshot", "X", "P_ijk_0", "P_ijk_1" }
+	units := []string{ "", "", "cMpc/h", "", "" }
+	for i, col := range res.Columns {
+		var ok bool
+		if i < len(intCols) {
+			ok = intsEqualTest(col.Ints, intCols[i])
+		} else {
+			ok = floatsEqualTest(col.Floats, floatCols[i - len(intCols)])
+		}
+		if !ok || col.Name != names[i] || col.Units != units[i] {
+			t.Errorf("Column %d was read by parquet-go as %+v.", i, col)
+		}
+	}
+}
+
+func intsEqualTest(xs, ys []int) bool {
+	if len(xs) != len(ys) { return false }
+	for i := range xs {
+		if xs[i] != ys[i] { return false }
+	}
+	return true
+}
+
+func floatsEqualTest(xs, ys []float64) bool {
+	if len(xs) != len(ys) { return false }
+	for i := range xs {
+		if xs[i] != ys[i] { return false }
+	}
+	return true
+}
+
+func TestHeaderCommentString(t *testing.T) {
+	intNames := []string{"ID", "Snapshot"}
+	floatNames := []string{"X [cMpc/h]", "P_ijk"}
+	order, sizes := []int{0, 1, 2, 3}, []int{1, 1, 1, 8}
+
+	hd := NewHeader("shell", intNames, floatNames, order, sizes)
+	exp := CommentString(intNames, floatNames, order, sizes)
+	if res := hd.CommentString(); res != exp {
+		t.Errorf("Expected '%s', got '%s'.", exp, res)
+	}
+}
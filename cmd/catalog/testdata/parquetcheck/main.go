@@ -0,0 +1,87 @@
+// parquetcheck reads a Parquet file with parquet-go and prints its contents
+// as JSON. It's run by the catalog tests to check EncodeParquet against an
+// independent Parquet implementation, and lives in its own module so that
+// Shellfish doesn't depend on parquet-go.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type column struct {
+	Name   string    `json:"name"`
+	Units  string    `json:"units"`
+	Ints   []int64   `json:"ints"`
+	Floats []float64 `json:"floats"`
+}
+
+type file struct {
+	Rows    int64    `json:"rows"`
+	Header  string   `json:"header"`
+	Columns []column `json:"columns"`
+}
+
+func main() {
+	if err := run(os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil { return err }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { return err }
+
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil { return err }
+
+	out := &file{ Rows: pf.NumRows() }
+	out.Header, _ = pf.Lookup("shellfish")
+
+	for _, field := range pf.Schema().Fields() {
+		out.Columns = append(out.Columns, column{ Name: field.Name() })
+	}
+	for _, chunk := range pf.Metadata().RowGroups[0].Columns {
+		for _, kv := range chunk.MetaData.KeyValueMetadata {
+			if kv.Key != "units" { continue }
+			for i := range out.Columns {
+				path := chunk.MetaData.PathInSchema
+				if out.Columns[i].Name == path[len(path) - 1] {
+					out.Columns[i].Units = kv.Value
+				}
+			}
+		}
+	}
+
+	for _, rg := range pf.RowGroups() {
+		for i, cc := range rg.ColumnChunks() {
+			pages := cc.Pages()
+			for {
+				page, err := pages.ReadPage()
+				if err != nil { break }
+				vals := make([]parquet.Value, page.NumValues())
+				n, _ := page.Values().ReadValues(vals)
+				for _, v := range vals[:n] {
+					switch v.Kind() {
+					case parquet.Int64:
+						out.Columns[i].Ints = append(out.Columns[i].Ints, v.Int64())
+					case parquet.Double:
+						out.Columns[i].Floats = append(out.Columns[i].Floats, v.Double())
+					default:
+						return fmt.Errorf("Unexpected type %s.", v.Kind())
+					}
+				}
+			}
+			pages.Close()
+		}
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(out)
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"math"
+
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+	intr "github.com/phil-mansfield/shellfish/math/interpolate"
+	msort "github.com/phil-mansfield/shellfish/math/sort"
+)
+
+// causticRanges returns the logarithmic radial range used when searching for
+// caustics around a halo with the given R_200m.
+func (config *PhaseConfig) causticRanges(r200m float64) (lrMin, lrMax float64) {
+	return math.Log(r200m * config.causticRMinMult),
+		math.Log(r200m * config.rMaxMult)
+}
+
+// causticPixels returns the number of angular pixels used when searching for
+// caustics. Zero is returned if pixels aren't used.
+func (config *PhaseConfig) causticPixels() int {
+	if config.causticPixelLevel == 0 { return 0 }
+	return geom.SpherePixelNum(int(config.causticPixelLevel))
+}
+
+// insertCausticPoints adds the mass of every outgoing (v_r > 0) particle in
+// xs to the radial histograms of a single halo. hists[0] is the histogram of
+// the whole halo and hists[1 + p] is the histogram of the pth angular pixel.
+// The radius of hx is R_200m * RMaxMult.
+func insertCausticPoints(
+	hists [][]float64,
+	hx, hv geom.Sphere,
+	xs, vs [][3]float32,
+	ms []float32,
+	config *PhaseConfig, hd *io.Header,
+) {
+	lrMin, lrMax := config.causticRanges(float64(hx.R) / config.rMaxMult)
+	bins := int(config.rbins)
+	dlr := (lrMax - lrMin) / float64(bins)
+	rMin2, rMax2 := math.Exp(2*lrMin), math.Exp(2*lrMax)
+
+	x0, y0, z0 := hx.C[0], hx.C[1], hx.C[2]
+	vx0, vy0, vz0 := hv.C[0], hv.C[1], hv.C[2]
+	tw2 := float32(hd.TotalWidth) / 2
+	level := int(config.causticPixelLevel)
+
+	for i, vec := range xs {
+		dx := wrap(vec[0] - x0, tw2)
+		dy := wrap(vec[1] - y0, tw2)
+		dz := wrap(vec[2] - z0, tw2)
+
+		r2 := float64(dx*dx + dy*dy + dz*dz)
+		if r2 <= rMin2 || r2 >= rMax2 { continue }
+
+		vx, vy, vz := vs[i][0] - vx0, vs[i][1] - vy0, vs[i][2] - vz0
+		if vx*dx + vy*dy + vz*dz <= 0 { continue }
+
+		ir := int((math.Log(r2)/2 - lrMin) / dlr)
+		if ir == bins { ir-- }
+
+		hists[0][ir] += float64(ms[i])
+		if level > 0 {
+			r := math.Sqrt(r2)
+			phi := math.Mod(
+				math.Atan2(float64(dy), float64(dx)) + math.Pi*2, math.Pi*2,
+			)
+			th := math.Acos(float64(dz) / r)
+			hists[1 + geom.SpherePixel(phi, th, level)][ir] += float64(ms[i])
+		}
+	}
+}
+
+// findCaustic returns the radius of the steepest drop in the density of a
+// radial histogram of outgoing particles, which corresponds to the outermost
+// caustic (the first apocenter of recently accreted material). The histogram
+// is log-spaced between exp(lrMin) and exp(lrMax). The logarithmic slope is
+// smoothed with a Savitzky-Golay filter of the given window width. -1 is
+// returned if the histogram is empty.
+func findCaustic(hist []float64, lrMin, lrMax float64, window int) float64 {
+	n := len(hist)
+	dlr := (lrMax - lrMin) / float64(n)
+
+	// Empty bins are given a density a small fraction of the smallest
+	// non-empty bin so that the logarithm is finite.
+	minRho := math.Inf(+1)
+	lrhos := make([]float64, n)
+	for j := range hist {
+		rLo, rHi := math.Exp(lrMin + dlr*float64(j)), math.Exp(lrMin + dlr*float64(j + 1))
+		lrhos[j] = hist[j] / ((rHi*rHi*rHi - rLo*rLo*rLo) * 4 * math.Pi / 3)
+		if lrhos[j] > 0 && lrhos[j] < minRho { minRho = lrhos[j] }
+	}
+	if math.IsInf(minRho, +1) { return -1 }
+	for j := range lrhos {
+		lrhos[j] = math.Log(math.Max(lrhos[j], minRho/10))
+	}
+
+	if window > n {
+		window = n
+		if window%2 == 0 { window-- }
+	}
+	if window <= 4 { return -1 }
+
+	k := intr.NewSavGolDerivKernel(dlr, 1, 4, window)
+	slopes := k.Convolve(lrhos, intr.Extension)
+
+	jMin := 0
+	for j := range slopes {
+		if slopes[j] < slopes[jMin] { jMin = j }
+	}
+
+	return math.Exp(lrMin + dlr*(float64(jMin) + 0.5))
+}
+
+// processCaustics finds the caustic radius of a halo as a whole, the median
+// caustic radius across angular pixels, and the caustic radius of each pixel.
+// Pixels without a caustic are ignored by the median. If no pixels are used,
+// the median is set to the halo-wide caustic radius.
+func processCaustics(
+	hists [][]float64, r200m float64, config *PhaseConfig,
+) (r float64, rMed float64, rPix []float64) {
+	lrMin, lrMax := config.causticRanges(r200m)
+	window := int(config.causticWindow)
+
+	r = findCaustic(hists[0], lrMin, lrMax, window)
+	rPix = make([]float64, len(hists) - 1)
+	valid := []float64{}
+	for p := range rPix {
+		rPix[p] = findCaustic(hists[p + 1], lrMin, lrMax, window)
+		if rPix[p] > 0 { valid = append(valid, rPix[p]) }
+	}
+
+	switch {
+	case len(rPix) == 0:
+		rMed = r
+	case len(valid) == 0:
+		rMed = -1
+	default:
+		rMed = msort.Median(valid)
+	}
+
+	return r, rMed, rPix
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+)
+
+func TestInsertCausticPointsPeriodic(t *testing.T) {
+	config := &PhaseConfig{ rbins: 2, rMaxMult: 2, causticRMinMult: 0.5 }
+	hd := &io.Header{ TotalWidth: 100 }
+	hx := geom.Sphere{ C: [3]float32{99, 50, 50}, R: 2 }
+	hv := geom.Sphere{}
+
+	// The first two particles are on the other side of the box from the
+	// halo center, the third is inside causticRMinMult, and the fourth is
+	// infalling.
+	xs := [][3]float32{ {0.5, 50, 50}, {97.5, 50, 50},
+		{99.1, 50, 50}, {0.5, 50, 50} }
+	vs := [][3]float32{ {10, 0, 0}, {-10, 0, 0}, {10, 0, 0}, {-10, 0, 0} }
+	ms := []float32{ 1, 2, 4, 8 }
+
+	hists := [][]float64{ make([]float64, 2) }
+	insertCausticPoints(hists, hx, hv, xs, vs, ms, config, hd)
+
+	if hists[0][0] != 0 || hists[0][1] != 3 {
+		t.Errorf("Expected histogram [0 3], got %v.", hists[0])
+	}
+}
@@ -94,7 +94,7 @@ func (config *CheckConfig) Run(
 	failedTests := []string{}
 
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(int(gConfig.HSnapMax), 0), gConfig,
+		e.ParticleCatalogs(int(gConfig.HSnapMax)), gConfig,
 	)
 	if err != nil { panic(err.Error()) }
 
@@ -174,7 +174,7 @@ func particleChecks(
 
 	if len(config.particleMasses) > 0 {
 
-		_, _, ms, _, err := buf.Read(fname)
+		_, _, ms, _, err := buf.ReadFields(fname, io.Masses)
 		if err != nil {
 			return failedTests, err
 		}
@@ -259,7 +259,9 @@ func haloChecks(
 			continue
 		}
 
-		xs, _, ms, _, err := buf.Read(files[i])
+		xs, _, ms, _, err := buf.ReadFields(
+			files[i], io.Positions | io.Masses,
+		)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/phil-mansfield/shellfish/parse"
+)
+
+// CheckConfigFiles validates every section of the given config files which
+// holds a global config file or a mode's config file. It returns one line
+// describing each section and an error if any section is invalid.
+func CheckConfigFiles(fnames []string) ([]string, error) {
+	out := []string{}
+	invalid, checked := 0, 0
+
+	for _, fname := range fnames {
+		titles, err := parse.Sections(fname)
+		if err != nil {
+			out = append(out, fmt.Sprintf("%s: %s", fname, err.Error()))
+			checked, invalid = checked + 1, invalid + 1
+			continue
+		}
+
+		for _, title := range titles {
+			mode, ok := configMode(title)
+			if !ok {
+				out = append(out, fmt.Sprintf("%s [%s]: skipped, since it "+
+					"isn't a Shellfish config section.", fname, title))
+				continue
+			}
+
+			checked++
+			if err := mode.ReadConfig(fname, nil); err != nil {
+				out = append(out, fmt.Sprintf("%s [%s]: %s",
+					fname, title, err.Error()))
+				invalid++
+			} else {
+				out = append(out, fmt.Sprintf("%s [%s]: ok", fname, title))
+			}
+		}
+	}
+
+	if invalid > 0 {
+		return out, fmt.Errorf("%d of the %d config files checked are "+
+			"invalid.", invalid, checked)
+	}
+	return out, nil
+}
+
+// configMode returns the Mode which reads config files with the given section
+// title.
+func configMode(title string) (Mode, bool) {
+	if title == "config" { return &GlobalConfig{}, true }
+
+	name := strings.TrimSuffix(title, ".config")
+	if name == title { return nil, false }
+	mode, ok := ModeNames[name]
+	return mode, ok
+}
@@ -5,6 +5,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +25,8 @@ var ModeNames map[string]Mode = map[string]Mode{
 	"phase": &PhaseConfig{},
 	"check": &CheckConfig{},
 	"potential": &PotentialConfig{},
+	"cache": &CacheConfig{},
+	"provenance": &ProvenanceConfig{},
 }
 
 // Mode represents the interface used by the main binary when interacting with
@@ -66,11 +69,19 @@ type GlobalConfig struct {
 	Endianness        string
 	ValidateFormats   bool
 	Threads           int64
+	SpatialIndexCells int64
+	PrefetchDepth     int64
+	PrefetchMemory    int64
+	ChunkSize         int64
+	HighResMass       float64
 
 	Logging           string
+	OutputFormat      string
+	Seed              int64
 
 	GadgetDMTypeIndices []int64
 	GadgetSingleMassIndices []int64
+	GadgetHighResTypeIndices []int64
 	GadgetPositionUnits float64
 	GadgetMassUnits float64
 
@@ -81,6 +92,55 @@ type GlobalConfig struct {
 	NilSnapH100 float64
 	NilSnapScaleFactors []float64
 	NilSnapTotalWidth float64
+
+	TipsyTotalWidth float64
+	TipsyOmegaM float64
+	TipsyOmegaL float64
+	TipsyH100 float64
+	TipsyParticleTypes []string
+
+	RawHeaderSize int64
+	RawPositionType string
+	RawPositionOffset int64
+	RawPositionStride int64
+	RawPositionUnits float64
+	RawVelocityType string
+	RawVelocityOffset int64
+	RawVelocityStride int64
+	RawVelocityUnits float64
+	RawMassType string
+	RawMassOffset int64
+	RawMassStride int64
+	RawMassUnits float64
+	RawMass float64
+	RawIDType string
+	RawIDOffset int64
+	RawIDStride int64
+	RawCountOffset int64
+	RawCountType string
+	RawScaleFactorOffset int64
+	RawScaleFactorType string
+	RawScaleFactor float64
+	RawTotalWidth float64
+	RawOmegaM float64
+	RawOmegaL float64
+	RawH100 float64
+
+	NumPyPositionArray string
+	NumPyVelocityArray string
+	NumPyMassArray string
+	NumPyIDArray string
+	NumPyMass float64
+	NumPyTotalWidth float64
+	NumPyOmegaM float64
+	NumPyOmegaL float64
+	NumPyH100 float64
+	NumPyScaleFactor float64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
+	// provenance is set by RecordProvenance.
+	provenance *provenance
 }
 
 var _ Mode = &GlobalConfig{}
@@ -91,12 +151,15 @@ func (config *GlobalConfig) ReadConfig(fname string, flags []string) error {
 	vars := parse.NewConfigVars("config")
 	vars.String(&config.Version, "Version", version.SourceVersion)
 	vars.String(&config.SnapshotFormat, "SnapshotFormat", "")
-	vars.String(&config.SnapshotType, "SnapshotType", "")
+	vars.String(&config.SnapshotType, "SnapshotType", "").Required().
+		Enum("gotetra", "LGadget-2", "Gadget-2", "ARTIO", "Bolshoi", "BolshoiP",
+		"RAMSES", "TIPSY", "raw", "NumPy", "nil")
 	vars.String(&config.HaloDir, "HaloDir", "")
-	vars.String(&config.HaloType, "HaloType", "nil")
+	vars.String(&config.HaloType, "HaloType", "nil").Enum("Text", "nil")
 	vars.String(&config.TreeDir, "TreeDir", "")
-	vars.String(&config.TreeType, "TreeType", "nil")
-	vars.String(&config.MemoDir, "MemoDir", "")
+	vars.String(&config.TreeType, "TreeType", "nil").
+		Enum("consistent-trees", "nil")
+	vars.String(&config.MemoDir, "MemoDir", "").Required()
 
 	vars.Strings(&config.HaloValueNames, "HaloValueNames", []string{})
 	vars.Ints(&config.HaloValueColumns, "HaloValueColumns", []int64{})
@@ -113,32 +176,114 @@ func (config *GlobalConfig) ReadConfig(fname string, flags []string) error {
 	vars.Ints(&config.BlockMaxes, "BlockMaxes", []int64{})
 	vars.Int(&config.SnapMin, "SnapMin", -1)
 	vars.Int(&config.SnapMax, "SnapMax", -1)
-	vars.String(&config.Endianness, "Endianness", "SystemOrder")
+	vars.String(&config.Endianness, "Endianness", "SystemOrder").
+		Enum("SystemOrder", "LittleEndian", "BigEndian")
 	vars.Bool(&config.ValidateFormats, "ValidateFormats", false)
 
 	vars.Int(&config.Threads, "Threads", -1)
-	vars.String(&config.Logging, "Logging", "nil")
+	vars.Int(&config.SpatialIndexCells, "SpatialIndexCells", 0).Min(0)
+	vars.Int(&config.PrefetchDepth, "PrefetchDepth", 0).Min(0)
+	vars.Int(&config.PrefetchMemory, "PrefetchMemory", 0)
+	vars.Int(&config.ChunkSize, "ChunkSize", 0).Min(0)
+	vars.Float(&config.HighResMass, "HighResMass", 0).Min(0)
+	vars.String(&config.Logging, "Logging", "nil").
+		Enum("nil", "performance", "debug")
+	vars.String(&config.OutputFormat, "OutputFormat", "text")
+	vars.Int(&config.Seed, "Seed", 0)
 
 	vars.Ints(&config.GadgetDMTypeIndices,
 		"GadgetDMTypeIndices", []int64{1})
 	vars.Ints(&config.GadgetSingleMassIndices,
 		"GadgetSingleMassIndices", []int64{1})
+	vars.Ints(&config.GadgetHighResTypeIndices,
+		"GadgetHighResTypeIndices", []int64{})
 	vars.Float(&config.GadgetPositionUnits, "GadgetPositionUnits", 1.0)
 	vars.Float(&config.GadgetMassUnits, "GadgetMassUnits", 1.0)
 
-	vars.Int(&config.LGadgetNpartNum, "LGadgetNpartNum", 2)
-
-	vars.Float(&config.NilSnapOmegaM, "NilSnapOmegaM", -1)
-	vars.Float(&config.NilSnapOmegaL, "NilSnapOmegaL", -1)
-	vars.Float(&config.NilSnapH100, "NilSnapH100", -1)
-	vars.Floats(&config.NilSnapScaleFactors, "NilSnapScaleFactors", []float64{})
-	vars.Float(&config.NilSnapTotalWidth, "NilSnapTotalWidth", -1)
+	vars.Int(&config.LGadgetNpartNum, "LGadgetNpartNum", 2).Min(1).Max(2)
+
+	vars.Float(&config.NilSnapOmegaM, "NilSnapOmegaM", -1).
+		RequiredIf("SnapshotType", "nil")
+	vars.Float(&config.NilSnapOmegaL, "NilSnapOmegaL", -1).
+		RequiredIf("SnapshotType", "nil")
+	vars.Float(&config.NilSnapH100, "NilSnapH100", -1).
+		RequiredIf("SnapshotType", "nil")
+	vars.Floats(&config.NilSnapScaleFactors, "NilSnapScaleFactors",
+		[]float64{}).RequiredIf("SnapshotType", "nil")
+	vars.Float(&config.NilSnapTotalWidth, "NilSnapTotalWidth", -1).
+		RequiredIf("SnapshotType", "nil")
+
+	vars.Float(&config.TipsyTotalWidth, "TipsyTotalWidth", -1).Positive().
+		RequiredIf("SnapshotType", "TIPSY")
+	vars.Float(&config.TipsyOmegaM, "TipsyOmegaM", -1).
+		RequiredIf("SnapshotType", "TIPSY")
+	vars.Float(&config.TipsyOmegaL, "TipsyOmegaL", -1).
+		RequiredIf("SnapshotType", "TIPSY")
+	vars.Float(&config.TipsyH100, "TipsyH100", -1).
+		RequiredIf("SnapshotType", "TIPSY")
+	vars.Strings(&config.TipsyParticleTypes,
+		"TipsyParticleTypes", []string{"dark"}).Enum("dark", "star", "gas")
+
+	rawFloats := []string{"float32", "float64"}
+	rawInts := []string{"int32", "int64", "uint32", "uint64"}
+	vars.Int(&config.RawHeaderSize, "RawHeaderSize", 0).Min(0)
+	vars.String(&config.RawPositionType, "RawPositionType", "float32").
+		Enum(rawFloats...)
+	vars.Int(&config.RawPositionOffset, "RawPositionOffset", 0).Min(0)
+	vars.Int(&config.RawPositionStride, "RawPositionStride", 0).Min(0)
+	vars.Float(&config.RawPositionUnits, "RawPositionUnits", 1)
+	vars.String(&config.RawVelocityType, "RawVelocityType", "none").
+		Enum(append(rawFloats, "none")...)
+	vars.Int(&config.RawVelocityOffset, "RawVelocityOffset", 0).Min(0)
+	vars.Int(&config.RawVelocityStride, "RawVelocityStride", 0).Min(0)
+	vars.Float(&config.RawVelocityUnits, "RawVelocityUnits", 1)
+	vars.String(&config.RawMassType, "RawMassType", "none").
+		Enum(append(rawFloats, "none")...)
+	vars.Int(&config.RawMassOffset, "RawMassOffset", 0).Min(0)
+	vars.Int(&config.RawMassStride, "RawMassStride", 0).Min(0)
+	vars.Float(&config.RawMassUnits, "RawMassUnits", 1)
+	vars.Float(&config.RawMass, "RawMass", -1)
+	vars.String(&config.RawIDType, "RawIDType", "none").
+		Enum(append(rawInts, "none")...)
+	vars.Int(&config.RawIDOffset, "RawIDOffset", 0).Min(0)
+	vars.Int(&config.RawIDStride, "RawIDStride", 0).Min(0)
+	vars.Int(&config.RawCountOffset, "RawCountOffset", -1)
+	vars.String(&config.RawCountType, "RawCountType", "int64").
+		Enum(rawInts...)
+	vars.Int(&config.RawScaleFactorOffset, "RawScaleFactorOffset", -1)
+	vars.String(&config.RawScaleFactorType, "RawScaleFactorType", "float64").
+		Enum(rawFloats...)
+	vars.Float(&config.RawScaleFactor, "RawScaleFactor", -1)
+	vars.Float(&config.RawTotalWidth, "RawTotalWidth", -1).Positive().
+		RequiredIf("SnapshotType", "raw")
+	vars.Float(&config.RawOmegaM, "RawOmegaM", -1).
+		RequiredIf("SnapshotType", "raw")
+	vars.Float(&config.RawOmegaL, "RawOmegaL", -1).
+		RequiredIf("SnapshotType", "raw")
+	vars.Float(&config.RawH100, "RawH100", -1).
+		RequiredIf("SnapshotType", "raw")
+
+	vars.String(&config.NumPyPositionArray, "NumPyPositionArray", "pos")
+	vars.String(&config.NumPyVelocityArray, "NumPyVelocityArray", "vel")
+	vars.String(&config.NumPyMassArray, "NumPyMassArray", "mass")
+	vars.String(&config.NumPyIDArray, "NumPyIDArray", "id")
+	vars.Float(&config.NumPyMass, "NumPyMass", -1)
+	vars.Float(&config.NumPyTotalWidth, "NumPyTotalWidth", -1)
+	vars.Float(&config.NumPyOmegaM, "NumPyOmegaM", -1)
+	vars.Float(&config.NumPyOmegaL, "NumPyOmegaL", -1)
+	vars.Float(&config.NumPyH100, "NumPyH100", -1)
+	vars.Float(&config.NumPyScaleFactor, "NumPyScaleFactor", -1)
 
 	if err := parse.ReadConfig(fname, vars); err != nil {
 		return err
 	}
+	if err := parse.ReadFlags(flags, vars); err != nil {
+		return err
+	}
 	config.HSnapMax = config.SnapMax
 	config.HSnapMin = config.SnapMin
+	config.vars = vars
+	if config.Seed != 0 { randSeed = uint64(config.Seed) }
 	
 	return config.validate()
 }
@@ -146,6 +291,8 @@ func (config *GlobalConfig) ReadConfig(fname string, flags []string) error {
 // validate checks that all the user-generated fields of GlobalConfig are
 // properly set.
 func (config *GlobalConfig) validate() error {
+	if err := config.vars.Validate(); err != nil { return err }
+
 	major, minor, patch, err := version.Parse(config.Version)
 	if err != nil {
 		return fmt.Errorf("I couldn't parse the 'Version' variable: %s",
@@ -162,24 +309,6 @@ func (config *GlobalConfig) validate() error {
 			"the source code is %s", config.Version, version.SourceVersion)
 	}
 
-	switch config.SnapshotType {
-	case "gotetra", "LGadget-2", "Gadget-2", "ARTIO", "Bolshoi", "BolshoiP", "nil":
-	case "":
-		return fmt.Errorf("The 'SnapshotType variable isn't set.'")
-	default:
-		return fmt.Errorf("The 'SnapshotType' variable is set to '%s', "+
-			"which I don't recognize.", config.SnapshotType)
-	}
-
-	switch config.HaloType {
-	case "Text", "nil":
-	case "":
-		return fmt.Errorf("The 'HaloType' variable isn't set.'")
-	default:
-		return fmt.Errorf("The 'HaloType' variable is set to '%s', "+
-			"which I don't recognize.", config.HaloType)
-	}
-
 	if config.HaloType != "nil" {
 		
 		config.HaloPositionUnits = strings.Join(
@@ -230,15 +359,6 @@ func (config *GlobalConfig) validate() error {
 		config.HaloMassUnits = "Msun/h"
 	}
 	
-	switch config.TreeType {
-	case "consistent-trees", "nil":
-	case "":
-		return fmt.Errorf("The 'TreeType variable isn't set.'")
-	default:
-		return fmt.Errorf("The 'TreeType' variable is set to '%s', "+
-			"which I don't recognize.", config.TreeType)
-	}
-
 	if config.HaloType != "nil" {
 		if config.HaloDir == "" {
 			return fmt.Errorf("The 'HaloDir' variable isn't set.")
@@ -257,9 +377,7 @@ func (config *GlobalConfig) validate() error {
 		}
 	}
 
-	if config.MemoDir == "" {
-		return fmt.Errorf("The 'MemoDir' variable isn't set.")
-	} else if err = validateDir(config.MemoDir); err != nil {
+	if err = validateDir(config.MemoDir); err != nil {
 		return fmt.Errorf("The 'MemoDir' variable is set to '%s', but %s",
 			config.MemoDir, err.Error())
 	}
@@ -299,42 +417,55 @@ func (config *GlobalConfig) validate() error {
 		}
 	}
 
-	if config.SnapshotType == "nil" {
-		switch {
-		case config.NilSnapOmegaM == -1:
-			return fmt.Errorf(
-				"'NilSnapOmegaM' not set even though SnapshotType == 'nil'",
-			)
-		case config.NilSnapOmegaL == -1:
-			fmt.Println()
-		case config.NilSnapH100 == -1:
-			return fmt.Errorf(
-				"'NilSnapOmegaM' not set even though SnapshotType == 'nil'",
-			)
-			fmt.Println()
-		case config.NilSnapTotalWidth == -1:
-			return fmt.Errorf(
-				"'NilSnapOmegaM' not set even though SnapshotType == 'nil'",
-			)
-			fmt.Println()
-		case len(config.NilSnapScaleFactors) == 0:
-			return fmt.Errorf(
-				"'NilSnapOmegaM' not set even though SnapshotType == 'nil'",
-			)
-			fmt.Println()
+	if config.SnapshotType == "raw" {
+		if err := config.validateRaw(); err != nil { return err }
+	}
+
+	if config.SnapshotType == "NumPy" {
+		names := []string{
+			config.NumPyPositionArray, config.NumPyVelocityArray,
+			config.NumPyMassArray, config.NumPyIDArray,
+		}
+		for i := range names {
+			if names[i] != "" { continue }
+			return fmt.Errorf("The variable 'NumPy%sArray' is empty.",
+				[]string{"Position", "Velocity", "Mass", "ID"}[i])
 		}
 	}
 
+	if len(config.GadgetHighResTypeIndices) > 0 {
+		if config.SnapshotType != "Gadget-2" {
+			return fmt.Errorf("GadgetHighResTypeIndices is set, but " +
+				"SnapshotType is %s. Use HighResMass instead.",
+				config.SnapshotType)
+		} else if config.HighResMass > 0 {
+			return fmt.Errorf("The variables 'HighResMass' and " +
+				"'GadgetHighResTypeIndices' cannot both be set.")
+		}
+		for _, i := range config.GadgetHighResTypeIndices {
+			if !inIntSlice(i, config.GadgetDMTypeIndices) {
+				return fmt.Errorf("Particle type %d is in " +
+					"GadgetHighResTypeIndices but not in GadgetDMTypeIndices.", i)
+			}
+		}
+	}
 
-	switch config.Endianness {
-	case "":
-		return fmt.Errorf("The variable 'Endianness' was not set.")
-	case "LittleEndian", "BigEndian", "SystemOrder":
-	default:
-		return fmt.Errorf("The variable 'Endianness' must be sent to " +
-			"either 'SystemOrder', 'LittleEndian', or 'BigEndian'.")
+	if config.ChunkSize > 0 {
+		switch {
+		case config.PrefetchDepth > 0:
+			return fmt.Errorf("The variables 'ChunkSize' and " +
+				"'PrefetchDepth' cannot both be set.")
+		case config.SpatialIndexCells > 0:
+			return fmt.Errorf("The variables 'ChunkSize' and " +
+				"'SpatialIndexCells' cannot both be set.")
+		case !inStringSlice(config.SnapshotType, chunkedSnapshotTypes):
+			return fmt.Errorf("ChunkSize is set, but %s files can't be " +
+				"read in chunks. ChunkSize can only be used with these " +
+				"SnapshotTypes: %s.", config.SnapshotType,
+				strings.Join(chunkedSnapshotTypes, ", "))
+		}
 	}
-	
+
 	if len(config.HaloValueNames) != len(config.HaloValueColumns) {
 		return fmt.Errorf(
 			"len(HaloValueNames) = %d, but len(HaloValueColumns = %d)",
@@ -347,17 +478,15 @@ func (config *GlobalConfig) validate() error {
 		)
 	}
 
-	if config.LGadgetNpartNum > 2 || config.LGadgetNpartNum <= 0 {
-		return fmt.Errorf(
-			"GadgetNpartNum set to %d, but the only valid values are 1 and 2.",
-			config.LGadgetNpartNum,
-		)
-	}
-
 	
 	return validateFormat(config)
 }
 
+// chunkedSnapshotTypes are the values of SnapshotType whose VectorBuffers
+// read files incrementally in ReadChunks. Every other format reads the whole
+// file into memory first, so ChunkSize wouldn't limit memory usage.
+var chunkedSnapshotTypes = []string{"LGadget-2", "Gadget-2", "Bolshoi"}
+
 func inStringSlice(x string, xs []string) bool {
 	for _, xx := range xs {
 		if x == xx {
@@ -367,6 +496,28 @@ func inStringSlice(x string, xs []string) bool {
 	return false
 }
 
+func inIntSlice(x int64, xs []int64) bool {
+	for _, xx := range xs {
+		if x == xx {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRaw checks the Raw* variables which depend on one another. The
+// types, offsets, and strides of each field are checked by the schema.
+func (config *GlobalConfig) validateRaw() error {
+	if config.RawMassType == "none" && config.RawMass <= 0 {
+		return fmt.Errorf("'RawMass' must be set if RawMassType = none.")
+	}
+	if config.RawScaleFactorOffset < 0 && config.RawScaleFactor <= 0 {
+		return fmt.Errorf("Either 'RawScaleFactorOffset' or " +
+			"'RawScaleFactor' must be set if SnapshotType == 'raw'.")
+	}
+	return nil
+}
+
 // validateDir returns an error if there are any problems with the given
 // directory.
 func validateDir(name string) error {
@@ -383,10 +534,8 @@ func validateDir(name string) error {
 // validateFormat returns an error if there are any problems with the
 // given format variables.
 func validateFormat(config *GlobalConfig) error {
-	// TODO: This doesn't validate formats correctly.
-
-	// This is wrong because of "%%" specifiers.
-	specifiers := strings.Count(config.SnapshotFormat, "%")
+	specifiers := strings.Count(config.SnapshotFormat, "%") -
+		2*strings.Count(config.SnapshotFormat, "%%")
 
 	if len(config.BlockMins) != len(config.BlockMaxes) {
 		return fmt.Errorf("The lengths of the variables 'FormatMins' and" +
@@ -451,6 +600,13 @@ func validateFormat(config *GlobalConfig) error {
 			config.Logging)
 	}
 
+	switch config.OutputFormat {
+	case "text", "parquet", "hdf5":
+	default:
+		return fmt.Errorf("I don't recognize the OutputFormat '%s'. It " +
+			"must be 'text', 'parquet', or 'hdf5'.", config.OutputFormat)
+	}
+
 	return nil
 }
 
@@ -475,7 +631,9 @@ Version = %s
 # is nil, you don't need to fill out any of the Tree* variables.
 #
 # Supported SnapshotTypes: LGadget-2, gotetra, Gadget-2 (experimental),
-# ARTIO (experimental), Bolshoi (experimental), BolshoiP (experiemntal)
+# ARTIO (experimental), Bolshoi (experimental), BolshoiP (experiemntal),
+# RAMSES (experimental), TIPSY (experimental), raw (experimental),
+# NumPy (experimental)
 # Supported HaloTypes: Text, nil
 # Supported TreeTypes: consistent-trees, nil
 SnapshotType = LGadget-2
@@ -522,6 +680,15 @@ BlockMaxes = 511
 SnapMin = 0
 SnapMax = 100
 
+# Snapshots and halo catalogs may be compressed with gzip, bzip2, or zstd
+# (zstd needs the zstd command to be installed). Compression is detected from
+# the .gz, .bz2, or .zst extension or from the start of the file, so
+# SnapshotFormat should include the extension, if there is one. Compressed
+# snapshots are decompressed as they're read instead of being held in memory,
+# but formats which jump around inside a file (like raw and NumPy .npz files)
+# may need to decompress some files more than once. ARTIO filesets and merger
+# trees can't be compressed.
+
 # ScaleFactorFile should only be set if one of the elements of
 # SnapshotFormatMeanings is 'ScaleFactor'. This should point to a file which
 # contains the scale factors of your files. A file like this can usually be
@@ -547,8 +714,9 @@ TreeDir = path/to/merger/tree/dir/
 
 # A directory you create the first time you run Shellfish for a particular
 # simulation. Shellfish will cache certain partial results in this directoy.
-# Every time a value is changed in this file, you must change the location of
-# this directory.
+# Cached files record the variables and input files they were computed from,
+# so they are rebuilt automatically if you change this file or your
+# simulation files, or if a file was corrupted by a job which was killed.
 # ("memo" is a reference to the term "memoization," which is just  a fancy
 # word for caching.)
 MemoDir = path/to/memo/dir/
@@ -571,22 +739,93 @@ Endianness = SystemOrder
 # performance.
 Threads = -1
 
+# SpatialIndexCells turns on spatial indexing of particle snapshots. If it is
+# set to a positive value, the first time a snapshot block is read its particles
+# will be sorted into a grid of SpatialIndexCells^3 cells and copied into
+# MemoDir. Afterwards, the modes which analyze halos (shell, stats, prof, phase,
+# and potential) will only read the cells close to the halos they are
+# analyzing instead of reading entire blocks. This is most useful when there
+# are many fewer halos than blocks. The copies take up as much disk space as
+# the original snapshots. Indexing is turned off by default.
+# SpatialIndexCells = 0
+
+# PrefetchDepth is the number of snapshot blocks which are read in the
+# background while earlier blocks are being analyzed. Each prefetched block
+# needs its own particle buffer, so memory usage grows with PrefetchDepth.
+# Prefetching is turned off by default.
+# PrefetchDepth = 0
+
+# PrefetchMemory is the maximum number of megabytes of particles that will be
+# held in memory by prefetched blocks. At least one block will always be read,
+# regardless of this limit. If PrefetchMemory is non-positive, memory usage is
+# limited only by PrefetchDepth.
+# PrefetchMemory = 0
+
+# ChunkSize is the maximum number of particles that will be read from a
+# snapshot file at once. If ChunkSize is positive, shell, stats, prof, phase,
+# and potential will read files a chunk at a time, so peak memory usage is set
+# by ChunkSize instead of by the largest file. This is only useful for
+# snapshots with files that are too large to fit in memory. Only LGadget-2,
+# Gadget-2, and Bolshoi files can be read incrementally, so setting ChunkSize
+# for any other SnapshotType is an error. ChunkSize cannot be used alongside
+# PrefetchDepth or SpatialIndexCells. Chunking is turned off by default.
+# ChunkSize = 0
+
+# HighResMass is the mass, in Msun/h, of the high-resolution dark matter
+# particles in a zoom-in simulation. If it is set, the high-resolution region
+# is defined as the particles no more than twice as massive as HighResMass, and
+# all other particles are treated as low-resolution particles. shell will use
+# HighResMass instead of the smallest particle mass in the simulation when
+# setting kernel densities and background densities, and stats will report how
+# contaminated each halo is by low-resolution particles. HighResMass should
+# only be set for zoom-in simulations. Gadget-2 users can define the region by
+# particle type with GadgetHighResTypeIndices instead.
+# HighResMass = 0
+
 # The logging mode to be used. There are three different logging modes:
 # nil - no logging is performed.
 # performance - runtime and memory consumption logging are written to stderr.
 # debugging - debugging information is written to stderr
 Logging = nil
 
+# The format of the catalogs written to stdout. There are three formats:
+# text    - whitespace-separated columns, with comments describing each
+#           column at the top of the file.
+# parquet - an Apache Parquet file. Columns which contain multiple values, like
+#           P_ijk, are split into columns named P_ijk_0, P_ijk_1, etc. The
+#           JSON header described in the quickstart guide is stored in the
+#           file's metadata under the key "shellfish", and every column's
+#           units are stored in its metadata under the key "units".
+# hdf5    - an HDF5 file. Each column is a dataset in the root group, named
+#           the same way as the columns of Parquet files. The JSON header is
+#           stored in a scalar string dataset named "shellfish", and every
+#           dataset's units are stored in an attribute named "units".
+# Any mode can read catalogs written in any of these formats from stdin.
+# OutputFormat = text
+
+# The seed of the random number generator used by shell, prof, stats, and
+# potential. If it isn't set, a new seed is chosen every time Shellfish is run.
+# Every catalog records the seed that was used to make it, and "shellfish
+# provenance" will set this variable when it remakes a catalog.
+# Seed = 0
+
 ###############################
 ## Format-specific variables ##
 ###############################
-# If SnapshotType is set to Gadget-2, LGadget-2, or nil, extra information
-# will need to be provided to read your files.
+# If SnapshotType is set to Gadget-2, LGadget-2, TIPSY, raw, NumPy, or nil,
+# extra information will need to be provided to read your files.
 
 ###############################
 ## Gadget-specific variables ##
 ###############################
 
+# Gadget-2 files written with DOUBLEPRECISION are detected automatically. The
+# shell mode keeps their positions in double precision until they've been
+# recentered on each halo. SpatialIndexCells, PrefetchDepth, and ChunkSize
+# only work in single precision, so if any of them are set, or if another mode
+# is run, positions are rounded to float32 and a warning is printed. The same
+# is true of RAMSES files and of raw and NumPy files with float64 positions.
+
 # GadgetDMTypeIndices indicates which particle types correspond to dark matter
 # particles. For a typical uniform mass DM-only simulation, this will be 1. For
 # simulations with particles of multiple masses, more than one index may be
@@ -599,6 +838,16 @@ Logging = nil
 # SnapshotType = Gadget-2.
 # GadgetSingleMassIndices = 0, 1, 2, 3, 4, 5
 
+# GadgetHighResTypeIndices indicates which dark matter particle types make up
+# the high-resolution region of a zoom-in simulation (e.g. 1, with the
+# lower-resolution boundary particles in types 2 through 5). If it is set, the
+# smallest mass of these types is used in place of HighResMass, and any
+# particle more massive than the largest mass of these types is treated as a
+# low-resolution particle. It's an error if the first file of a snapshot
+# doesn't have any particles of these types. It cannot be set alongside
+# HighResMass. This only needs to be set for Gadget-2 zoom-in simulations.
+# GadgetHighResTypeIndices = 1
+
 # GadgetPositionUnits indicates how positions are stored within your Gadget
 # snapshot. Set this variable so the following equation is true:
 # (1 Mpc/h) * GadgetPositionUnits = (Your position units).
@@ -629,6 +878,135 @@ Logging = nil
 # fail and tell you to change this variable.
 # LGadgetNpartNum = 2
 
+########################
+## RAMSES file layout ##
+########################
+# RAMSES needs no extra variables, but SnapshotFormat must point to the
+# part_XXXXX.outNNNNN files, and each output directory must also contain its
+# info_XXXXX.txt file. Only dark matter particles are read. For example:
+#
+# SnapshotFormat = path/to/sim/output_%%05d/part_%%05d.out%%05d
+# SnapshotFormatMeanings = Snapshot, Snapshot, Block
+# BlockMins = 1
+# BlockMaxes = 64
+
+##############################
+## TIPSY-specific variables ##
+##############################
+# TIPSY files don't contain box sizes or cosmologies, so you will need to
+# provide them here. Units are assumed to be the standard PKDGRAV/ChaNGa
+# cosmological units, where the box is centered on the origin and has width
+# 1. Byte order (native or XDR) is detected automatically, so Endianness is
+# ignored. TIPSY files don't contain particle IDs, so each particle is given
+# its index within its file as an ID.
+
+# TipsyTotalWidth is the width of the box in cMpc/h.
+# TipsyTotalWidth = 100
+# TipsyOmegaM = 0.3
+# TipsyOmegaL = 0.7
+# TipsyH100 = 0.7
+
+# TipsyParticleTypes lists the types of particles which will be read. The
+# supported types are dark, star, and gas. By default, only dark particles are
+# read.
+# TipsyParticleTypes = dark
+
+############################
+## raw-specific variables ##
+############################
+# SnapshotType = raw reads simple binary files whose layout is described
+# here, which lets you read one-off formats without changing Shellfish's
+# source code. Numbers are read using the byte order given by Endianness.
+
+# RawHeaderSize is the number of bytes at the start of each file before any
+# particle data.
+# RawHeaderSize = 0
+
+# Each particle field is described by four variables. The Type is one of
+# float32 or float64 (or int32, int64, uint32, or uint64 for IDs), or none if
+# the field isn't stored in the file. The i-th particle's value starts
+# Offset + i*Stride bytes after the end of the header. A Stride of 0 means
+# the field is a contiguous array, so for a file storing all the positions
+# and then all the velocities as float32s, RawVelocityOffset would be 12
+# times the number of particles. For a file which stores each particle as an
+# (x, y, z, vx, vy, vz) struct of float32s, the offsets would be 0 and 12 and
+# both strides would be 24. Values are multiplied by the Units variables to
+# convert them to comoving Mpc/h, km/s, and Msun/h. Positions are required.
+# If masses aren't stored, every particle is given the mass RawMass (Msun/h).
+# If IDs aren't stored, each particle is given its index in the file.
+# Velocities don't have a default, so modes which need them (phase, potential,
+# BoundMass in stats, and bound-density profiles in prof) will stop with an
+# error if RawVelocityType is none.
+# RawPositionType = float32
+# RawPositionOffset = 0
+# RawPositionStride = 0
+# RawPositionUnits = 1
+# RawVelocityType = none
+# RawVelocityOffset = 0
+# RawVelocityStride = 0
+# RawVelocityUnits = 1
+# RawMassType = none
+# RawMassOffset = 0
+# RawMassStride = 0
+# RawMassUnits = 1
+# RawMass = 1e9
+# RawIDType = none
+# RawIDOffset = 0
+# RawIDStride = 0
+
+# If the header contains the number of particles in the file, set
+# RawCountOffset to its offset from the start of the file and RawCountType to
+# its type. Otherwise, the number of particles is the largest number that
+# fits in the file, so files must not contain trailing data.
+# RawCountOffset = -1
+# RawCountType = int64
+
+# If the header contains the scale factor, set RawScaleFactorOffset and
+# RawScaleFactorType like the count variables. Otherwise, every file is
+# assumed to have the scale factor RawScaleFactor.
+# RawScaleFactorOffset = -1
+# RawScaleFactorType = float64
+# RawScaleFactor = 1
+
+# The box width (in comoving Mpc/h) and cosmology must be given explicitly.
+# RawTotalWidth = 100
+# RawOmegaM = 0.3
+# RawOmegaL = 0.7
+# RawH100 = 0.7
+
+##############################
+## NumPy-specific variables ##
+##############################
+# SnapshotType = NumPy reads particles from .npz archives or .npy files. An
+# .npz file contains every array. A .npy file contains the positions, and the
+# other arrays are read from files with the same name, except that
+# "_<array name>" is added before the extension: block_0.npy would have its
+# velocities in block_0_vel.npy. Positions must be an (N, 3) array in
+# comoving Mpc/h. Velocities (in km/s), masses (in Msun/h), and IDs are
+# optional, but modes which need velocities will stop with an error if
+# there's no velocity array. float32, float64, int32, int64, uint32, and
+# uint64 arrays in either byte order are supported, so Endianness is ignored.
+
+# These are the names of the arrays.
+# NumPyPositionArray = pos
+# NumPyVelocityArray = vel
+# NumPyMassArray = mass
+# NumPyIDArray = id
+
+# If there's no mass array, every particle is given the mass NumPyMass. If
+# there's no ID array, each particle is given its index in the file as an ID.
+# NumPyMass = 1e9
+
+# If a snapshot has a JSON file with the same name, but a .json extension
+# (e.g. block_0.json), its TotalWidth, OmegaM, OmegaL, H100, and ScaleFactor
+# fields will be used. Anything missing from it (or every value, if it
+# doesn't exist) is taken from the variables below.
+# NumPyTotalWidth = 100
+# NumPyOmegaM = 0.3
+# NumPyOmegaL = 0.7
+# NumPyH100 = 0.7
+# NumPyScaleFactor = 1
+
 ##########################################
 ## nil (SnapshotType)-specifc variables ##
 ##########################################
@@ -654,5 +1032,53 @@ func (config *GlobalConfig) Run(
 	panic("GlobalConfig.Run() should never be executed.")
 }
 
+// runtimeVariables are the GlobalConfig variables which don't change the
+// contents of the files cached in MemoDir.
+var runtimeVariables = map[string]bool{
+	"MemoDir": true, "Threads": true, "ValidateFormats": true,
+	"Logging": true, "SpatialIndexCells": true, "PrefetchDepth": true,
+	"PrefetchMemory": true, "ChunkSize": true, "HighResMass": true,
+	"GadgetHighResTypeIndices": true,
+	"HaloValueComments": true, "OutputFormat": true, "Seed": true,
+}
+
+// CacheKeys returns descriptions of the variables which cached snapshot
+// files and cached halo catalogs depend on. Every variable which isn't in
+// runtimeVariables is included, so new variables invalidate the cache by
+// default.
+func (config *GlobalConfig) CacheKeys() (snapshot, halo string) {
+	snapVars, haloVars := []string{}, []string{}
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous {
+				walk(v.Field(i))
+				continue
+			}
+			if runtimeVariables[field.Name] || field.PkgPath != "" {
+				continue
+			}
+
+			s := fmt.Sprintf("%s = %#v", field.Name, v.Field(i).Interface())
+			switch {
+			case field.Name == "Version":
+				snapVars, haloVars = append(snapVars, s), append(haloVars, s)
+			case strings.HasPrefix(field.Name, "Halo"),
+				strings.HasPrefix(field.Name, "Tree"),
+				strings.HasPrefix(field.Name, "HSnap"):
+				haloVars = append(haloVars, s)
+			default:
+				snapVars = append(snapVars, s)
+			}
+		}
+	}
+	walk(reflect.ValueOf(config).Elem())
+
+	return strings.Join(snapVars, "\n"), strings.Join(haloVars, "\n")
+}
+
 // This needs to be global for debugging purposes.
 var randSeed = uint64(time.Now().UnixNano())
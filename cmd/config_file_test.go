@@ -3,6 +3,7 @@ package cmd
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -30,10 +31,38 @@ func TestExampleFiles(t *testing.T) {
 			panic(err.Error())
 		}
 
-		err = mode.ReadConfig(f.Name())
+		err = mode.ReadConfig(f.Name(), nil)
 		if err != nil {
 			t.Errorf("%d) Got error when parsing config file:\n%s",
 				i, err.Error())
 		}
 	}
 }
+
+func TestCheckConfigFiles(t *testing.T) {
+	f, err := ioutil.TempFile("", "shellfish_config_test")
+	if err != nil {
+		panic(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	text := "[shell.config]\nSmoothingWindow = 120\n\n" +
+		"[stats.config]\nMonteCarloSamples = 10\n\n[other]\nx = 1\n"
+	if _, err = f.Write([]byte(text)); err != nil {
+		panic(err.Error())
+	}
+	if err = f.Close(); err != nil {
+		panic(err.Error())
+	}
+
+	out, err := CheckConfigFiles([]string{f.Name()})
+	if err == nil {
+		t.Errorf("Expected an invalid config file to be reported.")
+	}
+	if len(out) != 3 || !strings.Contains(out[0], "must be odd") ||
+		!strings.Contains(out[0], "on line 2 of " + f.Name()) ||
+		!strings.HasSuffix(out[1], "ok") ||
+		!strings.Contains(out[2], "skipped") {
+		t.Errorf("Got unexpected output:\n%s", strings.Join(out, "\n"))
+	}
+}
@@ -16,22 +16,30 @@ import (
 
 type CoordConfig struct {
 	values []string
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &CoordConfig{}
 
 func (config *CoordConfig) ExampleConfig() string {
-	return`[config.coord]
-# Values are the names of the values you want to write to an output catalog.
-# The default order is the one which is needed by Shellfish. Any other order
-# would correspond to a catalog which is for your personal use only.
-Values = X, Y, Z, R200m
-`
+	return (&CoordConfig{}).configVars().ExampleConfig()
 }
 
-func (config *CoordConfig) ReadConfig(fname string, flags []string) error {
+// configVars registers the variables of a coord.config file.
+func (config *CoordConfig) configVars() *parse.ConfigVars {
 	vars := parse.NewConfigVars("coord.config")
-	vars.Strings(&config.values, "Values", []string{"X", "Y", "Z", "R200m"})
+	vars.Strings(&config.values, "Values", []string{"X", "Y", "Z", "R200m"}).
+		Doc(`Values are the names of the values you want to write to an output catalog.
+The default order is the one which is needed by Shellfish. Any other order
+would correspond to a catalog which is for your personal use only.`)
+	return vars
+}
+
+func (config *CoordConfig) ReadConfig(fname string, flags []string) error {
+	vars := config.configVars()
+	config.vars = vars
 
 	if fname == "" {
 		if len(flags) == 0 {
@@ -73,7 +81,7 @@ func (config *CoordConfig) Run(
 		t = time.Now()
 	}
 
-	intCols, _, err := catalog.Parse(stdin, []int{0, 1}, []int{})
+	intCols, _, _, err := catalog.ParseColumns(stdin, haloRequests, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +100,7 @@ func (config *CoordConfig) Run(
 	}
 
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
+		e.ParticleCatalogs(snaps[0]), gConfig,
 	)
 	if err != nil {
 		return nil, err
@@ -144,23 +152,23 @@ func (config *CoordConfig) Run(
 	}
 
 	colOrder := append(icolOrder, fcolOrder...)
-	lines := catalog.FormatCols(icols, fcols, colOrder)
-
-	cString := makeCommentString(gConfig, config)
+	hd := coordHeader(gConfig, config)
 
 	if logging.Mode == logging.Performance {
 		log.Printf("Time: %s", time.Since(t).String())
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(gConfig, hd, icols, fcols, colOrder), nil
 }
 
 func isIntType(comment string) bool {
 	return comment == "int" || comment == "\"int\""
 }
 
-func makeCommentString(gConfig *GlobalConfig, config *CoordConfig) string {
+func coordHeader(
+	gConfig *GlobalConfig, config *CoordConfig,
+) *catalog.Header {
 	colNames := make([]string, len(config.values))
 	for i := 0; i < len(config.values); i++ {
 		switch config.values[i] {
@@ -190,7 +198,8 @@ func makeCommentString(gConfig *GlobalConfig, config *CoordConfig) string {
 		colOrder[i], colSizes[i] = i, 1
 	}
 
-	return catalog.CommentString(
+	return catalogHeader(
+		"coord", config.vars, 0,
 		[]string{"ID", "Snapshot"}, colNames, colOrder, colSizes,
 	)
 }
@@ -24,6 +24,10 @@ const (
 	ARTIO
 	Bolshoi
 	BolshoiP
+	RAMSES
+	Tipsy
+	Raw
+	NumPy
 	Nil
 
 	Rockstar HaloType = iota
@@ -37,6 +41,10 @@ type Environment struct {
 	Catalogs
 	Halos
 	MemoDir string
+
+	// SnapshotCacheKey and HaloCacheKey describe the config variables which
+	// files cached in MemoDir depend on.
+	SnapshotCacheKey, HaloCacheKey string
 }
 
 //////////////////
@@ -150,6 +158,17 @@ func (cat *Catalogs) ParticleCatalog(snap, block int) string {
 	return cat.names[snap-cat.snapMin][block]
 }
 
+// ParticleCatalogs returns the names of every block of the given snapshot.
+func (cat *Catalogs) ParticleCatalogs(snap int) []string {
+	return cat.names[snap-cat.snapMin]
+}
+
+// HasParticleCatalog returns true if the config file lists particle files
+// for the given snapshot.
+func (cat *Catalogs) HasParticleCatalog(snap int) bool {
+	return snap >= cat.snapMin && snap - cat.snapMin < len(cat.names)
+}
+
 ///////////
 // Halos //
 ///////////
@@ -166,6 +185,12 @@ func (h *Halos) HaloCatalog(snap int) string {
 	return h.names[snap-h.snapMin]
 }
 
+// HasHaloCatalog returns true if the config file lists a halo catalog for
+// the given snapshot.
+func (h *Halos) HasHaloCatalog(snap int) bool {
+	return snap >= h.snapMin && snap - h.snapMin < len(h.names)
+}
+
 func (h *Halos) SnapOffset() int {
 	return h.snapOffset
 }
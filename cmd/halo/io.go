@@ -1,8 +1,10 @@
 package halo
 
 import (
+	"bytes"
 	"encoding/binary"
-	"os"
+	"fmt"
+	"math"
 	"sort"
 
 	"github.com/phil-mansfield/shellfish/cmd/catalog"
@@ -97,9 +99,11 @@ func (hs *halos) Swap(i, j int) {
 	hs.rids[i], hs.rids[j] = hs.rids[j], hs.rids[i]
 }
 
+// RockstarConvert reads the columns in vars from a text halo catalog and
+// returns them in the binary format read by ReadBinaryRockstar.
 func RockstarConvert(
-	inFile, outFile string, vars *VarColumns, cosmo *io.CosmologyHeader,
-) error {
+	inFile string, vars *VarColumns, cosmo *io.CosmologyHeader,
+) ([]byte, error) {
 	valIdxs := vars.Columns
 	for i := range valIdxs {
 		if valIdxs[i] == -1 {
@@ -110,27 +114,28 @@ func RockstarConvert(
 
 	cols, err := readTable(inFile, valIdxs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	f, err := os.Create(outFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	
-	err = binary.Write(f, binary.LittleEndian, int64(len(cols[0])))
+	return encodeBinaryRockstar(cols)
+}
+
+// encodeBinaryRockstar encodes halo catalog columns as a count followed by
+// each column.
+func encodeBinaryRockstar(cols [][]float64) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, int64(len(cols[0])))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, col := range cols {
-		err := binary.Write(f, binary.LittleEndian, col)
+		err := binary.Write(buf, binary.LittleEndian, col)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return buf.Bytes(), nil
 }
 
 type idxSet struct {
@@ -160,9 +165,11 @@ func idxSort(xs []float64) []int {
 	return idxs
 }
 
+// RockstarConvertTopN is identical to RockstarConvert, except that only the
+// n halos with the largest M200m are returned.
 func RockstarConvertTopN(
-	inFile, outFile string, n int, vars *VarColumns, cosmo *io.CosmologyHeader,
-) error {
+	inFile string, n int, vars *VarColumns, cosmo *io.CosmologyHeader,
+) ([]byte, error) {
 	valIdxs := vars.Columns
 	for i := range valIdxs {
 		if valIdxs[i] == -1 {
@@ -173,7 +180,7 @@ func RockstarConvertTopN(
 
 	cols, err := readTable(inFile, valIdxs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if n > len(cols[0]) {
@@ -193,74 +200,49 @@ func RockstarConvertTopN(
 		}
 	}
 
-	f, err := os.Create(outFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	err = binary.Write(f, binary.LittleEndian, int64(n))
-	if err != nil {
-		return err
-	}
-	for _, col := range outCols {
-		err := binary.Write(f, binary.LittleEndian, col)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return encodeBinaryRockstar(outCols)
 }
 
+// ReadBinaryRockstar reads a halo catalog returned by RockstarConvert.
 func ReadBinaryRockstar(
-	file string, vc *VarColumns,
+	data []byte, vc *VarColumns,
 ) (ids []int, rawCols [][]float64, err error) {
-	return readRockstarVals(file, binaryColGetter, vc)
+	return readRockstarVals(data, binaryColGetter, vc)
 }
 
 func readRockstarVals(
-	file string, getter colGetter, vc *VarColumns,
+	data []byte, getter colGetter, vc *VarColumns,
 ) (ids []int, rawCols [][]float64, err error) {
 	
 	colIdxs := make([]int, vc.NBinary)
 	for i := range colIdxs { colIdxs[i] = i }
 
-	rawCols, err = getter(file, colIdxs)
+	rawCols, err = getter(data, colIdxs)
 	if err != nil { return nil, nil, err }
 
 	ids = vc.GetIDs(rawCols)
 	return ids, rawCols, err
 }
 
-type colGetter func(file string, colIdxs []int) ([][]float64, error)
+type colGetter func(data []byte, colIdxs []int) ([][]float64, error)
 
-func binaryColGetter(file string, colIdxs []int) ([][]float64, error) {
-	// TODO: make this not slow as molasses. Just read everything in one go.
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+func binaryColGetter(data []byte, colIdxs []int) ([][]float64, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("Binary halo catalog is truncated.")
 	}
+	n := int64(binary.LittleEndian.Uint64(data))
 
-	n := int64(0)
-	err = binary.Read(f, binary.LittleEndian, &n)
-	if err != nil {
-		return nil, err
-	}
-	
-	jump := n * 8
 	cols := make([][]float64, len(colIdxs))
-	for i := range cols {
-		cols[i] = make([]float64, n)
-	}
 	for i, colIdx := range colIdxs {
-		_, err = f.Seek(8+jump*int64(colIdx), 0)
-		if err != nil {
-			return nil, err
+		start := 8 + n*8*int64(colIdx)
+		if n < 0 || start + n*8 > int64(len(data)) {
+			return nil, fmt.Errorf("Binary halo catalog is truncated.")
 		}
-		err = binary.Read(f, binary.LittleEndian, cols[i])
-		if err != nil {
-			return nil, err
+
+		cols[i] = make([]float64, n)
+		for j := range cols[i] {
+			bits := binary.LittleEndian.Uint64(data[start + int64(j)*8:])
+			cols[i][j] = math.Float64frombits(bits)
 		}
 	}
 	return cols, nil
@@ -26,6 +26,9 @@ type IDConfig struct {
 
 	exclusionStrategy          string
 	exclusionRadiusMult        float64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &IDConfig{}
@@ -101,15 +104,17 @@ IDs = 10, 11, 12, 13, 14
 func (config *IDConfig) ReadConfig(fname string, flags []string) error {
 
 	vars := parse.NewConfigVars("id.config")
-	vars.String(&config.idType, "IDType", "m200m")
+	config.vars = vars
+	vars.String(&config.idType, "IDType", "m200m").Enum("halo-id", "m200m")
 	vars.Ints(&config.ids, "IDs", []int64{})
 	vars.Int(&config.idStart, "IDStart", -1)
 	vars.Int(&config.idEnd, "IDEnd", -1)
-	vars.Int(&config.mult, "Mult", 1)
-	vars.Int(&config.snap, "Snap", -1)
-	vars.String(&config.exclusionStrategy, "ExclusionStrategy", "overlap")
+	vars.Int(&config.mult, "Mult", 1).Min(1)
+	vars.Int(&config.snap, "Snap", -1).Required().Min(0)
+	vars.String(&config.exclusionStrategy, "ExclusionStrategy", "overlap").
+		Enum("none", "subhalo", "overlap", "neighbor")
 	vars.Float(&config.exclusionRadiusMult, "ExclusionRadiusMult", 1)
-	vars.Float(&config.m200mMax, "M200mMax", 0)
+	vars.Float(&config.m200mMax, "M200mMax", 0).Min(0)
 	vars.Float(&config.m200mMin, "M200mMin", 0)
 
 	if fname == "" {
@@ -134,41 +139,18 @@ func (config *IDConfig) ReadConfig(fname string, flags []string) error {
 
 // validate checks whether all the fields of config are valid.
 func (config *IDConfig) validate() error {
-	switch config.idType {
-	case "halo-id", "m200m":
-	default:
-		return fmt.Errorf("The 'IDType' variable is set to '%s', which I "+
-			"don't recognize.", config.idType)
-	}
-
-	switch config.exclusionStrategy {
-	case "none", "subhalo", "neighbor":
-	case "overlap":
-		if config.exclusionRadiusMult <= 0 {
-			return fmt.Errorf("The 'ExclusionRadiusMult' varaible is set to "+
-				"%g, but it needs to be positive.", config.exclusionRadiusMult)
-		}
-	default:
-		return fmt.Errorf("The 'ExclusionStrategy' variable is set to '%s', "+
-			"which I don't recognize.", config.exclusionStrategy)
-	}
-
-	switch {
-	case config.snap == -1:
-		return fmt.Errorf("'Snap' variable not set.")
-	case config.snap < 0:
-		return fmt.Errorf("'Snap' variable set to %d.", config.snap)
-	}
+	if err := config.vars.Validate(); err != nil { return err }
 
-	if config.mult <= 0 {
-		return fmt.Errorf("'Mult' variable set to %d", config.mult)
+	if config.exclusionStrategy == "overlap" &&
+		config.exclusionRadiusMult <= 0 {
+		return fmt.Errorf("The 'ExclusionRadiusMult' varaible is set to "+
+			"%g, but it needs to be positive.", config.exclusionRadiusMult)
 	}
 
-	switch {
-	case config.m200mMax < 0:
-		return fmt.Errorf("M200mStart set to %g", config.m200mMax)
-	case config.m200mMax > config.m200mMin:
-		fmt.Errorf("M200mEnd smaller than M200mStart")
+	if config.m200mMax > 0 && config.m200mMax < config.m200mMin {
+		return fmt.Errorf("The variable 'M200mMax' was set to %g, but the "+
+			"variable 'M200mMin' was set to %g.",
+			config.m200mMax, config.m200mMin)
 	}
 
 	return nil
@@ -238,7 +220,7 @@ func (config *IDConfig) Run(
 
 		var err error
 		buf, err = getVectorBuffer(
-			e.ParticleCatalog(snaps[0], 0), gConfig,
+			e.ParticleCatalogs(snaps[0]), gConfig,
 		)
 		if err != nil {
 			return nil, err
@@ -251,7 +233,7 @@ func (config *IDConfig) Run(
 
 		var err error
 		buf, err = getVectorBuffer(
-			e.ParticleCatalog(snaps[0], 0), gConfig,
+			e.ParticleCatalogs(snaps[0]), gConfig,
 		)
 		if err != nil {
 			return nil, err
@@ -290,32 +272,23 @@ func (config *IDConfig) Run(
 		}
 	}
 	
-	// Generate lines
-	intCols := [][]int{ids, snaps}
-	floatCols := [][]float64{}
-	colOrder := []int{0, 1}
-	lines := catalog.FormatCols(intCols, floatCols, colOrder)
-
-	// Filter
-	fLines := []string{}
-	for i := range lines {
-		if !exclude[i] {
-			fLines = append(fLines, lines[i])
-		}
-	}
-
-	// Multiply
-	mLines := []string{}
-	for i := range fLines {
+	// Filter and multiply
+	mIDs, mSnaps := []int{}, []int{}
+	for i := range ids {
+		if exclude[i] { continue }
 		for j := 0; j < int(config.mult); j++ {
-			mLines = append(mLines, fLines[i])
+			mIDs = append(mIDs, ids[i])
+			mSnaps = append(mSnaps, snaps[i])
 		}
 	}
 
-	cString := catalog.CommentString(
+	hd := catalogHeader(
+		"id", config.vars, 0,
 		[]string{"ID", "Snapshot"}, []string{}, []int{0, 1}, []int{1, 1},
 	)
-	mLines = append([]string{cString}, mLines...)
+	mLines := formatCatalog(
+		gConfig, hd, [][]int{mIDs, mSnaps}, [][]float64{}, []int{0, 1},
+	)
 
 	if logging.Mode == logging.Performance {
 		log.Printf("Time: %s", time.Since(t).String())
@@ -333,7 +306,7 @@ func getMassIDRange(
 ) error {
 	config.idType = "m200m"
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(int(config.snap), 0), gConfig,
+		e.ParticleCatalogs(int(config.snap)), gConfig,
 	)
 
 	if err != nil { return err }
@@ -378,7 +351,9 @@ func getIDs(idStart, idEnd int64, ids []int64, stdin []byte) ([]int, error) {
 		}
 		return out, nil
 	} else {
-		intCols, _, err := catalog.Parse(stdin, []int{0}, []int{})
+		intCols, _, _, err := catalog.ParseColumns(
+			stdin, haloRequests[:1], nil,
+		)
 		if err != nil {
 			return nil, err
 		}
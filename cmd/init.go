@@ -0,0 +1,566 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/version"
+)
+
+// InitConfig writes a global config file for the snapshots in snapDir and
+// the halo catalogs in haloDir. If haloDir is "", HaloType is set to nil. The
+// text of the config file is returned along with notes on any guesses that
+// the user should check.
+func InitConfig(snapDir, haloDir string) (string, []string, error) {
+	snapDir, err := filepath.Abs(snapDir)
+	if err != nil { return "", nil, err }
+
+	layout, err := findSnapshotLayout(snapDir)
+	if err != nil { return "", nil, err }
+	notes := layout.notes
+
+	lines := []string{
+		"[config]",
+		"# Generated by 'shellfish init'. Type 'shellfish help config' for " +
+			"a\n# description of every variable.",
+		"Version = " + version.SourceVersion,
+	}
+
+	formatLines, formatNotes := formatVariables(layout.detected, layout.example)
+	lines = append(lines, strings.Join(formatLines, "\n"))
+	notes = append(notes, formatNotes...)
+	lines = append(lines, layout.variables(snapDir))
+
+	if haloDir == "" {
+		lines = append(lines, "HaloType = nil\nTreeType = nil")
+	} else {
+		haloLines, haloNotes, err := haloVariables(haloDir)
+		if err != nil { return "", nil, err }
+		lines = append(lines, haloLines)
+		notes = append(notes, haloNotes...)
+	}
+
+	memoDir, err := filepath.Abs("shellfish_memo")
+	if err != nil { return "", nil, err }
+	memoLine := fmt.Sprintf("# Shellfish caches files here to speed up "+
+		"later runs. This directory must\n# exist before Shellfish is run."+
+		"\nMemoDir = %s", memoDir)
+
+	// The config is checked with a stand-in MemoDir if the real one hasn't
+	// been made yet, since init doesn't write to the file system.
+	checkLine := memoLine
+	if _, err := os.Stat(memoDir); err != nil {
+		notes = append(notes, fmt.Sprintf("MemoDir is set to %s, which "+
+			"doesn't exist yet. Create it with 'mkdir -p %s' or point "+
+			"MemoDir somewhere else before running Shellfish.",
+			memoDir, memoDir))
+		checkLine = "MemoDir = " + os.TempDir()
+	}
+
+	text := strings.Join(append(lines, memoLine), "\n\n") + "\n"
+	checkText := strings.Join(append(lines, checkLine), "\n\n") + "\n"
+	if err = checkGeneratedConfig(checkText); err != nil {
+		notes = append(notes, fmt.Sprintf("The generated config file isn't "+
+			"valid yet: %s", err.Error()))
+	}
+
+	return text, notes, nil
+}
+
+// checkGeneratedConfig checks that the text of a generated config file can
+// be read.
+func checkGeneratedConfig(text string) error {
+	f, err := ioutil.TempFile("", "shellfish_init")
+	if err != nil { return err }
+	defer os.Remove(f.Name())
+	if _, err = f.Write([]byte(text)); err != nil { return err }
+	if err = f.Close(); err != nil { return err }
+
+	return (&GlobalConfig{}).ReadConfig(f.Name(), nil)
+}
+
+// snapshotLayout describes how the particle files of a simulation are laid
+// out on disk.
+type snapshotLayout struct {
+	detected *io.Format
+	example  string
+	// format is the SnapshotFormat of the files relative to the snapshot
+	// directory.
+	format   string
+	meanings []string
+
+	snapMin, snapMax      int
+	blockMins, blockMaxes []int
+	notes                 []string
+}
+
+// numberRegexp matches the numbers within file names.
+var numberRegexp = regexp.MustCompile("[0-9]+")
+
+// fileName is the name of a particle file split into its numbers and the
+// text between them.
+type fileName struct {
+	path    string
+	literal []string
+	numbers []string
+}
+
+func splitFileName(path string) fileName {
+	locs := numberRegexp.FindAllStringIndex(path, -1)
+	name := fileName{ path: path }
+	start := 0
+	for _, loc := range locs {
+		name.literal = append(name.literal, path[start:loc[0]])
+		name.numbers = append(name.numbers, path[loc[0]:loc[1]])
+		start = loc[1]
+	}
+	name.literal = append(name.literal, path[start:])
+	return name
+}
+
+// findSnapshotLayout finds the particle files in snapDir and works out the
+// SnapshotFormat which describes them. Files are grouped by the text between
+// the numbers in their names and the largest group of particle files is
+// used.
+func findSnapshotLayout(snapDir string) (*snapshotLayout, error) {
+	groups := map[string][]fileName{}
+	err := filepath.Walk(snapDir, func(
+		path string, info os.FileInfo, err error,
+	) error {
+		if err != nil { return err }
+		if strings.HasPrefix(info.Name(), ".") && path != snapDir {
+			if info.IsDir() { return filepath.SkipDir }
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() { return nil }
+
+		rel, err := filepath.Rel(snapDir, path)
+		if err != nil { return err }
+		name := splitFileName(rel)
+		key := strings.Join(name.literal, "\x00")
+		groups[key] = append(groups[key], name)
+		return nil
+	})
+	if err != nil { return nil, err }
+
+	keys := []string{}
+	for key := range groups { keys = append(keys, key) }
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var detectErr error
+	for _, key := range keys {
+		names := groups[key]
+		sort.Slice(names, func(i, j int) bool {
+			return names[i].path < names[j].path
+		})
+		example := filepath.Join(snapDir, names[0].path)
+		format, err := io.DetectFormat(example)
+		if err != nil {
+			if detectErr == nil { detectErr = err }
+			continue
+		}
+		layout, err := newSnapshotLayout(names, format)
+		if err != nil { return nil, err }
+		layout.example = example
+		return layout, nil
+	}
+
+	if detectErr == nil {
+		return nil, fmt.Errorf("There aren't any files in %s.", snapDir)
+	}
+	return nil, fmt.Errorf("I couldn't find any particle files in %s. %s",
+		snapDir, detectErr.Error())
+}
+
+// newSnapshotLayout works out the SnapshotFormat which describes a group of
+// file names that only differ in their numbers. Numbers which are the same in
+// every file are kept as they are. Numbers which always equal each other are
+// given the same meaning. The first of these is the snapshot and the rest are
+// blocks.
+func newSnapshotLayout(
+	names []fileName, format *io.Format,
+) (*snapshotLayout, error) {
+	n := len(names[0].numbers)
+	values := make([][]int, n)
+	for i := range values {
+		values[i] = make([]int, len(names))
+		for j := range names {
+			values[i][j], _ = strconv.Atoi(names[j].numbers[i])
+		}
+	}
+
+	// classes[i] is the index of the first number which always equals
+	// number i, or -1 if number i is the same in every file.
+	classes := make([]int, n)
+	order := []int{}
+	for i := range classes {
+		classes[i] = -1
+		if isConstant(values[i]) { continue }
+		for _, k := range order {
+			if intsEqual(values[k], values[i]) { classes[i] = k }
+		}
+		if classes[i] == -1 {
+			classes[i] = i
+			order = append(order, i)
+		}
+	}
+
+	layout := &snapshotLayout{ detected: format }
+	snapClass, blockClasses := -1, order
+	switch {
+	case len(order) == 0 || (len(order) == 1 && format.NumFiles > 1 &&
+		format.NumFiles == len(names)):
+		// Only one snapshot, so its index is one of the constant numbers.
+		// The last one before the blocks is the most likely.
+		end := n
+		if len(order) == 1 { end = order[0] }
+		for i := end - 1; i >= 0; i-- {
+			if classes[i] == -1 { snapClass = i; break }
+		}
+		if snapClass == -1 {
+			return nil, fmt.Errorf("I couldn't find the snapshot index in "+
+				"the name of %s.", names[0].path)
+		}
+		// Directory names often repeat the snapshot index.
+		for i := range classes {
+			if classes[i] == -1 &&
+				names[0].numbers[i] == names[0].numbers[snapClass] {
+				classes[i] = snapClass
+			}
+		}
+	default:
+		snapClass, blockClasses = order[0], order[1:]
+	}
+
+	layout.snapMin, layout.snapMax = intRange(values[snapClass])
+	for _, k := range blockClasses {
+		lo, hi := intRange(values[k])
+		layout.blockMins = append(layout.blockMins, lo)
+		layout.blockMaxes = append(layout.blockMaxes, hi)
+	}
+
+	for i := 0; i < n; i++ {
+		layout.format += escapePercent(names[0].literal[i])
+		if classes[i] == -1 {
+			layout.format += names[0].numbers[i]
+			continue
+		}
+
+		layout.format += specifier(names, i)
+		switch {
+		case classes[i] == snapClass:
+			layout.meanings = append(layout.meanings, "Snapshot")
+		case len(blockClasses) == 1:
+			layout.meanings = append(layout.meanings, "Block")
+		default:
+			for b, k := range blockClasses {
+				if classes[i] == k {
+					layout.meanings = append(layout.meanings,
+						fmt.Sprintf("Block%d", b))
+				}
+			}
+		}
+	}
+	layout.format += escapePercent(names[0].literal[n])
+
+	layout.checkComplete(len(names), values[snapClass])
+	return layout, nil
+}
+
+// escapePercent escapes the '%' characters in a file name so that it can be
+// used in SnapshotFormat.
+func escapePercent(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
+
+// checkComplete adds notes if the snapshot directory is missing any of the
+// files described by the layout.
+func (layout *snapshotLayout) checkComplete(files int, snaps []int) {
+	distinct := map[int]bool{}
+	for _, snap := range snaps { distinct[snap] = true }
+	if len(distinct) != layout.snapMax - layout.snapMin + 1 {
+		layout.notes = append(layout.notes, fmt.Sprintf("Only %d of the "+
+			"snapshots between %d and %d were found. Shellfish will fail "+
+			"if you analyze a missing one.", len(distinct), layout.snapMin,
+			layout.snapMax))
+	}
+
+	expected := len(distinct)
+	for i := range layout.blockMins {
+		expected *= layout.blockMaxes[i] - layout.blockMins[i] + 1
+	}
+	if expected != files {
+		layout.notes = append(layout.notes, fmt.Sprintf("I expected %d "+
+			"particle files, but found %d. Some snapshots might be "+
+			"missing blocks.", expected, files))
+	}
+}
+
+// variables returns the config variables which describe the layout.
+func (layout *snapshotLayout) variables(snapDir string) string {
+	lines := []string{
+		"# Inferred from the names of the files in the snapshot directory.",
+		"SnapshotFormat = " + filepath.Join(snapDir, layout.format),
+		"SnapshotFormatMeanings = " + strings.Join(layout.meanings, ", "),
+		fmt.Sprintf("SnapMin = %d", layout.snapMin),
+		fmt.Sprintf("SnapMax = %d", layout.snapMax),
+	}
+	if len(layout.blockMins) > 0 {
+		lines = append(lines,
+			"BlockMins = " + joinInts(layout.blockMins),
+			"BlockMaxes = " + joinInts(layout.blockMaxes))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// specifier returns the format specifier for number i of a group of file
+// names. Numbers are zero-padded if they always have the same width and
+// at least one starts with a zero.
+func specifier(names []fileName, i int) string {
+	width, padded := len(names[0].numbers[i]), false
+	for _, name := range names {
+		num := name.numbers[i]
+		if len(num) != width { return "%d" }
+		if len(num) > 1 && num[0] == '0' { padded = true }
+	}
+	if padded { return fmt.Sprintf("%%0%dd", width) }
+	return "%d"
+}
+
+// formatVariables returns the config variables which describe the format of
+// the particle files, along with notes on any guesses.
+func formatVariables(format *io.Format, example string) ([]string, []string) {
+	lines := []string{
+		fmt.Sprintf("# Detected from the contents of %s.", example),
+		"SnapshotType = " + format.SnapshotType,
+	}
+	notes := []string{}
+
+	switch format.Order {
+	case binary.LittleEndian:
+		lines = append(lines, "Endianness = LittleEndian")
+	case binary.BigEndian:
+		lines = append(lines, "Endianness = BigEndian")
+	}
+
+	switch format.SnapshotType {
+	case "Gadget-2":
+		if format.BoxSize > 5000 {
+			lines = append(lines, "# The box size suggests that positions "+
+				"are in kpc/h.", "GadgetPositionUnits = 0.001")
+			notes = append(notes, fmt.Sprintf("The box width is %g, so I "+
+				"guessed that positions are in kpc/h.", format.BoxSize))
+		}
+		for _, m := range format.Mass {
+			if m <= 0 { continue }
+			if m < 1e3 {
+				lines = append(lines, "# The particle masses suggest that "+
+					"masses are in 10^10 Msun/h.", "GadgetMassUnits = 1e10")
+				notes = append(notes, fmt.Sprintf("The particle mass is "+
+					"%g, so I guessed that masses are in 10^10 Msun/h.", m))
+			}
+			break
+		}
+		if format.NPart[1] == 0 {
+			notes = append(notes, "There aren't any type 1 particles in "+
+				"the first file, so you might need to set "+
+				"GadgetDMTypeIndices.")
+		}
+	case "LGadget-2":
+		lines = append(lines, fmt.Sprintf("LGadgetNpartNum = %d",
+			format.LGadgetNpartNum))
+	case "TIPSY":
+		lines = append(lines, "# TIPSY files don't contain the box size or "+
+			"cosmology, so these\n# need to be set by hand.",
+			"# TipsyTotalWidth = 100", "# TipsyOmegaM = 0.27",
+			"# TipsyOmegaL = 0.73", "# TipsyH100 = 0.7")
+		notes = append(notes, "TIPSY files don't contain the box size or "+
+			"cosmology, so you'll need to set TipsyTotalWidth, TipsyOmegaM, "+
+			"TipsyOmegaL, and TipsyH100 by hand.")
+	}
+
+	return lines, notes
+}
+
+// haloNames maps the lower-case column names used by common halo finders
+// onto the names used by Shellfish.
+var haloNames = map[string]string{
+	"id": "ID", "x": "X", "y": "Y", "z": "Z",
+	"vx": "Vx", "vy": "Vy", "vz": "Vz",
+	"m200m": "M200m", "m200b": "M200m", "r200m": "R200m", "r200b": "R200m",
+	"m200c": "M200c", "r200c": "R200c", "m500c": "M500c", "r500c": "R500c",
+	"m2500c": "M2500c", "r2500c": "R2500c",
+}
+
+// haloColumnRegexp matches the column numbers that some halo finders add to
+// the names in their headers, e.g. "x(17)".
+var haloColumnRegexp = regexp.MustCompile(`\([0-9]+\)$`)
+
+// haloVariables returns the config variables which describe the halo
+// catalogs in haloDir, along with notes on any guesses. Column names are
+// read from the header of the last catalog. Like particle files, hidden
+// files and anything which isn't a regular file are skipped.
+func haloVariables(haloDir string) (string, []string, error) {
+	haloDir, err := filepath.Abs(haloDir)
+	if err != nil { return "", nil, err }
+	infos, err := ioutil.ReadDir(haloDir)
+	if err != nil { return "", nil, err }
+
+	fname := ""
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") ||
+			!info.Mode().IsRegular() { continue }
+		fname = filepath.Join(haloDir, info.Name())
+	}
+	if fname == "" {
+		return "", nil, fmt.Errorf("There aren't any halo catalogs in %s.",
+			haloDir)
+	}
+
+	header, firstRow, err := readHaloHeader(fname)
+	if err != nil { return "", nil, err }
+
+	names, columns, comments := []string{}, []string{}, []string{}
+	rockstar := false
+	found := map[string]int{}
+	for i, col := range header {
+		col = haloColumnRegexp.ReplaceAllString(col, "")
+		switch strings.ToLower(col) {
+		case "rvir", "desc_id", "scale": rockstar = true
+		}
+
+		name, ok := haloNames[strings.ToLower(col)]
+		if !ok { continue }
+		if _, ok = found[name]; ok { continue }
+		found[name] = i
+		names = append(names, name)
+		columns = append(columns, strconv.Itoa(i))
+		comments = append(comments, col)
+	}
+
+	for _, name := range []string{"ID", "X", "Y", "Z", "M200m"} {
+		if _, ok := found[name]; ok { continue }
+		return "", nil, fmt.Errorf("The header of %s doesn't have a column "+
+			"for %s. Column names were read from its first line: %s",
+			fname, name, strings.Join(header, " "))
+	}
+
+	lines := []string{
+		fmt.Sprintf("# Read from the header of %s.", fname),
+		"HaloType = Text",
+		"HaloDir = " + haloDir,
+	}
+	notes := []string{}
+	if _, ok := found["R200m"]; !ok {
+		// id, shell, prof, and stats all need R200m. Halo catalogs
+		// without it, like Rockstar's, get it from M200m instead.
+		lines = append(lines, "# There isn't an R200m column, so R200m "+
+			"is computed from M200m.")
+		notes = append(notes, fmt.Sprintf("%s doesn't have an R200m "+
+			"column, so Shellfish will compute R200m from M200m and the "+
+			"cosmology of the particle files. This is only correct if "+
+			"M200m is in Msun/h.", fname))
+	}
+	posUnits, radUnits := "cMpc/h", "cMpc/h"
+	if rockstar {
+		radUnits = "ckpc/h"
+	} else {
+		if i, ok := found["R200m"]; ok && i < len(firstRow) {
+			if r, err := strconv.ParseFloat(firstRow[i], 64); err == nil &&
+				r > 10 {
+				radUnits = "ckpc/h"
+			}
+		}
+		notes = append(notes, fmt.Sprintf("I don't recognize the halo "+
+			"finder which wrote %s, so I guessed HaloPositionUnits = %s and "+
+			"HaloRadiusUnits = %s.", fname, posUnits, radUnits))
+	}
+
+	lines = append(lines,
+		"HaloValueNames = " + strings.Join(names, ", "),
+		"HaloValueColumns = " + strings.Join(columns, ", "),
+		"HaloValueComments = " + strings.Join(comments, ", "),
+		"HaloPositionUnits = " + posUnits,
+		"HaloRadiusUnits = " + radUnits,
+		"HaloMassUnits = Msun/h",
+		"TreeType = nil",
+		"# TreeDir must be set whenever halos are used, but it's only read "+
+			"by the\n# tree tool. Point it at your merger trees if you have "+
+			"them.",
+		"TreeDir = " + haloDir,
+	)
+
+	return strings.Join(lines, "\n"), notes, nil
+}
+
+// readHaloHeader returns the column names in the header of a text halo
+// catalog and the first row of the table.
+func readHaloHeader(fname string) (header, firstRow []string, err error) {
+	f, err := os.Open(fname)
+	if err != nil { return nil, nil, err }
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<16), 1<<24)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" { continue }
+		if !strings.HasPrefix(line, "#") {
+			firstRow = strings.Fields(line)
+			break
+		}
+		if header == nil {
+			header = strings.Fields(strings.TrimLeft(line, "#"))
+		}
+	}
+	if err = scanner.Err(); err != nil { return nil, nil, err }
+
+	if len(header) == 0 {
+		return nil, nil, fmt.Errorf("%s doesn't start with a header "+
+			"naming its columns.", fname)
+	}
+	return header, firstRow, nil
+}
+
+func isConstant(xs []int) bool {
+	for _, x := range xs {
+		if x != xs[0] { return false }
+	}
+	return true
+}
+
+func intsEqual(xs, ys []int) bool {
+	for i := range xs {
+		if xs[i] != ys[i] { return false }
+	}
+	return true
+}
+
+func intRange(xs []int) (lo, hi int) {
+	lo, hi = xs[0], xs[0]
+	for _, x := range xs {
+		if x < lo { lo = x }
+		if x > hi { hi = x }
+	}
+	return lo, hi
+}
+
+func joinInts(xs []int) string {
+	strs := make([]string, len(xs))
+	for i := range xs { strs[i] = strconv.Itoa(xs[i]) }
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/io"
+)
+
+func TestNewSnapshotLayout(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		numFiles int
+		format   string
+		meanings string
+		snapMin, snapMax      int
+		blockMins, blockMaxes string
+	}{
+		{[]string{"snap_0.0", "snap_0.1", "snap_1.0", "snap_1.1"}, 0,
+			"snap_%d.%d", "Snapshot, Block", 0, 1, "0", "1"},
+		{[]string{"snapdir_005/snapshot_005.0", "snapdir_005/snapshot_005.1",
+			"snapdir_005/snapshot_005.2"}, 3,
+			"snapdir_%03d/snapshot_%03d.%d", "Snapshot, Snapshot, Block",
+			5, 5, "0", "2"},
+		{[]string{"run2/out_098.dat", "run2/out_099.dat", "run2/out_100.dat"},
+			1, "run2/out_%03d.dat", "Snapshot", 98, 100, "", ""},
+		{[]string{"run2/out_8.dat", "run2/out_9.dat", "run2/out_10.dat"},
+			1, "run2/out_%d.dat", "Snapshot", 8, 10, "", ""},
+		{[]string{"s1_b0_x2%.bin", "s1_b1_x2%.bin", "s2_b0_x2%.bin",
+			"s2_b1_x2%.bin"}, 0, "s%d_b%d_x2%%.bin", "Snapshot, Block",
+			1, 2, "0", "1"},
+		{[]string{"a/1.1.0", "a/1.1.1", "a/1.2.0", "a/1.2.1", "a/2.1.0",
+			"a/2.1.1", "a/2.2.0", "a/2.2.1"}, 0, "a/%d.%d.%d",
+			"Snapshot, Block0, Block1", 1, 2, "1, 0", "2, 1"},
+	}
+
+	for i := range tests {
+		names := []fileName{}
+		for _, path := range tests[i].paths {
+			names = append(names, splitFileName(path))
+		}
+		format := &io.Format{ NumFiles: tests[i].numFiles }
+
+		layout, err := newSnapshotLayout(names, format)
+		if err != nil {
+			t.Errorf("%d) Got error '%s'.", i, err.Error())
+			continue
+		}
+
+		meanings := strings.Join(layout.meanings, ", ")
+		blockMins, blockMaxes := joinInts(layout.blockMins),
+			joinInts(layout.blockMaxes)
+		if layout.format != tests[i].format ||
+			meanings != tests[i].meanings ||
+			layout.snapMin != tests[i].snapMin ||
+			layout.snapMax != tests[i].snapMax ||
+			blockMins != tests[i].blockMins ||
+			blockMaxes != tests[i].blockMaxes {
+			t.Errorf("%d) Expected format '%s' with meanings '%s', "+
+				"snapshots %d-%d, and blocks [%s]-[%s], got '%s' with '%s', "+
+				"%d-%d, and [%s]-[%s].", i, tests[i].format,
+				tests[i].meanings, tests[i].snapMin, tests[i].snapMax,
+				tests[i].blockMins, tests[i].blockMaxes, layout.format,
+				meanings, layout.snapMin, layout.snapMax,
+				blockMins, blockMaxes)
+		}
+	}
+}
+
+// writeGadget writes the start of a Gadget-2-style file: a header with the
+// given particle counts followed by a position block of posSize bytes.
+func writeGadget(
+	t *testing.T, fname string, order binary.ByteOrder,
+	npart [6]uint32, posSize int,
+) {
+	hd := &io.Gadget2Header{
+		NPart: npart, NumFiles: 1, BoxSize: 100,
+		Mass: [6]float64{0, 0.01, 0, 0, 0, 0},
+	}
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, order, uint32(256))
+	binary.Write(buf, order, hd)
+	binary.Write(buf, order, uint32(256))
+	binary.Write(buf, order, uint32(posSize))
+	buf.Write(make([]byte, posSize))
+	binary.Write(buf, order, uint32(posSize))
+
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_detect")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	le, be := binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		order    binary.ByteOrder
+		npart    [6]uint32
+		posSize  int
+		snapType string
+		npartNum int
+	}{
+		{le, [6]uint32{0, 8, 0, 0, 0, 0}, 12*8, "Gadget-2", 0},
+		{be, [6]uint32{0, 8, 0, 0, 0, 0}, 12*8, "Gadget-2", 0},
+		{le, [6]uint32{0, 8, 0, 0, 0, 0}, 24*8, "Gadget-2", 0},
+		{be, [6]uint32{2, 8, 0, 0, 0, 0}, 24*10, "Gadget-2", 0},
+		{le, [6]uint32{8, 4, 0, 0, 0, 0}, 12*8, "LGadget-2", 1},
+		{be, [6]uint32{0, 8, 0, 0, 0, 2}, 12*8, "LGadget-2", 2},
+		{le, [6]uint32{0, 8, 0, 0, 0, 0}, 12*7, "", 0},
+	}
+
+	for i := range tests {
+		fname := filepath.Join(dir, fmt.Sprintf("snap_%d", i))
+		writeGadget(t, fname, tests[i].order, tests[i].npart, tests[i].posSize)
+
+		format, err := io.DetectFormat(fname)
+		if tests[i].snapType == "" {
+			if err == nil {
+				t.Errorf("%d) Expected an error, but detected %s.",
+					i, format.SnapshotType)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d) Got error '%s'.", i, err.Error())
+			continue
+		}
+
+		if format.SnapshotType != tests[i].snapType ||
+			format.Order != tests[i].order ||
+			format.LGadgetNpartNum != tests[i].npartNum ||
+			format.NPart != tests[i].npart || format.NumFiles != 1 {
+			t.Errorf("%d) Expected %s file with order %v and "+
+				"LGadgetNpartNum %d, got %s with %v and %d.", i,
+				tests[i].snapType, tests[i].order, tests[i].npartNum,
+				format.SnapshotType, format.Order, format.LGadgetNpartNum)
+		}
+	}
+
+	npy := filepath.Join(dir, "x.npy")
+	ioutil.WriteFile(npy, []byte("\x93NUMPY\x01\x00 junk"), 0644)
+	if format, err := io.DetectFormat(npy); err != nil ||
+		format.SnapshotType != "NumPy" {
+		t.Errorf("Expected NumPy file, got %v and %v.", format, err)
+	}
+
+	junk := filepath.Join(dir, "junk")
+	ioutil.WriteFile(junk, []byte("this isn't a particle file"), 0644)
+	if _, err := io.DetectFormat(junk); err == nil {
+		t.Errorf("Expected an error for a file with an unknown format.")
+	}
+	tiny := filepath.Join(dir, "tiny")
+	ioutil.WriteFile(tiny, []byte{1, 2}, 0644)
+	if _, err := io.DetectFormat(tiny); err == nil {
+		t.Errorf("Expected an error for a file smaller than any header.")
+	}
+}
+
+func TestFormatVariables(t *testing.T) {
+	tests := []struct {
+		format *io.Format
+		lines  []string
+		notes  int
+	}{
+		{&io.Format{ SnapshotType: "Gadget-2", Order: binary.BigEndian,
+			BoxSize: 62500, Mass: [6]float64{0, 0.1, 0, 0, 0, 0},
+			NPart: [6]uint32{0, 8, 0, 0, 0, 0} },
+			[]string{"SnapshotType = Gadget-2", "Endianness = BigEndian",
+				"GadgetPositionUnits = 0.001", "GadgetMassUnits = 1e10"}, 2},
+		{&io.Format{ SnapshotType: "Gadget-2", Order: binary.LittleEndian,
+			BoxSize: 62.5, Mass: [6]float64{0, 0, 1e9, 0, 0, 0},
+			NPart: [6]uint32{0, 0, 8, 0, 0, 0} },
+			[]string{"Endianness = LittleEndian"}, 1},
+		{&io.Format{ SnapshotType: "LGadget-2", Order: binary.LittleEndian,
+			LGadgetNpartNum: 2 },
+			[]string{"SnapshotType = LGadget-2", "LGadgetNpartNum = 2"}, 0},
+		{&io.Format{ SnapshotType: "TIPSY", Order: binary.BigEndian },
+			[]string{"SnapshotType = TIPSY", "# TipsyTotalWidth = 100"}, 1},
+		{&io.Format{ SnapshotType: "NumPy" },
+			[]string{"SnapshotType = NumPy"}, 0},
+	}
+
+	for i := range tests {
+		lines, notes := formatVariables(tests[i].format, "snap_0")
+		text := strings.Join(lines, "\n")
+		for _, line := range tests[i].lines {
+			if !strings.Contains(text, line + "\n") &&
+				!strings.HasSuffix(text, line) {
+				t.Errorf("%d) Expected line '%s' in:\n%s", i, line, text)
+			}
+		}
+		if strings.Contains(text, "Endianness") !=
+			(tests[i].format.Order != nil) {
+			t.Errorf("%d) Endianness set incorrectly in:\n%s", i, text)
+		}
+		if len(notes) != tests[i].notes {
+			t.Errorf("%d) Expected %d notes, got %d: %v",
+				i, tests[i].notes, len(notes), notes)
+		}
+	}
+}
+
+func TestHaloVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_halos")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	rockstar := "#ID DescID Mvir Vmax Vrms Rvir Rs Np X Y Z VX VY VZ " +
+		"M200b M200c\n#a = 1.0\n1 -1 1e12 1 1 200 1 1 1 2 3 4 5 6 1e12 8e11\n"
+	ioutil.WriteFile(filepath.Join(dir, "out_0.list"), []byte(rockstar), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "out_1.list"), []byte(rockstar), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "zz.swp"), []byte("junk"), 0644)
+	os.Rename(filepath.Join(dir, "zz.swp"), filepath.Join(dir, ".zz.swp"))
+	os.Mkdir(filepath.Join(dir, "trees"), 0755)
+
+	text, notes, err := haloVariables(dir)
+	if err != nil { t.Fatalf("Got error '%s'.", err.Error()) }
+
+	for _, line := range []string{
+		"# Read from the header of " + filepath.Join(dir, "out_1.list"),
+		"HaloValueNames = ID, X, Y, Z, Vx, Vy, Vz, M200m, M200c",
+		"HaloValueColumns = 0, 8, 9, 10, 11, 12, 13, 14, 15",
+		"HaloValueComments = ID, X, Y, Z, VX, VY, VZ, M200b, M200c",
+		"# There isn't an R200m column, so R200m is computed from M200m.",
+		"HaloRadiusUnits = ckpc/h",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected line '%s' in:\n%s", line, text)
+		}
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "R200m") {
+		t.Errorf("Expected a single note about R200m, got %v.", notes)
+	}
+
+	generic := "# id x y z m200m r200m\n1 1 2 3 1e12 250\n"
+	ioutil.WriteFile(filepath.Join(dir, "out_2.list"), []byte(generic), 0644)
+	text, notes, err = haloVariables(dir)
+	if err != nil { t.Fatalf("Got error '%s'.", err.Error()) }
+	if !strings.Contains(text, "HaloValueNames = ID, X, Y, Z, M200m, R200m") ||
+		!strings.Contains(text, "HaloRadiusUnits = ckpc/h") ||
+		strings.Contains(text, "computed from M200m") || len(notes) != 1 {
+		t.Errorf("Generic catalog gave notes %v and config:\n%s", notes, text)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "out_3.list"),
+		[]byte("# id x y z r200c\n1 1 2 3 0.2\n"), 0644)
+	if _, _, err = haloVariables(dir); err == nil {
+		t.Errorf("Expected an error for a catalog without M200m.")
+	}
+
+	empty := filepath.Join(dir, "trees")
+	ioutil.WriteFile(filepath.Join(empty, ".hidden"), []byte("x"), 0644)
+	if _, _, err = haloVariables(empty); err == nil {
+		t.Errorf("Expected an error for a directory without catalogs.")
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_init")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil { t.Fatal(err.Error()) }
+	if err = os.Chdir(dir); err != nil { t.Fatal(err.Error()) }
+	defer os.Chdir(wd)
+
+	snapDir, haloDir := filepath.Join(dir, "out"), filepath.Join(dir, "halos")
+	os.MkdirAll(filepath.Join(snapDir, "snapdir_005"), 0755)
+	os.Mkdir(haloDir, 0755)
+	for i := 0; i < 2; i++ {
+		writeGadget(t, filepath.Join(snapDir, "snapdir_005",
+			fmt.Sprintf("snapshot_005.%d", i)), binary.LittleEndian,
+			[6]uint32{0, 8, 0, 0, 0, 0}, 12*8)
+	}
+	ioutil.WriteFile(filepath.Join(haloDir, "out_5.list"),
+		[]byte("#ID X Y Z M200b Rvir\n1 1 2 3 1e12 200\n"), 0644)
+
+	text, notes, err := InitConfig(snapDir, haloDir)
+	if err != nil { t.Fatalf("Got error '%s'.", err.Error()) }
+
+	memoDir := filepath.Join(dir, "shellfish_memo")
+	if _, err := os.Stat(memoDir); err == nil {
+		t.Errorf("InitConfig created %s.", memoDir)
+	}
+	if !strings.Contains(text, "MemoDir = " + memoDir) {
+		t.Errorf("Expected MemoDir to be %s in:\n%s", memoDir, text)
+	}
+
+	foundMkdir := false
+	for _, note := range notes {
+		if strings.Contains(note, "isn't valid") {
+			t.Errorf("Generated config is invalid: %s\n%s", note, text)
+		}
+		if strings.Contains(note, "mkdir -p " + memoDir) { foundMkdir = true }
+	}
+	if !foundMkdir {
+		t.Errorf("Expected a note telling the user to make %s, got %v.",
+			memoDir, notes)
+	}
+
+	// Once MemoDir exists, the generated file is a working config.
+	os.Mkdir(memoDir, 0755)
+	fname := filepath.Join(dir, "shellfish.config")
+	ioutil.WriteFile(fname, []byte(text), 0644)
+	if err = (&GlobalConfig{}).ReadConfig(fname, nil); err != nil {
+		t.Errorf("Generated config is invalid: %s\n%s", err.Error(), text)
+	}
+}
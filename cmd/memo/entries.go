@@ -0,0 +1,193 @@
+package memo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/phil-mansfield/shellfish/cmd/cache"
+	"github.com/phil-mansfield/shellfish/cmd/env"
+)
+
+// EntryType is a type of file cached in MemoDir.
+type EntryType int
+
+const (
+	HeaderEntry EntryType = iota
+	RockstarEntry
+	RockstarShortEntry
+	IndexEntry
+)
+
+// EntryTypeNames are the names of each EntryType.
+var EntryTypeNames = []string{
+	"headers", "rockstar", "rockstar-short", "index",
+}
+
+func (typ EntryType) String() string { return EntryTypeNames[typ] }
+
+// Entry is a file cached in MemoDir.
+type Entry struct {
+	Type  EntryType
+	Snap  int
+	// Block is the block that an IndexEntry was built from. It's -1 for all
+	// other entries.
+	Block int
+	File  string
+	Size  int64
+}
+
+// ListEntries returns the files cached in MemoDir, sorted by snapshot. Files
+// which aren't cache entries, like memo.config, are skipped.
+func ListEntries(e *env.Environment) ([]Entry, error) {
+	entries := []Entry{}
+
+	for _, dir := range []struct{
+		name string
+		add  func(name string, info os.FileInfo)
+	}{
+		{"", func(name string, info os.FileInfo) {
+			snap := 0
+			if scanName(name, headerMemoFile, &snap) {
+				entries = append(entries, Entry{
+					HeaderEntry, snap, -1, path.Join(e.MemoDir, name),
+					info.Size(),
+				})
+			}
+		}},
+		{rockstarMemoDir, func(name string, info os.FileInfo) {
+			file := path.Join(e.MemoDir, rockstarMemoDir, name)
+			snap := 0
+			if scanName(name, rockstarMemoFile, &snap) {
+				entries = append(entries, Entry{
+					RockstarEntry, snap, -1, file, info.Size(),
+				})
+			} else if scanName(name, rockstarShortMemoFile, &snap) {
+				entries = append(entries, Entry{
+					RockstarShortEntry, snap, -1, file, info.Size(),
+				})
+			}
+		}},
+		{cellIndexMemoDir, func(name string, info os.FileInfo) {
+			snap, block := 0, 0
+			if scanName(name, cellIndexMemoFile, &snap, &block) {
+				entries = append(entries, Entry{
+					IndexEntry, snap, block,
+					path.Join(e.MemoDir, cellIndexMemoDir, name), info.Size(),
+				})
+			}
+		}},
+	} {
+		infos, err := ioutil.ReadDir(path.Join(e.MemoDir, dir.name))
+		if os.IsNotExist(err) && dir.name != "" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() { dir.add(info.Name(), info) }
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Snap != entries[j].Snap {
+			return entries[i].Snap < entries[j].Snap
+		}
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		return entries[i].Block < entries[j].Block
+	})
+
+	return entries, nil
+}
+
+// scanName returns true if name was generated by the given file name format
+// and parses its integers into ptrs.
+func scanName(name, format string, ptrs ...*int) bool {
+	args := make([]interface{}, len(ptrs))
+	for i := range ptrs { args[i] = ptrs[i] }
+	n, err := fmt.Sscanf(name, format, args...)
+	if err != nil || n != len(ptrs) { return false }
+
+	// Sscanf ignores trailing characters.
+	vals := make([]interface{}, len(ptrs))
+	for i := range ptrs { vals[i] = *ptrs[i] }
+	return fmt.Sprintf(format, vals...) == name
+}
+
+// TempFiles returns the temporary files which were left in MemoDir by jobs
+// that were killed while writing to it.
+func TempFiles(e *env.Environment) ([]string, error) {
+	files := []string{}
+	for _, dir := range []string{ "", rockstarMemoDir, cellIndexMemoDir } {
+		dir = path.Join(e.MemoDir, dir)
+		infos, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() && strings.Contains(info.Name(), ".tmp") {
+				files = append(files, path.Join(dir, info.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// Check returns true if entry is still valid for the current config file and
+// source files. Entries whose source files have been removed are invalid.
+// cells is the current value of SpatialIndexCells, and index entries with a
+// different number of cells are invalid.
+func (entry *Entry) Check(e *env.Environment, cells int) (bool, error) {
+	var (
+		key *cache.Key
+		err error
+	)
+
+	switch entry.Type {
+	case RockstarEntry, RockstarShortEntry:
+		if !e.HasHaloCatalog(entry.Snap) { return false, nil }
+	default:
+		if !e.HasParticleCatalog(entry.Snap) { return false, nil }
+	}
+
+	switch entry.Type {
+	case HeaderEntry:
+		key, err = headerKey(e, entry.Snap)
+	case RockstarEntry:
+		key, err = rockstarKey(e, entry.Snap, -1)
+	case RockstarShortEntry:
+		key, err = rockstarKey(e, entry.Snap, rockstarShortMemoNum)
+	case IndexEntry:
+		if entry.Block >= e.Blocks() { return false, nil }
+		key, err = indexKey(e, entry.Snap, entry.Block, cells)
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if entry.Type == IndexEntry {
+		return cache.CheckFile(entry.File, key)
+	}
+	_, ok, err := cache.Read(entry.File, key)
+	return ok, err
+}
+
+// Remove deletes entry from MemoDir.
+func (entry *Entry) Remove() error {
+	if entry.Type == IndexEntry {
+		err := os.Remove(entry.File + ".stamp")
+		if err != nil && !os.IsNotExist(err) { return err }
+	}
+	return os.Remove(entry.File)
+}
@@ -2,16 +2,19 @@
 package memo
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"os"
 	"path"
 	"sort"
 	
+	"github.com/phil-mansfield/shellfish/cmd/cache"
 	"github.com/phil-mansfield/shellfish/cmd/env"
 
 	"github.com/phil-mansfield/shellfish/cmd/halo"
 	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
 )
 
 
@@ -22,8 +25,23 @@ const (
 	rockstarShortMemoNum  = 10 * 1000
 
 	headerMemoFile = "hd_snap%d.dat"
+
+	cellIndexMemoDir  = "index"
+	cellIndexMemoFile = "snap%d_block%d.dat"
 )
 
+// memoSubdir returns the named directory inside MemoDir, creating it if it
+// doesn't exist. The cache mode builds memo files from several workers at
+// once, so the directory may appear at any time and MkdirAll is used instead
+// of checking for it first.
+func memoSubdir(e *env.Environment, name string) (string, error) {
+	dir := path.Join(e.MemoDir, name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 // ReadSortedRockstarIDs returns a slice of IDs corresponding to the highest
 // values of some quantity in a particular snapshot. maxID is the number of
 // halos to return.
@@ -37,12 +55,9 @@ func ReadSortedRockstarIDs(
 	}
 	cosmo := &hds[0].Cosmo
 
-	dir := path.Join(e.MemoDir, rockstarMemoDir)
-	if _, err := os.Stat(dir); err != nil {
-		err = os.Mkdir(dir, 0777)
-		if err != nil {
-			return nil, err
-		}
+	dir, err := memoSubdir(e, rockstarMemoDir)
+	if err != nil {
+		return nil, err
 	}
 
 	var (
@@ -117,12 +132,9 @@ func ReadRockstar(
 	cosmo := &hds[0].Cosmo
 
 	// Find binFile.
-	dir := path.Join(e.MemoDir, rockstarMemoDir)
-	if _, err := os.Stat(dir); err != nil {
-		err = os.Mkdir(dir, 0777)
-		if err != nil {
-			return nil, nil, err
-		}
+	dir, err := memoSubdir(e, rockstarMemoDir)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	binFile := path.Join(dir, fmt.Sprintf(rockstarMemoFile, snap))
@@ -161,26 +173,23 @@ func readRockstar(
 	}
 	hd := &hds[0]
 
-	// If binFile doesn't exist, create it.
-	if _, err := os.Stat(binFile); err != nil {
+	catFile := e.HaloCatalog(snap)
+	key, err := rockstarKey(e, snap, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := cache.Load(binFile, key, func() ([]byte, error) {
 		if n == -1 {
-			err = halo.RockstarConvert(
-				e.HaloCatalog(snap), binFile, vars, &hd.Cosmo,
-			)
-			if err != nil {
-				return nil, nil, err
-			}
-		} else {
-			err = halo.RockstarConvertTopN(
-				e.HaloCatalog(snap), binFile, n, vars, &hd.Cosmo,
-			)
-			if err != nil {
-				return nil, nil, err
-			}
+			return halo.RockstarConvert(catFile, vars, &hd.Cosmo)
 		}
+		return halo.RockstarConvertTopN(catFile, n, vars, &hd.Cosmo)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	rids, rawCols, err := halo.ReadBinaryRockstar(binFile, vars)
+	rids, rawCols, err := halo.ReadBinaryRockstar(data, vars)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -255,6 +264,47 @@ func readUnmemoizedHeaders(
 	return hds, files, nil
 }
 
+// snapshotKey returns the cache key of a file computed from the given
+// particle files.
+func snapshotKey(
+	e *env.Environment, name string, snap int, files ...string,
+) (*cache.Key, error) {
+	key := cache.NewKey(e.SnapshotCacheKey, name, snap)
+	// nil snapshots don't have any files.
+	if e.CatalogType == env.Nil { return key, nil }
+	if err := key.AddFiles(files...); err != nil { return nil, err }
+	return key, nil
+}
+
+// headerKey returns the cache key of the header file for a snapshot.
+func headerKey(e *env.Environment, snap int) (*cache.Key, error) {
+	files := make([]string, e.Blocks())
+	for i := range files {
+		files[i] = e.ParticleCatalog(snap, i)
+	}
+	return snapshotKey(e, "headers", snap, files...)
+}
+
+// indexKey returns the cache key of the index file for a block which has
+// been sorted into cells^3 cells.
+func indexKey(
+	e *env.Environment, snap, block, cells int,
+) (*cache.Key, error) {
+	key, err := snapshotKey(e, "index", snap, e.ParticleCatalog(snap, block))
+	if err != nil { return nil, err }
+	return key.Add(cells), nil
+}
+
+// rockstarKey returns the cache key of the binary halo catalog containing the
+// n largest halos in a snapshot, or every halo if n is -1.
+func rockstarKey(e *env.Environment, snap, n int) (*cache.Key, error) {
+	key := cache.NewKey(e.HaloCacheKey, "rockstar", snap, n)
+	if err := key.AddFiles(e.HaloCatalog(snap)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 // ReadHeaders returns all the segment headers and segment file names for all
 // the segments at a given snapshot.
 func ReadHeaders(
@@ -265,38 +315,143 @@ func ReadHeaders(
 	}
 	memoFile := path.Join(e.MemoDir, fmt.Sprintf(headerMemoFile, snap))
 
-	if _, err := os.Stat(memoFile); err != nil {
-		// File not written yet.
-		hds, files, err := readUnmemoizedHeaders(snap, buf, e)
-		if err != nil {
-			return nil, nil, err
-		}
+	files := make([]string, e.Blocks())
+	for i := range files {
+		files[i] = e.ParticleCatalog(snap, i)
+	}
+	key, err := headerKey(e, snap)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		f, err := os.Create(memoFile)
+	data, err := cache.Load(memoFile, key, func() ([]byte, error) {
+		hds, _, err := readUnmemoizedHeaders(snap, buf, e)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		defer f.Close()
+		out := &bytes.Buffer{}
+		err = binary.Write(out, binary.LittleEndian, hds)
+		return out.Bytes(), err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		binary.Write(f, binary.LittleEndian, hds)
+	hds := make([]io.Header, e.Blocks())
+	if len(data) != binary.Size(hds) {
+		return nil, nil, fmt.Errorf("Header cache file %s has %d bytes, "+
+			"but expected %d.", memoFile, len(data), binary.Size(hds))
+	}
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, hds)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		return hds, files, nil
-	} else {
-		// File exists: read from it instead.
+	return hds, files, nil
+}
 
-		f, err := os.Open(memoFile)
-		if err != nil {
-			return nil, nil, err
-		}
-		defer f.Close()
+// ReadSpheres returns the particles in a block which are close to at least
+// one of the given spheres. The returned particles are a superset of the
+// particles inside the spheres. The first time a block is read, its particles
+// are sorted into a grid of cells^3 cells and are written to MemoDir so that
+// later calls only need to read the cells which are close to the spheres.
+// Index files are rebuilt if cells changes. Only the given fields are
+// returned.
+//
+// hd must be the header of the block returned by ReadHeaders.
+func ReadSpheres(
+	snap, block, cells int, buf io.VectorBuffer, e *env.Environment,
+	hd *io.Header, spheres []geom.Sphere, fields io.Field,
+) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
+	file, err := BuildIndex(snap, block, cells, buf, e, hd)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
 
-		hds := make([]io.Header, e.Blocks())
-		binary.Read(f, binary.LittleEndian, hds)
-		files := make([]string, e.Blocks())
-		for i := range files {
-			files[i] = e.ParticleCatalog(snap, i)
-		}
+	idx, err := io.OpenCellIndex(file)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	defer idx.Close()
+
+	cs := make([][3]float32, len(spheres))
+	rs := make([]float32, len(spheres))
+	for i := range spheres {
+		cs[i], rs[i] = spheres[i].C, spheres[i].R
+	}
+
+	return idx.ReadSpheres(cs, rs, fields)
+}
+
+// BuildIndex writes the index file used by ReadSpheres for a block if it
+// doesn't already exist and returns its name.
+func BuildIndex(
+	snap, block, cells int, buf io.VectorBuffer, e *env.Environment,
+	hd *io.Header,
+) (string, error) {
+	dir, err := memoSubdir(e, cellIndexMemoDir)
+	if err != nil {
+		return "", err
+	}
+	file := path.Join(dir, fmt.Sprintf(cellIndexMemoFile, snap, block))
 
-		return hds, files, nil
+	key, err := indexKey(e, snap, block, cells)
+	if err != nil {
+		return "", err
 	}
+
+	ok, err := cache.CheckFile(file, key)
+	if err != nil || ok {
+		return file, err
+	}
+
+	fname := e.ParticleCatalog(snap, block)
+	fields, err := io.StoredFields(buf, fname)
+	if err != nil {
+		return "", err
+	}
+	xs, vs, ms, ids, err := buf.ReadFields(fname, fields)
+	if err != nil {
+		if buf.IsOpen() { buf.Close() }
+		return "", err
+	}
+	err = cache.WriteFile(file, key, func(tmp string) error {
+		return io.WriteCellIndex(tmp, hd, cells, xs, vs, ms, ids)
+	})
+	buf.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
+
+// BuildRockstar writes both binary halo catalogs used by ReadRockstar for a
+// snapshot if they don't already exist.
+func BuildRockstar(
+	snap int, vars *halo.VarColumns, buf io.VectorBuffer, e *env.Environment,
+) error {
+	hds, _, err := ReadHeaders(snap, buf, e)
+	if err != nil {
+		return err
+	}
+	cosmo := &hds[0].Cosmo
+
+	dir, err := memoSubdir(e, rockstarMemoDir)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range []int{ -1, rockstarShortMemoNum } {
+		name := rockstarMemoFile
+		if n != -1 { name = rockstarShortMemoFile }
+		file := path.Join(dir, fmt.Sprintf(name, snap))
+
+		_, _, err = readRockstar(file, nil, n, snap, nil, vars, buf, e, cosmo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
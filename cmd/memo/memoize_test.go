@@ -0,0 +1,35 @@
+package memo
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/cmd/env"
+)
+
+func TestMemoSubdirConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_memo")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+	e := &env.Environment{ MemoDir: dir }
+
+	workers := 16
+	errs := make([]error, workers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = memoSubdir(e, cellIndexMemoDir)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range errs {
+		if errs[i] != nil {
+			t.Errorf("Worker %d got error '%s'.", i, errs[i].Error())
+		}
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"os"
 	"sort"
 	"time"
 
+	"github.com/phil-mansfield/shellfish/cosmo"
 	"github.com/phil-mansfield/shellfish/los/geom"
 	"github.com/phil-mansfield/shellfish/cmd/catalog"
 	"github.com/phil-mansfield/shellfish/cmd/env"
@@ -21,6 +23,14 @@ type PhaseConfig struct {
 	rMaxMult, vMaxMult float64
 	pType phaseProfileType
 	subHub bool
+	outputFile string
+
+	caustic bool
+	causticPixelLevel, causticWindow int64
+	causticRMinMult float64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 type phaseProfileType int
@@ -28,14 +38,85 @@ type phaseProfileType int
 const (
 	radialPhaseProfile phaseProfileType = iota
 	totalPhaseProfile
+	tangentialPhaseProfile
+	infallPhaseProfile
+	outgoingPhaseProfile
+	radialTangentialPhaseProfile
 )
 
+// phaseProfileNames maps the values of the ProfileType variable onto
+// phaseProfileTypes.
+var phaseProfileNames = map[string]phaseProfileType{
+	"radial": radialPhaseProfile,
+	"total": totalPhaseProfile,
+	"tangential": tangentialPhaseProfile,
+	"infall": infallPhaseProfile,
+	"outgoing": outgoingPhaseProfile,
+	"radial-tangential": radialTangentialPhaseProfile,
+}
+
+// bins returns the number of bins along the x- and y-axes of the histogram.
+func (config *PhaseConfig) bins() (nx, ny int) {
+	if config.pType == radialTangentialPhaseProfile {
+		return int(config.vbins), int(config.vbins)
+	}
+	return int(config.rbins), int(config.vbins)
+}
+
+// ranges returns the range of the x- and y-axes of the histogram for a halo
+// with the given maximum radius and velocity. radial-tangential histograms
+// have v_r in [-vMax, vMax] along the x-axis and v_t in [0, vMax] along the
+// y-axis. Every other type has r in [0, rMax] along the x-axis.
+func (t phaseProfileType) ranges(
+	rMax, vMax float64,
+) (xMin, xMax, yMin, yMax float64) {
+	switch t {
+	case radialPhaseProfile:
+		return 0, rMax, -vMax, vMax
+	case infallPhaseProfile:
+		return 0, rMax, -vMax, 0
+	case radialTangentialPhaseProfile:
+		return -vMax, vMax, 0, vMax
+	}
+	return 0, rMax, 0, vMax
+}
+
+// columnNames returns the names of the x, y, and histogram columns.
+func (t phaseProfileType) columnNames() (x, y, rho string) {
+	switch t {
+	case radialPhaseProfile, infallPhaseProfile, outgoingPhaseProfile:
+		return "R [cMpc/h]", "V_r [pkm/s]",
+			"Rho [h^2 Msun/cMpc^3/(pkm/s), V major]"
+	case tangentialPhaseProfile:
+		return "R [cMpc/h]", "V_t [pkm/s]",
+			"Rho [h^2 Msun/cMpc^3/(pkm/s), V major]"
+	case radialTangentialPhaseProfile:
+		return "V_r [pkm/s]", "V_t [pkm/s]",
+			"Rho [Msun/h/(pkm/s)^2, V_t major]"
+	}
+	return "R [cMpc/h]", "V [pkm/s]", "Rho [h^2 Msun/cMpc^3/(pkm/s), V major]"
+}
+
 var _ Mode = &PhaseConfig{}
 
 func (config *PhaseConfig) ExampleConfig() string {
 	return `[phase.config]
 
-# radial | total
+# ProfileType is the type of phase-space histogram which will be computed.
+# The input catalog must contain the columns ID, Snapshot, X, Y, Z, R_200m,
+# Vx, Vy, and Vz. Velocities are measured relative to (Vx, Vy, Vz) and are in
+# units of physical km/s.
+#
+# Known profile types are:
+# radial -            (r, v_r) histograms.
+# total -             (r, |v|) histograms.
+# tangential -        (r, v_t) histograms.
+# infall -            (r, v_r) histograms of only the particles with v_r < 0.
+# outgoing -          (r, v_r) histograms of only the particles with v_r > 0.
+# radial-tangential - (v_r, v_t) histograms of all the particles within
+#                     RMaxMult*R_200m. Both axes use VBins bins.
+#
+# Halos with a snapshot of -1 are given histograms of zeros.
 ProfileType = radial
 
 ###################
@@ -47,22 +128,75 @@ ProfileType = radial
 
 # Multiplies R200m:
 # RMaxMult = 3.0
-# Mutliplies V200m:
+# Mutliplies V200m, which is computed from R200m and the cosmology of the
+# particle snapshots:
 # VMaxMult = 3.0
 
 # SubtractHubble = false
+
+# OutputFile is a binary file that the histograms will be written to instead
+# of the output catalog. If it is set, the output catalog will only contain
+# the ID and Snapshot columns. The format of the file is:
+#
+# |- 0 -||- 1 -||- 2 -||- 3 -||- 4_0 -| ... |- 4_i -|
+#
+# 0) Endianness: int32
+#        The endianness of the file. 0 -> little endian, -1 -> big endian.
+# 1) HaloCount: int32
+#        The number of halos in the file.
+# 2) XBins: int32
+#        The number of bins along the x-axis of each histogram.
+# 3) YBins: int32
+#        The number of bins along the y-axis of each histogram.
+# 4_i) Halo: struct { ID, Snap int64; X [XBins]float32; Y [YBins]float32;
+#                     Rho [XBins][YBins]float32 }
+#        The histogram of the ith halo. X and Y are the bin centers.
+#
+# The file will be written with the byte ordering specified by the Endianness
+# variable in the global config file.
+# OutputFile = phase.dat
+
+# Caustic switches phase mode from computing histograms to locating the
+# outermost caustic in radial phase space: the radius where the stream of
+# outgoing (v_r > 0) particles on their first orbit turns around. This is a
+# kinematic estimate of R_sp which can be compared against the shells found
+# by shell mode. ProfileType and OutputFile are ignored if Caustic is set.
+#
+# The caustic is found by binning outgoing particles into RBins logarithmic
+# radial bins between CausticRMinMult*R200m and RMaxMult*R200m and finding
+# the radius where the logarithmic slope of their density, smoothed with a
+# Savitzky-Golay filter with a width of CausticWindow bins, is steepest.
+#
+# The output catalog contains the columns ID, Snapshot, R_caustic (found
+# using all the particles around the halo), and R_caustic_median (the median
+# of the caustic radii found in each angular pixel). If CausticPixelLevel is
+# larger than zero, particles are also split into angular pixels in the same
+# way as prof mode's MedianPixelLevel and the caustic radius of each pixel is
+# written as an additional column group. Pixels without any outgoing
+# particles are given a radius of -1.
+# Caustic = false
+# CausticPixelLevel = 0
+# CausticWindow = 11
+# CausticRMinMult = 0.5
 `
 }
 
 
 func (config *PhaseConfig) ReadConfig(fname string, flags []string) error {
-	vars := parse.NewConfigVars("prof.config")
+	vars := parse.NewConfigVars("phase.config")
+	config.vars = vars
 
-	vars.Int(&config.rbins, "RBins", 100)
-	vars.Int(&config.vbins, "VBins", 100)
-	vars.Float(&config.rMaxMult, "RMaxMult", 3.0)
-	vars.Float(&config.vMaxMult, "VMaxMult", 3.0)
+	vars.Int(&config.rbins, "RBins", 100).Min(0)
+	vars.Int(&config.vbins, "VBins", 100).Min(0)
+	vars.Float(&config.rMaxMult, "RMaxMult", 3.0).Min(0)
+	vars.Float(&config.vMaxMult, "VMaxMult", 3.0).Min(0)
 	vars.Bool(&config.subHub, "SubtractHubble", false)
+	vars.String(&config.outputFile, "OutputFile", "")
+	vars.Bool(&config.caustic, "Caustic", false)
+	vars.Int(&config.causticPixelLevel, "CausticPixelLevel", 0).Min(0)
+	vars.Int(&config.causticWindow, "CausticWindow", 11).Min(5).Odd()
+	vars.Float(&config.causticRMinMult, "CausticRMinMult", 0.5).
+		Positive()
 	
 	var pType string
 	vars.String(&pType, "ProfileType", "")
@@ -78,46 +212,51 @@ func (config *PhaseConfig) ReadConfig(fname string, flags []string) error {
 	}
 	
 	// Needs to be done here: can't be in the validate method.
-	switch pType {
-	case "":
+	if pType == "" && !config.caustic {
 		return fmt.Errorf("The variable 'ProfileType' was not set.")
-	case "radial":
-		config.pType = radialPhaseProfile
-	case "total":
-		config.pType = totalPhaseProfile
-	default:
-		return fmt.Errorf("The varaiable 'ProfileType' was set to '%s'.", pType)
+	} else if pType != "" {
+		var ok bool
+		config.pType, ok = phaseProfileNames[pType]
+		if !ok {
+			return fmt.Errorf("The varaiable 'ProfileType' was set to '%s'.",
+				pType)
+		}
 	}
 	
 	return config.validate()
 }
 
 func (config *PhaseConfig) validate() error {
-	if config.rbins < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"RBins", config.rbins)
-	} else if config.vbins < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"VBins", config.rbins)
-	} else if config.rMaxMult < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"RMaxMult", config.rbins)
-	} else if config.vMaxMult < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"VMaxMult", config.rbins)
+	if err := config.vars.Validate(); err != nil { return err }
+
+	if config.causticRMinMult >= config.rMaxMult {
+		return fmt.Errorf("The variable '%s' was set to %g, but the "+
+			"variable '%s' was set to %g.", "CausticRMinMult",
+			config.causticRMinMult, "RMaxMult", config.rMaxMult)
 	}
 
 	return nil
 }
 
+// v200m returns the circular velocity at R_200m in physical km/s, where
+// r200m is in cMpc/h.
+func v200m(r200m float64, hd *io.Header) float64 {
+	c := &hd.Cosmo
+	a := 1 / (1 + c.Z)
+	rhoM := cosmo.RhoAverage(100*c.H100, c.OmegaM, c.OmegaL, c.Z)
+	r := a*r200m
+	m := 200 * rhoM * (4*math.Pi/3) * r*r*r
+	return math.Sqrt(gravConst * m / r)
+}
+
 func (config *PhaseConfig) Run(
 	gConfig *GlobalConfig, e *env.Environment, stdin []byte,
 ) ([]string, error) {
 	if logging.Mode != logging.Nil {
 		log.Println(`
-####################
-## shellfish prof ##
-####################`,
+#####################
+## shellfish phase ##
+#####################`,
 		)
 	}
 	
@@ -126,31 +265,46 @@ func (config *PhaseConfig) Run(
 		t = time.Now()
 	}
 
-	icols, fcols, err := catalog.Parse(
-		stdin, []int{0, 1}, []int{2, 3, 4, 5, 6, 7, 8, 9},
+	icols, fcols, _, err := catalog.ParseColumns(
+		stdin, haloRequests,
+		coordRequests("X", "Y", "Z", "R200m", "Vx", "Vy", "Vz"),
 	)
 	if err != nil { return nil, err }
 
 	ids, snaps := icols[0], icols[1]
 	hx := [3][]float64{ fcols[0], fcols[1], fcols[2] }
-	hr, hm := fcols[3], fcols[4]
-	hvx := [3][]float64{ fcols[5], fcols[6], fcols[7] }
+	hr := fcols[3]
+	hvx := [3][]float64{ fcols[4], fcols[5], fcols[6] }
 
+	// V200m depends on the snapshot's cosmology, so it's set once each
+	// snapshot's headers have been read.
 	hvr := make([]float64, len(hr))
-	for i := range hvr {
-		hvr[i] = 508.0 * math.Sqrt((hm[i] / 6e13) / (hr[i] / 1.0))
-	}
 
 	if len(ids) == 0 { return nil, fmt.Errorf("No input halos.") }
 
 	// Initialize phase profiles
-	rSets := make([][]float64, len(ids))
-	vSets := make([][]float64, len(ids))
+	nx, ny := config.bins()
+	xSets := make([][]float64, len(ids))
+	ySets := make([][]float64, len(ids))
 	rhoSets := make([][]float64, len(ids))
-	for i := range rSets {
-		rSets[i] = make([]float64, config.rbins)
-		vSets[i] = make([]float64, config.vbins)
-		rhoSets[i] = make([]float64, config.rbins*config.vbins)
+	for i := range xSets {
+		xSets[i] = make([]float64, nx)
+		ySets[i] = make([]float64, ny)
+		rhoSets[i] = make([]float64, nx*ny)
+	}
+
+	// Radial histograms of outgoing particles. The first histogram of each
+	// halo contains every particle and the rest correspond to angular pixels.
+	var causticSets [][][]float64
+	if config.caustic {
+		xSets, ySets, rhoSets = nil, nil, nil
+		causticSets = make([][][]float64, len(ids))
+		for i := range causticSets {
+			causticSets[i] = make([][]float64, 1 + config.causticPixels())
+			for p := range causticSets[i] {
+				causticSets[i][p] = make([]float64, config.rbins)
+			}
+		}
 	}
 	
 	snapBins, idxBins := binBySnap(snaps, ids)
@@ -162,17 +316,27 @@ func (config *PhaseConfig) Run(
 	sort.Ints(sortedSnaps)
 	
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
+		e.ParticleCatalogs(snaps[0]), gConfig,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	br := newBlockReader(
+		buf, gConfig, e, io.Positions | io.Velocities | io.Masses,
+	)
+	defer br.Stop()
+
 	for _, snap := range sortedSnaps {
 		if snap == -1 {
 			continue
 		}
 
+		hds, _, err := memo.ReadHeaders(snap, buf, e)
+		if err != nil {
+			return nil, err
+		}
+
 		idxs := idxBins[snap]
 		snapCoords := [][]float64{
 			make([]float64, len(idxs)), make([]float64, len(idxs)),
@@ -183,6 +347,8 @@ func (config *PhaseConfig) Run(
 			make([]float64, len(idxs)), make([]float64, len(idxs)),
 		}
 		for i, idx := range idxs {
+			hvr[idx] = v200m(hr[idx], &hds[0])
+
 			snapCoords[0][i] = hx[0][idx]
 			snapCoords[1][i] = hx[1][idx]
 			snapCoords[2][i] = hx[2][idx]
@@ -194,69 +360,102 @@ func (config *PhaseConfig) Run(
 			snapVel[3][i] = hvr[idx]*config.vMaxMult
 		}
 
-		hds, files, err := memo.ReadHeaders(snap, buf, e)
+		hxBounds, err := boundingSpheres(snapCoords, &hds[0], e)
 		if err != nil {
 			return nil, err
 		}
-		hxBounds, err := boundingSpheres(snapCoords, &hds[0], e)
 		hvBounds, err := boundingSpheres(snapVel, &hds[0], e)
 		if err != nil {
 			return nil, err
 		}
 		_, intrIdxs := binSphereIntersections(hds, hxBounds)
 
+		blocks, blockSpheres := intersectingBlocks(intrIdxs, hxBounds, 1)
+		err = br.Prefetch(snap, hds, blocks, blockSpheres)
+		if err != nil {
+			return nil, err
+		}
+
 		for i := range hds {
 			if len(intrIdxs[i]) == 0 {
 				continue
 			}
 
-			xs, vs, ms, _, err := buf.Read(files[i])
+			err = br.Each(snap, i, &hds[i], blockSpheres[i], func(
+				xs, vs [][3]float32, ms []float32, _ []int64,
+			) error {
+				// Waarrrgggble
+				for _, j := range intrIdxs[i] {
+					if causticSets != nil {
+						insertCausticPoints(
+							causticSets[idxs[j]],
+							hxBounds[j], hvBounds[j],
+							xs, vs, ms,
+							config, &hds[i],
+						)
+						continue
+					}
+
+					rhos := rhoSets[idxs[j]]
+
+					insertPhasePoints(
+						rhos,
+						hxBounds[j], hvBounds[j],
+						xs, vs, ms,
+						config, &hds[i],
+					)
+				}
+
+				return nil
+			})
 			if err != nil {
 				return nil, err
 			}
-
-			// Waarrrgggble
-			for _, j := range intrIdxs[i] {
-				rhos := rhoSets[idxs[j]]
-
-				insertPhasePoints(
-					rhos,
-					hxBounds[j], hvBounds[j],
-					xs, vs, ms,
-					config, &hds[i],
-				)
-			}
-
-			buf.Close()
 		}
 	}
 	
-	for i := range rSets {
+	if causticSets != nil {
+		return causticCatalog(
+			ids, snaps, hr, causticSets, gConfig, config, t,
+		), nil
+	}
+
+	for i := range xSets {
 		rMax := hr[i]*config.rMaxMult
 		vMax := hvr[i]*config.vMaxMult
+		// Halos which weren't found (snap == -1) or which have a zero
+		// radius have empty bins, so their histograms are left as zeros.
+		if snaps[i] == -1 || rMax <= 0 || vMax <= 0 { continue }
 		processPhaseProfile(
-			rSets[i], vSets[i], rhoSets[i], rMax, vMax, config.pType,
+			xSets[i], ySets[i], rhoSets[i], rMax, vMax, config.pType,
+		)
+	}
+
+	if config.outputFile != "" {
+		err := writePhaseProfiles(ids, snaps, xSets, ySets, rhoSets,
+			gConfig, config)
+		if err != nil { return nil, err }
+
+		hd := catalogHeader(
+			"phase", config.vars, 0,
+			[]string{"ID", "Snapshot"}, []string{}, []int{0, 1}, []int{1, 1},
 		)
+		return formatCatalog(
+			gConfig, hd, [][]int{ids, snaps}, nil, []int{0, 1},
+		), nil
 	}
 
-	rSets = transpose(rSets)
-	vSets = transpose(vSets)
+	xSets = transpose(xSets)
+	ySets = transpose(ySets)
 	rhoSets = transpose(rhoSets)
 
-	order := make([]int, len(rSets) + len(vSets) + len(rhoSets) + 2)
+	order := make([]int, len(xSets) + len(ySets) + len(rhoSets) + 2)
 	for i := range order { order[i] = i }
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps},
-		append(append(rSets, vSets...), rhoSets...),
-		order,
-	)
-	
-	cString := catalog.CommentString(
-		[]string{"ID", "Snapshot", "R [cMpc/h]", "V [pkm/s]",
-			"Rho [h^2 Msun/cMpc^3/(pkm/s), V major]"},
-		[]string{}, []int{0, 1, 2, 3, 4},
-		[]int{1, 1, int(config.vbins), int(config.rbins),
-			int(config.rbins)*int(config.vbins)},
+	xName, yName, rhoName := config.pType.columnNames()
+	hd := catalogHeader(
+		"phase", config.vars, 0,
+		[]string{"ID", "Snapshot"}, []string{xName, yName, rhoName},
+		[]int{0, 1, 2, 3, 4}, []int{1, 1, nx, ny, nx*ny},
 	)
 
 	if logging.Mode == logging.Performance {
@@ -264,7 +463,10 @@ func (config *PhaseConfig) Run(
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(
+		gConfig, hd, [][]int{ids, snaps},
+		append(append(xSets, ySets...), rhoSets...), order,
+	), nil
 }
 
 func insertPhasePoints(
@@ -274,10 +476,11 @@ func insertPhasePoints(
 	ms []float32,
 	config *PhaseConfig, hd *io.Header,
 ) {
-	vMin, vMax, rMax := 0.0, float64(hv.R), float64(hx.R)
-	if config.pType == radialPhaseProfile { vMin = -vMax }
-	dv := (vMax - vMin) / float64(config.vbins)
-	dr := rMax / float64(config.rbins)
+	rMax := float64(hx.R)
+	xMin, xMax, yMin, yMax := config.pType.ranges(rMax, float64(hv.R))
+	nx, ny := config.bins()
+	dx := (xMax - xMin) / float64(nx)
+	dy := (yMax - yMin) / float64(ny)
 
 	rMax2 := rMax*rMax
 
@@ -285,65 +488,147 @@ func insertPhasePoints(
 	vx0, vy0, vz0 := hv.C[0], hv.C[1], hv.C[2]
 	tw2 := float32(hd.TotalWidth) / 2
 
+	// The Hubble flow in units of pkm/s per cMpc/h.
+	hFlow := float32(100 * cosmo.HubbleFrac(
+		hd.Cosmo.OmegaM, hd.Cosmo.OmegaL, hd.Cosmo.Z,
+	) / (1 + hd.Cosmo.Z))
+
 	for i, vec := range xs {
 		x, y, z := vec[0], vec[1], vec[2]
-		dx, dy, dz := x - x0, y - y0, z - z0
-		dx = wrap(dx, tw2)
-		dy = wrap(dy, tw2)
-		dz = wrap(dz, tw2)
+		dx0, dy0, dz0 := x - x0, y - y0, z - z0
+		dx0 = wrap(dx0, tw2)
+		dy0 = wrap(dy0, tw2)
+		dz0 = wrap(dz0, tw2)
 
-		r2 := float64(dx*dx + dy*dy + dz*dz)
-		if r2 >= rMax2 { continue }
+		r2 := float64(dx0*dx0 + dy0*dy0 + dz0*dz0)
+		if r2 >= rMax2 || r2 == 0 { continue }
 
 		r := math.Sqrt(r2)
 
-		var v float64
 		vx, vy, vz := vs[i][0] - vx0, vs[i][1] - vy0, vs[i][2] - vz0
-		if config.pType == radialPhaseProfile {
-			v = float64(vx*dx + vy*dy + vz*dz) / r
-			if config.subHub {
-				h0 := 70.0 // TODO: Fix this.
-				v -= r * h0
-			}
-		} else {
-			if config.subHub {
-				h0 := float32(70.0) // TODO: Fix this.
-				vxHub, vyHub, vzHub := h0*dx, h0*dy, h0*dz
-				vx, vy, vz = vx - vxHub, vy - vyHub, vz - vzHub
-			}
-			v = math.Sqrt(float64(vx*vx + vy*vy + vz*vz))
+		if config.subHub {
+			vx, vy, vz = vx - hFlow*dx0, vy - hFlow*dy0, vz - hFlow*dz0
+		}
+		v2 := float64(vx*vx + vy*vy + vz*vz)
+		vr := float64(vx*dx0 + vy*dy0 + vz*dz0) / r
+		vt := math.Sqrt(math.Max(v2 - vr*vr, 0))
+
+		var px, py float64
+		switch config.pType {
+		case radialPhaseProfile, infallPhaseProfile, outgoingPhaseProfile:
+			px, py = r, vr
+		case totalPhaseProfile:
+			px, py = r, math.Sqrt(v2)
+		case tangentialPhaseProfile:
+			px, py = r, vt
+		case radialTangentialPhaseProfile:
+			px, py = vr, vt
 		}
 
-		ir := int(r / dr)
-		if ir == int(config.rbins) { ir-- }
-		iv := int((v - vMin) / dv)
-		if iv >= int(config.vbins) || iv < 0 { continue }
+		ix := int((px - xMin) / dx)
+		if ix == nx { ix-- }
+		iy := int((py - yMin) / dy)
+		if px < xMin || ix >= nx || py < yMin || iy >= ny { continue }
 
-		rhos[ir*int(config.vbins) + iv] += float64(ms[i])
+		rhos[ix*ny + iy] += float64(ms[i])
 	}
 }
+
 func processPhaseProfile(
-	rs, vs, rhos []float64, rMax, vMax float64, pType phaseProfileType,
-) {	
-	vMin := 0.0
-	if pType == radialPhaseProfile { vMin = -vMax }
+	xs, ys, rhos []float64, rMax, vMax float64, pType phaseProfileType,
+) {
+	xMin, xMax, yMin, yMax := pType.ranges(rMax, vMax)
 
-	dv := (vMax - vMin) / float64(len(vs))
-	dr := rMax / float64(len(rs))
+	dx := (xMax - xMin) / float64(len(xs))
+	dy := (yMax - yMin) / float64(len(ys))
 	
-	for i := range vs {
-		vs[i] = vMin + dv*(float64(i) + 0.5)
+	for i := range ys {
+		ys[i] = yMin + dy*(float64(i) + 0.5)
 	}
 	
-	for j := range rs {
-		rs[j] = dr*(float64(j) + 0.5)
-
-		rLo := dr*float64(j)
-		rHi := dr*float64(j+1)
-		dV := (rHi*rHi*rHi - rLo*rLo*rLo) * 4 * math.Pi / 3
+	for j := range xs {
+		xs[j] = xMin + dx*(float64(j) + 0.5)
+
+		// Histograms over radius are normalized by the volume of each
+		// radial bin. The x-axis of (v_r, v_t) histograms is v_r, so they're
+		// only normalized by the area of each bin.
+		dV := dx
+		if pType != radialTangentialPhaseProfile {
+			rLo := dx*float64(j)
+			rHi := dx*float64(j+1)
+			dV = (rHi*rHi*rHi - rLo*rLo*rLo) * 4 * math.Pi / 3
+		}
 		
-		for i := range vs {
-			rhos[j*len(vs) + i] = rhos[j*len(vs) + i] / (dV * dv)
+		for i := range ys {
+			rhos[j*len(ys) + i] = rhos[j*len(ys) + i] / (dV * dy)
 		}
 	}
 }
+
+// causticCatalog finds the caustic radius of every halo and returns the
+// output catalog of caustic mode.
+func causticCatalog(
+	ids, snaps []int, hr []float64, causticSets [][][]float64,
+	gConfig *GlobalConfig, config *PhaseConfig, t time.Time,
+) []string {
+	rs := make([]float64, len(ids))
+	rMeds := make([]float64, len(ids))
+	rPixs := make([][]float64, len(ids))
+	for i := range ids {
+		rs[i], rMeds[i], rPixs[i] = processCaustics(
+			causticSets[i], hr[i], config,
+		)
+	}
+
+	cols := [][]float64{rs, rMeds}
+	names := []string{"ID", "Snapshot",
+		"R_caustic [cMpc/h]", "R_caustic_median [cMpc/h]"}
+	sizes := []int{1, 1, 1, 1}
+	if n := config.causticPixels(); n > 0 {
+		cols = append(cols, transpose(rPixs)...)
+		names = append(names, "R_caustic_pixel [cMpc/h]")
+		sizes = append(sizes, n)
+	}
+
+	order := make([]int, len(cols) + 2)
+	for i := range order { order[i] = i }
+	nameOrder := make([]int, len(names))
+	for i := range nameOrder { nameOrder[i] = i }
+	hd := catalogHeader(
+		"phase", config.vars, 0, names[:2], names[2:], nameOrder, sizes,
+	)
+
+	if logging.Mode == logging.Performance {
+		log.Printf("Time: %s", time.Since(t).String())
+		log.Printf("Memory:\n%s", logging.MemString())
+	}
+
+	return formatCatalog(gConfig, hd, [][]int{ids, snaps}, cols, order)
+}
+
+func writePhaseProfiles(
+	ids, snaps []int, xs, ys, rhos [][]float64,
+	gConfig *GlobalConfig, config *PhaseConfig,
+) error {
+	data := io.PhaseData{
+		Snaps: make([]int64, len(ids)),
+		IDs: make([]int64, len(ids)),
+		Xs: make([][]float32, len(ids)),
+		Ys: make([][]float32, len(ids)),
+		Rhos: make([][]float32, len(ids)),
+	}
+	for i := range ids {
+		data.Snaps[i], data.IDs[i] = int64(snaps[i]), int64(ids[i])
+		data.Xs[i] = float64sTo32(xs[i])
+		data.Ys[i] = float64sTo32(ys[i])
+		data.Rhos[i] = float64sTo32(rhos[i])
+	}
+
+	f, err := os.Create(config.outputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return io.WritePhase(f, gConfig.Endianness, data)
+}
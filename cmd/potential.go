@@ -3,13 +3,13 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"math"
-	"math/rand"
+	"os"
 	"sort"
 	"time"
 	"runtime"
 
-	"github.com/phil-mansfield/shellfish/los/geom"
+	"github.com/phil-mansfield/shellfish/math/grav"
+	"github.com/phil-mansfield/shellfish/math/rand"
 	"github.com/phil-mansfield/shellfish/cmd/catalog"
 	"github.com/phil-mansfield/shellfish/cmd/env"
 	"github.com/phil-mansfield/shellfish/logging"
@@ -23,34 +23,85 @@ type PotentialConfig struct {
 	rGridMult float64
 	rMinMult, rMaxMult float64
 	frac float64
+	softening, theta float64
+	potentialFile string
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &PotentialConfig{}
 
 func (config *PotentialConfig) ExampleConfig() string {
 	return `[potential.config]
-NCells = 64
-GridRMult = 8
-# RMinMult is the minimum radius inside which particles are used to calculate
-# the potential.
-RMinMult = 1
+
+#####################
+## Optional Fields ##
+#####################
+
+# Potentials are computed with a Barnes-Hut tree using every particle between
+# RMinMult*R_200m and RMaxMult*R_200m of each halo. The input catalog must
+# contain the columns ID, Snapshot, X, Y, Z, R_200m, and M_200m.
+
+# NCells is the number of grid cells on each side of the three potential
+# slices (the xy-, yz-, and xz-planes through the halo center) written to the
+# output catalog.
+# NCells = 64
+
+# GridRMult is the half-width of the potential slices as a multiple of R_200m.
+# GridRMult = 2
+
+# RMinMult is the minimum radius outside which particles are used to calculate
+# the potential, as a multiple of R_200m.
+# RMinMult = 0
+
 # RMaxMult is the maximum radius inside which particles are used to calculate
-# the potential.
-RMaxMult = 50
-# Percentage of particles that will be used to compute the potential.
-ParticleFraction = 0.01
+# the potential, as a multiple of R_200m. Potentials and energies are only
+# written for particles inside this radius.
+# RMaxMult = 3
+
+# ParticleFraction is the fraction of particles that will be used to compute
+# the potential. The masses of these particles are scaled up to compensate.
+# Particles are chosen using the same RNG seed reported by shell mode.
+# ParticleFraction = 1
+
+# Softening is the Plummer softening length in cMpc/h. If it is set to a
+# non-positive number, 0.01*R_200m will be used for each halo.
+# Softening = -1
+
+# Theta is the opening angle used when walking the tree. Smaller values are
+# more accurate and slower. Theta = 0 corresponds to direct summation.
+# Theta = 0.5
+
+# PotentialFile is a binary file that the potential and binding energy of
+# every particle within RMaxMult*R_200m of each halo will be written to. Both
+# are in physical (km/s)^2 and energies are measured relative to the bulk
+# velocity of the particles within R_200m. This file can be passed to the
+# PotentialFile variables of prof and stats mode.
+#
+# The format of the file is the same as the ShellParticleFile written by stats
+# mode, except that each halo's array of particle IDs is followed by a float32
+# array of potentials and a float32 array of binding energies. StartByte
+# points to the start of the ID array.
+#
+# If PotentialFile = "", no such file will be created.
+# PotentialFile = potentials.dat
 `
 }
 
 
 func (config *PotentialConfig) ReadConfig(fname string, flags []string) error {
-	vars := parse.NewConfigVars("prof.config")
-
-	vars.Int(&config.ncells, "RBins", 64)
-	vars.Float(&config.rGridMult, "GridRMult", 8)
-	vars.Float(&config.rMaxMult, "RMaxMult", 1.0)
-	vars.Float(&config.rMinMult, "RMinMult", 1.0)
-	vars.Float(&config.frac, "ParticleFraction", 1.0)
+	vars := parse.NewConfigVars("potential.config")
+	config.vars = vars
+
+	vars.Int(&config.ncells, "NCells", 64).Min(2)
+	vars.Float(&config.rGridMult, "GridRMult", 2).Positive()
+	vars.Float(&config.rMaxMult, "RMaxMult", 3.0).Positive()
+	vars.Float(&config.rMinMult, "RMinMult", 0.0).Min(0)
+	vars.Float(&config.frac, "ParticleFraction", 1.0).Positive().Max(1)
+	vars.Float(&config.softening, "Softening", -1)
+	vars.Float(&config.theta, "Theta", 0.5).Min(0)
+	vars.String(&config.potentialFile, "PotentialFile", "")
 
 	if fname == "" {
 		if len(flags) == 0 { return nil }
@@ -61,23 +112,17 @@ func (config *PotentialConfig) ReadConfig(fname string, flags []string) error {
 		if err := parse.ReadConfig(fname, vars); err != nil { return err }
 		if err := parse.ReadFlags(flags, vars); err != nil { return err }
 	}
-	
+
 	return config.validate()
 }
 
 func (config *PotentialConfig) validate() error {
-	if config.ncells < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"NCells", config.ncells)
-	} else if config.rGridMult < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"RGridMult", config.rGridMult)
-	} else if config.rMaxMult < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
+	if err := config.vars.Validate(); err != nil { return err }
+
+	if config.rMinMult >= config.rMaxMult {
+		return fmt.Errorf("The variable '%s' was set to %g, but the "+
+			"variable '%s' was set to %g.", "RMinMult", config.rMinMult,
 			"RMaxMult", config.rMaxMult)
-	} else if config.rMinMult < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"RMinMult", config.rMinMult)
 	}
 
 	return nil
@@ -88,19 +133,20 @@ func (config *PotentialConfig) Run(
 ) ([]string, error) {
 	if logging.Mode != logging.Nil {
 		log.Println(`
-####################
-## shellfish prof ##
-####################`,
+#########################
+## shellfish potential ##
+#########################`,
 		)
+		log.Println("RNG Seed is", randSeed)
 	}
-	
+
 	var t time.Time
 	if logging.Mode == logging.Performance {
 		t = time.Now()
 	}
 
-	icols, fcols, err := catalog.Parse(
-		stdin, []int{0, 1}, []int{2, 3, 4, 5, 6},
+	icols, fcols, _, err := catalog.ParseColumns(
+		stdin, haloRequests, coordRequests("X", "Y", "Z", "R200m", "M200m"),
 	)
 	if err != nil { return nil, err }
 
@@ -110,7 +156,7 @@ func (config *PotentialConfig) Run(
 
 	if len(ids) == 0 { return nil, fmt.Errorf("No input halos.") }
 
-	// Initialize phase profiles
+	// Initialize potential slices
 	rSets := make([][]float64, len(ids))
 	phiSets := [3][][]float64{
 		make([][]float64, len(ids)),
@@ -125,6 +171,17 @@ func (config *PotentialConfig) Run(
 		phiSets[2][i] = make([]float64, config.ncells*config.ncells)
 	}
 
+	pData := io.PotentialData{
+		Snaps: make([]int64, len(ids)),
+		IDs: make([]int64, len(ids)),
+		Particles: make([][]int64, len(ids)),
+		Phis: make([][]float32, len(ids)),
+		Energies: make([][]float32, len(ids)),
+	}
+	for i := range ids {
+		pData.Snaps[i], pData.IDs[i] = int64(snaps[i]), int64(ids[i])
+	}
+
 	snapBins, idxBins := binBySnap(snaps, ids)
 
 	sortedSnaps := []int{}
@@ -132,9 +189,9 @@ func (config *PotentialConfig) Run(
 		sortedSnaps = append(sortedSnaps, snap)
 	}
 	sort.Ints(sortedSnaps)
-	
+
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
+		e.ParticleCatalogs(snaps[0]), gConfig,
 	)
 	if err != nil {
 		return nil, err
@@ -145,7 +202,12 @@ func (config *PotentialConfig) Run(
 	workers := runtime.NumCPU()
 	if gConfig.Threads > 0 { workers = int(gConfig.Threads) }
 	runtime.GOMAXPROCS(workers)
-	
+
+	gen := rand.New(rand.Xorshift, randSeed)
+
+	br := newBlockReader(buf, gConfig, e, io.AllFields)
+	defer br.Stop()
+
 	for _, snap := range sortedSnaps {
 		if snap == -1 {
 			continue
@@ -164,56 +226,82 @@ func (config *PotentialConfig) Run(
 			snapCoords[3][i] = hr[idx]*config.rMaxMult
 		}
 
-		hds, files, err := memo.ReadHeaders(snap, buf, e)
+		hds, _, err := memo.ReadHeaders(snap, buf, e)
 		if err != nil { return nil, err }
 		hxBounds, err := boundingSpheres(snapCoords, &hds[0], e)
 		if err != nil { return nil, err }
 		_, intrIdxs := binSphereIntersections(hds, hxBounds)
 
 		for i := range hxBounds { hxBounds[i].R /= float32(config.rMaxMult) }
-		
+
+		haloBufs := make([]haloParticles, len(idxs))
+		blocks, blockSpheres := intersectingBlocks(
+			intrIdxs, hxBounds, config.rMaxMult,
+		)
+		err = br.Prefetch(snap, hds, blocks, blockSpheres)
+		if err != nil { return nil, err }
+
 		for i := range hds {
 			if len(intrIdxs[i]) == 0 { continue }
 
-			xs, _, ms, _, err := buf.Read(files[i])
+			err = br.Each(snap, i, &hds[i], blockSpheres[i], func(
+				xs, vs [][3]float32, ms []float32, pIDs []int64,
+			) error {
+				for _, j := range intrIdxs[i] {
+					rMax := float64(hxBounds[j].R) * config.rMaxMult
+					haloBufs[j].appendParticles(
+						&hds[i], hxBounds[j], rMax, xs, vs, ms, pIDs,
+					)
+				}
+				return nil
+			})
 			if err != nil {
 				return nil, err
 			}
+		}
 
-			table := make([]bool, len(xs))
-
-			// Waarrrgggble
-			for _, j := range intrIdxs[i] {
-				phisXY := phiSets[0][idxs[j]]
-				phisYZ := phiSets[1][idxs[j]]
-				phisXZ := phiSets[2][idxs[j]]
-
-				lg := NewLockGroup(workers)
-				for i := range table { table[i] = rand.Float64() <= config.frac }
-				
-				for k := 0; k < workers; k++ {
-					go insertPotentialPoints(
-						phisXY, phisYZ, phisXZ,
-						hxBounds[j],
-						xs, ms, table,
-						config, &hds[i],
-						lg.Lock(k),
+		// The RNG isn't thread-safe, so source particles are chosen before
+		// any of the workers start.
+		sources := make([][]bool, len(idxs))
+		for j := range haloBufs {
+			sources[j] = potentialSources(
+				&haloBufs[j], hr[idxs[j]], config, gen,
+			)
+		}
+
+		lg := NewLockGroup(workers)
+		for w := 0; w < workers; w++ {
+			go func(w int, lock *Lock) {
+				for j := lock.Idx; j < len(idxs); j += workers {
+					idx := idxs[j]
+					h := &haloBufs[j]
+					phis, es, tree := h.energies(
+						&hds[0], hr[idx], config.softening, config.theta,
+						sources[j], float32(1/config.frac),
 					)
-				}
-				
-				lg.Synchronize()
-			}
 
-			buf.Close()
+					potentialSlices(
+						rSets[idx], phiSets[0][idx], phiSets[1][idx],
+						phiSets[2][idx], tree, hr[idx], hm[idx], config,
+					)
+
+					if config.potentialFile != "" {
+						pData.Particles[idx] = h.ids
+						pData.Phis[idx] = float64sTo32(phis)
+						pData.Energies[idx] = float64sTo32(es)
+					}
+
+					haloBufs[j] = haloParticles{}
+				}
+				lock.Unlock()
+			}(w, lg.Lock(w))
 		}
+		lg.Synchronize()
 	}
-	
-	for i := range rSets {
-		processPotential(
-			rSets[i],
-			phiSets[0][i], phiSets[1][i], phiSets[2][i],
-			hr[i], hm[i], config,
-		)
+
+	if config.potentialFile != "" {
+		err := writePotentials(pData, gConfig, config)
+		if err != nil { return nil, err }
 	}
 
 	rSets = transpose(rSets)
@@ -223,21 +311,14 @@ func (config *PotentialConfig) Run(
 
 	order := make([]int, len(rSets) + len(phiSets[0])*3 + 2)
 	for i := range order { order[i] = i }
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps},
-		append(append(append(
-			rSets, phiSets[0]...),
-			phiSets[1]...),
-			phiSets[2]...),
-		order,
-	)
-	
-	cString := catalog.CommentString(
-		[]string{"ID", "Snapshot", "R [cMpc/h]",
+	hd := catalogHeader(
+		"potential", config.vars, 0,
+		[]string{"ID", "Snapshot"},
+		[]string{"R [cMpc/h]",
 			"Phi_xy/(G Mvir / Rvir)",
 			"Phi_yz/(G Mvir / Rvir)",
 			"Phi_xz/(G Mvir / Rvir)"},
-		[]string{}, []int{0, 1, 2, 3, 4, 5},
+		[]int{0, 1, 2, 3, 4, 5},
 		[]int{1, 1, int(config.ncells),
 			int(config.ncells*config.ncells),
 			int(config.ncells*config.ncells),
@@ -249,81 +330,75 @@ func (config *PotentialConfig) Run(
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(
+		gConfig, hd, [][]int{ids, snaps},
+		append(append(append(
+			rSets, phiSets[0]...),
+			phiSets[1]...),
+			phiSets[2]...),
+		order,
+	), nil
 }
 
-func insertPotentialPoints(
-	phisXY, phisYZ, phisXZ []float64,
-	hx geom.Sphere,
-	xs [][3]float32,
-	ms []float32,
-	skipTable []bool,
-	config *PotentialConfig, hd *io.Header,
-	lock *Lock,
-) {
-	rmax2 := (config.rMaxMult*config.rMaxMult) * float64(hx.R*hx.R)
-	rmin2 := (config.rMinMult*config.rMinMult) * float64(hx.R*hx.R)
-	
-	gridR := float64(hx.R) * config.rGridMult
-	delta := gridR * 2 / float64(config.ncells - 1)
-	nc := int(config.ncells)
-	
-	for i := 0; i < len(xs); i++ {
-		if !skipTable[i] { continue }
-		
-		dx0 := float64(xs[i][0] - hx.C[0])
-		dy0 := float64(xs[i][1] - hx.C[1])
-		dz0 := float64(xs[i][2] - hx.C[2])
-		
-		dr02 := dx0*dx0 + dy0*dy0 + dz0*dz0
-		pm := float64(ms[i])
-
-		if dr02 > rmax2 || dr02 < rmin2 { continue }
-		
-		for i := lock.Idx; i < len(phisXY); i += lock.Workers {
-			var (
-				ix, iy, iz int
-				dx, dy, dz, dr float64
-			)
-
-			// phiXY
-			ix, iy = i % nc, i / nc
-			dx = dx0 - gridR + delta*float64(ix)
-			dy = dy0 - gridR + delta*float64(iy)
-			dr = math.Sqrt(dx*dx + dy*dy + dz0*dz0)
-			phisXY[i] -= pm/dr
-
-			// phiXZ
-			ix, iz = i % nc, i / nc
-			dx = dx0 - gridR + delta*float64(ix)
-			dz = dz0 - gridR + delta*float64(iz)
-			dr = math.Sqrt(dx*dx + dy0*dy0 + dz*dz)
-			phisXZ[i] -= pm/dr
-
-			// phiYZ
-			iy, iz = i % nc, i / nc
-			dy = dy0 - gridR + delta*float64(iy)
-			dz = dz0 - gridR + delta*float64(iz)
-			dr = math.Sqrt(dx0*dx0 + dy*dy + dz*dz)
-			phisYZ[i] -= pm/dr
-		}
+// potentialSources returns a slice indicating which particles in h should
+// contribute to the potential.
+func potentialSources(
+	h *haloParticles, hr float64, config *PotentialConfig, gen *rand.Generator,
+) []bool {
+	rMin := hr * config.rMinMult
+	sources := make([]bool, len(h.xs))
+	for i := range sources {
+		sources[i] = h.radius(i) >= rMin &&
+			(config.frac >= 1 || gen.Uniform(0, 1) < config.frac)
 	}
-	
-	lock.Unlock()
+	return sources
 }
 
-func processPotential(
-	rs, phisXY, phisYZ, phisXZ []float64,
+// potentialSlices evaluates the potential of tree on the xy-, yz-, and
+// xz-planes through the halo center in units of G M_200m / R_200m (with
+// comoving lengths) and writes the grid coordinates to rs.
+func potentialSlices(
+	rs, phisXY, phisYZ, phisXZ []float64, tree *grav.Tree,
 	hr, hm float64, config *PotentialConfig,
 ) {
+	nc := int(config.ncells)
+	gridR := hr * config.rGridMult
+	delta := gridR * 2 / float64(nc - 1)
+	for i := range rs {
+		rs[i] = delta*float64(i) - gridR
+	}
+
+	norm := hm / hr
 	for i := range phisXY {
-		phisXY[i] /= config.frac * hm/hr
-		phisXZ[i] /= config.frac * hm/hr
-		phisYZ[i] /= config.frac * hm/hr
+		u, v := float32(rs[i % nc]), float32(rs[i / nc])
+		phisXY[i] = tree.Potential([3]float32{u, v, 0}) / norm
+		phisYZ[i] = tree.Potential([3]float32{0, u, v}) / norm
+		phisXZ[i] = tree.Potential([3]float32{u, 0, v}) / norm
+	}
+}
+
+func writePotentials(
+	data io.PotentialData, gConfig *GlobalConfig, config *PotentialConfig,
+) error {
+	for i := range data.Particles {
+		if data.Particles[i] == nil {
+			data.Particles[i] = []int64{}
+			data.Phis[i] = []float32{}
+			data.Energies[i] = []float32{}
+		}
 	}
 
-	delta := hr * config.rGridMult * 2 / float64(config.ncells)
-	for i := range rs {
-		rs[i] = delta*float64(i) - hr*config.rGridMult
+	f, err := os.Create(config.potentialFile)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+
+	return io.WritePotential(f, gConfig.Endianness, data)
+}
+
+func float64sTo32(xs []float64) []float32 {
+	out := make([]float32, len(xs))
+	for i := range xs { out[i] = float32(xs[i]) }
+	return out
 }
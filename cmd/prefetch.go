@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/phil-mansfield/shellfish/io"
+)
+
+// prefetchParticleBytes is the approximate number of bytes needed to store a
+// single particle's position, velocity, mass, and ID.
+const prefetchParticleBytes = 12 + 12 + 4 + 8
+
+// prefetchBlock is a block which has been read by a prefetcher.
+type prefetchBlock struct {
+	block int
+	buf   io.VectorBuffer
+	bytes int64
+
+	xs, vs [][3]float32
+	ms     []float32
+	ids    []int64
+	err    error
+}
+
+// prefetcher reads blocks on a background goroutine. VectorBuffers are not
+// threadsafe, so every block is read with a buffer from free which is not
+// returned to free until the block has been released. This means that the
+// number of buffers in free limits how far ahead the prefetcher can read.
+type prefetcher struct {
+	blocks chan *prefetchBlock
+	free   chan io.VectorBuffer
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	stopped  bool
+	inFlight int64
+	budget   int64
+}
+
+// newPrefetcher creates a prefetcher which reads with the buffers in bufs and
+// which tries to keep fewer than budget bytes of particles in memory at
+// once. If budget is non-positive, memory usage is only limited by the number
+// of buffers.
+func newPrefetcher(bufs []io.VectorBuffer, budget int64) *prefetcher {
+	pf := &prefetcher{
+		blocks: make(chan *prefetchBlock, len(bufs)),
+		free: make(chan io.VectorBuffer, len(bufs)),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		budget: budget,
+	}
+	pf.cond = sync.NewCond(&pf.mu)
+	for _, buf := range bufs { pf.free <- buf }
+	return pf
+}
+
+// run reads the given blocks in order with read and sends them to
+// pf.blocks. sizes gives the number of particles in each block. run closes
+// pf.blocks when it's finished.
+func (pf *prefetcher) run(
+	blocks []int, sizes []int64,
+	read func(k int, buf io.VectorBuffer) (
+		xs, vs [][3]float32, ms []float32, ids []int64, err error,
+	),
+) {
+	defer close(pf.done)
+	defer close(pf.blocks)
+
+	for k, block := range blocks {
+		var buf io.VectorBuffer
+		select {
+		case buf = <-pf.free:
+		case <-pf.stop:
+			return
+		}
+
+		pb := &prefetchBlock{
+			block: block, buf: buf, bytes: sizes[k]*prefetchParticleBytes,
+		}
+		if !pf.reserve(pb.bytes) {
+			pf.free <- buf
+			return
+		}
+
+		pb.xs, pb.vs, pb.ms, pb.ids, pb.err = read(k, buf)
+
+		select {
+		case pf.blocks <- pb:
+		case <-pf.stop:
+			pf.release(pb)
+			return
+		}
+	}
+}
+
+// reserve waits until there is enough room in the memory budget for a block
+// of the given size and reserves it. A block is always allowed to be read if
+// nothing else is in memory. false is returned if the prefetcher is stopped
+// while waiting.
+func (pf *prefetcher) reserve(bytes int64) bool {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	for !pf.stopped && pf.budget > 0 && pf.inFlight > 0 &&
+		pf.inFlight + bytes > pf.budget {
+		pf.cond.Wait()
+	}
+	if pf.stopped { return false }
+
+	pf.inFlight += bytes
+	return true
+}
+
+// release closes the buffer of a block, returns it to the free list, and
+// frees up its part of the memory budget.
+func (pf *prefetcher) release(pb *prefetchBlock) {
+	if pb.buf.IsOpen() { pb.buf.Close() }
+	pf.free <- pb.buf
+
+	pf.mu.Lock()
+	pf.inFlight -= pb.bytes
+	pf.mu.Unlock()
+	pf.cond.Broadcast()
+}
+
+// Stop stops the prefetcher, waits for the background goroutine to exit, and
+// releases every block which was read but never used.
+func (pf *prefetcher) Stop() {
+	pf.mu.Lock()
+	if !pf.stopped {
+		pf.stopped = true
+		close(pf.stop)
+	}
+	pf.mu.Unlock()
+	pf.cond.Broadcast()
+
+	<-pf.done
+	for pb := range pf.blocks { pf.release(pb) }
+}
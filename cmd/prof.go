@@ -6,7 +6,6 @@ import (
 	"math"
 	"sort"
 	"time"
-	"math/rand"
 	"runtime"
 
 	msort "github.com/phil-mansfield/shellfish/math/sort"
@@ -15,6 +14,7 @@ import (
 	"github.com/phil-mansfield/shellfish/cmd/catalog"
 	"github.com/phil-mansfield/shellfish/cmd/env"
 	"github.com/phil-mansfield/shellfish/logging"
+	"github.com/phil-mansfield/shellfish/math/rand"
 	"github.com/phil-mansfield/shellfish/parse"
 	"github.com/phil-mansfield/shellfish/io"
 	"github.com/phil-mansfield/shellfish/cmd/memo"
@@ -25,9 +25,14 @@ type ProfConfig struct {
 	rMaxMult, rMinMult float64
 	medianPixelLevel int64
 	percentile float64
+	boundIterations int64
+	boundSoftening float64
+	potentialFile string
 
-	pType profileType
+	pTypes []profileType
 
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 type profileType int
@@ -40,6 +45,76 @@ const (
 	boundDensityProfile
 )
 
+// profileTypeNames maps the values of the ProfileType variable onto
+// profileTypes.
+var profileTypeNames = map[string]profileType{
+	"density": densityProfile,
+	"median-density": medianDensityProfile,
+	"median-error": medianErrorProfile,
+	"contained-density": containedDensityProfile,
+	"angular-fraction": angularFractionProfile,
+	"bound-density": boundDensityProfile,
+}
+
+func (t profileType) String() string {
+	for name, tt := range profileTypeNames {
+		if t == tt { return name }
+	}
+	panic("Impossible")
+}
+
+// columnName returns the name of the column group that a profile of type t
+// is written to.
+func (t profileType) columnName() string {
+	switch t {
+	case densityProfile:
+		return "Rho [h^2 Msun/cMpc^3]"
+	case medianDensityProfile:
+		return "Rho_median [h^2 Msun/cMpc^3]"
+	case medianErrorProfile:
+		return "Rho_median_err [h^2 Msun/cMpc^3]"
+	case containedDensityProfile:
+		return "Rho_contained [h^2 Msun/cMpc^3]"
+	case angularFractionProfile:
+		return "Volume Fraction Contained"
+	case boundDensityProfile:
+		return "Rho_bound [h^2 Msun/cMpc^3]"
+	}
+	panic("Impossible")
+}
+
+// hasType returns true if the profile type t was requested.
+func (config *ProfConfig) hasType(t profileType) bool {
+	for _, tt := range config.pTypes {
+		if tt == t { return true }
+	}
+	return false
+}
+
+// typeIndex returns the index of the profile type t in config.pTypes.
+func (config *ProfConfig) typeIndex(t profileType) int {
+	for i, tt := range config.pTypes {
+		if tt == t { return i }
+	}
+	panic("Impossible")
+}
+
+// needsMedian returns true if any of the requested profiles need to be binned
+// by angular pixel.
+func (config *ProfConfig) needsMedian() bool {
+	return config.hasType(medianDensityProfile) ||
+		config.hasType(medianErrorProfile)
+}
+
+// needsParticles returns true if any of the requested profiles need to be
+// computed from the particle snapshots.
+func (config *ProfConfig) needsParticles() bool {
+	for _, t := range config.pTypes {
+		if t != angularFractionProfile { return true }
+	}
+	return false
+}
+
 var _ Mode = &ProfConfig{}
 
 func (config *ProfConfig) ExampleConfig() string {
@@ -49,7 +124,12 @@ func (config *ProfConfig) ExampleConfig() string {
 ## Required Fields ##
 #####################
 
-# ProfileType determines what type of profile will be output.
+# ProfileType determines what type of profile will be output. It may be a
+# single profile type or a list of them (e.g. "density, median-density"), in
+# which case every profile is computed during the same pass over the particle
+# snapshots. The output catalog will contain a single column group of radii
+# followed by one column group for each profile, in the order listed here.
+#
 # Known profile types are:
 # density -           The traditional spherical densiy profile that we all
 #                     know and love.
@@ -60,7 +140,11 @@ func (config *ProfConfig) ExampleConfig() string {
 # contained-densiy -  A density profile which only uses particles.
 # angular-fraction -  The angular fraction at each radius which is contained
 #                     within the shell.
-# bound-density -     The density of bound matter, assuming an NFW profile.
+# bound-density -     The density of matter gravitationally bound to the halo.
+#                     Boundness is found by iteratively unbinding all the
+#                     particles within RMaxMult*R_200m using their actual
+#                     potential. This requires every particle around a halo
+#                     to be held in memory at once.
 ProfileType = median-density
 
 # Order is the order of the Penna-Dines shell fit that Shellfish uses. This
@@ -96,22 +180,43 @@ ProfileType = median-density
 
 # RMinMult is the minimum radius of the profile as a function of R_200m.
 # RMinMult = 0.03
+
+# BoundIterations is the maximum number of unbinding iterations used by
+# bound-density profiles. During each iteration the potential and the halo's
+# bulk velocity (measured within R_200m) are recomputed using only the
+# particles which are still bound.
+# BoundIterations = 10
+
+# BoundSoftening is the softening length, in cMpc/h, used when computing
+# potentials for bound-density profiles. If it is set to a non-positive
+# number, 0.01*R_200m will be used for each halo.
+# BoundSoftening = -1
+
+# PotentialFile is a file of particle binding energies written by potential
+# mode. If it is set, bound-density profiles will use the binding energies in
+# this file instead of unbinding particles themselves, and BoundIterations and
+# BoundSoftening will be ignored. Every input halo must be in the file.
+# PotentialFile = potentials.dat
 `
 }
 
 
 func (config *ProfConfig) ReadConfig(fname string, flags []string) error {
 	vars := parse.NewConfigVars("prof.config")
+	config.vars = vars
 
-	vars.Int(&config.bins, "Bins", 150)
+	vars.Int(&config.bins, "Bins", 150).Min(0)
 	vars.Int(&config.order, "Order", 3)
 	vars.Int(&config.samples, "Samples", 50 * 1000)
-	vars.Float(&config.rMaxMult, "RMaxMult", 3.0)
-	vars.Float(&config.rMinMult, "RMinMult", 0.03)
-	vars.Int(&config.medianPixelLevel, "MedianPixelLevel", 3)
+	vars.Float(&config.rMaxMult, "RMaxMult", 3.0).Positive()
+	vars.Float(&config.rMinMult, "RMinMult", 0.03).Positive()
+	vars.Int(&config.medianPixelLevel, "MedianPixelLevel", 3).Min(0)
 	vars.Float(&config.percentile, "Percentile", 50)
-	var pType string
-	vars.String(&pType, "ProfileType", "")
+	vars.Int(&config.boundIterations, "BoundIterations", 10).Min(1)
+	vars.Float(&config.boundSoftening, "BoundSoftening", -1)
+	vars.String(&config.potentialFile, "PotentialFile", "")
+	var pTypes []string
+	vars.Strings(&pTypes, "ProfileType", []string{})
 
 	if fname == "" {
 		if len(flags) == 0 {
@@ -132,44 +237,30 @@ func (config *ProfConfig) ReadConfig(fname string, flags []string) error {
 	}
 	
 	// Needs to be done here: can't be in the validate method.
-	switch pType {
-	case "":
+	if len(pTypes) == 0 || (len(pTypes) == 1 && pTypes[0] == "") {
 		return fmt.Errorf("The variable 'ProfileType' was not set.")
-	case "density":
-		config.pType = densityProfile
-	case "median-density":
-		config.pType = medianDensityProfile
-	case "median-error":
-		config.pType = medianErrorProfile
-	case "contained-density":
-		config.pType = containedDensityProfile
-	case "angular-fraction":
-		config.pType = angularFractionProfile
-	case "bound-density":
-		config.pType = boundDensityProfile
-	default:
-		return fmt.Errorf("The varaiable 'ProfileType' was set to '%s'.", pType)
+	}
+	config.pTypes = make([]profileType, len(pTypes))
+	for i, name := range pTypes {
+		pType, ok := profileTypeNames[name]
+		if !ok {
+			return fmt.Errorf("Item %d of the variable 'ProfileType' was " +
+				"set to '%s', which I don't recognize.", i, name)
+		}
+		for j := 0; j < i; j++ {
+			if config.pTypes[j] == pType {
+				return fmt.Errorf("The variable 'ProfileType' contains " +
+					"'%s' more than once.", name)
+			}
+		}
+		config.pTypes[i] = pType
 	}
 	
 	return config.validate()
 }
 
 func (config *ProfConfig) validate() error {
-	if config.bins < 0 {
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"Bins", config.bins)
-	} else if config.rMinMult <= 0 {
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"RMinMult", config.rMinMult)
-	} else if config.rMaxMult <= 0 {
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"RMinMult", config.rMinMult)
-	} else if config.medianPixelLevel < 0 {
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"MedianPixelLevel", config.medianPixelLevel)
-	}
-
-	return nil
+	return config.vars.Validate()
 }
 
 func (config *ProfConfig) Run(
@@ -181,6 +272,7 @@ func (config *ProfConfig) Run(
 ## shellfish prof ##
 ####################`,
 		)
+		log.Println("RNG Seed is", randSeed)
 	}
 	
 	var t time.Time
@@ -191,43 +283,31 @@ func (config *ProfConfig) Run(
 	var (
 		intCols [][]int
 		coords  [][]float64
-		vCoords [][]float64
-		scaleRs []float64
-		masses  []float64
 		shells []analyze.Shell
 		err error
 	)
 
-	switch config.pType {
-	case densityProfile, medianDensityProfile, medianErrorProfile:
-		intColIdxs := []int{0, 1}
-		floatColIdxs := []int{2, 3, 4, 5}
-		
-		intCols, coords, err = catalog.Parse(
-			stdin, intColIdxs, floatColIdxs,
+	switch {
+	case config.hasType(containedDensityProfile),
+		config.hasType(angularFractionProfile):
+		floatReqs := append(
+			coordRequests("X", "Y", "Z", "R200m"),
+			catalog.Request{
+				Name: "P_ijk", Width: int(2*config.order*config.order),
+			},
 		)
-		
+
+		hd, err := catalog.ParseHeader(stdin)
 		if err != nil {
 			return nil, err
 		}
-
-		shells = make([]analyze.Shell, len(coords[0]))
-		vCoords = make([][]float64, 3)
-		masses = make([]float64, len(coords[0]))
-		scaleRs = make([]float64, len(coords[0]))
-		for i := range vCoords {
-			vCoords[i] = make([]float64, len(coords[0]))
-		}
-	case containedDensityProfile, angularFractionProfile:
-		intColIdxs := []int{0, 1}
-		floatColIdxs := make([]int, 4 + config.order*config.order*2)
-		for i := range floatColIdxs {
-			floatColIdxs[i] += i + 2
+		if err = hd.CheckPenna(int(config.order)); err != nil {
+			return nil, err
 		}
 
 		var floatCols [][]float64
-		intCols, floatCols, err = catalog.Parse(
-			stdin, intColIdxs, floatColIdxs,
+		intCols, floatCols, _, err = catalog.ParseColumns(
+			stdin, haloRequests, floatReqs,
 		)
 
 		if err != nil {
@@ -245,25 +325,10 @@ func (config *ProfConfig) Run(
 			order := int(config.order)
 			shells[i] = analyze.PennaFunc(coeffVec, order, order, 2)
 		}
-
-		scaleRs = make([]float64, len(coords[0]))
-		masses = make([]float64, len(coords[0]))
-		vCoords = make([][]float64, 3)
-		for i := range vCoords {
-			vCoords[i] = make([]float64, len(coords[0]))
-		}
-	case boundDensityProfile:
-		intColIdxs := []int{0, 1}
-		floatColIdxs := []int{2, 3, 4, 5, 6, 7, 8, 9, 10}
-
-		var fCols [][]float64
-		intCols, fCols, err = catalog.Parse(
-			stdin, intColIdxs, floatColIdxs,
+	default:
+		intCols, coords, _, err = catalog.ParseColumns(
+			stdin, haloRequests, coordRequests("X", "Y", "Z", "R200m"),
 		)
-		if err != nil { return nil, err }
-
-		coords, masses, scaleRs, vCoords =
-			fCols[:4], fCols[4], fCols[5], fCols[6:9]
 		
 		if err != nil {
 			return nil, err
@@ -272,33 +337,34 @@ func (config *ProfConfig) Run(
 		shells = make([]analyze.Shell, len(coords[0]))
 	}
 
-	if len(intCols) == 0 {
+	if len(intCols) == 0 || len(intCols[0]) == 0 {
 		return nil, fmt.Errorf("No input IDs.")
 	}
 
 	ids, snaps := intCols[0], intCols[1]
 	snapBins, idxBins := binBySnap(snaps, ids)
 
-	if config.pType == angularFractionProfile {
-		return angularFractionMain(ids, snaps, shells, coords[3], config)
-	}
-
-	// Profiles for everyone
+	// Profiles for everyone. Every requested profile type gets its own set of
+	// accumulators so they can all be filled during the same read of a block.
 	rSets := make([][]float64, len(ids))
-	rhoSets := make([][]float64, len(ids))
 	for i := range rSets {
 		rSets[i] = make([]float64, config.bins)
-		rhoSets[i] = make([]float64, config.bins)
+	}
+	rhoSets := make([][][]float64, len(config.pTypes))
+	for k := range rhoSets {
+		rhoSets[k] = make([][]float64, len(ids))
+		for i := range rhoSets[k] {
+			rhoSets[k][i] = make([]float64, config.bins)
+		}
 	}
 
-	// Workspace buffers just for the median-density mode.
+	// Workspace buffers just for the median-density mode. median-density
+	// and median-error profiles share the same angular bins.
 	var (
 		medRhoSets [][][]float64
 		medScratchBuffer []float64
 	)
-	if config.pType == medianDensityProfile ||
-		config.pType == medianErrorProfile {
-
+	if config.needsMedian() {
 		medRhoSets = make([][][]float64, len(ids))
 		n := geom.SpherePixelNum(int(config.medianPixelLevel))
 		medScratchBuffer = make([]float64, n)
@@ -311,19 +377,11 @@ func (config *ProfConfig) Run(
 
 	}
 
-
 	sortedSnaps := []int{}
 	for snap := range snapBins {
 		sortedSnaps = append(sortedSnaps, snap)
 	}
 	sort.Ints(sortedSnaps)
-	
-	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
-	)
-	if err != nil {
-		return nil, err
-	}
 
 	// Count number of workers
 
@@ -331,124 +389,202 @@ func (config *ProfConfig) Run(
 	if gConfig.Threads > 0 { workers = int(gConfig.Threads) }
 	runtime.GOMAXPROCS(workers)
 
+	var energies map[haloKey]map[int64]float32
+	if config.hasType(boundDensityProfile) && config.potentialFile != "" {
+		energies, err = readPotentialEnergies(config.potentialFile)
+		if err != nil { return nil, err }
+	}
+
+	var buf io.VectorBuffer
+	if config.needsParticles() {
+		buf, err = getVectorBuffer(
+			e.ParticleCatalogs(snaps[0]), gConfig,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Velocities and IDs are only needed for unbinding.
+	fields := io.Positions | io.Masses
+	if config.hasType(boundDensityProfile) { fields = io.AllFields }
+	br := newBlockReader(buf, gConfig, e, fields)
+	defer br.Stop()
+
 	for _, snap := range sortedSnaps {
-		if snap == -1 {
+		if snap == -1 || !config.needsParticles() {
 			continue
 		}
 
 		idxs := idxBins[snap]
 		snapCoords := [][]float64{
-			// radii and positions
-			make([]float64, len(idxs)), make([]float64, len(idxs)),
 			make([]float64, len(idxs)), make([]float64, len(idxs)),
-			// scale radii
 			make([]float64, len(idxs)), make([]float64, len(idxs)),
-			// halo velocities
-			make([]float64, len(idxs)),  make([]float64, len(idxs)),
-			make([]float64, len(idxs)),
 		}
 		for i, idx := range idxs {
 			snapCoords[0][i] = coords[0][idx]
 			snapCoords[1][i] = coords[1][idx]
 			snapCoords[2][i] = coords[2][idx]
 			snapCoords[3][i] = coords[3][idx]
-
-			snapCoords[4][i] = masses[idx]
-			snapCoords[5][i] = scaleRs[idx]
-
-			snapCoords[6][i] = vCoords[0][idx]
-			snapCoords[7][i] = vCoords[1][idx]
-			snapCoords[8][i] = vCoords[2][idx]
 		}
-		hds, files, err := memo.ReadHeaders(snap, buf, e)
+		hds, _, err := memo.ReadHeaders(snap, buf, e)
 		if err != nil {
 			return nil, err
 		}
-		hBounds, err := extendedBoundingSpheres(snapCoords, &hds[0], e)
+		hBounds, err := boundingSpheres(snapCoords, &hds[0], e)
 		if err != nil {
 			return nil, err
 		}
 
-		for i := range hBounds { hBounds[i].S.R *= float32(config.rMaxMult) }
-		_, intrIdxs := binExtendedSphereIntersections(hds, hBounds)
-		for i := range hBounds { hBounds[i].S.R /= float32(config.rMaxMult) }
+		for i := range hBounds { hBounds[i].R *= float32(config.rMaxMult) }
+		_, intrIdxs := binSphereIntersections(hds, hBounds)
+		for i := range hBounds { hBounds[i].R /= float32(config.rMaxMult) }
+
+		// bound-density profiles can only be computed once every block
+		// around a halo has been read.
+		var haloBufs []haloParticles
+		if config.hasType(boundDensityProfile) {
+			haloBufs = make([]haloParticles, len(idxs))
+		}
 		
+		blocks, blockSpheres := intersectingBlocks(
+			intrIdxs, hBounds, config.rMaxMult,
+		)
+		err = br.Prefetch(snap, hds, blocks, blockSpheres)
+		if err != nil { return nil, err }
+
 		for i := range hds {
 			if len(intrIdxs[i]) == 0 {
 				continue
 			}
 
-			xs, vs, ms, _, err := buf.Read(files[i])
-			if err != nil { return nil, err }
-			
-			lg := NewLockGroup(workers)
-
-			for w := 0; w < workers; w++ {
-				go func(w int, lock *Lock) {
-					// Waarrrgggble
-					for jj := lock.Idx; jj < len(intrIdxs[i]); jj += workers {
-						j := intrIdxs[i][jj]
+			err = br.Each(snap, i, &hds[i], blockSpheres[i], func(
+				xs, vs [][3]float32, ms []float32, pIDs []int64,
+			) error {
+				lg := NewLockGroup(workers)
+
+				for w := 0; w < workers; w++ {
+					go func(w int, lock *Lock) {
+						// Waarrrgggble
+						rhos := make([][]float64, len(config.pTypes))
+						intr := intrIdxs[i]
+						for jj := lock.Idx; jj < len(intr); jj += workers {
+							j := intr[jj]
 						
-						rhos := rhoSets[idxs[j]]
-						s := hBounds[j]
-						
-						if config.pType == medianDensityProfile ||
-							config.pType == medianErrorProfile {
-							medRhos := medRhoSets[idxs[j]]
-							insertMedianPoints(
-								medRhos, s, xs, ms, config, &hds[i],
-							)
-						} else {
+							for k := range rhos {
+								rhos[k] = rhoSets[k][idxs[j]]
+							}
+							var medRhos [][]float64
+							if medRhoSets != nil {
+								medRhos = medRhoSets[idxs[j]]
+							}
+
 							insertPoints(
-								rhos, s, xs, vs, ms,
+								rhos, medRhos, hBounds[j], xs, ms,
 								shells[idxs[j]], config, &hds[i],
 							)
+
+							if haloBufs != nil {
+								rMax := float64(hBounds[j].R) * config.rMaxMult
+								haloBufs[j].appendParticles(
+									&hds[i], hBounds[j], rMax,
+									xs, vs, ms, pIDs,
+								)
+							}
 						}
-					}
 
+						lock.Unlock()
+					}(w, lg.Lock(w))
+				}
+			
+				lg.Synchronize()
+				return nil
+			})
+			if err != nil { return nil, err }
+		}
+
+		if haloBufs != nil {
+			k := config.typeIndex(boundDensityProfile)
+
+			var haloEnergies []map[int64]float32
+			if energies != nil {
+				haloEnergies = make([]map[int64]float32, len(idxs))
+				for j, idx := range idxs {
+					haloEnergies[j], err = lookupEnergies(
+						energies, ids[idx], snap, config.potentialFile,
+					)
+					if err != nil { return nil, err }
+				}
+			}
+
+			lg := NewLockGroup(workers)
+			for w := 0; w < workers; w++ {
+				go func(w int, lock *Lock) {
+					for j := lock.Idx; j < len(haloBufs); j += workers {
+						var es map[int64]float32
+						if haloEnergies != nil { es = haloEnergies[j] }
+						insertBoundPoints(
+							rhoSets[k][idxs[j]], &haloBufs[j], es,
+							float64(hBounds[j].R), config, &hds[0],
+						)
+						haloBufs[j] = haloParticles{}
+					}
 					lock.Unlock()
 				}(w, lg.Lock(w))
 			}
-			
 			lg.Synchronize()
-			
-			buf.Close()
-
-			return nil, nil
 		}
 	}
 	
+	// Bootstrap errors and angular fractions are found by Monte Carlo
+	// sampling.
+	gen := rand.New(rand.Xorshift, randSeed)
 	for i := range rSets {
 		rMax := coords[3][i]*config.rMaxMult
 		rMin := coords[3][i]*config.rMinMult
-		if config.pType == medianDensityProfile {
-			processMedianProfile(rSets[i], rhoSets[i],
-				medRhoSets[i], medScratchBuffer, rMin, rMax,
-				config.percentile,
-			)
-		} else if config.pType == medianErrorProfile {
-			processMedianErrorProfile(rSets[i], rhoSets[i],
-				medRhoSets[i], medScratchBuffer, rMin, rMax,
-				config.percentile, config.samples,
-			)
-		} else {
-			processProfile(rSets[i], rhoSets[i], rMin, rMax)
+		// Every profile type shares the same radial bins. The density
+		// profiles also write them, but angular-fraction doesn't.
+		profileRadii(rSets[i], rMin, rMax)
+		for k, pType := range config.pTypes {
+			switch pType {
+			case medianDensityProfile:
+				processMedianProfile(rSets[i], rhoSets[k][i],
+					medRhoSets[i], medScratchBuffer, rMin, rMax,
+					config.percentile,
+				)
+			case medianErrorProfile:
+				processMedianErrorProfile(rSets[i], rhoSets[k][i],
+					medRhoSets[i], medScratchBuffer, rMin, rMax,
+					config.percentile, config.samples, gen,
+				)
+			case angularFractionProfile:
+				_, fs := shells[i].AngularFractionProfile(
+					int(config.samples), int(config.bins), rMin, rMax, gen,
+				)
+				copy(rhoSets[k][i], fs)
+			default:
+				processProfile(rSets[i], rhoSets[k][i], rMin, rMax)
+			}
 		}
 	}
 
-	rSets = transpose(rSets)
-	rhoSets = transpose(rhoSets)
+	cols := transpose(rSets)
+	for k := range rhoSets {
+		cols = append(cols, transpose(rhoSets[k])...)
+	}
 
-	order := make([]int, len(rSets) + len(rhoSets) + 2)
+	order := make([]int, len(cols) + 2)
 	for i := range order { order[i] = i }
-	lines := catalog.FormatCols(
-			[][]int{ids, snaps}, append(rSets, rhoSets...), order,
-	)
-	
-	cString := catalog.CommentString(
-		[]string{"ID", "Snapshot", "R [cMpc/h]", "Rho [h^2 Msun/cMpc^3]"},
-		[]string{}, []int{0, 1, 2, 3},
-		[]int{1, 1, int(config.bins), int(config.bins)},
+	names := []string{"ID", "Snapshot", "R [cMpc/h]"}
+	sizes := []int{1, 1, int(config.bins)}
+	for _, pType := range config.pTypes {
+		names = append(names, pType.columnName())
+		sizes = append(sizes, int(config.bins))
+	}
+	nameOrder := make([]int, len(names))
+	for i := range nameOrder { nameOrder[i] = i }
+	hd := catalogHeader(
+		"prof", config.vars, 0, names[:2], names[2:], nameOrder, sizes,
 	)
 
 	if logging.Mode == logging.Performance {
@@ -456,29 +592,32 @@ func (config *ProfConfig) Run(
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(gConfig, hd, [][]int{ids, snaps}, cols, order), nil
 }
 
-// rhos is a buffer and will be cleared before use
+// insertPoints adds every particle in xs to the profiles of a single halo.
+// rhos contains one accumulator per requested profile type (in the same order
+// as config.pTypes) and medRhos is the angular-pixel accumulator used by
+// median profiles. medRhos may be nil if no median profiles were requested.
+// Accumulators are added to, not cleared. bound-density profiles are
+// handled separately by insertBoundPoints.
 func insertPoints(
-	rhos []float64, s ExtendedSphere, xs, vs [][3]float32,
-	ms []float32, shell analyze.Shell, config *ProfConfig, hd *io.Header,
+	rhos [][]float64, medRhos [][]float64, s geom.Sphere,
+	xs [][3]float32, ms []float32, shell analyze.Shell,
+	config *ProfConfig, hd *io.Header,
 ) {
-	lrMax := math.Log(float64(s.S.R) * config.rMaxMult)
-	lrMin := math.Log(float64(s.S.R) * config.rMinMult)
+	lrMax := math.Log(float64(s.R) * config.rMaxMult)
+	lrMin := math.Log(float64(s.R) * config.rMinMult)
 	dlr := (lrMax - lrMin) / float64(config.bins)
-	rMax2 := s.S.R * float32(config.rMaxMult)
-	rMin2 := s.S.R * float32(config.rMinMult)
+	rMax2 := s.R * float32(config.rMaxMult)
+	rMin2 := s.R * float32(config.rMinMult)
 	rMax2 *= rMax2
 	rMin2 *= rMin2
 
-	x0, y0, z0 := s.S.C[0], s.S.C[1], s.S.C[2]
+	x0, y0, z0 := s.C[0], s.C[1], s.C[2]
 	tw2 := float32(hd.TotalWidth) / 2
 
-	vVir := float32(508.0 * math.Sqrt(float64((s.M / 6e13) / (s.S.R / 1.0))))
-	cVir := s.S.R / s.Rs
-	phiPrefactor := 1/(float32(math.Log(1 + float64(cVir)) -
-		float64(cVir/(1+ cVir))))
+	pixelNum := geom.SpherePixelNum(int(config.medianPixelLevel))
 	
 	for i := range xs {
 		x, y, z := xs[i][0], xs[i][1], xs[i][2]
@@ -490,78 +629,72 @@ func insertPoints(
 		r2 := dx*dx + dy*dy + dz*dz
 		if r2 <= rMin2 || r2 >= rMax2 { continue }
 
-		if config.pType == containedDensityProfile &&
-			!shell.Contains(float64(dx), float64(dy), float64(dz)) {
-			continue
-		}
-
 		lr := math.Log(float64(r2)) / 2
 		ir := int(((lr) - lrMin) / dlr)
-		if ir == len(rhos) { ir-- }
-
-		if config.pType == boundDensityProfile {
-			dr := float32(math.Sqrt(float64(r2)))
-			x := dr / s.S.R
+		if ir == int(config.bins) { ir-- }
 
-			dvx := vs[i][0] - s.Vx
-			dvy := vs[i][1] - s.Vy
-			dvz := vs[i][2] - s.Vz
-			dv2 := dvx*dvx + dvy*dvy + dvz*dvz
+		if medRhos != nil {
+			r := math.Sqrt(float64(r2))
+			phi := math.Mod(
+				math.Atan2(float64(dy), float64(dx)) + math.Pi*2, math.Pi*2,
+			)
+			th := math.Acos(float64(dz) / r)
+			p := geom.SpherePixel(phi, th, int(config.medianPixelLevel))
+			medRhos[ir][p] += float64(ms[i])*float64(pixelNum)
+		}
 
-			phi := vVir*vVir * phiPrefactor *
-				float32(math.Log(float64(1 + cVir*x)))/x
-			vesc2 := phi * 2.0
-			
-			if vesc2 > dv2 {
-				rhos[ir] += float64(ms[i])
+		for k, pType := range config.pTypes {
+			switch pType {
+			case densityProfile:
+				rhos[k][ir] += float64(ms[i])
+			case containedDensityProfile:
+				if shell.Contains(float64(dx), float64(dy), float64(dz)) {
+					rhos[k][ir] += float64(ms[i])
+				}
 			}
-		} else {
-			rhos[ir] += float64(ms[i])
 		}
 	}
 }
 
-func insertMedianPoints(
-	medRhos [][]float64, s ExtendedSphere,  xs [][3]float32,
-	ms []float32, config *ProfConfig, hd *io.Header,
+// insertBoundPoints unbinds the particles around a single halo and adds the
+// bound ones to the accumulator rhos. r200m is the halo's R_200m. If es is
+// non-nil, it is used to look up particle binding energies instead.
+func insertBoundPoints(
+	rhos []float64, h *haloParticles, es map[int64]float32, r200m float64,
+	config *ProfConfig, hd *io.Header,
 ) {
-	lrMax := math.Log(float64(s.S.R) * config.rMaxMult)
-	lrMin := math.Log(float64(s.S.R) * config.rMinMult)
-	dlr := (lrMax - lrMin) / float64(config.bins)
-	rMax2 := s.S.R * float32(config.rMaxMult)
-	rMin2 := s.S.R * float32(config.rMinMult)
-	rMax2 *= rMax2
-	rMin2 *= rMin2
-
-	x0, y0, z0 := s.S.C[0], s.S.C[1], s.S.C[2]
-	tw2 := float32(hd.TotalWidth) / 2
-
-	pixelNum := geom.SpherePixelNum(int(config.medianPixelLevel))
+	var bound []bool
+	if es != nil {
+		bound = h.boundFromEnergies(es)
+	} else {
+		bound = h.unbind(
+			hd, r200m, config.boundSoftening, config.boundIterations,
+		)
+	}
 
-	for i, vec := range xs {
-		x, y, z := vec[0], vec[1], vec[2]
-		dx, dy, dz := x - x0, y - y0, z - z0
-		dx = wrap(dx, tw2)
-		dy = wrap(dy, tw2)
-		dz = wrap(dz, tw2)
+	lrMax := math.Log(r200m * config.rMaxMult)
+	lrMin := math.Log(r200m * config.rMinMult)
+	dlr := (lrMax - lrMin) / float64(config.bins)
 
-		r2 := dx*dx + dy*dy + dz*dz
-		if r2 <= rMin2 || r2 >= rMax2 {
-			continue
-		}
+	for i := range bound {
+		if !bound[i] { continue }
 
-		r := math.Sqrt(float64(r2))
-		phi := math.Mod(
-			math.Atan2(float64(dy), float64(dx)) + math.Pi*2, math.Pi*2,
-		)
-		th := math.Acos(float64(dz) / r)
-		p := geom.SpherePixel(phi, th, int(config.medianPixelLevel))
+		lr := math.Log(h.radius(i))
+		if lr <= lrMin || lr >= lrMax { continue }
+		ir := int((lr - lrMin) / dlr)
+		if ir == int(config.bins) { ir-- }
 
-		lr := math.Log(r)
-		ir := int(((lr) - lrMin) / dlr)
+		rhos[ir] += float64(h.ms[i])
+	}
+}
 
-		if ir == len(medRhos) { ir-- }
-		medRhos[ir][p] += float64(ms[i])*float64(pixelNum)
+// profileRadii writes the centers of len(rs) logarithmic bins between rMin
+// and rMax to rs.
+func profileRadii(rs []float64, rMin, rMax float64) {
+	dlr := (math.Log(rMax) - math.Log(rMin)) / float64(len(rs))
+	lrMin := math.Log(rMin)
+	for j := range rs {
+		rs[j] = math.Exp(lrMin + dlr*(float64(j) + 0.5))
 	}
 }
 
@@ -606,7 +739,7 @@ func processMedianProfile(rs, rhos []float64, medRhos [][]float64,
 
 func processMedianErrorProfile(rs, rhos []float64, medRhos [][]float64,
 	medScratchBuffer []float64, rMin, rMax float64,
-	percentile float64, samples int64,
+	percentile float64, samples int64, gen *rand.Generator,
 ) {
 	n := len(rs)
 
@@ -621,13 +754,14 @@ func processMedianErrorProfile(rs, rhos []float64, medRhos [][]float64,
 		dV := (rHi*rHi*rHi - rLo*rLo*rLo) * 4 * math.Pi / 3
 
 		rhos[j] = bootstrapErrorPercentile(
-			medRhos[j], percentile, medScratchBuffer, samples,
+			medRhos[j], percentile, medScratchBuffer, samples, gen,
 		) / dV
 	}
 }
 
 func bootstrapErrorPercentile(
 	x []float64, percentile float64, scratchBuffer []float64, samples int64,
+	gen *rand.Generator,
 ) float64 {
 	sampleBuffer := make([]float64, len(x))
 
@@ -636,7 +770,7 @@ func bootstrapErrorPercentile(
 
 	for i := int64(0); i < samples; i++ {
 		for j := range x {
-			sampleBuffer[j] = x[rand.Intn(len(x))]
+			sampleBuffer[j] = x[gen.UniformInt(0, len(x))]
 		}
 		p := msort.Percentile(sampleBuffer, percentile/100, scratchBuffer)
 		sum += p
@@ -648,84 +782,3 @@ func bootstrapErrorPercentile(
 
 	return math.Sqrt(sqrSum - sum*sum)
 }
-
-func angularFractionMain(
-	ids, snaps []int, shells []analyze.Shell, rs []float64, config *ProfConfig,
-) ([]string, error) {
-	rCols := make([][]float64, config.bins)
-	fCols := make([][]float64, config.bins)
-	for i := range rCols {
-		rCols[i] = make([]float64, len(ids))
-		fCols[i] = make([]float64, len(ids))
-	}
-
-	for i := range shells {
-		rs, fs := shells[i].AngularFractionProfile(
-			int(config.samples), int(config.bins),
-			rs[i] * config.rMinMult, rs[i] * config.rMaxMult,
-		)
-
-		for j := range rs {
-			rCols[j][i], fCols[j][i] = rs[j], fs[j]
-		}
-	}
-
-	order := make([]int, len(rCols) + len(fCols) + 2)
-	for i := range order { order[i] = i }
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps}, append(rCols, fCols...), order,
-	)
-
-	cString := catalog.CommentString(
-		[]string{"ID", "Snapshot", "R [cMpc/h]", "Volume Fraction Contained"},
-		[]string{}, []int{0, 1, 2, 3},
-		[]int{1, 1, int(config.bins), int(config.bins)},
-	)
-
-	return append([]string{cString}, lines...), nil
-}
-
-type ExtendedSphere struct {
-	S geom.Sphere
-	M, Rs float32
-	Vx, Vy, Vz float32
-}
-
-func extendedBoundingSpheres(
-	coords [][]float64, hd *io.Header, e *env.Environment,
-) ([]ExtendedSphere, error) {
-	xs, ys, zs, rs := coords[0], coords[1], coords[2], coords[3]
-	ms, Rs := coords[4], coords[5]
-	vx, vy, vz :=  coords[6], coords[7], coords[8]
-
-	spheres := make([]ExtendedSphere, len(coords[0]))
-	for i := range spheres {
-		spheres[i].S.C = [3]float32{
-			float32(xs[i]), float32(ys[i]), float32(zs[i]),
-		}
-		spheres[i].S.R = float32(rs[i])
-		spheres[i].M = float32(ms[i])
-		spheres[i].Rs = float32(Rs[i])
-		spheres[i].Vx = float32(vx[i])
-		spheres[i].Vy = float32(vy[i])
-		spheres[i].Vz = float32(vz[i])
-	}
-
-	return spheres, nil
-}
-
-func binExtendedSphereIntersections(
-	hds []io.Header, spheres []ExtendedSphere,
-) ([][]ExtendedSphere, [][]int) {
-	bins := make([][]ExtendedSphere, len(hds))
-	idxs := make([][]int, len(hds))
-	for i := range hds {
-		for si := range spheres {
-			if sphereSheetIntersect(spheres[si].S, &hds[i]) {
-				bins[i] = append(bins[i], spheres[si])
-				idxs[i] = append(idxs[i], si)
-			}
-		}
-	}
-	return bins, idxs
-}
@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/phil-mansfield/shellfish/cmd/catalog"
+	"github.com/phil-mansfield/shellfish/cmd/env"
+	"github.com/phil-mansfield/shellfish/parse"
+	"github.com/phil-mansfield/shellfish/version"
+)
+
+// provenance describes how Shellfish was run. It's embedded in the header of
+// every catalog written by a mode.
+type provenance struct {
+	catalog.Provenance
+	e *env.Environment
+}
+
+// RecordProvenance records how Shellfish was run so that it can be embedded
+// in the catalogs written by modes. args are the command line arguments,
+// starting with the mode, configName is the mode's config file, if any, and
+// stdin is the catalog read from stdin, if any.
+func (config *GlobalConfig) RecordProvenance(
+	fname string, args []string, configName string, stdin []byte,
+	e *env.Environment,
+) {
+	p := &provenance{ e: e }
+	p.Command = append([]string{}, args...)
+	p.Revision = version.Revision()
+	p.GlobalConfigFile = absPath(fname)
+	p.GlobalConfig = configValues(config.vars)
+	p.ConfigFile = absPath(configName)
+	p.Seed = randSeed
+
+	if stdin != nil {
+		sum := sha256.Sum256(stdin)
+		p.Input = &catalog.Input{
+			SHA256: hex.EncodeToString(sum[:]), Size: len(stdin),
+		}
+	}
+
+	config.provenance = p
+}
+
+// absPath returns the absolute version of a path, if it can be found.
+func absPath(path string) string {
+	if path == "" { return "" }
+	abs, err := filepath.Abs(path)
+	if err != nil { return path }
+	return abs
+}
+
+// catalogProvenance returns the Provenance of a catalog with the given
+// header and columns. The arguments are the same as formatCatalog. nil is
+// returned if RecordProvenance was never called.
+func (p *provenance) catalogProvenance(
+	hd *catalog.Header, intCols [][]int, order []int,
+) *catalog.Provenance {
+	if p == nil { return nil }
+	out := p.Provenance
+
+	col, err := hd.Column("Snapshot")
+	if err != nil || col.Start >= len(order) ||
+		order[col.Start] >= len(intCols) {
+		return &out
+	}
+
+	snaps := map[int]bool{}
+	for _, snap := range intCols[order[col.Start]] { snaps[snap] = true }
+	sorted := []int{}
+	for snap := range snaps { sorted = append(sorted, snap) }
+	sort.Ints(sorted)
+
+	for _, snap := range sorted {
+		if !p.e.HasParticleCatalog(snap) { continue }
+		s := catalog.Snapshot{
+			Snap: snap, Blocks: p.e.Blocks(),
+			FirstBlock: p.e.ParticleCatalog(snap, 0),
+		}
+		if p.e.HasHaloCatalog(snap) { s.HaloCatalog = p.e.HaloCatalog(snap) }
+		out.Snapshots = append(out.Snapshots, s)
+	}
+
+	return &out
+}
+
+type ProvenanceConfig struct {
+	action    string
+	dir       string
+	inputFile string
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
+}
+
+var _ Mode = &ProvenanceConfig{}
+
+func (config *ProvenanceConfig) ExampleConfig() string {
+	return (&ProvenanceConfig{}).configVars().ExampleConfig()
+}
+
+// configVars registers the variables of a provenance.config file.
+func (config *ProvenanceConfig) configVars() *parse.ConfigVars {
+	vars := parse.NewConfigVars("provenance.config")
+	vars.String(&config.action, "Action", "print").Enum("print", "rerun").
+		Doc(`Action is what the provenance tool does with the catalog passed to it
+through stdin. It can be set to:
+
+print - Print the catalog's provenance: the command, config files, and
+        Shellfish version used to make it, along with the snapshots and random
+        seed it used and a hash of its input catalog.
+rerun - Remake the catalog by running the same mode with the same variables
+        and random seed. The config files are written to Dir, so you can also
+        rerun the mode by hand.`)
+	vars.String(&config.dir, "Dir", "").Example("path/to/dir").
+		Doc(`Dir is the directory that rerun writes config files to. By default, a new
+temporary directory is used.`)
+	vars.String(&config.inputFile, "InputFile", "").
+		Example("path/to/input.txt").
+		Doc(`InputFile is the catalog that was passed to the original command through
+stdin. rerun checks that it's the same file using the hash in the
+provenance. It must be set if the original command read from stdin.`)
+	return vars
+}
+
+func (config *ProvenanceConfig) ReadConfig(fname string, flags []string) error {
+	vars := config.configVars()
+	config.vars = vars
+
+	if fname == "" {
+		if len(flags) == 0 { return config.validate() }
+		if err := parse.ReadFlags(flags, vars); err != nil { return err }
+	} else {
+		if err := parse.ReadConfig(fname, vars); err != nil { return err }
+		if err := parse.ReadFlags(flags, vars); err != nil { return err }
+	}
+
+	return config.validate()
+}
+
+func (config *ProvenanceConfig) validate() error {
+	return config.vars.Validate()
+}
+
+func (config *ProvenanceConfig) Run(
+	gConfig *GlobalConfig, e *env.Environment, stdin []byte,
+) ([]string, error) {
+	hd, err := catalog.ParseHeader(stdin)
+	if err != nil { return nil, err }
+	if hd == nil || hd.Provenance == nil {
+		return nil, fmt.Errorf("The input catalog doesn't contain a " +
+			"provenance record. Catalogs written by older versions of " +
+			"Shellfish don't have them.")
+	}
+
+	switch config.action {
+	case "print":
+		return config.print(hd)
+	case "rerun":
+		return config.rerun(hd)
+	}
+
+	panic("Impossible")
+}
+
+// print returns the provenance of a catalog as indented JSON.
+func (config *ProvenanceConfig) print(hd *catalog.Header) ([]string, error) {
+	out := struct {
+		Version    string                 `json:"version"`
+		Mode       string                 `json:"mode"`
+		Config     map[string]interface{} `json:"config,omitempty"`
+		Provenance *catalog.Provenance    `json:"provenance"`
+	}{ hd.Version, hd.Mode, hd.Config, hd.Provenance }
+
+	b, err := json.MarshalIndent(out, "", "    ")
+	if err != nil { return nil, err }
+	return []string{string(b)}, nil
+}
+
+// rerun remakes a catalog and returns the output of the mode which made it.
+func (config *ProvenanceConfig) rerun(hd *catalog.Header) ([]string, error) {
+	p := hd.Provenance
+	if hd.Version != version.SourceVersion {
+		log.Printf("The input catalog was made by Shellfish %s, but this "+
+			"is Shellfish %s, so the remade catalog might be different.",
+			hd.Version, version.SourceVersion)
+	}
+	if _, ok := ModeNames[hd.Mode]; !ok || hd.Mode == "provenance" {
+		return nil, fmt.Errorf("The input catalog was made by the mode "+
+			"'%s', which can't be rerun.", hd.Mode)
+	}
+
+	var input []byte
+	if p.Input != nil {
+		if config.inputFile == "" {
+			return nil, fmt.Errorf("The input catalog was made from a "+
+				"catalog passed through stdin, so InputFile must be set.")
+		}
+		var err error
+		input, err = ioutil.ReadFile(config.inputFile)
+		if err != nil { return nil, err }
+
+		sum := sha256.Sum256(input)
+		if hex.EncodeToString(sum[:]) != p.Input.SHA256 {
+			return nil, fmt.Errorf("InputFile, %s, isn't the catalog that "+
+				"the input catalog was made from.", config.inputFile)
+		}
+	}
+
+	dir := config.dir
+	if dir == "" {
+		var err error
+		dir, err = ioutil.TempDir("", "shellfish_provenance")
+		if err != nil { return nil, err }
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	globalValues := map[string]interface{}{}
+	for name, val := range p.GlobalConfig { globalValues[name] = val }
+	globalValues["Seed"] = json.Number(fmt.Sprint(int64(p.Seed)))
+
+	globalFile := filepath.Join(dir, "global.config")
+	modeFile := filepath.Join(dir, hd.Mode + ".config")
+	err := writeConfigValues(globalFile, "config", globalValues)
+	if err != nil { return nil, err }
+	err = writeConfigValues(modeFile, hd.Mode + ".config", hd.Config)
+	if err != nil { return nil, err }
+
+	exe, err := os.Executable()
+	if err != nil { return nil, err }
+	cmd := exec.Command(exe, hd.Mode, modeFile, "--global-config", globalFile)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Running '%s' failed: %s",
+			strings.Join(cmd.Args, " "), err.Error())
+	}
+
+	if catalog.IsBinary(out) { return []string{string(out)}, nil }
+	return []string{strings.TrimSuffix(string(out), "\n")}, nil
+}
+
+// writeConfigValues writes a config file with the given title which sets
+// variables to the values recorded in a catalog header.
+func writeConfigValues(
+	fname, title string, values map[string]interface{},
+) error {
+	names := []string{}
+	for name := range values { names = append(names, name) }
+	sort.Strings(names)
+
+	lines := []string{fmt.Sprintf("[%s]", title)}
+	for _, name := range names {
+		val, err := configValueString(values[name], false)
+		if err != nil {
+			return fmt.Errorf("The recorded value of '%s' can't be written "+
+				"to a config file: %s", name, err.Error())
+		}
+		// Config files can't represent empty lists, so these variables are
+		// left at their defaults, which are almost always empty.
+		if val == "" {
+			if _, ok := values[name].([]interface{}); ok { continue }
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", name, val))
+	}
+
+	return ioutil.WriteFile(fname, []byte(strings.Join(lines, "\n")+"\n"),
+		0644)
+}
+
+// configValueString returns a value decoded from a catalog header as it
+// would be written in a config file.
+func configValueString(val interface{}, inList bool) (string, error) {
+	switch x := val.(type) {
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return fmt.Sprint(x), nil
+	case string:
+		if strings.ContainsAny(x, "#\n") || (inList && strings.Contains(x, ",")) {
+			return "", fmt.Errorf("'%s' contains special characters.", x)
+		}
+		return x, nil
+	case []interface{}:
+		if inList { return "", fmt.Errorf("lists can't be nested.") }
+		strs := make([]string, len(x))
+		for i := range x {
+			var err error
+			strs[i], err = configValueString(x[i], true)
+			if err != nil { return "", err }
+		}
+		return strings.Join(strs, ", "), nil
+	}
+	return "", fmt.Errorf("values of type %T aren't supported.", val)
+}
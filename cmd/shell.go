@@ -16,6 +16,7 @@ import (
 	"github.com/phil-mansfield/shellfish/logging"
 	"github.com/phil-mansfield/shellfish/los"
 	"github.com/phil-mansfield/shellfish/los/analyze"
+	"github.com/phil-mansfield/shellfish/los/geom"
 	"github.com/phil-mansfield/shellfish/math/rand"
 	"github.com/phil-mansfield/shellfish/parse"
 	msort "github.com/phil-mansfield/shellfish/math/sort"
@@ -32,6 +33,9 @@ type ShellConfig struct {
 	eta                                             float64
 	order, smoothingWindow, levels, subsampleFactor int64
 	losSlopeCutoff, backgroundRhoMult               float64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &ShellConfig{}
@@ -101,18 +105,20 @@ BackgroundRhoMult = 0.5`
 
 func (config *ShellConfig) ReadConfig(fname string, flags []string) error {
 	vars := parse.NewConfigVars("shell.config")
-
-	vars.Int(&config.subsampleFactor, "SubsampleFactor", 1)
-	vars.Int(&config.radialBins, "RadialBins", 256)
-	vars.Int(&config.spokes, "Spokes", 256)
-	vars.Int(&config.rings, "Rings", 100)
-	vars.Float(&config.rMaxMult, "RMaxMult", 3)
-	vars.Float(&config.rMinMult, "RMinMult", 0.3)
-	vars.Float(&config.rKernelMult, "RKernelMult", 0.2)
-	vars.Float(&config.eta, "Eta", 10)
-	vars.Int(&config.order, "Order", 3)
-	vars.Int(&config.levels, "Levels", 3)
-	vars.Int(&config.smoothingWindow, "SmoothingWindow", 121)
+	config.vars = vars
+
+	vars.Int(&config.subsampleFactor, "SubsampleFactor", 1).Min(1)
+	vars.Int(&config.radialBins, "RadialBins", 256).Min(1)
+	vars.Int(&config.spokes, "Spokes", 256).Min(1)
+	vars.Int(&config.rings, "Rings", 100).Min(1)
+	vars.Float(&config.rMaxMult, "RMaxMult", 3).Positive()
+	vars.Float(&config.rMinMult, "RMinMult", 0.3).Positive()
+	vars.Float(&config.rKernelMult, "RKernelMult", 0.2).Positive()
+	vars.Float(&config.eta, "Eta", 10).Positive()
+	vars.Int(&config.order, "Order", 3).Min(1)
+	vars.Int(&config.levels, "Levels", 3).Min(1)
+	vars.Int(&config.smoothingWindow, "SmoothingWindow", 121).
+		Min(1).Odd()
 	vars.Float(&config.losSlopeCutoff, "LOSSlopeCutoff", 0.0)
 	vars.Float(&config.backgroundRhoMult, "BackgroundRhoMult", 0.5)
 	vars.Bool(&config.percentileProfile, "PercentileProfile", false)
@@ -141,41 +147,7 @@ func (config *ShellConfig) ReadConfig(fname string, flags []string) error {
 }
 
 func (config *ShellConfig) validate() error {
-	switch {
-	case config.subsampleFactor <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"SubsampleFactor", config.subsampleFactor)
-	case config.radialBins <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"RadialBins", config.radialBins)
-	case config.spokes <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"Spokes", config.spokes)
-	case config.rings <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"Rings", config.rings)
-	case config.rMaxMult <= 0:
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"RMaxMult", config.rMaxMult)
-	case config.rMinMult <= 0:
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"RMinMult", config.rMinMult)
-	case config.rKernelMult <= 0:
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"RKernelMult", config.rKernelMult)
-	case config.eta <= 0:
-		return fmt.Errorf("The variable '%s' was set to %g.",
-			"Eta", config.eta)
-	case config.order <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"Order", config.order)
-	case config.levels <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"Levels", config.levels)
-	case config.smoothingWindow <= 0:
-		return fmt.Errorf("The variable '%s' was set to %d.",
-			"SmoothingWindow", config.smoothingWindow)
-	}
+	if err := config.vars.Validate(); err != nil { return err }
 
 	if config.rMinMult >= config.rMaxMult {
 		return fmt.Errorf("The variable '%s' was set to %g, but the "+
@@ -206,8 +178,8 @@ func (config *ShellConfig) Run(
 	}
 
 	// Parse.
-	intCols, coords, err := catalog.Parse(
-		stdin, []int{0, 1}, []int{2, 3, 4, 5},
+	intCols, coords, _, err := catalog.ParseColumns(
+		stdin, haloRequests, coordRequests("X", "Y", "Z", "R200m"),
 	)
 	if err != nil {
 		return nil, err
@@ -231,14 +203,14 @@ func (config *ShellConfig) Run(
 	}
 	
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
+		e.ParticleCatalogs(snaps[0]), gConfig,
 	)
 	
 	if err != nil {
 		return nil, err
 	}
 
-	err = loop(ids, snaps, coords, config, buf, e, out, gConfig.Threads)
+	err = loop(ids, snaps, coords, config, buf, e, out, gConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -252,11 +224,10 @@ func (config *ShellConfig) Run(
 		colOrder[i] = i
 	}
 
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps}, append(coords, transpose(out)...), colOrder,
-	)
-
-	cString := catalog.CommentString(
+	penna := int(config.order)
+	if config.percentileProfile { penna = 0 }
+	hd := catalogHeader(
+		"shell", config.vars, penna,
 		intNames, floatNames, []int{0, 1, 2, 3, 4, 5, 6},
 		[]int{1, 1, 1, 1, 1, 1, len(out[0])},
 	)
@@ -266,7 +237,10 @@ func (config *ShellConfig) Run(
 		log.Printf("Memory: %s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(
+		gConfig, hd,
+		[][]int{ids, snaps}, append(coords, transpose(out)...), colOrder,
+	), nil
 }
 
 func transpose(in [][]float64) [][]float64 {
@@ -288,7 +262,7 @@ func transpose(in [][]float64) [][]float64 {
 func loop(
 	ids, snaps []int, coords [][]float64, c *ShellConfig,
 	buf io.VectorBuffer, e *env.Environment, out [][]float64,
-	threads int64,
+	gConfig *GlobalConfig,
 ) error {
 	snapBins, idxBins := binBySnap(snaps, ids)
 	ringBuf := make([]analyze.RingBuffer, c.rings)
@@ -306,11 +280,16 @@ func loop(
 	if err != nil {
 		return err
 	}
+	// Kernel densities are set by the smallest particles. In zoom-in
+	// simulations these are the high-resolution particles.
 	minMass := buf.MinMass()
+	if lo, _, zoom := highResMasses(buf, gConfig); zoom {
+		minMass = float32(lo)
+	}
 
 	workers := runtime.NumCPU()
-	if threads > 0 {
-		workers = int(threads)
+	if gConfig.Threads > 0 {
+		workers = int(gConfig.Threads)
 	}
 	sphBuf := &sphBuffers{
 		intr:       make([]bool, hds[0].N),
@@ -319,6 +298,10 @@ func loop(
 		sphWorkers: make([]los.Halo, workers-1),
 	}
 
+	// The block reader's prefetch buffers are reused across snapshots.
+	br := newBlockReader(buf, gConfig, e, io.Positions | io.Masses)
+	defer br.Stop()
+
 	for _, snap := range sortedSnaps {
 		if snap == -1 {
 			continue
@@ -344,7 +327,7 @@ func loop(
 
 		// I'm so sorry about having ten arguments to this function.
 		if err = sphereLoop(snap, ids, idxs, halos, c,
-			buf, e, sphBuf, threads, out); err != nil {
+			br, e, sphBuf, gConfig, out); err != nil {
 
 			return err
 		}
@@ -375,14 +358,25 @@ func loop(
 
 func sphereLoop(
 	snap int, IDs, ids []int, halos []*los.Halo, c *ShellConfig,
-	buf io.VectorBuffer, e *env.Environment, sphBuf *sphBuffers,
-	threads int64, out [][]float64,
+	br *blockReader, e *env.Environment, sphBuf *sphBuffers,
+	gConfig *GlobalConfig, out [][]float64,
 ) error {
-	hds, files, err := memo.ReadHeaders(snap, buf, e)
+	hds, _, err := memo.ReadHeaders(snap, br.buf, e)
 	if err != nil {
 		return err
 	}
 	intrBins := binIntersections(hds, halos)
+
+	blocks, blockSpheres := []int{}, make([][]geom.Sphere, len(hds))
+	for i := range hds {
+		if len(intrBins[i]) == 0 { continue }
+		blocks = append(blocks, i)
+		blockSpheres[i] = haloSpheres(intrBins[i], c)
+	}
+	defer br.Stop()
+	if err = br.Prefetch(snap, hds, blocks, blockSpheres); err != nil {
+		return err
+	}
 	
 	for i := range hds {
 		runtime.GC()
@@ -396,28 +390,66 @@ func sphereLoop(
 			log.Printf("Memory: %s", logging.MemString())
 		}
 		
-		sphBuf.xs, _, sphBuf.ms, _, err = buf.Read(files[i])
+		binHs := intrBins[i]
+		float64s, err := br.Float64(snap, i)
+		if err != nil { return err }
+		if float64s {
+			// Positions are recentered on each halo in float64 so that
+			// precision isn't lost in large boxes.
+			sphBuf.xs64, _, sphBuf.ms, _, err = br.Read64(snap, i)
+			if err == nil {
+				for j := range binHs {
+					loadSphereVecs(
+						binHs[j], sphBuf, &hds[i], c, gConfig.Threads,
+					)
+				}
+			}
+			sphBuf.xs64 = nil
+			br.Close()
+		} else {
+			err = br.Each(snap, i, &hds[i], blockSpheres[i], func(
+				xs, _ [][3]float32, ms []float32, _ []int64,
+			) error {
+				sphBuf.xs, sphBuf.ms = xs, ms
+				for j := range binHs {
+					loadSphereVecs(
+						binHs[j], sphBuf, &hds[i], c, gConfig.Threads,
+					)
+				}
+				return nil
+			})
+		}
 		
 		if err != nil {
 			return err
 		}
-
-		binHs := intrBins[i]
-		for j := range binHs {
-			loadSphereVecs(binHs[j], sphBuf, &hds[i], c, threads)
-		}
-
-		buf.Close()
 	}
 
 	return nil
 }
 
+// haloSpheres returns spheres which contain every particle that
+// loadSphereVecs could use for the given halos.
+func haloSpheres(halos []*los.Halo, c *ShellConfig) []geom.Sphere {
+	spheres := make([]geom.Sphere, len(halos))
+	for i, h := range halos {
+		origin := h.Origin()
+		for k := 0; k < 3; k++ { spheres[i].C[k] = float32(origin[k]) }
+		spheres[i].R = float32(h.RMax() * (1 + c.rKernelMult/c.rMaxMult))
+	}
+	return spheres
+}
+
 type sphBuffers struct {
 	sphWorkers []los.Halo
 	xs         [][3]float32
 	ms         []float32
 	intr       []bool
+
+	// If xs64 is set, positions are converted into each halo's local
+	// coordinates and written to local instead of being read from xs.
+	xs64  [][3]float64
+	local [][3]float32
 }
 
 func loadSphereVecs(
@@ -430,6 +462,11 @@ func loadSphereVecs(
 	}
 	runtime.GOMAXPROCS(workers)
 	sphWorkers, xs := sphBuf.sphWorkers, sphBuf.xs
+	local := sphBuf.xs64 != nil
+	if local {
+		sphBuf.local = expandVectors(sphBuf.local[:0], len(sphBuf.xs64))
+		xs = sphBuf.local
+	}
 	sphBuf.intr = expandBools(sphBuf.intr[:0], len(xs))
 	ms, intr := sphBuf.ms, sphBuf.intr
 	if len(sphWorkers)+1 != workers {
@@ -438,9 +475,14 @@ func loadSphereVecs(
 
 	sync := make(chan bool, workers)
 
-	h.Transform(xs, hd.TotalWidth)
 	rad := h.RMax() * c.rKernelMult / c.rMaxMult
-	h.Intersect(xs, rad, intr)
+	if local {
+		h.LocalCoords(sphBuf.xs64, hd.TotalWidth, xs)
+		h.IntersectLocal(xs, rad, intr)
+	} else {
+		h.Transform(xs, hd.TotalWidth)
+		h.Intersect(xs, rad, intr)
+	}
 	
 	numIntr := 0
 	for i := range intr {
@@ -453,9 +495,13 @@ func loadSphereVecs(
 
 	for i := range sphWorkers {
 		wh := &sphBuf.sphWorkers[i]
-		go chanLoadSphereVec(wh, xs, ms, intr, i, workers, hd, c, sync)
+		go chanLoadSphereVec(
+			wh, xs, ms, intr, local, i, workers, hd, c, sync,
+		)
 	}
-	chanLoadSphereVec(h, xs, ms, intr, workers-1, workers, hd, c, sync)
+	chanLoadSphereVec(
+		h, xs, ms, intr, local, workers-1, workers, hd, c, sync,
+	)
 
 	for i := 0; i < workers; i++ {
 		<-sync
@@ -476,9 +522,21 @@ func expandBools(scalars []bool, n int) []bool {
 	}
 }
 
+func expandVectors(vecs [][3]float32, n int) [][3]float32 {
+	switch {
+	case cap(vecs) >= n:
+		return vecs[:n]
+	case int(float64(cap(vecs))*1.5) > n:
+		return append(vecs[:cap(vecs)],
+			make([][3]float32, n-cap(vecs))...)
+	default:
+		return make([][3]float32, n)
+	}
+}
+
 func chanLoadSphereVec(
 	h *los.Halo, xs [][3]float32, ms []float32,
-	intr []bool, offset, workers int,
+	intr []bool, local bool, offset, workers int,
 	hd *io.Header, c *ShellConfig, sync chan bool,
 ) {
 	rad := h.RMax() * c.rKernelMult / c.rMaxMult
@@ -490,9 +548,12 @@ func chanLoadSphereVec(
 	sf := c.subsampleFactor
 	skip := workers * int(sf*sf*sf)
 	for i := offset * int(sf*sf*sf); i < len(xs); i += skip {
-		if intr[i] {
-			h.Insert(xs[i], rad, (float64(ms[i])*float64(sf*sf*sf)/
-				sphVol)/rhoM)
+		if !intr[i] { continue }
+		rho := (float64(ms[i])*float64(sf*sf*sf)/sphVol)/rhoM
+		if local {
+			h.InsertLocal(xs[i], rad, rho)
+		} else {
+			h.Insert(xs[i], rad, rho)
 		}
 	}
 
@@ -17,6 +17,7 @@ import (
 	"github.com/phil-mansfield/shellfish/cmd/env"
 	"github.com/phil-mansfield/shellfish/cmd/memo"
 	"github.com/phil-mansfield/shellfish/logging"
+	"github.com/phil-mansfield/shellfish/math/rand"
 	"github.com/phil-mansfield/shellfish/parse"
 )
 
@@ -27,10 +28,18 @@ type StatsConfig struct {
 	order             int64
 
 	skipMass          bool
+
+	boundMass         bool
+	boundIterations   int64
+	boundSoftening    float64
+	potentialFile     string
 	
 	shellFilter       bool
 	shellParticleFile string
 	shellWidth        float64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &StatsConfig{}
@@ -70,6 +79,36 @@ Order = 3
 # ShellParticle files will also not be writen.
 # SkipMass = false
 
+# BoundMass indicates whether the gravitationally bound masses within R_200m
+# and within the splashback shell should be calculated. If set, the columns
+# Mb_200m and Mb_sp are added to the end of the catalog. Boundness is found by
+# iteratively unbinding the particles around each halo using their actual
+# potential, which requires every particle within max(R_200m, RMax) of a halo
+# to be held in memory at once. BoundMass cannot be used with SkipMass.
+# BoundMass = false
+
+# BoundIterations is the maximum number of unbinding iterations. During each
+# iteration the potential and the halo's bulk velocity (measured within R_200m)
+# are recomputed using only the particles which are still bound.
+# BoundIterations = 10
+
+# BoundSoftening is the softening length, in cMpc/h, used when computing
+# potentials. If it is set to a non-positive number, 0.01*R_200m will be used
+# for each halo.
+# BoundSoftening = -1
+
+# PotentialFile is a file of particle binding energies written by potential
+# mode. If it is set, bound masses will be computed using the binding energies
+# in this file instead of unbinding particles directly, and BoundIterations and
+# BoundSoftening will be ignored. Every input halo must be in the file.
+# PotentialFile = potentials.dat
+
+# If HighResMass or GadgetHighResTypeIndices is set in the global config file,
+# the columns fc_200m and fc_sp are added to the end of the catalog (after any
+# bound mass columns). These are the fractions of the mass within R_200m and
+# within the splashback shell which is in low-resolution particles. They aren't
+# added if SkipMass is set.
+
 # ShellParticleFile and ShellWidth allow Shellfish to output a file containing
 # the IDs of particles which are close to the edge of the halo.
 # ShellParticlesFile is a file that the IDs will be written out to, and
@@ -106,14 +145,21 @@ Order = 3
 
 func (config *StatsConfig) ReadConfig(fname string, flags []string) error {
 	vars := parse.NewConfigVars("stats.config")
+	config.vars = vars
 
-	vars.Strings(&config.values, "Values", []string{})
-	vars.Int(&config.monteCarloSamples, "MonteCarloSamples", 50*1000)
-	vars.String(&config.exclusionStrategy, "ExclusionStrategy", "none")
+	vars.Strings(&config.values, "Values", []string{}).Enum("snap", "id",
+		"m_sp", "r_sp", "a_sp", "b_sp", "c_sp", "SA_sp/V_sp")
+	vars.Int(&config.monteCarloSamples, "MonteCarloSamples", 50*1000).Min(1)
+	vars.String(&config.exclusionStrategy, "ExclusionStrategy", "none").
+		Enum("none", "contain", "overlap")
 	vars.Int(&config.order, "Order", 3)
 	vars.String(&config.shellParticleFile, "ShellParticleFile", "")
 	vars.Float(&config.shellWidth, "ShellWidth", 0)
 	vars.Bool(&config.skipMass, "SkipMass", false)
+	vars.Bool(&config.boundMass, "BoundMass", false)
+	vars.Int(&config.boundIterations, "BoundIterations", 10).Min(1)
+	vars.Float(&config.boundSoftening, "BoundSoftening", -1)
+	vars.String(&config.potentialFile, "PotentialFile", "")
 
 	
 	if fname == "" {
@@ -145,27 +191,11 @@ func (config *StatsConfig) ReadConfig(fname string, flags []string) error {
 }
 
 func (config *StatsConfig) validate() error {
-	for i, val := range config.values {
-		switch val {
-		case "snap", "id", "m_sp", "r_sp", "a_sp", "b_sp", "c_sp",
-			"SA_sp/V_sp":
-		default:
-			return fmt.Errorf("Item %d of variable 'Values' is set to '%s', "+
-				"which I don't recognize.", i, val)
-		}
-	}
+	if err := config.vars.Validate(); err != nil { return err }
 
-	switch config.exclusionStrategy {
-	case "none", "contain", "overlap":
-	default:
-		return fmt.Errorf("variable 'ExclusionStrategy' set to '%s', which "+
-			"I don't recognize.", config.exclusionStrategy)
-	}
-
-	switch {
-	case config.monteCarloSamples <= 0:
-		return fmt.Errorf("The variable '%s' was set to %g",
-			"MonteCarloSamples", config.monteCarloSamples)
+	if config.boundMass && config.skipMass {
+		return fmt.Errorf("The variables 'BoundMass' and 'SkipMass' " +
+			"cannot both be set.")
 	}
 
 	return nil
@@ -181,19 +211,28 @@ func (config *StatsConfig) Run(
 ## shellfish stats ##
 #####################`,
 		)
+		log.Println("RNG Seed is", randSeed)
 	}
 	var t time.Time
 	if logging.Mode == logging.Performance {
 		t = time.Now()
 	}
 
-	intColIdxs := []int{0, 1}
-	floatColIdxs := make([]int, 4+2*config.order*config.order)
-	for i := range floatColIdxs {
-		floatColIdxs[i] = i + len(intColIdxs)
+	floatReqs := append(
+		coordRequests("X", "Y", "Z", "R200m"),
+		catalog.Request{
+			Name: "P_ijk", Width: int(2*config.order*config.order),
+		},
+	)
+	hd, err := catalog.ParseHeader(stdin)
+	if err != nil {
+		return nil, err
+	}
+	if err = hd.CheckPenna(int(config.order)); err != nil {
+		return nil, err
 	}
-	intCols, floatCols, err := catalog.Parse(
-		stdin, intColIdxs, floatColIdxs,
+	intCols, floatCols, _, err := catalog.ParseColumns(
+		stdin, haloRequests, floatReqs,
 	)
 
 	if err != nil {
@@ -207,6 +246,8 @@ func (config *StatsConfig) Run(
 	snapBins, coeffBins, idxBins := binCoeffsBySnap(snaps, ids, coeffs)
 
 	masses := make([]float64, len(ids))
+	boundMasses200m := make([]float64, len(ids))
+	boundMassesSp := make([]float64, len(ids))
 
 	rads := make([]float64, len(ids))
 	rmins := make([]float64, len(ids))
@@ -231,17 +272,43 @@ func (config *StatsConfig) Run(
 	}
 	
 	buf, err := getVectorBuffer(
-		e.ParticleCatalog(snaps[0], 0), gConfig,
+		e.ParticleCatalogs(snaps[0]), gConfig,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Zoom-in simulations track the mass in low-resolution particles.
+	_, maxHighResMass, zoom := highResMasses(buf, gConfig)
+	zoom = zoom && !config.skipMass
+	var masses200m, lowMasses200m, lowMassesSp []float64
+	if zoom {
+		masses200m = make([]float64, len(ids))
+		lowMasses200m = make([]float64, len(ids))
+		lowMassesSp = make([]float64, len(ids))
+	}
+	var lowMs []float32
+
+	var energies map[haloKey]map[int64]float32
+	if config.boundMass && config.potentialFile != "" {
+		energies, err = readPotentialEnergies(config.potentialFile)
+		if err != nil { return nil, err }
+	}
+
 	if logging.Mode == logging.Performance {
 		log.Println("Initialized VectorBuffer")
 		log.Println(logging.MemString())
 	}
 	
+	fields := io.Positions | io.Masses
+	if config.shellFilter { fields |= io.IDs }
+	if config.boundMass { fields = io.AllFields }
+	br := newBlockReader(buf, gConfig, e, fields)
+	defer br.Stop()
+
+	// Shell volumes, areas, and axes are found by Monte Carlo sampling.
+	gen := rand.New(rand.Xorshift, randSeed)
+
 	for _, snap := range sortedSnaps {
 		if snap == -1 {
 			continue
@@ -265,16 +332,16 @@ func (config *StatsConfig) Run(
 			order := findOrder(coeffs[idxs[j]])
 			shell := analyze.PennaFunc(coeffs[idxs[j]], order, order, 2)
 
-			vol := shell.Volume(samples)
+			vol := shell.Volume(samples, gen)
 			r := math.Pow(vol/(math.Pi*4/3), 0.33333)
 
 			vols[idxs[j]] = vol
 			rads[idxs[j]] = r
-			sas[idxs[j]] = shell.SurfaceArea(samples)
+			sas[idxs[j]] = shell.SurfaceArea(samples, gen)
 			as[idxs[j]], bs[idxs[j]], cs[idxs[j]], aVecs[idxs[j]] =
-				shell.Axes(samples)
+				shell.Axes(samples, gen)
 
-			rmins[idxs[j]], rmaxes[idxs[j]] = rangeSp(snapCoeffs[j], config)
+			rmins[idxs[j]], rmaxes[idxs[j]] = rangeSp(snapCoeffs[j], config, gen)
 		}
 
 		if logging.Mode == logging.Performance {
@@ -282,7 +349,7 @@ func (config *StatsConfig) Run(
 			log.Println(logging.MemString())
 		}
 		
-		hds, files, err := memo.ReadHeaders(snap, buf, e)
+		hds, _, err := memo.ReadHeaders(snap, buf, e)
 		if err != nil {
 			return nil, err
 		}
@@ -291,53 +358,129 @@ func (config *StatsConfig) Run(
 		if err != nil {
 			return nil, err
 		}
-		intrBins, _ := binSphereIntersections(hds, hBounds)
-
 		
 		rLows := make([]float64, len(snapCoeffs))
 		rHighs := make([]float64, len(snapCoeffs))
 		for i := range snapCoeffs {
 			// TODO: Figure out what's going on here and refactor.
-			rLows[i], rHighs[i] = rangeSp(snapCoeffs[i], config)
+			rLows[i], rHighs[i] = rangeSp(snapCoeffs[i], config, gen)
+		}
+
+		var haloBufs []haloParticles
+		if config.boundMass {
+			haloBufs = make([]haloParticles, len(idxs))
+		}
+
+		// Every particle used by any of the output columns is within
+		// readSpheres of its halo.
+		readSpheres := make([]geom.Sphere, len(hBounds))
+		for j := range hBounds {
+			r := float64(hBounds[j].R)
+			readSpheres[j] = hBounds[j]
+			readSpheres[j].R = float32(math.Max(
+				r, rHighs[j] + r*math.Max(config.shellWidth, 0),
+			))
+		}
+		intrBins, _ := binSphereIntersections(hds, readSpheres)
+
+		blocks, blockSpheres := []int{}, make([][]geom.Sphere, len(hds))
+		for i := range hds {
+			if config.skipMass || len(intrBins[i]) == 0 { continue }
+			blocks, blockSpheres[i] = append(blocks, i), readSpheres
+		}
+		err = br.Prefetch(snap, hds, blocks, blockSpheres)
+		if err != nil {
+			return nil, err
 		}
 
 		for i := range hds {
 			if config.skipMass { break }
 			if len(intrBins[i]) == 0 { continue }
 
-			xs, _, ms, pIDs, err := buf.Read(files[i])
+			err = br.Each(snap, i, &hds[i], readSpheres, func(
+				xs, vs [][3]float32, ms []float32, pIDs []int64,
+			) error {
+				if zoom {
+					lowMs = lowResMasses(ms, maxHighResMass, lowMs)
+				}
+
+				for j := range idxs {
+					masses[idxs[j]] += massContained(
+						&hds[i], xs, ms, snapCoeffs[j],
+						hBounds[j], rLows[j], rHighs[j],
+						gConfig.Threads,
+					)
+
+					if zoom {
+						lowMassesSp[idxs[j]] += massContained(
+							&hds[i], xs, lowMs, snapCoeffs[j],
+							hBounds[j], rLows[j], rHighs[j],
+							gConfig.Threads,
+						)
+						m, mLow := sphereMasses(
+							&hds[i], xs, ms, lowMs, hBounds[j],
+						)
+						masses200m[idxs[j]] += m
+						lowMasses200m[idxs[j]] += mLow
+					}
+
+					if haloBufs != nil {
+						rMax := math.Max(float64(hBounds[j].R), rHighs[j])
+						haloBufs[j].appendParticles(
+							&hds[i], hBounds[j], rMax, xs, vs, ms, pIDs,
+						)
+					}
+
+					if config.shellFilter {
+						// This isn't the correct way to handle this for
+						// performance, but massContained is already gross
+						// enough as it is.
+						shellParticles[idxs[j]] = appendShellParticles(
+							&hds[i], xs, pIDs, snapCoeffs[j],
+							hBounds[j], rLows[j], rHighs[j],
+							config.shellWidth,
+							gConfig.Threads,
+							shellParticles[idxs[j]],
+						)
+					}
+
+				}
+				return nil
+			})
 
 			if logging.Mode == logging.Performance {
 				log.Printf("Read segment %d.", i)
 				log.Println(logging.MemString())
 			}
-			
+
 			if err != nil {
 				return nil, err
 			}
+		}
 
-			for j := range idxs {
-				masses[idxs[j]] += massContained(
-					&hds[i], xs, ms, snapCoeffs[j],
-					hBounds[j], rLows[j], rHighs[j],
-					gConfig.Threads,
+		for j := range haloBufs {
+			h := &haloBufs[j]
+			r200m := float64(hBounds[j].R)
+			var bound []bool
+			if energies != nil {
+				es, err := lookupEnergies(
+					energies, ids[idxs[j]], snap, config.potentialFile,
+				)
+				if err != nil { return nil, err }
+				bound = h.boundFromEnergies(es)
+			} else {
+				bound = h.unbind(
+					&hds[0], r200m, config.boundSoftening,
+					config.boundIterations,
 				)
-
-				if config.shellFilter {
-					// This isn't the correct way to handle this for
-					// performance, but massContained is already gross enough as
-					// it is.
-					shellParticles[idxs[j]] = appendShellParticles(
-						&hds[i], xs, pIDs, snapCoeffs[j],
-						hBounds[j], rLows[j], rHighs[j],
-						config.shellWidth,
-						gConfig.Threads,
-						shellParticles[idxs[j]],
-					)
-				}
-
 			}
-			buf.Close()
+			if bound == nil { continue }
+
+			boundMasses200m[idxs[j]] = h.boundMass(bound, r200m)
+			boundMassesSp[idxs[j]] = boundMassContained(
+				h, bound, snapCoeffs[j], rLows[j], rHighs[j],
+			)
+			haloBufs[j] = haloParticles{}
 		}
 	}
 
@@ -352,24 +495,40 @@ func (config *StatsConfig) Run(
 		axs[i], ays[i], azs[i] = aVecs[i][0], aVecs[i][1], aVecs[i][2]
 	}
 
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps},
-		[][]float64{masses, rads, vols, sas,
-			as, bs, cs, axs, ays, azs, rmins, rmaxes},
-		[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
-	)
-	cString := catalog.CommentString(
-		[]string{"ID", "Snapshot"},
-		[]string{"M_sp [M_sun/h]", "R_sp [cMpc/h]",
-			"Volume [cMpc^3/h^3]", "Surface Area [cMpc^2/h^2]",
-			"Major Axis [cMpc/h]",
-			"Intermediate Axis [cMpc/h]",
-			"Minor Axis [cMpc/h]",
-			"Ax", "Ay", "Az",
-			"RMin [cMpc/h]", "RMax [cMpc/h]",
-		},
-		[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
-		[]int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	outCols := [][]float64{masses, rads, vols, sas,
+		as, bs, cs, axs, ays, azs, rmins, rmaxes}
+	outNames := []string{"M_sp [M_sun/h]", "R_sp [cMpc/h]",
+		"Volume [cMpc^3/h^3]", "Surface Area [cMpc^2/h^2]",
+		"Major Axis [cMpc/h]",
+		"Intermediate Axis [cMpc/h]",
+		"Minor Axis [cMpc/h]",
+		"Ax", "Ay", "Az",
+		"RMin [cMpc/h]", "RMax [cMpc/h]",
+	}
+	if config.boundMass {
+		outCols = append(outCols, boundMasses200m, boundMassesSp)
+		outNames = append(outNames, "Mb_200m [M_sun/h]", "Mb_sp [M_sun/h]")
+	}
+	if zoom {
+		fc200m := make([]float64, len(ids))
+		fcSp := make([]float64, len(ids))
+		for i := range ids {
+			fc200m[i] = contamination(masses200m[i], lowMasses200m[i])
+			fcSp[i] = contamination(masses[i], lowMassesSp[i])
+		}
+		outCols = append(outCols, fc200m, fcSp)
+		outNames = append(outNames, "fc_200m", "fc_sp")
+	}
+
+	order := make([]int, len(outCols) + 2)
+	sizes := make([]int, len(outCols) + 2)
+	for i := range order {
+		order[i], sizes[i] = i, 1
+	}
+
+	outHd := catalogHeader(
+		"stats", config.vars, 0,
+		[]string{"ID", "Snapshot"}, outNames, order, sizes,
 	)
 
 	if logging.Mode == logging.Performance {
@@ -377,7 +536,9 @@ func (config *StatsConfig) Run(
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, lines...), nil
+	return formatCatalog(
+		gConfig, outHd, [][]int{ids, snaps}, outCols, order,
+	), nil
 }
 
 func wrapDist(x1, x2, width float64) float64 {
@@ -433,19 +594,23 @@ func findOrder(coeffs []float64) int {
 	}
 }
 
+// wrap moves the displacement x into the range [-tw2, tw2] by adding or
+// subtracting the box width, 2*tw2.
 func wrap(x, tw2 float32) float32 {
 	if x > tw2 {
-		return x - tw2
+		return x - 2*tw2
 	} else if x < -tw2 {
-		return x + tw2
+		return x + 2*tw2
 	}
 	return x
 }
 
-func rangeSp(coeffs []float64, c *StatsConfig) (rmin, rmax float64) {
+func rangeSp(
+	coeffs []float64, c *StatsConfig, gen *rand.Generator,
+) (rmin, rmax float64) {
 	order := findOrder(coeffs)
 	shell := analyze.PennaFunc(coeffs, order, order, 2)
-	return shell.RadialRange(int(c.monteCarloSamples))
+	return shell.RadialRange(int(c.monteCarloSamples), gen)
 }
 
 func massContained(
@@ -477,6 +642,27 @@ func massContained(
 	return sum
 }
 
+// boundMassContained returns the mass of the bound particles in h which are
+// contained within the splashback shell described by coeffs.
+func boundMassContained(
+	h *haloParticles, bound []bool, coeffs []float64, rLow, rHigh float64,
+) float64 {
+	order := findOrder(coeffs)
+	shell := analyze.PennaFunc(coeffs, order, order, 2)
+	low2, high2 := float32(rLow*rLow), float32(rHigh*rHigh)
+
+	sum := 0.0
+	for i, x := range h.xs {
+		if !bound[i] { continue }
+		r2 := x[0]*x[0] + x[1]*x[1] + x[2]*x[2]
+		if r2 < low2 || (r2 < high2 &&
+			shell.Contains(float64(x[0]), float64(x[1]), float64(x[2]))) {
+			sum += float64(h.ms[i])
+		}
+	}
+	return sum
+}
+
 // TODO: Humans cannot remember this many parameters.
 
 func appendShellParticles(
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		x, tw2, exp float32
+	}{
+		{0, 50, 0},
+		{10, 50, 10},
+		{-10, 50, -10},
+		{99, 50, -1},
+		{-99, 50, 1},
+		{60, 50, -40},
+		{-60, 50, 40},
+	}
+
+	for i := range tests {
+		res := wrap(tests[i].x, tests[i].tw2)
+		if res != tests[i].exp {
+			t.Errorf("%d) Expected wrap(%g, %g) = %g, got %g.",
+				i, tests[i].x, tests[i].tw2, tests[i].exp, res)
+		}
+	}
+}
+
+func TestAppendParticlesPeriodic(t *testing.T) {
+	hd := &io.Header{ TotalWidth: 100 }
+	s := geom.Sphere{ C: [3]float32{99, 50, 1} }
+
+	xs := [][3]float32{ {1, 50, 1}, {99, 50, 99}, {50, 50, 50} }
+	vs := make([][3]float32, len(xs))
+	ms := []float32{ 1, 2, 3 }
+	ids := []int64{ 10, 11, 12 }
+
+	h := &haloParticles{}
+	h.appendParticles(hd, s, 5, xs, vs, ms, ids)
+
+	if len(h.ids) != 2 || h.ids[0] != 10 || h.ids[1] != 11 {
+		t.Fatalf("Expected particles 10 and 11, got %v.", h.ids)
+	}
+	exp := [][3]float32{ {2, 0, 0}, {0, 0, -2} }
+	for i := range exp {
+		if h.xs[i] != exp[i] {
+			t.Errorf("Expected offset %v for particle %d, got %v.",
+				exp[i], h.ids[i], h.xs[i])
+		}
+	}
+}
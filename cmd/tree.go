@@ -15,6 +15,9 @@ import (
 
 type TreeConfig struct {
 	selectSnaps []int64
+
+	// vars holds the variables read by ReadConfig.
+	vars *parse.ConfigVars
 }
 
 var _ Mode = &TreeConfig{}
@@ -34,6 +37,7 @@ func (config *TreeConfig) ExampleConfig() string {
 
 func (config *TreeConfig) ReadConfig(fname string, flags []string) error {
 	vars := parse.NewConfigVars("tree.config")
+	config.vars = vars
 	vars.Ints(&config.selectSnaps, "SelectSnaps", []int64{})
 
 	if fname == "" {
@@ -74,7 +78,7 @@ func (config *TreeConfig) Run(
 		t = time.Now()
 	}
 
-	intCols, _, err := catalog.Parse(stdin, []int{0, 1}, []int{})
+	intCols, _, _, err := catalog.ParseColumns(stdin, haloRequests, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -103,27 +107,27 @@ func (config *TreeConfig) Run(
 		}
 	}
 
-	lines := catalog.FormatCols(
-		[][]int{ids, snaps}, [][]float64{}, []int{0, 1},
-	)
-	fLines := []string{}
-	for i := range lines {
+	fIDs, fSnaps := []int{}, []int{}
+	for i := range ids {
 		if snaps[i] >= int(gConfig.SnapMin) &&
 			snaps[i] <= int(gConfig.SnapMax) {
 
 			if len(config.selectSnaps) > 0 {
 				for j := range config.selectSnaps {
 					if int(config.selectSnaps[j]) == snaps[i] {
-						fLines = append(fLines, lines[i])
+						fIDs = append(fIDs, ids[i])
+						fSnaps = append(fSnaps, snaps[i])
 					}
 				}
 			} else {
-				fLines = append(fLines, lines[i])
+				fIDs = append(fIDs, ids[i])
+				fSnaps = append(fSnaps, snaps[i])
 			}
 		}
 	}
 
-	cString := catalog.CommentString(
+	hd := catalogHeader(
+		"tree", config.vars, 0,
 		[]string{"ID", "Snapshot"}, []string{}, []int{0, 1}, []int{1, 1},
 	)
 
@@ -132,7 +136,9 @@ func (config *TreeConfig) Run(
 		log.Printf("Memory:\n%s", logging.MemString())
 	}
 
-	return append([]string{cString}, fLines...), nil
+	return formatCatalog(
+		gConfig, hd, [][]int{fIDs, fSnaps}, [][]float64{}, []int{0, 1},
+	), nil
 }
 
 func treeFiles(gConfig *GlobalConfig) ([]string, error) {
@@ -2,16 +2,115 @@ package cmd
 
 import (
 	"fmt"
+	goio "io"
+	"log"
+	"math"
+
+	"github.com/phil-mansfield/shellfish/cmd/catalog"
+	"github.com/phil-mansfield/shellfish/cmd/env"
+	"github.com/phil-mansfield/shellfish/cmd/memo"
 	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+	"github.com/phil-mansfield/shellfish/parse"
+	"github.com/phil-mansfield/shellfish/version"
 )
 
+// catalogHeader returns the catalog.Header of a catalog written by a mode.
+// The names, order, and sizes are the same arguments that
+// catalog.CommentString takes. penna is the order of the catalog's
+// Penna-Dines coefficients, or 0 if it doesn't have any.
+func catalogHeader(
+	mode string, vars *parse.ConfigVars, penna int,
+	intNames, floatNames []string, order, sizes []int,
+) *catalog.Header {
+	hd := catalog.NewHeader(mode, intNames, floatNames, order, sizes)
+	hd.Version = version.SourceVersion
+	hd.Config = configValues(vars)
+	if penna > 0 {
+		hd.Penna = &catalog.Penna{ Order: penna, Basis: catalog.PennaBasis }
+	}
+
+	return hd
+}
+
+// configValues returns the values of a config file's variables in a form
+// which can be written as JSON, or nil if vars is nil.
+func configValues(vars *parse.ConfigVars) map[string]interface{} {
+	if vars == nil { return nil }
+	values := vars.Values()
+
+	// JSON can't represent infinities or NaNs.
+	for name, val := range values {
+		switch x := val.(type) {
+		case float64:
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				values[name] = fmt.Sprint(x)
+			}
+		case []float64:
+			for i := range x {
+				if math.IsInf(x[i], 0) || math.IsNaN(x[i]) {
+					values[name] = fmt.Sprint(x)
+					break
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// formatCatalog returns the output of a mode as a catalog in the format set
+// by OutputFormat. The columns are the same arguments that
+// catalog.FormatCols takes. Text catalogs start with a line containing hd
+// and a line containing the column comment. Parquet and HDF5 catalogs are
+// returned as a single "line" containing the entire file.
+func formatCatalog(
+	gConfig *GlobalConfig, hd *catalog.Header,
+	intCols [][]int, floatCols [][]float64, order []int,
+) []string {
+	hd.Provenance = gConfig.provenance.catalogProvenance(hd, intCols, order)
+
+	switch gConfig.OutputFormat {
+	case "parquet":
+		return []string{
+			string(catalog.EncodeParquet(hd, intCols, floatCols, order)),
+		}
+	case "hdf5":
+		return []string{
+			string(catalog.EncodeHDF5(hd, intCols, floatCols, order)),
+		}
+	}
+
+	lines := catalog.FormatCols(intCols, floatCols, order)
+	cString := hd.String() + "\n" + hd.CommentString()
+	return append([]string{cString}, lines...)
+}
+
+// haloRequests are the columns which identify a halo in a catalog.
+var haloRequests = []catalog.Request{
+	{Name: "ID", Width: 1}, {Name: "Snapshot", Width: 1},
+}
+
+// coordRequests returns requests for the named halo properties, each of
+// which is a single column.
+func coordRequests(names ...string) []catalog.Request {
+	reqs := make([]catalog.Request, len(names))
+	for i := range names { reqs[i] = catalog.Request{ Name: names[i], Width: 1 } }
+	return reqs
+}
+
+// getVectorBuffer returns a VectorBuffer for the snapshot with the given
+// block files. Some formats need to look at every block to find the range of
+// particle masses.
 func getVectorBuffer(
-	fname string, config *GlobalConfig,
+	fnames []string, config *GlobalConfig,
 ) (io.VectorBuffer, error) {
+	fname := fnames[0]
 	context := io.Context{
 		LGadgetNPartNum: config.LGadgetNpartNum,
 		GadgetDMTypeIndices: config.GadgetDMTypeIndices,
 		GadgetDMSingleMassIndices: config.GadgetSingleMassIndices,
+		GadgetHighResTypeIndices: config.GadgetHighResTypeIndices,
 		GadgetMassUnits: config.GadgetMassUnits,
 		GadgetPositionUnits: config.GadgetPositionUnits,
 		NilOmegaM: config.NilSnapOmegaM,
@@ -19,6 +118,48 @@ func getVectorBuffer(
 		NilH100: config.NilSnapH100,
 		NilScaleFactors: config.NilSnapScaleFactors,
 		NilTotalWidth: config.NilSnapTotalWidth,
+		TipsyTotalWidth: config.TipsyTotalWidth,
+		TipsyOmegaM: config.TipsyOmegaM,
+		TipsyOmegaL: config.TipsyOmegaL,
+		TipsyH100: config.TipsyH100,
+		TipsyParticleTypes: config.TipsyParticleTypes,
+		RawHeaderSize: config.RawHeaderSize,
+		RawPositions: io.RawField{
+			Type: config.RawPositionType, Offset: config.RawPositionOffset,
+			Stride: config.RawPositionStride, Units: config.RawPositionUnits,
+		},
+		RawVelocities: io.RawField{
+			Type: config.RawVelocityType, Offset: config.RawVelocityOffset,
+			Stride: config.RawVelocityStride, Units: config.RawVelocityUnits,
+		},
+		RawMasses: io.RawField{
+			Type: config.RawMassType, Offset: config.RawMassOffset,
+			Stride: config.RawMassStride, Units: config.RawMassUnits,
+		},
+		RawIDs: io.RawField{
+			Type: config.RawIDType, Offset: config.RawIDOffset,
+			Stride: config.RawIDStride, Units: 1,
+		},
+		RawMass: config.RawMass,
+		RawCountOffset: config.RawCountOffset,
+		RawCountType: config.RawCountType,
+		RawScaleFactorOffset: config.RawScaleFactorOffset,
+		RawScaleFactorType: config.RawScaleFactorType,
+		RawScaleFactor: config.RawScaleFactor,
+		RawTotalWidth: config.RawTotalWidth,
+		RawOmegaM: config.RawOmegaM,
+		RawOmegaL: config.RawOmegaL,
+		RawH100: config.RawH100,
+		NumPyPositionArray: config.NumPyPositionArray,
+		NumPyVelocityArray: config.NumPyVelocityArray,
+		NumPyMassArray: config.NumPyMassArray,
+		NumPyIDArray: config.NumPyIDArray,
+		NumPyMass: config.NumPyMass,
+		NumPyTotalWidth: config.NumPyTotalWidth,
+		NumPyOmegaM: config.NumPyOmegaM,
+		NumPyOmegaL: config.NumPyOmegaL,
+		NumPyH100: config.NumPyH100,
+		NumPyScaleFactor: config.NumPyScaleFactor,
 	}
 	
 	switch config.SnapshotType {
@@ -27,13 +168,21 @@ func getVectorBuffer(
 	case "LGadget-2":
 		return io.NewLGadget2Buffer(fname, config.Endianness, context)
 	case "Gadget-2":
-		return io.NewGadget2Buffer(fname, config.Endianness, context)
+		return io.NewGadget2Buffer(fnames, config.Endianness, context)
 	case "ARTIO":
 		return io.NewARTIOBuffer(fname)
 	case "Bolshoi":
 		return io.NewBolshoiBuffer(fname, config.Endianness, context)
 	case "BolshoiP":
 		return io.NewBolshoiPBuffer(fname, config.Endianness, context)
+	case "RAMSES":
+		return io.NewRAMSESBuffer(fnames, config.Endianness, context)
+	case "TIPSY":
+		return io.NewTipsyBuffer(fnames, context)
+	case "raw":
+		return io.NewRawBuffer(fnames, config.Endianness, context)
+	case "NumPy":
+		return io.NewNumPyBuffer(fnames, context)
 	case "nil":
 		return io.NewNilBuffer(context)
 	}
@@ -44,6 +193,237 @@ func getVectorBuffer(
 	)
 }
 
+// blockReader reads the particles in snapshot blocks which are needed by a
+// set of halos. If spatial indexing is turned on, only the particles close to
+// the halos are read. Otherwise entire blocks are read. If prefetching is
+// turned on, blocks passed to Prefetch are read in the background.
+type blockReader struct {
+	buf     io.VectorBuffer
+	e       *env.Environment
+	gConfig *GlobalConfig
+	cells   int64
+	chunk   int64
+	fields  io.Field
+
+	// warned is true once a warning about reading double-precision positions
+	// as float32 has been printed.
+	warned bool
+
+	// Prefetching state. bufs are independent from buf and from each other.
+	bufs []io.VectorBuffer
+	pf   *prefetcher
+	curr *prefetchBlock
+}
+
+// newBlockReader creates a blockReader which only reads the given particle
+// fields. Fields which aren't read are returned as nil.
+func newBlockReader(
+	buf io.VectorBuffer, gConfig *GlobalConfig, e *env.Environment,
+	fields io.Field,
+) *blockReader {
+	return &blockReader{
+		buf: buf, e: e, gConfig: gConfig,
+		cells: gConfig.SpatialIndexCells, chunk: gConfig.ChunkSize,
+		fields: fields,
+	}
+}
+
+// Prefetch starts reading the given blocks of a snapshot in the background.
+// The blocks must later be read with Read in the same order. spheres[i] are
+// the spheres that will be passed to Read for block i. Prefetch does nothing
+// if PrefetchDepth is not positive.
+func (br *blockReader) Prefetch(
+	snap int, hds []io.Header, blocks []int, spheres [][]geom.Sphere,
+) error {
+	br.Stop()
+	depth := int(br.gConfig.PrefetchDepth)
+	if depth <= 0 || len(blocks) == 0 { return nil }
+
+	// One buffer is held by the caller while the rest are filled.
+	for len(br.bufs) < depth + 1 {
+		buf, err := getVectorBuffer(
+			br.e.ParticleCatalogs(snap), br.gConfig,
+		)
+		if err != nil { return err }
+		br.bufs = append(br.bufs, buf)
+	}
+
+	sizes := make([]int64, len(blocks))
+	for k, block := range blocks { sizes[k] = hds[block].N }
+
+	br.pf = newPrefetcher(br.bufs, br.gConfig.PrefetchMemory << 20)
+	go br.pf.run(blocks, sizes, func(k int, buf io.VectorBuffer) (
+		xs, vs [][3]float32, ms []float32, ids []int64, err error,
+	) {
+		block := blocks[k]
+		return br.read(buf, snap, block, &hds[block], spheres[block])
+	})
+
+	return nil
+}
+
+// Read returns a superset of the particles in the given block which are
+// inside at least one of spheres. hd is the header of the block. Close must
+// be called before Read is called again.
+func (br *blockReader) Read(
+	snap, block int, hd *io.Header, spheres []geom.Sphere,
+) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
+	if err = br.warnFloat32(snap, block); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if br.pf != nil {
+		pb, ok := <-br.pf.blocks
+		if ok {
+			br.curr = pb
+			if pb.block != block {
+				return nil, nil, nil, nil, fmt.Errorf(
+					"Block %d of snapshot %d was prefetched, but block %d " +
+						"was read.", pb.block, snap, block,
+				)
+			}
+			return pb.xs, pb.vs, pb.ms, pb.ids, pb.err
+		}
+	}
+
+	return br.read(br.buf, snap, block, hd, spheres)
+}
+
+func (br *blockReader) read(
+	buf io.VectorBuffer, snap, block int, hd *io.Header,
+	spheres []geom.Sphere,
+) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
+	if br.cells <= 0 {
+		return buf.ReadFields(br.e.ParticleCatalog(snap, block), br.fields)
+	}
+	return memo.ReadSpheres(
+		snap, block, int(br.cells), buf, br.e, hd, spheres, br.fields,
+	)
+}
+
+// Each calls f on the same particles that Read would return. If ChunkSize is
+// set, the block is read ChunkSize particles at a time and f is called once
+// for each chunk. Otherwise f is called once on the whole block. The slices
+// passed to f are only valid until f returns. GlobalConfig.validate ensures
+// that ChunkSize is never set alongside SpatialIndexCells or PrefetchDepth.
+func (br *blockReader) Each(
+	snap, block int, hd *io.Header, spheres []geom.Sphere,
+	f func(xs, vs [][3]float32, ms []float32, ids []int64) error,
+) error {
+	if br.chunk <= 0 {
+		defer br.Close()
+		xs, vs, ms, ids, err := br.Read(snap, block, hd, spheres)
+		if err != nil { return err }
+		return f(xs, vs, ms, ids)
+	}
+
+	if err := br.warnFloat32(snap, block); err != nil { return err }
+	it, err := br.buf.ReadChunks(
+		br.e.ParticleCatalog(snap, block), br.fields, int(br.chunk),
+	)
+	if err != nil { return err }
+	defer it.Close()
+
+	for {
+		xs, vs, ms, ids, err := it.Next()
+		if err == goio.EOF { return nil }
+		if err != nil { return err }
+		if err = f(xs, vs, ms, ids); err != nil { return err }
+	}
+}
+
+// float64Positions returns true if the given block stores double-precision
+// positions.
+func (br *blockReader) float64Positions(snap, block int) (bool, error) {
+	buf, ok := br.buf.(io.Float64Buffer)
+	if !ok { return false, nil }
+	return buf.Float64Positions(br.e.ParticleCatalog(snap, block))
+}
+
+// Float64 returns true if the given block stores double-precision positions,
+// in which case Read64 should be used instead of Read. Spatial indexing,
+// prefetching, and chunking all work in float32, so if any of them are
+// turned on, false is returned and a warning is printed when the block is
+// read.
+func (br *blockReader) Float64(snap, block int) (bool, error) {
+	ok, err := br.float64Positions(snap, block)
+	if err != nil || !ok { return false, err }
+	return br.cells <= 0 && br.chunk <= 0 && br.gConfig.PrefetchDepth <= 0,
+		nil
+}
+
+// warnFloat32 prints a warning if the given block stores double-precision
+// positions, since Read and Each round them to float32. Only one warning is
+// printed.
+func (br *blockReader) warnFloat32(snap, block int) error {
+	if br.warned { return nil }
+	ok, err := br.float64Positions(snap, block)
+	if err != nil || !ok { return err }
+
+	br.warned = true
+	log.Printf("Warning: %s stores double-precision positions, but they "+
+		"will be rounded to float32. Only shell mode reads float64 "+
+		"positions, and only if SpatialIndexCells, PrefetchDepth, and "+
+		"ChunkSize aren't set.", br.e.ParticleCatalog(snap, block))
+	return nil
+}
+
+// Read64 is identical to Read, except that positions are returned as
+// float64s. It should only be called if Float64 returns true.
+func (br *blockReader) Read64(snap, block int) (
+	xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	buf := br.buf.(io.Float64Buffer)
+	return buf.ReadFields64(br.e.ParticleCatalog(snap, block), br.fields)
+}
+
+// Close releases the particles returned by the last call to Read.
+func (br *blockReader) Close() {
+	if br.curr != nil {
+		br.pf.release(br.curr)
+		br.curr = nil
+		return
+	}
+	if br.buf.IsOpen() { br.buf.Close() }
+}
+
+// Stop stops any background reads. It is safe to call Stop multiple times.
+func (br *blockReader) Stop() {
+	if br.pf == nil { return }
+	if br.curr != nil { br.Close() }
+	br.pf.Stop()
+	br.pf = nil
+}
+
+// sphereSubset returns the spheres with the given indices with their radii
+// multiplied by mult.
+func sphereSubset(
+	spheres []geom.Sphere, idxs []int, mult float64,
+) []geom.Sphere {
+	out := make([]geom.Sphere, len(idxs))
+	for i, j := range idxs {
+		out[i] = spheres[j]
+		out[i].R *= float32(mult)
+	}
+	return out
+}
+
+// intersectingBlocks returns the blocks which intersect at least one halo and
+// the spheres which need to be read from each block. intrIdxs[i] are the
+// indices of the halos which intersect block i and the radii of spheres are
+// multiplied by mult.
+func intersectingBlocks(
+	intrIdxs [][]int, spheres []geom.Sphere, mult float64,
+) (blocks []int, blockSpheres [][]geom.Sphere) {
+	blockSpheres = make([][]geom.Sphere, len(intrIdxs))
+	for i := range intrIdxs {
+		if len(intrIdxs[i]) == 0 { continue }
+		blocks = append(blocks, i)
+		blockSpheres[i] = sphereSubset(spheres, intrIdxs[i], mult)
+	}
+	return blocks, blockSpheres
+}
+
 // How to use:
 //
 // lg := NewLockGroup(workers)
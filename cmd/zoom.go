@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+)
+
+// lowResMassRatio is the largest mass, as a multiple of HighResMass, that a
+// high-resolution particle can have. Neighboring levels of zoom-in
+// simulations usually differ in mass by a factor of eight, so anything in
+// between works.
+const lowResMassRatio = 2
+
+// highResMasses returns the smallest and largest masses of the particles in
+// the high-resolution region of a zoom-in simulation. Every more massive
+// particle is outside the region. The region is made up of the particle types
+// in GadgetHighResTypeIndices if they're given and of the particles no more
+// than lowResMassRatio times as massive as HighResMass otherwise. zoom is
+// false if neither is set.
+func highResMasses(
+	buf io.VectorBuffer, gConfig *GlobalConfig,
+) (lo, hi float64, zoom bool) {
+	if gConfig.HighResMass > 0 {
+		return gConfig.HighResMass, gConfig.HighResMass*lowResMassRatio, true
+	}
+	if zBuf, ok := buf.(io.ZoomBuffer); ok {
+		lo, hi, ok := zBuf.HighResMasses()
+		return float64(lo), float64(hi), ok
+	}
+	return 0, 0, false
+}
+
+// isLowRes returns true if a particle with mass m is a low-resolution
+// particle in a zoom-in simulation where the most massive high-resolution
+// particle has the mass maxHighResMass.
+func isLowRes(m float32, maxHighResMass float64) bool {
+	return float64(m) > maxHighResMass
+}
+
+// lowResMasses writes the masses of the particles in ms to buf with the
+// masses of high-resolution particles set to zero and returns the result.
+func lowResMasses(
+	ms []float32, maxHighResMass float64, buf []float32,
+) []float32 {
+	if cap(buf) < len(ms) {
+		buf = make([]float32, len(ms))
+	}
+	buf = buf[:len(ms)]
+
+	for i := range ms {
+		if isLowRes(ms[i], maxHighResMass) {
+			buf[i] = ms[i]
+		} else {
+			buf[i] = 0
+		}
+	}
+	return buf
+}
+
+// sphereMasses returns the total mass of the particles inside sphere and the
+// mass of the low-resolution particles inside it. lowMs are masses returned by
+// lowResMasses.
+func sphereMasses(
+	hd *io.Header, xs [][3]float32, ms, lowMs []float32, sphere geom.Sphere,
+) (m, mLow float64) {
+	tw2 := float32(hd.TotalWidth) / 2
+	r2 := sphere.R * sphere.R
+
+	for i := range xs {
+		x := wrap(xs[i][0] - sphere.C[0], tw2)
+		y := wrap(xs[i][1] - sphere.C[1], tw2)
+		z := wrap(xs[i][2] - sphere.C[2], tw2)
+		if x*x + y*y + z*z >= r2 { continue }
+
+		m += float64(ms[i])
+		mLow += float64(lowMs[i])
+	}
+
+	return m, mLow
+}
+
+// contamination returns the fraction of mass which is in low-resolution
+// particles. It's zero if there's no mass at all.
+func contamination(m, mLow float64) float64 {
+	if m <= 0 { return 0 }
+	return mLow / m
+}
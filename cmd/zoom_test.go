@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/io"
+	"github.com/phil-mansfield/shellfish/los/geom"
+)
+
+func TestSphereMassesPeriodic(t *testing.T) {
+	hd := &io.Header{ TotalWidth: 100 }
+	sphere := geom.Sphere{ C: [3]float32{1, 1, 99}, R: 3 }
+
+	xs := [][3]float32{ {99, 1, 99}, {1, 99, 1}, {50, 50, 50}, {1, 1, 95} }
+	ms := []float32{ 1, 8, 1, 1 }
+	lowMs := lowResMasses(ms, 2, nil)
+
+	m, mLow := sphereMasses(hd, xs, ms, lowMs, sphere)
+	if m != 9 || mLow != 8 {
+		t.Errorf("Expected masses 9 and 8, got %g and %g.", m, mLow)
+	}
+}
@@ -3,6 +3,7 @@ package io
 import (
 	"fmt"
 	"math"
+	"os"
 	"strconv"
 	"strings"
 
@@ -36,6 +37,9 @@ func NewARTIOBuffer(filename string) (VectorBuffer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = checkARTIOCompression(fileset); err != nil {
+		return nil, err
+	}
 
 	h, err := artio.FilesetOpen(fileset, artio.OpenHeader, artio.NullContext)
 	if err != nil {
@@ -79,6 +83,22 @@ func NewARTIOBuffer(filename string) (VectorBuffer, error) {
 	}, nil
 }
 
+// checkARTIOCompression returns an error if the header of an ARTIO fileset
+// has been compressed. Filesets are read by the ARTIO library instead of
+// through Open, so they can't be decompressed.
+func checkARTIOCompression(fileset string) error {
+	hdName := fileset + ".art"
+	if _, err := os.Stat(hdName); err == nil { return nil }
+	for _, ext := range compressionExts {
+		if _, err := os.Stat(hdName + ext); err == nil {
+			return fmt.Errorf("The ARTIO header %s is compressed, but " +
+				"ARTIO filesets can't be read while compressed. " +
+				"Decompress them first.", hdName + ext)
+		}
+	}
+	return nil
+}
+
 func parseARTIOFilename(fname string) (fileset string, block int, err error) {
 	split := strings.LastIndex(fname, ".")
 	if split == -1 || split == len(fname)-1 {
@@ -100,6 +120,14 @@ func parseARTIOFilename(fname string) (fileset string, block int, err error) {
 
 func (buf *ARTIOBuffer) Read(
 	filename string,
+) (xs, vs[][3]float32, ms []float32, ids []int64, err error) {
+	return buf.ReadFields(filename, AllFields)
+}
+
+// ReadFields reads the requested fields from an ARTIO block. Only positions
+// and masses are supported, so velocities and IDs are never returned.
+func (buf *ARTIOBuffer) ReadFields(
+	filename string, fields Field,
 ) (xs, vs[][3]float32, ms []float32, ids []int64, err error) {
 	// Open the file.
 	if buf.open {
@@ -144,27 +172,42 @@ func (buf *ARTIOBuffer) Read(
 	for i := range sCounts {
 		if flags[i] {
 			totCount += sCounts[i]
-			buf.xsBufs[i] = expandVectors(buf.xsBufs[i][:0], int(sCounts[i]))
-			err = h.GetPositionsAt(i, sfcStart, sfcEnd, buf.xsBufs[i])
-			if err != nil {
-				return nil, nil, nil, nil, err
+			if fields.Has(Positions) {
+				buf.xsBufs[i] = expandVectors(
+					buf.xsBufs[i][:0], int(sCounts[i]),
+				)
+				err = h.GetPositionsAt(i, sfcStart, sfcEnd, buf.xsBufs[i])
+				if err != nil {
+					return nil, nil, nil, nil, err
+				}
 			}
+		}
+	}
 
-			buf.msBufs[i] = expandScalars(buf.msBufs[i][:0], int(sCounts[i]))
-			for j := range buf.msBufs[i] {
-				buf.msBufs[i][j] = buf.sMasses[i]
+	// Copy to output buffer.
+	if fields.Has(Masses) {
+		buf.msBuf = expandScalars(buf.msBuf[:0], int(totCount))
+		k := 0
+		for j := range sCounts {
+			if !flags[j] { continue }
+			for i := int64(0); i < sCounts[j]; i++ {
+				buf.msBuf[k] = buf.sMasses[j]
+				k++
 			}
 		}
+		ms = buf.msBuf
+	}
+
+	if !fields.Has(Positions) {
+		return nil, nil, ms, nil, nil
 	}
 
-	// Copy to output buffer.
 	buf.xsBuf = expandVectors(buf.xsBuf[:0], int(totCount))
-	buf.msBuf = expandScalars(buf.msBuf[:0], int(totCount))
 	k := 0
 	for j := range buf.xsBufs {
+		if !flags[j] { continue }
 		for i := range buf.xsBufs[j] {
 			buf.xsBuf[k] = buf.xsBufs[j][i]
-			buf.msBuf[k] = buf.msBufs[j][i]
 			k++
 		}
 	}
@@ -190,7 +233,7 @@ func (buf *ARTIOBuffer) Read(
 		buf.xsBuf[i][2] *= lengthUnit
 	}
 
-	return buf.xsBuf, nil, buf.msBuf, buf.idsBuf, nil
+	return buf.xsBuf, nil, ms, nil, nil
 }
 
 func nBodyFlags(h artio.Fileset, fname string) ([]bool, error) {
@@ -219,7 +262,7 @@ func (buf *ARTIOBuffer) IsOpen() bool {
 }
 
 func (buf *ARTIOBuffer) ReadHeader(fileNumStr string, out *Header) error {
-	xs, _, _, _, err := buf.Read(fileNumStr)
+	xs, _, _, _, err := buf.ReadFields(fileNumStr, Positions)
 	defer buf.Close()
 
 	h, err := artio.FilesetOpen(
@@ -302,4 +345,12 @@ func (buf *ARTIOBuffer) MinMass() float32 {
 
 func (buf *ARTIOBuffer) TotalParticles(fname string) (int, error) {
 	return -1, nil
-}
\ No newline at end of file
+}
+
+// ReadChunks returns an iterator over the particles in fname. ARTIO files
+// can't be read incrementally, so the whole file is read into memory first.
+func (buf *ARTIOBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
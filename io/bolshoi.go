@@ -2,7 +2,6 @@ package io
 
 import (
 	"encoding/binary"
-	"os"
 	"io"
 	"fmt"
 )
@@ -57,7 +56,7 @@ func NewBolshoiBuffer(
 		}
 	}
 	
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil { return nil, err }
 	defer f.Close()
 
@@ -74,7 +73,16 @@ func NewBolshoiBuffer(
 func (bol *BolshoiBuffer) Read(fname string) (
 	xs, vs [][3]float32, ms []float32, ids []int64, err error,
 ) {
-	f, err := os.Open(fname)
+	return bol.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a Bolshoi segment file. Bolshoi
+// particles are stored as interleaved records, so every field needs to be
+// read from disk, but unrequested fields aren't copied into output buffers.
+func (bol *BolshoiBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	f, err := Open(fname)
 	if err != nil { return nil, nil, nil, nil, err }
 	defer f.Close()
 	
@@ -105,7 +113,7 @@ func (bol *BolshoiBuffer) Read(fname string) (
 	if err != nil { return nil, nil, nil, nil, err }
 	
 	bol.pBuf = filterBolshoiParticles(bol.pBuf, &bh1, hd)
-	xs, vs, ms, ids = postprocessBolshoi(bol.pBuf, hd, &bh1, bol)
+	xs, vs, ms, ids = postprocessBolshoi(bol.pBuf, hd, &bh1, bol, fields)
 	
 	return xs, vs, ms, ids, nil
 }
@@ -149,25 +157,40 @@ func bolshoiInRange(p bolshoiParticle, hd *Header) bool {
 
 func postprocessBolshoi(
 	p []bolshoiParticle, hd *Header, bh1 *bolshoiHeader1, buf *BolshoiBuffer,
+	fields Field,
 ) (
 	x, v [][3]float32, m []float32, id []int64,
 ) {
-	buf.xsBuf = expandVectors(buf.xsBuf[:0], len(p))
-	buf.vsBuf = expandVectors(buf.vsBuf[:0], len(p))
-	buf.msBuf = expandScalars(buf.msBuf[:0], len(p))
-	buf.idsBuf = expandInts(buf.idsBuf[:0], len(p))
+	if fields.Has(Positions) {
+		buf.xsBuf = expandVectors(buf.xsBuf[:0], len(p))
+		x = buf.xsBuf
+	}
+	if fields.Has(Velocities) {
+		buf.vsBuf = expandVectors(buf.vsBuf[:0], len(p))
+		v = buf.vsBuf
+	}
+	if fields.Has(Masses) {
+		buf.msBuf = expandScalars(buf.msBuf[:0], len(p))
+		m = buf.msBuf
+	}
+	if fields.Has(IDs) {
+		buf.idsBuf = expandInts(buf.idsBuf[:0], len(p))
+		id = buf.idsBuf
+	}
 
 	scale := float32(1/(1 + hd.Cosmo.Z))
 	mp := buf.MinMass()
 	
 	for i, pp := range p {
-		buf.xsBuf[i] = pp.X
-		buf.vsBuf[i] = [3]float32{pp.V[0]*scale, pp.V[1]*scale, pp.V[2]*scale }
-		buf.idsBuf[i] = pp.ID
-		buf.msBuf[i] = mp
+		if x != nil { x[i] = pp.X }
+		if v != nil {
+			v[i] = [3]float32{pp.V[0]*scale, pp.V[1]*scale, pp.V[2]*scale }
+		}
+		if id != nil { id[i] = pp.ID }
+		if m != nil { m[i] = mp }
 	}
 	
-	return buf.xsBuf, buf.vsBuf, buf.msBuf, buf.idsBuf
+	return x, v, m, id
 }
 
 func (bol *BolshoiBuffer)  Close() { }
@@ -193,7 +216,7 @@ func fortranRead(rd io.Reader, order binary.ByteOrder, buf interface{}) {
 }
 
 func (bol *BolshoiBuffer) ReadHeader(fname string, out *Header) error {
-	f, err := os.Open(fname)
+	f, err := Open(fname)
 	if err != nil { return err }
 	defer f.Close()
 	
@@ -250,3 +273,81 @@ func (bol *BolshoiBuffer) MinMass() float32 {
 func (bol *BolshoiBuffer) TotalParticles(fname string) (int, error) {
 	return 2048*2048*2048, nil
 }
+
+func (bol *BolshoiBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	hd := &Header{}
+	if err := bol.ReadHeader(fname, hd); err != nil { return nil, err }
+
+	f, err := Open(fname)
+	if err != nil { return nil, err }
+
+	it := &bolshoiIterator{
+		bol: bol, f: f, hd: hd, fields: fields, chunkSize: chunkSize,
+	}
+
+	comment := [45]byte{}
+	bh2 := bolshoiHeader2{}
+	boundary := [6]float32{}
+
+	fortranRead(f, bol.order, &comment)
+	fortranRead(f, bol.order, &it.bh1)
+	fortranRead(f, bol.order, &bh2)
+	fortranRead(f, bol.order, &boundary)
+	fortranRead(f, bol.order, &it.nPart)
+
+	return it, nil
+}
+
+// bolshoiIterator reads a Bolshoi segment file a chunk at a time. Particles
+// are stored in a sequence of records, and chunks can start and end in the
+// middle of a record. Particles outside of the segment's boundaries are
+// removed after each chunk is read, so chunks are usually smaller than
+// chunkSize.
+type bolshoiIterator struct {
+	bol       *BolshoiBuffer
+	f         File
+	hd        *Header
+	bh1       bolshoiHeader1
+	fields    Field
+	chunkSize int
+
+	// nRead is the number of particles read so far and left is the number of
+	// particles remaining in the current record.
+	nPart, nRead, left int32
+}
+
+func (it *bolshoiIterator) Next() (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if it.nRead == it.nPart { return nil, nil, nil, nil, io.EOF }
+
+	bol, order := it.bol, it.bol.order
+	bol.pBuf = expandBolshoiParticles(bol.pBuf[:0], it.chunkSize)
+
+	n := 0
+	for n < it.chunkSize && it.nRead < it.nPart {
+		if it.left == 0 {
+			// The first record has no footer before it.
+			if it.nRead > 0 { _ = readInt32(it.f, order) }
+			fortranRead(it.f, order, &it.left)
+			_ = readInt32(it.f, order)
+		}
+
+		m := int(it.left)
+		if m > it.chunkSize - n { m = it.chunkSize - n }
+		err = readBolshoiParticleAsByte(it.f, order, bol.pBuf[n: n+m])
+		if err != nil { return nil, nil, nil, nil, err }
+
+		n += m
+		it.left -= int32(m)
+		it.nRead += int32(m)
+	}
+
+	p := filterBolshoiParticles(bol.pBuf[:n], &it.bh1, it.hd)
+	xs, vs, ms, ids = postprocessBolshoi(p, it.hd, &it.bh1, bol, it.fields)
+	return xs, vs, ms, ids, nil
+}
+
+func (it *bolshoiIterator) Close() error { return it.f.Close() }
@@ -2,7 +2,6 @@ package io
 
 import (
 	"encoding/binary"
-	"os"
 )
 
 // Unfortunately, the BolshoiP boundary region is too small for us to get away
@@ -51,7 +50,7 @@ func NewBolshoiPBuffer(
 		}
 	}
 	
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil { return nil, err }
 	defer f.Close()
 
@@ -68,7 +67,17 @@ func NewBolshoiPBuffer(
 func (bol *BolshoiPBuffer) Read(fname string) (
 	xs, vs [][3]float32, ms []float32, ids []int64, err error,
 ) {
-	f, err := os.Open(fname)
+	return bol.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a BolshoiP segment file.
+// Positions are always read from disk, since they're needed to remove
+// boundary particles, but velocity and ID records are skipped over if they
+// weren't requested.
+func (bol *BolshoiPBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	f, err := Open(fname)
 	if err != nil { return nil, nil, nil, nil, err }
 	defer f.Close()
 	
@@ -84,16 +93,22 @@ func (bol *BolshoiPBuffer) Read(fname string) (
 	fortranRead(f, bol.order, &boundary)
 	fortranRead(f, bol.order, &nPart)
 
+	readVs, readIDs := fields.Has(Velocities), fields.Has(IDs)
+
 	for i := 0; i < 3; i++ {
 		bol.xReadBuf[i] = expandScalars(bol.xReadBuf[i], int(nPart))
-		bol.vReadBuf[i] = expandScalars(bol.vReadBuf[i], int(nPart))
+		if readVs {
+			bol.vReadBuf[i] = expandScalars(bol.vReadBuf[i], int(nPart))
+		}
 	}
 	
 	bol.xsBuf = expandVectors(bol.xsBuf, int(nPart))
-	bol.vsBuf = expandVectors(bol.vsBuf, int(nPart))
-	bol.idsBuf = expandInts(bol.idsBuf, int(nPart))
-	bol.msBuf = expandScalars(bol.msBuf, int(nPart))
-	bol.wpBuf = expandScalars(bol.wpBuf, int(nPart))
+	if readVs { bol.vsBuf = expandVectors(bol.vsBuf, int(nPart)) }
+	if readIDs {
+		bol.idsBuf = expandInts(bol.idsBuf, int(nPart))
+		bol.wpBuf = expandScalars(bol.wpBuf, int(nPart))
+	}
+	if fields.Has(Masses) { bol.msBuf = expandScalars(bol.msBuf, int(nPart)) }
 
 	for nRead := int32(0); nRead < nPart; {
 		fortranRead(f, bol.order, &m)
@@ -104,16 +119,26 @@ func (bol *BolshoiPBuffer) Read(fname string) (
 		}
 		_ = readInt32(f, bol.order)
 		
-		_ = readInt32(f, bol.order)
-		for i := 0; i < 3; i++ {
-			readFloat32AsByte(f, bol.order, bol.vReadBuf[i][nRead: nRead+m])
+		if readVs {
+			_ = readInt32(f, bol.order)
+			for i := 0; i < 3; i++ {
+				readFloat32AsByte(
+					f, bol.order, bol.vReadBuf[i][nRead: nRead+m],
+				)
+			}
+			_ = readInt32(f, bol.order)
+		} else if err = skipFortranBlock(f, bol.order); err != nil {
+			return nil, nil, nil, nil, err
 		}
-		_ = readInt32(f, bol.order)
 
-		_ = readInt32(f, bol.order)
-		readFloat32AsByte(f, bol.order, bol.wpBuf[nRead: nRead+m])
-		readInt64AsByte(f, bol.order, bol.idsBuf[nRead: nRead+m])
-		_ = readInt32(f, bol.order)
+		if readIDs {
+			_ = readInt32(f, bol.order)
+			readFloat32AsByte(f, bol.order, bol.wpBuf[nRead: nRead+m])
+			readInt64AsByte(f, bol.order, bol.idsBuf[nRead: nRead+m])
+			_ = readInt32(f, bol.order)
+		} else if err = skipFortranBlock(f, bol.order); err != nil {
+			return nil, nil, nil, nil, err
+		}
 		
 		nRead += m
 	}
@@ -130,29 +155,38 @@ func (bol *BolshoiPBuffer) Read(fname string) (
 		bol.xsBuf[i] = [3]float32{
 			bol.xReadBuf[0][i], bol.xReadBuf[1][i], bol.xReadBuf[2][i],
 		}
-		bol.vsBuf[i] = [3]float32{
-			bol.vReadBuf[0][i], bol.vReadBuf[1][i], bol.vReadBuf[2][i],
-		}
-		
 		if !vecInRange(bol.xsBuf[i], hd) { continue }
-		
 		bol.xsBuf[j] = bol.xsBuf[i]
-		
-		bol.vsBuf[j][0] = scale*bol.vsBuf[i][0]
-		bol.vsBuf[j][1] = scale*bol.vsBuf[i][1]
-		bol.vsBuf[j][2] = scale*bol.vsBuf[i][2]
 
-		bol.idsBuf[j] = bol.idsBuf[i]
-		
-		bol.msBuf[j] = mp
+		if readVs {
+			bol.vsBuf[j] = [3]float32{
+				scale*bol.vReadBuf[0][i],
+				scale*bol.vReadBuf[1][i],
+				scale*bol.vReadBuf[2][i],
+			}
+		}
+		if readIDs { bol.idsBuf[j] = bol.idsBuf[i] }
+		if fields.Has(Masses) { bol.msBuf[j] = mp }
 		
 		j++
 	}
 	
-	bol.xsBuf, bol.vsBuf = bol.xsBuf[:j], bol.vsBuf[:j]
-	bol.msBuf, bol.idsBuf = bol.msBuf[:j], bol.idsBuf[:j]
+	bol.xsBuf = bol.xsBuf[:j]
+	if fields.Has(Positions) { xs = bol.xsBuf }
+	if readVs {
+		bol.vsBuf = bol.vsBuf[:j]
+		vs = bol.vsBuf
+	}
+	if fields.Has(Masses) {
+		bol.msBuf = bol.msBuf[:j]
+		ms = bol.msBuf
+	}
+	if readIDs {
+		bol.idsBuf = bol.idsBuf[:j]
+		ids = bol.idsBuf
+	}
 	
-	return bol.xsBuf, bol.vsBuf, bol.msBuf, bol.idsBuf, nil
+	return xs, vs, ms, ids, nil
 }
 
 
@@ -170,7 +204,7 @@ func (bol *BolshoiPBuffer)  Close() { }
 func (bol *BolshoiPBuffer) IsOpen() bool { return false }
 
 func (bol *BolshoiPBuffer) ReadHeader(fname string, out *Header) error {
-	f, err := os.Open(fname)
+	f, err := Open(fname)
 	if err != nil { return err }
 	defer f.Close()
 	
@@ -227,3 +261,11 @@ func (bol *BolshoiPBuffer) MinMass() float32 {
 func (bol *BolshoiPBuffer) TotalParticles(fname string) (int, error) {
 	return 2048*2048*2048, nil
 }
+
+// ReadChunks returns an iterator over the particles in fname. BolshoiP files
+// can't be read incrementally, so the whole file is read into memory first.
+func (bol *BolshoiPBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(bol, fname, fields, chunkSize)
+}
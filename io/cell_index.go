@@ -0,0 +1,242 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// cellIndexHeader is the header of a cell index file. It is followed by
+// Cells^3 + 1 int64 offsets and then by the position, velocity, mass, and
+// ID arrays of all the particles in the block, sorted by cell. Cells are
+// ordered so that x varies fastest. Fields is the mask of fields that the
+// block stored. The arrays of fields that it didn't store are zeroed.
+type cellIndexHeader struct {
+	Cells         int64
+	N             int64
+	Fields        int64
+	TotalWidth    float64
+	Origin, Width [3]float32
+}
+
+const cellIndexHeaderSize = 8 + 8 + 8 + 8 + 12 + 12
+
+// CellIndex is a copy of a single block's particles which have been sorted
+// into a coarse grid of cells so that only the particles near a point need to
+// be read. Cell index files are always little endian.
+type CellIndex struct {
+	f       *os.File
+	hd      cellIndexHeader
+	offsets []int64
+}
+
+// WriteCellIndex sorts the particles in a block into cells^3 cells and
+// writes them to the file fname. hd is the header of the block. vs is nil if
+// the block doesn't store velocities.
+func WriteCellIndex(
+	fname string, hd *Header, cells int,
+	xs, vs [][3]float32, ms []float32, ids []int64,
+) error {
+	fields := AllFields
+	if vs == nil { fields &^= Velocities }
+	cHd := cellIndexHeader{
+		Cells: int64(cells), N: int64(len(xs)), Fields: int64(fields),
+		TotalWidth: hd.TotalWidth, Origin: hd.Origin, Width: hd.Width,
+	}
+
+	counts := make([]int64, cells*cells*cells + 1)
+	cellIdxs := make([]int, len(xs))
+	for i := range xs {
+		cellIdxs[i] = cHd.cell(xs[i])
+		counts[cellIdxs[i] + 1]++
+	}
+	for i := 1; i < len(counts); i++ { counts[i] += counts[i - 1] }
+
+	sxs := make([][3]float32, len(xs))
+	svs := make([][3]float32, len(xs))
+	sms := make([]float32, len(xs))
+	sids := make([]int64, len(xs))
+	next := make([]int64, len(counts))
+	copy(next, counts)
+	for i := range xs {
+		j := next[cellIdxs[i]]
+		next[cellIdxs[i]]++
+		sxs[j], sms[j], sids[j] = xs[i], ms[i], ids[i]
+		if vs != nil { svs[j] = vs[i] }
+	}
+
+	f, err := os.Create(fname)
+	if err != nil { return err }
+	defer f.Close()
+
+	order := binary.LittleEndian
+	for _, data := range []interface{}{ cHd, counts, sxs, svs, sms, sids } {
+		if err := binary.Write(f, order, data); err != nil { return err }
+	}
+
+	return nil
+}
+
+// OpenCellIndex opens a cell index file written by WriteCellIndex.
+func OpenCellIndex(fname string) (*CellIndex, error) {
+	f, err := os.Open(fname)
+	if err != nil { return nil, err }
+
+	idx := &CellIndex{ f: f }
+	if err := binary.Read(f, binary.LittleEndian, &idx.hd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c := idx.hd.Cells
+	idx.offsets = make([]int64, c*c*c + 1)
+	if err := binary.Read(f, binary.LittleEndian, idx.offsets); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if idx.offsets[len(idx.offsets) - 1] != idx.hd.N {
+		f.Close()
+		return nil, fmt.Errorf("Cell index file %s is corrupted.", fname)
+	}
+
+	return idx, nil
+}
+
+// Close closes the underlying file.
+func (idx *CellIndex) Close() error { return idx.f.Close() }
+
+// ReadSpheres returns every particle in a cell which intersects the bounding
+// box of at least one of the spheres with centers cs and radii rs. Periodic
+// boundary conditions are respected. The returned particles are a superset of
+// the particles inside the spheres. Only the given fields are read and the
+// others are returned as nil. It's an error to ask for a field that the block
+// didn't store.
+func (idx *CellIndex) ReadSpheres(
+	cs [][3]float32, rs []float32, fields Field,
+) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
+	if missing := fields &^ Field(idx.hd.Fields); missing != 0 {
+		return nil, nil, nil, nil, fmt.Errorf("%s are needed, but the "+
+			"block indexed in %s doesn't have any.", missing, idx.f.Name())
+	}
+
+	c := int(idx.hd.Cells)
+	marked := make([]bool, c*c*c)
+	for i := range cs {
+		idx.hd.markSphere(cs[i], rs[i], marked)
+	}
+
+	// Read contiguous runs of marked cells.
+	for start := 0; start < len(marked); {
+		if !marked[start] { start++; continue }
+		end := start
+		for end < len(marked) && marked[end] { end++ }
+
+		lo, hi := idx.offsets[start], idx.offsets[end]
+		if hi > lo {
+			n := hi - lo
+			var rxs, rvs [][3]float32
+			var rms []float32
+			var rids []int64
+			if fields.Has(Positions) { rxs = make([][3]float32, n) }
+			if fields.Has(Velocities) { rvs = make([][3]float32, n) }
+			if fields.Has(Masses) { rms = make([]float32, n) }
+			if fields.Has(IDs) { rids = make([]int64, n) }
+			if err := idx.readRange(lo, rxs, rvs, rms, rids); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			xs, vs = append(xs, rxs...), append(vs, rvs...)
+			ms, ids = append(ms, rms...), append(ids, rids...)
+		}
+
+		start = end
+	}
+
+	return xs, vs, ms, ids, nil
+}
+
+// readRange reads the particles starting at index lo into the given slices.
+// nil slices are skipped.
+func (idx *CellIndex) readRange(
+	lo int64, xs, vs [][3]float32, ms []float32, ids []int64,
+) error {
+	n := idx.hd.N
+	base := int64(cellIndexHeaderSize) + 8*int64(len(idx.offsets))
+	starts := []int64{
+		base + 12*lo,
+		base + 12*n + 12*lo,
+		base + 24*n + 4*lo,
+		base + 28*n + 8*lo,
+	}
+	bufs := []interface{}{ xs, vs, ms, ids }
+	lens := []int{ len(xs), len(vs), len(ms), len(ids) }
+
+	for i := range bufs {
+		if lens[i] == 0 { continue }
+		if _, err := idx.f.Seek(starts[i], 0); err != nil { return err }
+		err := binary.Read(idx.f, binary.LittleEndian, bufs[i])
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// cellCoord returns the cell coordinate of x along dimension dim.
+func (hd *cellIndexHeader) cellCoord(x float32, dim int) int {
+	w := float64(hd.Width[dim])
+	if w == 0 { return 0 }
+
+	// Blocks which straddle the edge of the box can have origins outside of
+	// it, so particles may need to be wrapped.
+	dx := float64(x - hd.Origin[dim])
+	if dx < 0 && dx + hd.TotalWidth <= w {
+		dx += hd.TotalWidth
+	} else if dx > w && dx - hd.TotalWidth >= 0 {
+		dx -= hd.TotalWidth
+	}
+
+	i := int(dx / w * float64(hd.Cells))
+	if i < 0 { return 0 }
+	if i >= int(hd.Cells) { return int(hd.Cells) - 1 }
+	return i
+}
+
+func (hd *cellIndexHeader) cell(x [3]float32) int {
+	c := int(hd.Cells)
+	return hd.cellCoord(x[0], 0) + c*hd.cellCoord(x[1], 1) +
+		c*c*hd.cellCoord(x[2], 2)
+}
+
+// markSphere marks every cell which intersects the bounding box of a sphere.
+func (hd *cellIndexHeader) markSphere(c [3]float32, r float32, marked []bool) {
+	n := int(hd.Cells)
+	var axes [3][]bool
+	for dim := 0; dim < 3; dim++ {
+		axes[dim] = make([]bool, n)
+		w := float64(hd.Width[dim])
+		if w == 0 {
+			axes[dim][0] = true
+			continue
+		}
+
+		for _, shift := range []float64{ -hd.TotalWidth, 0, hd.TotalWidth } {
+			lo := float64(c[dim] - r - hd.Origin[dim]) + shift
+			hi := float64(c[dim] + r - hd.Origin[dim]) + shift
+			if hi < 0 || lo > w { continue }
+
+			iLo := int(math.Floor(math.Max(lo, 0) / w * float64(n)))
+			iHi := int(math.Floor(math.Min(hi, w) / w * float64(n)))
+			if iHi >= n { iHi = n - 1 }
+			for i := iLo; i <= iHi; i++ { axes[dim][i] = true }
+		}
+	}
+
+	for iz := 0; iz < n; iz++ {
+		if !axes[2][iz] { continue }
+		for iy := 0; iy < n; iy++ {
+			if !axes[1][iy] { continue }
+			for ix := 0; ix < n; ix++ {
+				if axes[0][ix] { marked[ix + n*iy + n*n*iz] = true }
+			}
+		}
+	}
+}
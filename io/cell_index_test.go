@@ -0,0 +1,76 @@
+package io
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCellIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_cell_index")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "index.dat")
+
+	hd := &Header{
+		TotalWidth: 100, Origin: [3]float32{0, 0, 0},
+		Width: [3]float32{100, 100, 100},
+	}
+	xs := [][3]float32{ {1, 1, 1}, {99, 1, 1}, {50, 50, 50}, {60, 60, 60} }
+	ms := []float32{ 1, 2, 3, 4 }
+	ids := []int64{ 10, 11, 12, 13 }
+
+	// The block doesn't store velocities.
+	err = WriteCellIndex(fname, hd, 4, xs, nil, ms, ids)
+	if err != nil { t.Fatal(err.Error()) }
+
+	idx, err := OpenCellIndex(fname)
+	if err != nil { t.Fatal(err.Error()) }
+	defer idx.Close()
+
+	cs, rs := [][3]float32{ {0, 1, 1} }, []float32{ 2 }
+	rxs, rvs, rms, rids, err := idx.ReadSpheres(cs, rs, Positions|IDs)
+	if err != nil { t.Fatal(err.Error()) }
+	if rvs != nil || rms != nil {
+		t.Errorf("Expected unrequested fields to be nil, got %v and %v.",
+			rvs, rms)
+	}
+
+	found := map[int64]bool{}
+	for i := range rids {
+		found[rids[i]] = true
+		if xs[rids[i] - 10] != rxs[i] {
+			t.Errorf("Particle %d has position %v, expected %v.",
+				rids[i], rxs[i], xs[rids[i] - 10])
+		}
+	}
+	if !found[10] || !found[11] || found[12] || found[13] {
+		t.Errorf("Expected particles 10 and 11 across the periodic " +
+			"boundary, got %v.", rids)
+	}
+
+	_, _, _, _, err = idx.ReadSpheres(cs, rs, Velocities|Masses)
+	if err == nil || !strings.HasPrefix(err.Error(), "Velocities are") {
+		t.Errorf("Expected an error about missing velocities, got %v.", err)
+	}
+}
+
+func TestFieldString(t *testing.T) {
+	tests := []struct {
+		f   Field
+		exp string
+	}{
+		{0, ""},
+		{Velocities, "Velocities"},
+		{Positions | Masses, "Positions, Masses"},
+		{AllFields, "Positions, Velocities, Masses, IDs"},
+	}
+
+	for i := range tests {
+		if res := tests[i].f.String(); res != tests[i].exp {
+			t.Errorf("%d) Expected '%s', got '%s'.", i, tests[i].exp, res)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package io
+
+import (
+	"io"
+)
+
+// headerChunkSize is the number of particles read at a time when a
+// VectorBuffer needs to look at every particle in a file to build its header.
+const headerChunkSize = 1 << 20
+
+// ChunkIterator iterates over the particles in a single file a chunk at a
+// time so that files which are too large to fit in memory can be analyzed.
+type ChunkIterator interface {
+	// Next returns the next chunk of particles. Fields which weren't
+	// requested are nil. Chunks never have more than the requested number of
+	// particles, but may have fewer (or even none). The returned slices may
+	// be overwritten by the next call to Next. io.EOF is returned once every
+	// particle has been read.
+	Next() (xs, vs [][3]float32, ms []float32, ids []int64, err error)
+	// Close closes the underlying file and the VectorBuffer which created
+	// the iterator.
+	Close() error
+}
+
+// openFieldFiles opens fname once for each of Positions, Velocities, IDs, and
+// Masses that's in fields and stores the Files in fs in that order. Iterators
+// over formats which store each field in its own block read every block with
+// its own File so that none of them ever seek backwards, which would make a
+// compressed file start being decompressed again from the beginning.
+func openFieldFiles(fname string, fields Field, fs []File) error {
+	for i, field := range []Field{ Positions, Velocities, IDs, Masses } {
+		if i >= len(fs) || !fields.Has(field) { continue }
+		f, err := Open(fname)
+		if err != nil {
+			closeFieldFiles(fs)
+			return err
+		}
+		fs[i] = f
+	}
+	return nil
+}
+
+// closeFieldFiles closes the Files opened by openFieldFiles.
+func closeFieldFiles(fs []File) error {
+	var err error
+	for i := range fs {
+		if fs[i] == nil { continue }
+		if closeErr := fs[i].Close(); err == nil { err = closeErr }
+		fs[i] = nil
+	}
+	return err
+}
+
+// sliceIterator is a ChunkIterator over a file which has already been read
+// into memory. It's used by formats which can't be read incrementally.
+type sliceIterator struct {
+	buf       VectorBuffer
+	xs, vs    [][3]float32
+	ms        []float32
+	ids       []int64
+	chunkSize int
+	n, i      int
+}
+
+// newSliceIterator reads an entire file with buf and returns an iterator over
+// it. Peak memory usage is set by the size of the file, not chunkSize. It's
+// the ReadChunks of formats which can't be read a piece at a time.
+func newSliceIterator(
+	buf VectorBuffer, fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	xs, vs, ms, ids, err := buf.ReadFields(fname, fields)
+	if err != nil {
+		if buf.IsOpen() { buf.Close() }
+		return nil, err
+	}
+
+	n := 0
+	for _, l := range []int{ len(xs), len(vs), len(ms), len(ids) } {
+		if l > n { n = l }
+	}
+
+	return &sliceIterator{
+		buf: buf, xs: xs, vs: vs, ms: ms, ids: ids,
+		chunkSize: chunkSize, n: n,
+	}, nil
+}
+
+func (it *sliceIterator) Next() (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if it.i >= it.n { return nil, nil, nil, nil, io.EOF }
+
+	lo, hi := it.i, it.i + it.chunkSize
+	if hi > it.n { hi = it.n }
+	it.i = hi
+
+	if it.xs != nil { xs = it.xs[lo: hi] }
+	if it.vs != nil { vs = it.vs[lo: hi] }
+	if it.ms != nil { ms = it.ms[lo: hi] }
+	if it.ids != nil { ids = it.ids[lo: hi] }
+	return xs, vs, ms, ids, nil
+}
+
+func (it *sliceIterator) Close() error {
+	if it.buf.IsOpen() { it.buf.Close() }
+	return nil
+}
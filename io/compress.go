@@ -0,0 +1,295 @@
+package io
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Compression is a type of file compression.
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Zstd
+)
+
+// compressionExts are the extensions of compressed files.
+var compressionExts = []string{ ".gz", ".bz2", ".zst" }
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b, 0x08}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// File is a file opened by Open. Compressed files support the same
+// operations as an *os.File, but are decompressed as they're read.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// FileCompression returns the type of compression used by a file. It's
+// found from the file's extension, if it has one of .gz, .bz2, or .zst, and
+// from its magic bytes otherwise.
+func FileCompression(fname string) (Compression, error) {
+	switch _, ext := TrimCompressionExt(fname); ext {
+	case ".gz": return Gzip, nil
+	case ".bz2": return Bzip2, nil
+	case ".zst": return Zstd, nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil { return Uncompressed, err }
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Uncompressed, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic): return Gzip, nil
+	case bytes.HasPrefix(magic, zstdMagic): return Zstd, nil
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) == 4 &&
+		magic[3] >= '1' && magic[3] <= '9':
+		return Bzip2, nil
+	}
+	return Uncompressed, nil
+}
+
+// TrimCompressionExt removes the compression extension from a file name, if
+// it has one, and returns the name and the extension.
+func TrimCompressionExt(fname string) (base, ext string) {
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(fname, ext) {
+			return strings.TrimSuffix(fname, ext), ext
+		}
+	}
+	return fname, ""
+}
+
+// OpenStream opens a file for sequential reading. Compressed files are
+// decompressed as they're read. gzip and bzip2 files are handled internally,
+// but zstd files need the zstd command to be installed.
+func OpenStream(fname string) (io.ReadCloser, error) {
+	c, err := FileCompression(fname)
+	if err != nil { return nil, err }
+
+	if c == Zstd { return openZstd(fname) }
+
+	f, err := os.Open(fname)
+	if err != nil { return nil, err }
+
+	switch c {
+	case Gzip:
+		rd, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Could not read %s: %s",
+				fname, err.Error())
+		}
+		return &streamFile{ rd, []io.Closer{ rd, f } }, nil
+	case Bzip2:
+		return &streamFile{ bzip2.NewReader(f), []io.Closer{ f } }, nil
+	}
+
+	return f, nil
+}
+
+// Open opens a file for reading. Compressed files are decompressed as they're
+// read, so memory usage doesn't depend on the size of the file. Seeking
+// forwards skips over decompressed data, but seeking backwards starts
+// decompressing again from the beginning of the file, so readers should move
+// through compressed files in order wherever they can.
+func Open(fname string) (File, error) {
+	c, err := FileCompression(fname)
+	if err != nil { return nil, err }
+	if c == Uncompressed { return os.Open(fname) }
+
+	info, err := os.Stat(fname)
+	if err != nil { return nil, err }
+	rd, err := OpenStream(fname)
+	if err != nil { return nil, err }
+
+	return &seekStream{ fname: fname, info: info, rd: rd, size: -1 }, nil
+}
+
+// ReadFile reads an entire file, decompressing it if needed.
+func ReadFile(fname string) ([]byte, error) {
+	rd, err := OpenStream(fname)
+	if err != nil { return nil, err }
+	data, err := ioutil.ReadAll(rd)
+	if closeErr := rd.Close(); err == nil { err = closeErr }
+	return data, err
+}
+
+func openZstd(fname string) (io.ReadCloser, error) {
+	if _, err := os.Stat(fname); err != nil { return nil, err }
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, fmt.Errorf("%s is zstd-compressed, but the zstd "+
+			"command isn't installed.", fname)
+	}
+
+	cmd := exec.Command("zstd", "-dcq", "--", fname)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil { return nil, err }
+	if err = cmd.Start(); err != nil { return nil, err }
+
+	return &zstdFile{
+		ReadCloser: out, cmd: cmd, stderr: stderr, fname: fname,
+	}, nil
+}
+
+// streamFile is a decompressing reader which closes the underlying file.
+type streamFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *streamFile) Close() error {
+	var err error
+	for _, c := range f.closers {
+		if cErr := c.Close(); err == nil { err = cErr }
+	}
+	return err
+}
+
+// zstdFile reads the output of the zstd command.
+type zstdFile struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	fname  string
+	eof    bool
+}
+
+func (f *zstdFile) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if err == io.EOF { f.eof = true }
+	return n, err
+}
+
+func (f *zstdFile) Close() error {
+	// If Close is called early, zstd is stopped instead of being left to
+	// decompress the rest of the file.
+	if !f.eof {
+		f.cmd.Process.Kill()
+		f.cmd.Wait()
+		return nil
+	}
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd could not decompress %s: %s",
+			f.fname, strings.TrimSpace(f.stderr.String()))
+	}
+	return nil
+}
+
+// seekStream is a compressed File. It decompresses the file with a stream
+// opened by OpenStream and opens a new stream whenever it needs to move
+// backwards.
+type seekStream struct {
+	fname string
+	info  os.FileInfo
+	rd    io.ReadCloser
+	// pos is the position of rd in the decompressed data and off is where the
+	// next call to Read will start. They differ after Seek and ReadAt.
+	pos, off int64
+	// size is the size of the decompressed data. It's -1 until it's needed.
+	size int64
+}
+
+func (f *seekStream) Read(p []byte) (int, error) {
+	if err := f.moveTo(f.off); err != nil { return 0, err }
+	n, err := f.rd.Read(p)
+	f.pos += int64(n)
+	f.off = f.pos
+	return n, err
+}
+
+func (f *seekStream) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.moveTo(off); err != nil { return 0, err }
+	n, err := io.ReadFull(f.rd, p)
+	f.pos += int64(n)
+	if err == io.ErrUnexpectedEOF { err = io.EOF }
+	return n, err
+}
+
+func (f *seekStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		size, err := f.decompressedSize()
+		if err != nil { return f.off, err }
+		offset += size
+	}
+	if offset < 0 {
+		return f.off, fmt.Errorf("Cannot seek to offset %d of %s.",
+			offset, f.fname)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// moveTo moves rd to the given offset in the decompressed data.
+func (f *seekStream) moveTo(off int64) error {
+	if off < f.pos {
+		if err := f.rd.Close(); err != nil { return err }
+		rd, err := OpenStream(f.fname)
+		if err != nil { return err }
+		f.rd, f.pos = rd, 0
+	}
+
+	n, err := io.CopyN(ioutil.Discard, f.rd, off - f.pos)
+	f.pos += n
+	return err
+}
+
+// decompressedSize returns the size of the decompressed data. The first call
+// decompresses the whole file without storing it.
+func (f *seekStream) decompressedSize() (int64, error) {
+	if f.size >= 0 { return f.size, nil }
+
+	rd, err := OpenStream(f.fname)
+	if err != nil { return 0, err }
+	size, err := io.Copy(ioutil.Discard, rd)
+	if closeErr := rd.Close(); err == nil { err = closeErr }
+	if err != nil { return 0, err }
+
+	f.size = size
+	return size, nil
+}
+
+func (f *seekStream) Stat() (os.FileInfo, error) {
+	size, err := f.decompressedSize()
+	if err != nil { return nil, err }
+	return &decompressedInfo{ f.info, size }, nil
+}
+
+func (f *seekStream) Close() error { return f.rd.Close() }
+
+// decompressedInfo is the os.FileInfo of a compressed file, except that
+// Size() is the size of the decompressed data.
+type decompressedInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (info *decompressedInfo) Size() int64 { return info.size }
@@ -0,0 +1,173 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// compressFile writes a compressed copy of fname next to it and returns its
+// name. gzip files are written directly, but bzip2 and zstd files need their
+// commands, so the test is skipped if they aren't installed.
+func compressFile(t *testing.T, fname string, c Compression) string {
+	switch c {
+	case Gzip:
+		data, err := ioutil.ReadFile(fname)
+		if err != nil { t.Fatal(err.Error()) }
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		w.Write(data)
+		if err = w.Close(); err != nil { t.Fatal(err.Error()) }
+		writeTestFile(t, fname + ".gz", buf.Bytes())
+		return fname + ".gz"
+	case Bzip2, Zstd:
+		name, ext := "bzip2", ".bz2"
+		if c == Zstd { name, ext = "zstd", ".zst" }
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("The %s command isn't installed.", name)
+		}
+		out, err := exec.Command(name, "-kqf", fname).CombinedOutput()
+		if err != nil { t.Fatalf("%s failed: %s", name, out) }
+		return fname + ext
+	}
+	return fname
+}
+
+func TestSeekStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_compress")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 100000)
+	for i := range data { data[i] = byte(i*i % 251) }
+	fname := filepath.Join(dir, "data")
+	writeTestFile(t, fname, data)
+
+	for _, c := range []Compression{ Gzip, Bzip2, Zstd } {
+		t.Run(compressionExts[c - 1], func(t *testing.T) {
+			path := compressFile(t, fname, c)
+
+			testSeekStream(t, path, data)
+
+			// Without an extension, the compression comes from the magic
+			// bytes.
+			noExt := filepath.Join(dir, "magic")
+			if err := os.Rename(path, noExt); err != nil {
+				t.Fatal(err.Error())
+			}
+			if got, err := FileCompression(noExt); err != nil || got != c {
+				t.Errorf("Expected compression %d, got %d (%v).", c, got, err)
+			}
+			testSeekStream(t, noExt, data)
+		})
+	}
+}
+
+// testSeekStream checks that fname decompresses to data through each of the
+// File methods.
+func testSeekStream(t *testing.T, fname string, data []byte) {
+	f, err := Open(fname)
+	if err != nil { t.Fatal(err.Error()) }
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil { t.Fatal(err.Error()) }
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Expected a size of %d, got %d.", len(data), info.Size())
+	}
+
+	p := make([]byte, 100)
+	check := func(desc string, off int64) {
+		if _, err := io.ReadFull(f, p); err != nil {
+			t.Fatalf("%s: %s", desc, err.Error())
+		}
+		if !bytes.Equal(p, data[off: off + 100]) {
+			t.Errorf("%s: read the wrong bytes.", desc)
+		}
+	}
+
+	check("Read from the start", 0)
+	if _, err = f.Seek(50000, io.SeekStart); err != nil { t.Fatal(err) }
+	check("Forward seek", 50000)
+	if _, err = f.Seek(1000, io.SeekStart); err != nil { t.Fatal(err) }
+	check("Backward seek", 1000)
+	if _, err = f.Seek(-50, io.SeekCurrent); err != nil { t.Fatal(err) }
+	check("Relative seek", 1050)
+	if _, err = f.Seek(-100, io.SeekEnd); err != nil { t.Fatal(err) }
+	check("Seek from the end", int64(len(data)) - 100)
+
+	if _, err = f.ReadAt(p, 20); err != nil { t.Fatal(err.Error()) }
+	if !bytes.Equal(p, data[20:120]) { t.Errorf("ReadAt read the wrong bytes.") }
+
+	if _, err = f.ReadAt(p, int64(len(data)) - 10); err != io.EOF {
+		t.Errorf("Expected io.EOF when reading past the end, got %v.", err)
+	}
+	if _, err = f.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Expected an error when seeking before the start.")
+	}
+}
+
+func TestCompressedTipsy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_compress")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "snap")
+	dark := tipsyWords[tipsyDark]
+	writeTipsy(t, fname, binary.BigEndian, 1, [3][][]float32{
+		nil,
+		{
+			tipsyParticle(1e-6, [3]float32{0.1, 0.2, 0.3},
+				[3]float32{1, 2, 3}, dark),
+			tipsyParticle(2e-6, [3]float32{-0.1, -0.2, -0.3},
+				[3]float32{-1, -2, -3}, dark),
+		},
+		nil,
+	})
+
+	context := Context{
+		TipsyTotalWidth: 100, TipsyOmegaM: 0.3, TipsyOmegaL: 0.7,
+		TipsyH100: 0.7, TipsyParticleTypes: []string{"dark"},
+	}
+	buf, err := NewTipsyBuffer([]string{ fname }, context)
+	if err != nil { t.Fatal(err.Error()) }
+	hd := &Header{}
+	if err = buf.ReadHeader(fname, hd); err != nil { t.Fatal(err.Error()) }
+	xs, vs, ms, ids, err := buf.Read(fname)
+	buf.Close()
+	if err != nil { t.Fatal(err.Error()) }
+	xs, vs = append([][3]float32{}, xs...), append([][3]float32{}, vs...)
+	ms, ids = append([]float32{}, ms...), append([]int64{}, ids...)
+
+	for _, c := range []Compression{ Gzip, Bzip2, Zstd } {
+		t.Run(compressionExts[c - 1], func(t *testing.T) {
+			path := compressFile(t, fname, c)
+
+			cHd := &Header{}
+			if err := buf.ReadHeader(path, cHd); err != nil {
+				t.Fatal(err.Error())
+			}
+			if *cHd != *hd {
+				t.Errorf("Expected header %v, got %v.", hd, cHd)
+			}
+
+			cXs, cVs, cMs, cIDs, err := buf.ReadFields(path, AllFields)
+			buf.Close()
+			if err != nil { t.Fatal(err.Error()) }
+			for i := range xs {
+				if cXs[i] != xs[i] || cVs[i] != vs[i] || cMs[i] != ms[i] ||
+					cIDs[i] != ids[i] {
+					t.Errorf("Particle %d was read as %v %v %g %d, " +
+						"expected %v %v %g %d.", i, cXs[i], cVs[i], cMs[i],
+						cIDs[i], xs[i], vs[i], ms[i], ids[i])
+				}
+			}
+		})
+	}
+}
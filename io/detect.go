@@ -0,0 +1,129 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+// Format describes a particle file whose format was found by DetectFormat.
+type Format struct {
+	// SnapshotType is the value of the SnapshotType config variable which
+	// reads the file.
+	SnapshotType string
+	// Order is the byte order of the file, or nil if the format records its
+	// own byte order.
+	Order binary.ByteOrder
+
+	// The following fields are only set for Gadget-2 and LGadget-2 files.
+
+	// NPart and Mass are the particle counts and the mass table of the
+	// file's header.
+	NPart [6]uint32
+	Mass  [6]float64
+	// BoxSize is the width of the simulation box in the file's units.
+	BoxSize float64
+	// NumFiles is the number of files in each snapshot.
+	NumFiles int
+	// LGadgetNpartNum is the value of the LGadgetNpartNum config variable
+	// which reads the file. It's only set for LGadget-2 files.
+	LGadgetNpartNum int
+}
+
+// DetectFormat finds the format of a particle file from its contents. Only
+// formats which can be recognized from a single file are detected:
+// Gadget-2, LGadget-2, TIPSY, gotetra, and NumPy.
+func DetectFormat(path string) (*Format, error) {
+	f, err := Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	prefix := make([]byte, 8)
+	if _, err = io.ReadFull(f, prefix); err != nil {
+		return nil, fmt.Errorf("%s is too small to be a particle file.", path)
+	}
+
+	switch {
+	case bytes.Equal(prefix[:6], npyMagic):
+		return &Format{ SnapshotType: "NumPy" }, nil
+	case bytes.Equal(prefix[:4], []byte("PK\x03\x04")) &&
+		strings.HasSuffix(path, ".npz"):
+		return &Format{ SnapshotType: "NumPy" }, nil
+	}
+
+	if format, ok := detectGadget(f, prefix); ok { return format, nil }
+	if format, ok := detectGotetra(prefix); ok { return format, nil }
+	if _, order, _, err := readTipsyHeader(f, path); err == nil {
+		return &Format{ SnapshotType: "TIPSY", Order: order }, nil
+	}
+
+	return nil, fmt.Errorf("I couldn't recognize the format of %s. Only "+
+		"Gadget-2, LGadget-2, TIPSY, gotetra, and NumPy files can be "+
+		"detected automatically.", path)
+}
+
+// detectGadget checks whether a file is a Gadget-2 or LGadget-2 file. Both
+// start with a Fortran record marker giving the size of their 256-byte
+// headers, which also gives their byte order. The two are told apart by the
+// size of the position block. Gadget-2 files may store double-precision
+// positions.
+func detectGadget(f File, prefix []byte) (*Format, bool) {
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(prefix) == 256:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(prefix) == 256:
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+
+	hd := &gadget2Header{}
+	if _, err := f.Seek(4, 0); err != nil { return nil, false }
+	if err := binary.Read(f, order, hd); err != nil { return nil, false }
+
+	var markers [2]uint32
+	if err := binary.Read(f, order, &markers); err != nil { return nil, false }
+	if markers[0] != 256 { return nil, false }
+	posSize := int64(markers[1])
+
+	format := &Format{
+		SnapshotType: "Gadget-2", Order: order,
+		NPart: hd.NPart, Mass: hd.Mass, BoxSize: hd.BoxSize,
+		NumFiles: int(hd.NumFiles),
+	}
+
+	// The Gadget-2 reader handles every file whose particle counts are
+	// consistent with the positions, so it's preferred.
+	n := int64(particleCount(hd))
+	if posSize == 12*n || posSize == 24*n { return format, true }
+
+	format.SnapshotType = "LGadget-2"
+	lgh := &lGadget2Header{ NPart: hd.NPart }
+	for _, npartNum := range []int{2, 1} {
+		context := Context{ LGadgetNPartNum: int64(npartNum) }
+		if npartNum == 2 && hd.NPart[0] > 100 * 1000 { continue }
+		if posSize == 12*lgadgetParticleNum(hd.NPart, lgh, context) {
+			format.LGadgetNpartNum = npartNum
+			return format, true
+		}
+	}
+
+	return nil, false
+}
+
+// detectGotetra checks whether a file is a gotetra file, which starts with
+// an endianness flag and the size of its header.
+func detectGotetra(prefix []byte) (*Format, bool) {
+	size := uint32(unsafe.Sizeof(rawGotetraHeader{}))
+	flag := int32(binary.LittleEndian.Uint32(prefix))
+	switch {
+	case flag == 0 && binary.LittleEndian.Uint32(prefix[4:]) == size,
+		flag == -1 && binary.BigEndian.Uint32(prefix[4:]) == size:
+		return &Format{ SnapshotType: "gotetra" }, true
+	}
+	return nil, false
+}
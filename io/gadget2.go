@@ -3,8 +3,8 @@ package io
 import (
 	"fmt"
 	"encoding/binary"
+	"io"
 	"math"
-	"os"
 )
 
 // gadgetHeader is the formatting for meta-information used by Gadget 2.
@@ -27,7 +27,7 @@ type gadget2Header struct {
 type Gadget2Header gadget2Header
 
 func (gh *gadget2Header) postprocess(
-	xs [][3]float32, context *Context, out *Header,
+	bb *boundingBoxer, context *Context, out *Header,
 ) {	
 	// Assumes the catalog has already been checked for corruption.
 	
@@ -44,13 +44,13 @@ func (gh *gadget2Header) postprocess(
 	out.Cosmo.OmegaL = gh.OmegaLambda
 	out.Cosmo.H100 = gh.HubbleParam
 
-	out.Origin, out.Width = boundingBox(xs, out.TotalWidth)
+	out.Origin, out.Width = bb.box()
 }
 
 func readGadget2Header(
 	path string, order binary.ByteOrder, out *gadget2Header,
 ) error {
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
 		return err
 	}
@@ -64,6 +64,7 @@ func readGadget2Header(
 func (buf *Gadget2Buffer) readGadget2Particles(
 	path string,
 	order binary.ByteOrder,
+	fields Field,
 	xsBuf, vsBuf [][3]float32,
 	multiMsBuf, msBuf []float32,
 	idsBuf []int64,
@@ -71,7 +72,7 @@ func (buf *Gadget2Buffer) readGadget2Particles(
 	
 	// Open the buffer and read the raw gadget header.
 
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
@@ -89,61 +90,141 @@ func (buf *Gadget2Buffer) readGadget2Particles(
 	dmN := dmCount(gh, &buf.context)
 	multiN := multiMassParticleCount(gh, &buf.context)
 	
-	// Resize buffers
+	// Resize buffers. Fields which weren't asked for are skipped over
+	// instead of read and are returned as nil.
 
-	xsBuf = expandVectors(xsBuf[:0], totalN)
-	vsBuf = expandVectors(vsBuf[:0], totalN)
-	idsBuf = expandInts(idsBuf[:0], totalN)
-	msBuf = expandScalars(msBuf[:0], totalN)
-	multiMsBuf = expandScalars(multiMsBuf[:0], multiN)
+	if fields.Has(Positions) {
+		xsBuf = expandVectors(xsBuf[:0], totalN)
+	} else {
+		xsBuf = nil
+	}
+	if fields.Has(Velocities) {
+		vsBuf = expandVectors(vsBuf[:0], totalN)
+	} else {
+		vsBuf = nil
+	}
+	if fields.Has(IDs) {
+		idsBuf = expandInts(idsBuf[:0], totalN)
+	} else {
+		idsBuf = nil
+	}
+	if fields.Has(Masses) {
+		msBuf = expandScalars(msBuf[:0], totalN)
+		multiMsBuf = expandScalars(multiMsBuf[:0], multiN)
+	} else {
+		msBuf, multiMsBuf = nil, nil
+	}
 
-	// Read all particles into buffers.
-	_ = readInt32(f, order)
-	readVecAsByte(f, order, xsBuf)
+	// Read all particles into buffers. Double-precision files are found
+	// from the size of the position block.
+	size := readInt32(f, order)
+	floatSize := gadget2FloatSize(size, totalN)
+	if xsBuf != nil {
+		readVecAsSize(f, order, xsBuf, floatSize)
+	} else if err = skipBytes(f, int64(size)); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
 	_ = readInt32(f, order)
 
-	_ = readInt32(f, order)
-	readVecAsByte(f, order, vsBuf)
-	_ = readInt32(f, order)
+	if vsBuf != nil {
+		_ = readInt32(f, order)
+		readVecAsSize(f, order, vsBuf, floatSize)
+		_ = readInt32(f, order)
+	} else if err = skipFortranBlock(f, order); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
 
 	/* IDs may sometimes be 32-bit. */
-	size := readInt32(f, order)
-	switch int(size) / len(idsBuf) {
-	case 8:
-		readInt64AsByte(f, order, idsBuf)
-	case 4:
-		i32Buf := make([]int32, len(idsBuf))
-		readInt32AsByte(f, order, i32Buf)
-		for i := range i32Buf {
-			idsBuf[i] = int64(i32Buf[i])
+	if idsBuf != nil {
+		size := readInt32(f, order)
+		switch int(size) / len(idsBuf) {
+		case 8:
+			readInt64AsByte(f, order, idsBuf)
+		case 4:
+			i32Buf := make([]int32, len(idsBuf))
+			readInt32AsByte(f, order, i32Buf)
+			for i := range i32Buf {
+				idsBuf[i] = int64(i32Buf[i])
+			}
 		}
+		_ = readInt32(f, order)
+	} else if err = skipFortranBlock(f, order); err != nil {
+		return nil, nil, nil, nil, nil, err
 	}
-	_ = readInt32(f, order)
 
-	_ = readInt32(f, order)
-	readFloat32AsByte(f, order, multiMsBuf)
-	_ = readInt32(f, order)
+	if msBuf != nil {
+		_ = readInt32(f, order)
+		readFloat32AsSize(f, order, multiMsBuf, floatSize)
+		_ = readInt32(f, order)
 	
-	// Expand uniform mass types
-	unpackMass(gh, &buf.context, multiMsBuf, msBuf)
+		// Expand uniform mass types
+		unpackMass(gh, &buf.context, multiMsBuf, msBuf)
+	}
 	
 	// Remove non-DM particle types
-	packVec(gh, &buf.context, xsBuf)
-	packVec(gh, &buf.context, vsBuf)
-	packInt64(gh, &buf.context, idsBuf)
-	packFloat32(gh, &buf.context, msBuf)
-
-	// Resize
-	xsBuf = xsBuf[0: dmN]
-	vsBuf = vsBuf[0: dmN]
-	idsBuf = idsBuf[0: dmN]
-	msBuf = msBuf[0: dmN]
+	if xsBuf != nil {
+		packVec(gh, &buf.context, xsBuf)
+		xsBuf = xsBuf[0: dmN]
+	}
+	if vsBuf != nil {
+		packVec(gh, &buf.context, vsBuf)
+		vsBuf = vsBuf[0: dmN]
+	}
+	if idsBuf != nil {
+		packInt64(gh, &buf.context, idsBuf)
+		idsBuf = idsBuf[0: dmN]
+	}
+	if msBuf != nil {
+		packFloat32(gh, &buf.context, msBuf)
+		msBuf = msBuf[0: dmN]
+	}
 	
 	err = fix(gh, &buf.context, path, xsBuf, vsBuf, msBuf)
 	
 	return xsBuf, vsBuf, multiMsBuf, msBuf, idsBuf, err
 }
 
+// gadget2FloatSize returns the size in bytes of the floats in a Gadget-2 file
+// with n particles whose position block is size bytes long. Gadget-2 writes
+// float64s when it's compiled with DOUBLEPRECISION.
+func gadget2FloatSize(size int32, n int) int64 {
+	if n > 0 && int64(size) == 24*int64(n) { return 8 }
+	return 4
+}
+
+// readGadget2Positions64 reads the positions of the DM particles in a Gadget-2
+// file as float64s.
+func readGadget2Positions64(
+	path string, order binary.ByteOrder, context *Context, xsBuf [][3]float64,
+) ([][3]float64, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gh := &gadget2Header{}
+
+	_ = readInt32(f, order)
+	binary.Read(f, order, gh)
+	_ = readInt32(f, order)
+
+	totalN := particleCount(gh)
+	dmN := dmCount(gh, context)
+
+	size := readInt32(f, order)
+	xsBuf = expandVectors64(xsBuf[:0], totalN)
+	err = readVec64AsSize(f, order, xsBuf, gadget2FloatSize(size, totalN))
+	if err != nil {
+		return nil, err
+	}
+
+	packVec64(gh, context, xsBuf)
+	xsBuf = xsBuf[0: dmN]
+
+	return xsBuf, fix64(gh, context, path, xsBuf)
+}
+
 func isMultiMass(context *Context, i int) bool {
 	for _, j := range context.GadgetDMSingleMassIndices {
 		if int(j) == i { return false }
@@ -238,6 +319,29 @@ func packVec(gh *gadget2Header, context *Context, buf [][3]float32) {
 	}
 }
 
+func packVec64(gh *gadget2Header, context *Context, buf [][3]float64) {
+	dmOffsets := make([]int, 7)
+	offsets := make([]int, 7)
+	for i := 0; i < 6; i++ {
+		if isDM(context, i) {
+			dmOffsets[i + 1] = dmOffsets[i] + int(gh.NPart[i])
+		} else {
+			dmOffsets[i + 1] = dmOffsets[i]
+		}
+
+		offsets[i + 1] = offsets[i] + int(gh.NPart[i])
+	}
+	
+	for i := 0; i < 6; i++ {
+		if isDM(context, i) {
+			copy(
+				buf[dmOffsets[i]: dmOffsets[i+1]],
+				buf[offsets[i]: offsets[i + 1]],
+			)
+		}
+	}
+}
+
 func packInt64(gh *gadget2Header, context *Context, buf []int64) {
 	dmOffsets := make([]int, 7)
 	offsets := make([]int, 7)
@@ -284,18 +388,22 @@ func packFloat32(gh *gadget2Header, context *Context, buf []float32) {
 	}
 }
 
-// Fix periodicity and units.
+// Fix periodicity and units. Any of xs, vs, and ms may be nil.
 func fix(
 	gh *gadget2Header, context *Context, path string,
 	xs, vs [][3]float32, ms[]float32,
 ) error {
 	rootA := float32(math.Sqrt(float64(gh.Time)))
 
+	for i := range vs {
+		for j := 0; j < 3; j++ {
+			vs[i][j] = vs[i][j] * rootA
+		}
+	}
+
 	tw := float32(gh.BoxSize)
 	for i := range xs {
 		for j := 0; j < 3; j++ {
-			vs[i][j] = vs[i][j] * rootA
-			
 			if xs[i][j] < 0 {
 				xs[i][j] += tw
 			} else if xs[i][j] >= tw {
@@ -314,25 +422,61 @@ func fix(
 
 			xs[i][j] *= float32(context.GadgetPositionUnits)
 		}
+	}
+
+	for i := range ms {
 		ms[i] *= float32(context.GadgetMassUnits)
 	}
 
 	return nil
 }
 
+// fix64 is identical to fix, except that it works on double-precision
+// positions.
+func fix64(
+	gh *gadget2Header, context *Context, path string, xs [][3]float64,
+) error {
+	tw := gh.BoxSize
+	for i := range xs {
+		for j := 0; j < 3; j++ {
+			if xs[i][j] < 0 {
+				xs[i][j] += tw
+			} else if xs[i][j] >= tw {
+				xs[i][j] -= tw
+			}
+
+			if math.IsNaN(xs[i][j]) || math.IsInf(xs[i][j], 0) ||
+				xs[i][j] < -tw || xs[i][j] > 2*tw {
+
+				return fmt.Errorf(
+					"Corruption detected in the file %s. I can't analyze it.",
+					path,
+				)
+			}
+
+			xs[i][j] *= context.GadgetPositionUnits
+		}
+	}
+
+	return nil
+}
+
 type Gadget2Buffer struct {
 	open        bool
 	order       binary.ByteOrder
 	hd          gadget2Header
 	mass        float32
+	// maxHighResMass is only meaningful if GadgetHighResTypeIndices is set.
+	maxHighResMass float32
 	xs, vs      [][3]float32
+	xs64        [][3]float64
 	ms, multiMs []float32
 	ids         []int64
 	context     Context
 }
 
 func NewGadget2Buffer(
-	path, orderFlag string, context Context,
+	paths []string, orderFlag string, context Context,
 ) (VectorBuffer, error) {
 	
 	var order binary.ByteOrder = binary.LittleEndian
@@ -347,7 +491,18 @@ func NewGadget2Buffer(
 	}
 
 	buf := &Gadget2Buffer{order: order, context: context}
-	err := readGadget2Header(path, order, &buf.hd)
+	err := readGadget2Header(paths[0], order, &buf.hd)
+	if err != nil {
+		return nil, err
+	}
+
+	// In zoom-in simulations, the smallest particles are the ones in the
+	// high-resolution region.
+	types := context.GadgetDMTypeIndices
+	if len(context.GadgetHighResTypeIndices) > 0 {
+		types = context.GadgetHighResTypeIndices
+	}
+	buf.mass, buf.maxHighResMass, err = buf.massRange(paths, types)
 	if err != nil {
 		return nil, err
 	}
@@ -355,8 +510,95 @@ func NewGadget2Buffer(
 	return buf, nil
 }
 
+// massRange returns the smallest and largest masses of the particles with the
+// given types in the files in paths. Types with a single mass are found from
+// the header, and the masses of other types are read from every file which
+// contains them. It is an error for there to be no particles of those types.
+func (buf *Gadget2Buffer) massRange(
+	paths []string, types []int64,
+) (lo, hi float32, err error) {
+	gh, ctx := &buf.hd, &buf.context
+	lo, hi = float32(math.Inf(+1)), float32(math.Inf(-1))
+	var multiTypes []int64
+	for _, i := range types {
+		if gh.NumPartTotal[i] == 0 && gh.NumPartTotalHW[i] == 0 { continue }
+		if isMultiMass(ctx, int(i)) {
+			multiTypes = append(multiTypes, i)
+		} else {
+			m := float32(gh.Mass[i] * ctx.GadgetMassUnits)
+			if m < lo { lo = m }
+			if m > hi { hi = m }
+		}
+	}
+
+	fileHd := &gadget2Header{}
+	for _, path := range paths {
+		if len(multiTypes) == 0 { break }
+
+		err := readGadget2Header(path, buf.order, fileHd)
+		if err != nil { return 0, 0, err }
+
+		// Files outside the high-resolution region of a zoom-in simulation
+		// might not have any of these particles.
+		n := uint32(0)
+		for _, i := range multiTypes { n += fileHd.NPart[i] }
+		if n == 0 { continue }
+
+		ms, err := buf.typeMasses(path, fileHd, multiTypes)
+		if err != nil { return 0, 0, err }
+		for _, m := range ms {
+			if m < lo { lo = m }
+			if m > hi { hi = m }
+		}
+	}
+
+	if lo > hi {
+		return 0, 0, fmt.Errorf("The particle types %v don't contain any " +
+			"particles in the snapshot containing %s. Check " +
+			"GadgetDMTypeIndices and GadgetHighResTypeIndices.",
+			types, paths[0])
+	}
+	return lo, hi, nil
+}
+
+// typeMasses returns the masses of the particles in the file at path with the
+// given types. gh is the header of that file. All the types must be DM types.
+func (buf *Gadget2Buffer) typeMasses(
+	path string, gh *gadget2Header, types []int64,
+) ([]float32, error) {
+	_, _, dmMs, _, err := buf.ReadFields(path, Masses)
+	buf.Close()
+	if err != nil { return nil, err }
+
+	// Only DM particles are returned, and they're still sorted by type.
+	ms, start := []float32{}, 0
+	for i := range gh.NPart {
+		if !isDM(&buf.context, i) { continue }
+		n := int(gh.NPart[i])
+		for _, j := range types {
+			if int(j) == i { ms = append(ms, dmMs[start: start + n]...) }
+		}
+		start += n
+	}
+	return ms, nil
+}
+
+// HighResMasses returns the smallest and largest masses of the particles with
+// types in GadgetHighResTypeIndices. ok is false if GadgetHighResTypeIndices
+// wasn't set.
+func (buf *Gadget2Buffer) HighResMasses() (lo, hi float32, ok bool) {
+	if len(buf.context.GadgetHighResTypeIndices) == 0 { return 0, 0, false }
+	return buf.mass, buf.maxHighResMass, true
+}
+
 func (buf *Gadget2Buffer) Read(fname string) (
 	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+func (buf *Gadget2Buffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
 ) {
 	if buf.open {
 		panic("Buffer already open.")
@@ -364,12 +606,53 @@ func (buf *Gadget2Buffer) Read(fname string) (
 	buf.open = true
 
 	// I am not proud of this
-	buf.xs, buf.vs, buf.multiMs, buf.ms, buf.ids, err =
-	buf.readGadget2Particles(
-		fname, buf.order, buf.xs, buf.vs, buf.multiMs, buf.ms, buf.ids,
+	var multiMs []float32
+	xs, vs, multiMs, ms, ids, err = buf.readGadget2Particles(
+		fname, buf.order, fields,
+		buf.xs, buf.vs, buf.multiMs, buf.ms, buf.ids,
 	)
 
-	return buf.xs, buf.vs, buf.ms, buf.ids, err
+	// Hold onto the old buffers when fields are skipped so they can be
+	// reused later.
+	if xs != nil { buf.xs = xs }
+	if vs != nil { buf.vs = vs }
+	if ms != nil { buf.ms, buf.multiMs = ms, multiMs }
+	if ids != nil { buf.ids = ids }
+
+	return xs, vs, ms, ids, err
+}
+
+// ReadFields64 reads the requested fields from a Gadget-2 file with
+// double-precision positions. Files written with single precision can still
+// be read, but their positions are no more accurate than ReadFields's.
+func (buf *Gadget2Buffer) ReadFields64(fname string, fields Field) (
+	xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	_, vs, ms, ids, err = buf.ReadFields(fname, fields &^ Positions)
+	if err != nil || !fields.Has(Positions) {
+		return nil, vs, ms, ids, err
+	}
+
+	xs, err = readGadget2Positions64(fname, buf.order, &buf.context, buf.xs64)
+	if xs != nil { buf.xs64 = xs }
+
+	return xs, vs, ms, ids, err
+}
+
+// Float64Positions returns true if fname was written with DOUBLEPRECISION.
+// This is found from the size of the position block.
+func (buf *Gadget2Buffer) Float64Positions(fname string) (bool, error) {
+	f, err := Open(fname)
+	if err != nil { return false, err }
+	defer f.Close()
+
+	gh := &gadget2Header{}
+	_ = readInt32(f, buf.order)
+	if err = binary.Read(f, buf.order, gh); err != nil { return false, err }
+	_ = readInt32(f, buf.order)
+
+	size := readInt32(f, buf.order)
+	return gadget2FloatSize(size, particleCount(gh)) == 8, nil
 }
 
 func (buf *Gadget2Buffer) Close() {
@@ -388,13 +671,26 @@ func (buf *Gadget2Buffer) ReadHeader(fname string, out *Header) error {
 	if err != nil {
 		return err
 	}
-	defer buf.Close()
-	xs, _, _, _, err := buf.Read(fname)
+
+	// The bounding box is built up a chunk at a time so that the whole file
+	// never needs to be in memory.
+	it, err := buf.ReadChunks(fname, Positions, headerChunkSize)
 	if err != nil {
 		return err
 	}
+	defer it.Close()
+
+	bb := &boundingBoxer{
+		totalWidth: buf.hd.BoxSize * buf.context.GadgetPositionUnits,
+	}
+	for {
+		xs, _, _, _, err := it.Next()
+		if err == io.EOF { break }
+		if err != nil { return err }
+		bb.add(xs)
+	}
 
-	buf.hd.postprocess(xs, &buf.context, out)
+	buf.hd.postprocess(bb, &buf.context, out)
 	
 	return nil
 }
@@ -413,3 +709,158 @@ func (buf *Gadget2Buffer) TotalParticles(fname string) (int, error) {
 
 	return n, nil
 }
+
+func (buf *Gadget2Buffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+
+	f, err := Open(fname)
+	if err != nil { return nil, err }
+
+	gh := &gadget2Header{}
+	_ = readInt32(f, buf.order)
+	if err = binary.Read(f, buf.order, gh); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	totalN := int64(particleCount(gh))
+	xsStart := int64(binary.Size(gh)) + 4*3
+
+	// Floats may sometimes be 64-bit.
+	if _, err = f.Seek(xsStart - 4, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	floatSize := gadget2FloatSize(readInt32(f, buf.order), int(totalN))
+
+	vsStart := xsStart + 3*floatSize*totalN + 4*2
+	idsStart := vsStart + 3*floatSize*totalN + 4*2
+	
+	// IDs may sometimes be 32-bit.
+	idSize := int64(8)
+	if totalN > 0 {
+		if _, err = f.Seek(idsStart - 4, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		idSize = int64(readInt32(f, buf.order)) / totalN
+	}
+	msStart := idsStart + idSize*totalN + 4*2
+	f.Close()
+
+	it := &gadget2Iterator{
+		buf: buf, gh: gh, path: fname, fields: fields,
+		chunkSize: int64(chunkSize), idSize: idSize, floatSize: floatSize,
+		starts: [4]int64{ xsStart, vsStart, idsStart, msStart },
+	}
+	if err = openFieldFiles(fname, fields, it.fs[:]); err != nil {
+		return nil, err
+	}
+	buf.open = true
+
+	// Find where each particle type starts in the full arrays and in the
+	// mass block.
+	offset, multiOffset := int64(0), int64(0)
+	for i := 0; i < 6; i++ {
+		it.offsets[i], it.multiOffsets[i] = offset, multiOffset
+		offset += int64(gh.NPart[i])
+		if isMultiMass(&buf.context, i) { multiOffset += int64(gh.NPart[i]) }
+	}
+
+	return it, nil
+}
+
+// gadget2Iterator reads a Gadget-2 file a chunk at a time. Chunks never
+// contain more than one particle type, which makes it easy to skip over
+// non-DM particles and to find masses.
+type gadget2Iterator struct {
+	buf    *Gadget2Buffer
+	fs     [4]File
+	gh     *gadget2Header
+	path   string
+	fields Field
+
+	chunkSize, idSize      int64
+	floatSize              int64
+	starts                 [4]int64
+	offsets, multiOffsets  [6]int64
+
+	// The current particle type and the index of the next particle within
+	// that type.
+	typ  int
+	next int64
+}
+
+func (it *gadget2Iterator) Next() (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	ctx := &it.buf.context
+	for it.typ < 6 &&
+		(!isDM(ctx, it.typ) || it.next >= int64(it.gh.NPart[it.typ])) {
+		it.typ++
+		it.next = 0
+	}
+	if it.typ == 6 { return nil, nil, nil, nil, io.EOF }
+
+	n := int64(it.gh.NPart[it.typ]) - it.next
+	if n > it.chunkSize { n = it.chunkSize }
+	idx := it.offsets[it.typ] + it.next
+
+	buf, order := it.buf, it.buf.order
+
+	if it.fields.Has(Positions) {
+		buf.xs = expandVectors(buf.xs[:0], int(n))
+		xs = buf.xs
+		_, err = it.fs[0].Seek(it.starts[0] + 3*it.floatSize*idx, 0)
+		if err != nil { return nil, nil, nil, nil, err }
+		readVecAsSize(it.fs[0], order, xs, it.floatSize)
+	}
+	if it.fields.Has(Velocities) {
+		buf.vs = expandVectors(buf.vs[:0], int(n))
+		vs = buf.vs
+		_, err = it.fs[1].Seek(it.starts[1] + 3*it.floatSize*idx, 0)
+		if err != nil { return nil, nil, nil, nil, err }
+		readVecAsSize(it.fs[1], order, vs, it.floatSize)
+	}
+	if it.fields.Has(IDs) {
+		buf.ids = expandInts(buf.ids[:0], int(n))
+		ids = buf.ids
+		if _, err = it.fs[2].Seek(it.starts[2] + it.idSize*idx, 0); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		switch it.idSize {
+		case 8:
+			readInt64AsByte(it.fs[2], order, ids)
+		case 4:
+			i32Buf := make([]int32, n)
+			readInt32AsByte(it.fs[2], order, i32Buf)
+			for i := range i32Buf { ids[i] = int64(i32Buf[i]) }
+		}
+	}
+	if it.fields.Has(Masses) {
+		buf.ms = expandScalars(buf.ms[:0], int(n))
+		ms = buf.ms
+		if isMultiMass(ctx, it.typ) {
+			midx := it.multiOffsets[it.typ] + it.next
+			_, err = it.fs[3].Seek(it.starts[3] + it.floatSize*midx, 0)
+			if err != nil { return nil, nil, nil, nil, err }
+			readFloat32AsSize(it.fs[3], order, ms, it.floatSize)
+		} else {
+			for i := range ms { ms[i] = float32(it.gh.Mass[it.typ]) }
+		}
+	}
+
+	it.next += n
+
+	err = fix(it.gh, ctx, it.path, xs, vs, ms)
+	return xs, vs, ms, ids, err
+}
+
+func (it *gadget2Iterator) Close() error {
+	it.buf.Close()
+	return closeFieldFiles(it.fs[:])
+}
@@ -0,0 +1,93 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeGadget2 writes a single-precision Gadget-2 file with the given number
+// of particles of each type. ms are the masses of the particles in types
+// without a mass in the header.
+func writeGadget2(
+	t *testing.T, fname string, hd *gadget2Header, ms []float32,
+) {
+	n := 0
+	for i := range hd.NPart { n += int(hd.NPart[i]) }
+
+	buf := &bytes.Buffer{}
+	block := func(size int, data interface{}) {
+		binary.Write(buf, binary.LittleEndian, uint32(size))
+		binary.Write(buf, binary.LittleEndian, data)
+		binary.Write(buf, binary.LittleEndian, uint32(size))
+	}
+
+	ids := make([]uint32, n)
+	for i := range ids { ids[i] = uint32(i) }
+
+	block(256, hd)
+	block(12*n, make([]float32, 3*n))
+	block(12*n, make([]float32, 3*n))
+	block(4*n, ids)
+	block(4*len(ms), ms)
+
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestGadget2MassRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_gadget2")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	// Type 1 particles are in the high-resolution region and type 2
+	// particles are outside it. The first file has no high-resolution
+	// particles.
+	total := [6]uint32{0, 3, 2, 0, 0, 0}
+	files := []struct {
+		npart [6]uint32
+		ms    []float32
+	}{
+		{[6]uint32{0, 0, 1, 0, 0, 0}, []float32{8}},
+		{[6]uint32{0, 2, 0, 0, 0, 0}, []float32{1, 1.5}},
+		{[6]uint32{0, 1, 1, 0, 0, 0}, []float32{0.5, 8}},
+	}
+
+	paths := make([]string, len(files))
+	for i := range files {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("snap.%d", i))
+		hd := &gadget2Header{
+			NPart: files[i].npart, NumPartTotal: total,
+			NumFiles: int32(len(files)), BoxSize: 100,
+		}
+		writeGadget2(t, paths[i], hd, files[i].ms)
+	}
+
+	context := Context{
+		GadgetDMTypeIndices: []int64{1, 2},
+		GadgetHighResTypeIndices: []int64{1},
+		GadgetMassUnits: 1, GadgetPositionUnits: 1,
+	}
+	buf, err := NewGadget2Buffer(paths, "LittleEndian", context)
+	if err != nil { t.Fatal(err.Error()) }
+
+	lo, hi, ok := buf.(ZoomBuffer).HighResMasses()
+	if !ok || lo != 0.5 || hi != 1.5 {
+		t.Errorf("Expected high-resolution masses 0.5 and 1.5, got %g, " +
+			"%g, and %v.", lo, hi, ok)
+	}
+	if buf.MinMass() != 0.5 {
+		t.Errorf("Expected a minimum mass of 0.5, got %g.", buf.MinMass())
+	}
+
+	context.GadgetHighResTypeIndices = []int64{3}
+	if _, err = NewGadget2Buffer(paths, "LittleEndian", context); err == nil {
+		t.Errorf("Expected an error when no particles have the " +
+			"high-resolution types.")
+	}
+}
@@ -3,7 +3,6 @@ package io
 import (
 	"encoding/binary"
 	"fmt"
-	"os"
 	
 	"github.com/phil-mansfield/shellfish/cosmo"
 
@@ -59,15 +58,25 @@ func (buf *GotetraBuffer) IsOpen() bool { return buf.open }
 
 func (buf *GotetraBuffer) Read(fname string) (
 	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a gotetra file. gotetra files
+// only contain positions, so velocities and IDs are never returned.
+func (buf *GotetraBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
 ) {
 	if buf.open {
 		panic("Buffer already open.")
 	}
 	buf.open = true
 
-	err = readSheetPositionsAt(fname, buf.sheet)
-	if err != nil {
-		return nil, nil, nil, nil, err
+	if fields.Has(Positions) {
+		err = readSheetPositionsAt(fname, buf.sheet)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
 	}
 
 	si := 0
@@ -84,7 +93,11 @@ func (buf *GotetraBuffer) Read(fname string) (
 		}
 	}
 
-	return buf.xs, nil, buf.ms, buf.ids, nil
+	if fields.Has(Positions) { xs = buf.xs }
+	if fields.Has(Masses) { ms = buf.ms }
+	if fields.Has(IDs) { ids = buf.ids }
+
+	return xs, nil, ms, ids, nil
 }
 
 func (buf *GotetraBuffer) Close() {
@@ -153,7 +166,7 @@ func endianness(flag int32) binary.ByteOrder {
 }
 
 func readRawGotetraHeader(file string, out *rawGotetraHeader) error {
-	f, err := os.OpenFile(file, os.O_RDONLY, os.ModePerm)
+	f, err := Open(file)
 	if err != nil {
 		return err
 	}
@@ -176,8 +189,8 @@ func readRawGotetraHeader(file string, out *rawGotetraHeader) error {
 
 func loadSheetHeader(
 	file string, hdBuf *gotetraHeader,
-) (*os.File, binary.ByteOrder, error) {
-	f, err := os.OpenFile(file, os.O_RDONLY, os.ModePerm)
+) (File, binary.ByteOrder, error) {
+	f, err := Open(file)
 	if err != nil {
 		return nil, binary.LittleEndian, err
 	}
@@ -252,4 +265,12 @@ func readSheetPositionsAt(file string, xsBuf [][3]float32) error {
 
 func (buf *GotetraBuffer) TotalParticles(fname string) (int, error) {
 	return -1, nil
-}
\ No newline at end of file
+}
+
+// ReadChunks returns an iterator over the particles in fname. gotetra files
+// can't be read incrementally, so the whole file is read into memory first.
+func (buf *GotetraBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
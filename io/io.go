@@ -9,12 +9,17 @@ package comment). It's not too painful to do that in most cases. I'll lay out
 exactly what this is going to look like. For the sake of the discussion I'm
 going pretend that you're adding the a file-type called "my_file" to Shellfish.
 
+Before you do any of this, check whether your files can be read with
+SnapshotType = raw (see raw.go). If your format is just a header followed by
+fixed-width arrays or structs, you only need to describe its layout in the
+config file.
+
 0. Read up on the usual instructions for editing Shellfish code. You can find
 these in the main documentation directory.
 
 1. Make a file in this directory called my_file.go.
 
-2. Make a struct in that file named "MyFileBuffer". Write five methods for that
+2. Make a struct in that file named "MyFileBuffer". Write eight methods for that
 struct that have the same names and type signatures as those found in the
 VectorBuffer interface (the first declaration in this file).
 
@@ -27,11 +32,19 @@ buffers. Sometimes you might also want the name of the directory containing
 the files.
 
 4. Implement each of those methods so that they do what the VectorBuffer
-comments indicate that they should do. Only two are non-trivial: Read() and
-(to a lesser extent) ReadHeader(). Look at the example in lgadget2.go and copy
-code as needed. If your file format is just  couple arrays of particles with
-some type of header (which it probably is), you can copy almost all of it and
-won't have to do much.
+comments indicate that they should do. Only two are non-trivial: ReadFields()
+and (to a lesser extent) ReadHeader(). Read() can just call ReadFields() with
+AllFields. If your format stores each field in its own block, ReadFields()
+should seek past the blocks which weren't requested. Look at the example in
+lgadget2.go and copy code as needed. If your format can't be read a piece at
+a time, ReadChunks() can just return newSliceIterator(). Otherwise, add your
+format to chunkedSnapshotTypes in cmd/cmd.go so that ChunkSize can be used
+with it. If it stores double-precision positions, also write a ReadFields64()
+method so that your buffer is a Float64Buffer (see gadget2.go), and
+ReadFields() can just call readFields32(). If your headers don't say what the
+particle masses are, use minMass() in your constructor. If your file
+format is just couple arrays of particles with some type of header (which it probably is),
+you can copy almost all of it and won't have to do much.
 
 5. Update getVectorBuffer() in cmd/util.go. It'll just be adding a case to a
 switch statement.
@@ -49,7 +62,9 @@ package io
 import (
 	"encoding/binary"
 	"io"
+	"math"
 	"reflect"
+	"strings"
 	
 	"unsafe"
 )
@@ -58,6 +73,16 @@ import (
 type VectorBuffer interface {
 	// Positions in Mpc/h and masses in Msun/h.
 	Read(fname string) (xs, vs [][3]float32, ms []float32, ids []int64, err error)
+	// ReadFields is identical to Read, except that only the fields in the
+	// given mask are read. Fields which aren't in the mask are returned as
+	// nil. Read(fname) is the same as ReadFields(fname, AllFields).
+	ReadFields(fname string, fields Field) (
+		xs, vs [][3]float32, ms []float32, ids []int64, err error,
+	)
+	// ReadChunks returns an iterator over the given fields of the particles
+	// in fname which reads at most chunkSize particles at a time. The buffer
+	// is open until the iterator is closed.
+	ReadChunks(fname string, fields Field, chunkSize int) (ChunkIterator, error)
 	Close()
 	IsOpen() bool
 	ReadHeader(fname string, out *Header) error
@@ -66,6 +91,117 @@ type VectorBuffer interface {
 	TotalParticles(fname string) (int, error)
 }
 
+// ZoomBuffer is a VectorBuffer for zoom-in simulations which knows which
+// particles make up the high-resolution region.
+type ZoomBuffer interface {
+	VectorBuffer
+	// HighResMasses returns the smallest and largest masses of the particles
+	// in the high-resolution region. ok is false if the buffer wasn't told
+	// which particles are in the region.
+	HighResMasses() (lo, hi float32, ok bool)
+}
+
+// Float64Buffer is a VectorBuffer which can return double-precision
+// positions. ReadFields64 is identical to ReadFields, except that positions
+// are returned as float64s. Files which store double-precision positions keep
+// their full precision, which matters in large boxes: float32 positions in a
+// 1 Gpc/h box are only good to about 0.1 kpc/h.
+type Float64Buffer interface {
+	VectorBuffer
+	ReadFields64(fname string, fields Field) (
+		xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+	)
+	// Float64Positions returns true if fname stores double-precision
+	// positions. ReadFields64 is no more accurate than ReadFields for files
+	// which don't.
+	Float64Positions(fname string) (bool, error)
+}
+
+// readFields32 implements ReadFields for a Float64Buffer by rounding the
+// positions returned by ReadFields64 to float32. xsBuf is the buffer's
+// float32 position slice, which is reused between calls.
+func readFields32(
+	buf Float64Buffer, fname string, fields Field, xsBuf *[][3]float32,
+) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
+	xs64, vs, ms, ids, err := buf.ReadFields64(fname, fields)
+	if err != nil || xs64 == nil { return nil, vs, ms, ids, err }
+
+	*xsBuf = roundVectors(*xsBuf, xs64)
+	return *xsBuf, vs, ms, ids, nil
+}
+
+// roundVectors writes vecs64 to vecs as float32s, resizing vecs as needed,
+// and returns the result.
+func roundVectors(vecs [][3]float32, vecs64 [][3]float64) [][3]float32 {
+	vecs = expandVectors(vecs[:0], len(vecs64))
+	for i := range vecs64 {
+		for k := 0; k < 3; k++ { vecs[i][k] = float32(vecs64[i][k]) }
+	}
+	return vecs
+}
+
+// OptionalFieldBuffer is a VectorBuffer for a format where some fields don't
+// need to be stored. ReadFields returns an error if it's asked for a field
+// that a file doesn't store, so StoredFields reports which ones it does.
+type OptionalFieldBuffer interface {
+	VectorBuffer
+	StoredFields(fname string) (Field, error)
+}
+
+// StoredFields returns the fields stored in fname. This is AllFields unless
+// buf is an OptionalFieldBuffer.
+func StoredFields(buf VectorBuffer, fname string) (Field, error) {
+	if obuf, ok := buf.(OptionalFieldBuffer); ok {
+		return obuf.StoredFields(fname)
+	}
+	return AllFields, nil
+}
+
+// minMass returns the smallest mass of the particles in the files in paths,
+// for formats which don't store particle masses in their headers. Every file
+// is read, since the smallest particles in a run with several particle
+// masses needn't be in the first one.
+func minMass(buf VectorBuffer, paths []string) (float32, error) {
+	mass := float32(math.Inf(+1))
+	for _, path := range paths {
+		_, _, ms, _, err := buf.ReadFields(path, Masses)
+		if buf.IsOpen() { buf.Close() }
+		if err != nil { return 0, err }
+
+		for _, m := range ms {
+			if m < mass { mass = m }
+		}
+	}
+	return mass, nil
+}
+
+// Field is a bit mask of particle fields.
+type Field int
+
+const (
+	Positions Field = 1 << iota
+	Velocities
+	Masses
+	IDs
+
+	AllFields = Positions | Velocities | Masses | IDs
+)
+
+// Has returns true if every field in g is in the mask f.
+func (f Field) Has(g Field) bool { return f&g == g }
+
+// String returns a comma-separated list of the fields in f.
+func (f Field) String() string {
+	names := []string{}
+	for _, g := range []struct{ Field; name string }{
+		{Positions, "Positions"}, {Velocities, "Velocities"},
+		{Masses, "Masses"}, {IDs, "IDs"},
+	} {
+		if f.Has(g.Field) { names = append(names, g.name) }
+	}
+	return strings.Join(names, ", ")
+}
+
 // CosmologyHeader contains information describing the cosmological
 // context in which the simulation was run.
 type CosmologyHeader struct {
@@ -87,6 +223,7 @@ type Context struct {
 
 	GadgetDMTypeIndices []int64
 	GadgetDMSingleMassIndices []int64
+	GadgetHighResTypeIndices []int64
 	GadgetMassUnits float64
 	GadgetPositionUnits float64
 
@@ -95,6 +232,42 @@ type Context struct {
 	NilOmegaL float64
 	NilH100 float64
 	NilScaleFactors []float64
+
+	TipsyTotalWidth float64
+	TipsyOmegaM float64
+	TipsyOmegaL float64
+	TipsyH100 float64
+	TipsyParticleTypes []string
+
+	// RawHeaderSize is the number of bytes before the first particle field.
+	// RawCountOffset and RawScaleFactorOffset are measured from the start of
+	// the file and are negative if the header doesn't contain that value.
+	RawHeaderSize int64
+	RawPositions RawField
+	RawVelocities RawField
+	RawMasses RawField
+	RawIDs RawField
+	RawMass float64
+	RawCountOffset int64
+	RawCountType string
+	RawScaleFactorOffset int64
+	RawScaleFactorType string
+	RawScaleFactor float64
+	RawTotalWidth float64
+	RawOmegaM float64
+	RawOmegaL float64
+	RawH100 float64
+
+	NumPyPositionArray string
+	NumPyVelocityArray string
+	NumPyMassArray string
+	NumPyIDArray string
+	NumPyMass float64
+	NumPyTotalWidth float64
+	NumPyOmegaM float64
+	NumPyOmegaL float64
+	NumPyH100 float64
+	NumPyScaleFactor float64
 }
 
 func reorder(buf []byte, size, words int) {
@@ -116,7 +289,7 @@ func readBolshoiParticleAsByte(
 	hd.Cap *= int(unsafe.Sizeof(bolshoiParticle{}))
 
 	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
-	_, err := rd.Read(byteBuf)
+	_, err := io.ReadFull(rd, byteBuf)
 	if err != nil {
 		return err
 	}
@@ -143,7 +316,7 @@ func readVecAsByte(rd io.Reader, end binary.ByteOrder, buf [][3]float32) error {
 	hd.Cap *= 12
 
 	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
-	_, err := rd.Read(byteBuf)
+	_, err := io.ReadFull(rd, byteBuf)
 	if err != nil {
 		return err
 	}
@@ -171,7 +344,7 @@ func readInt64AsByte(rd io.Reader, end binary.ByteOrder, buf []int64) error {
 	hd.Cap *= 8
 
 	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
-	_, err := rd.Read(byteBuf)
+	_, err := io.ReadFull(rd, byteBuf)
 	if err != nil {
 		return err
 	}
@@ -199,7 +372,7 @@ func readInt32AsByte(rd io.Reader, end binary.ByteOrder, buf []int32) error {
 	hd.Cap *= 4
 
 	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
-	_, err := rd.Read(byteBuf)
+	_, err := io.ReadFull(rd, byteBuf)
 	if err != nil {
 		return err
 	}
@@ -226,7 +399,7 @@ func readFloat32AsByte(rd io.Reader, end binary.ByteOrder, buf []float32) error
 	hd.Cap *= 4
 
 	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
-	_, err := rd.Read(byteBuf)
+	_, err := io.ReadFull(rd, byteBuf)
 	if err != nil {
 		return err
 	}
@@ -246,6 +419,111 @@ func readFloat32AsByte(rd io.Reader, end binary.ByteOrder, buf []float32) error
 	return nil
 }
 
+func readVec64AsByte(
+	rd io.Reader, end binary.ByteOrder, buf [][3]float64,
+) error {
+	bufLen := len(buf)
+
+	hd := *(*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	hd.Len *= 24
+	hd.Cap *= 24
+
+	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
+	_, err := io.ReadFull(rd, byteBuf)
+	if err != nil {
+		return err
+	}
+
+	if !IsSysOrder(end) {
+		reorder(byteBuf, 8, bufLen*3)
+	}
+
+	hd.Len /= 24
+	hd.Cap /= 24
+
+	return nil
+}
+
+func readFloat64AsByte(rd io.Reader, end binary.ByteOrder, buf []float64) error {
+	bufLen := len(buf)
+	hd := *(*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	hd.Len *= 8
+	hd.Cap *= 8
+
+	byteBuf := *(*[]byte)(unsafe.Pointer(&hd))
+	_, err := io.ReadFull(rd, byteBuf)
+	if err != nil {
+		return err
+	}
+
+	if !IsSysOrder(end) {
+		reorder(byteBuf, 8, bufLen)
+	}
+
+	hd.Len /= 8
+	hd.Cap /= 8
+
+	return nil
+}
+
+// readVecAsSize reads vectors whose components are floats with the given
+// size in bytes into buf. Double-precision vectors are converted to float32.
+func readVecAsSize(
+	rd io.Reader, end binary.ByteOrder, buf [][3]float32, size int64,
+) error {
+	if size == 4 { return readVecAsByte(rd, end, buf) }
+
+	buf64 := make([][3]float64, len(buf))
+	if err := readVec64AsByte(rd, end, buf64); err != nil { return err }
+	for i := range buf {
+		for j := 0; j < 3; j++ { buf[i][j] = float32(buf64[i][j]) }
+	}
+	return nil
+}
+
+// readFloat32AsSize reads floats with the given size in bytes into buf.
+// Double-precision floats are converted to float32.
+func readFloat32AsSize(
+	rd io.Reader, end binary.ByteOrder, buf []float32, size int64,
+) error {
+	if size == 4 { return readFloat32AsByte(rd, end, buf) }
+
+	buf64 := make([]float64, len(buf))
+	if err := readFloat64AsByte(rd, end, buf64); err != nil { return err }
+	for i := range buf { buf[i] = float32(buf64[i]) }
+	return nil
+}
+
+// readVec64AsSize is identical to readVecAsSize, except that single-precision
+// vectors are converted to float64.
+func readVec64AsSize(
+	rd io.Reader, end binary.ByteOrder, buf [][3]float64, size int64,
+) error {
+	if size == 8 { return readVec64AsByte(rd, end, buf) }
+
+	buf32 := make([][3]float32, len(buf))
+	if err := readVecAsByte(rd, end, buf32); err != nil { return err }
+	for i := range buf {
+		for j := 0; j < 3; j++ { buf[i][j] = float64(buf32[i][j]) }
+	}
+	return nil
+}
+
+// skipBytes moves rd forward by n bytes.
+func skipBytes(rd io.Seeker, n int64) error {
+	_, err := rd.Seek(n, 1)
+	return err
+}
+
+// skipFortranBlock moves rd past a block which starts and ends with an int32
+// giving its size in bytes.
+func skipFortranBlock(rd io.ReadSeeker, order binary.ByteOrder) error {
+	size := readInt32(rd, order)
+	if err := skipBytes(rd, int64(size)); err != nil { return err }
+	_ = readInt32(rd, order)
+	return nil
+}
+
 func IsSysOrder(end binary.ByteOrder) bool {
 	buf32 := []int32{1}
 
@@ -263,16 +541,32 @@ func IsSysOrder(end binary.ByteOrder) bool {
 func boundingBox(
 	xs [][3]float32, totalWidth float64,
 ) (origin, width [3]float32) {
+	bb := &boundingBoxer{ totalWidth: totalWidth }
+	bb.add(xs)
+	return bb.box()
+}
+
+// boundingBoxer finds the bounding box of a set of particles which are added
+// a few at a time, so that the bounding box of a file can be found without
+// loading the whole thing into memory.
+type boundingBoxer struct {
+	totalWidth    float64
+	started       bool
+	origin, width [3]float32
+}
+
+func (bb *boundingBoxer) add(xs [][3]float32) {
 	// Assumes that the slice has already been checked for corruption.
-	origin = xs[0]
-	width = [3]float32{0, 0, 0}
-	tw, tw2 := float32(totalWidth), float32(totalWidth)/2
-	
-	max, min := origin, origin
+	if len(xs) == 0 { return }
+	if !bb.started {
+		bb.origin, bb.started = xs[0], true
+	}
+
+	tw, tw2 := float32(bb.totalWidth), float32(bb.totalWidth)/2
 
 	for i := range xs {
 		for j := 0; j < 3; j++ {
-			x, x0, w := xs[i][j], origin[j], width[j]
+			x, x0, w := xs[i][j], bb.origin[j], bb.width[j]
 
 			if x > x0 && x < x0 + w { continue }
 						
@@ -283,16 +577,18 @@ func boundingBox(
 			}
 
 			if x < x0 {
-				width[j] += x0 - x
-				origin[j] = x
-				min[j] = x
+				bb.width[j] += x0 - x
+				bb.origin[j] = x
 			} else if x-x0 > w {
-				width[j] = x - x0
-				max[j] = x
+				bb.width[j] = x - x0
 			}
 		}
 	}
+}
 
+func (bb *boundingBoxer) box() (origin, width [3]float32) {
+	origin, width = bb.origin, bb.width
+	tw, tw2 := float32(bb.totalWidth), float32(bb.totalWidth)/2
 	for j := 0; j < 3; j++ {
 		if width[j] > tw2 {
 			width[j] = tw
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"os"
 )
 
 // gadgetHeader is the formatting for meta-information used by Gadget 2.
@@ -23,7 +22,7 @@ type lGadget2Header struct {
 }
 
 func (gh *lGadget2Header) postprocess(
-	xs [][3]float32, context Context, out *Header,
+	bb *boundingBoxer, context Context, out *Header,
 ) {
 	// Assumes the catalog has already been checked for corruption.
 	
@@ -36,7 +35,7 @@ func (gh *lGadget2Header) postprocess(
 	out.Cosmo.OmegaL = gh.OmegaLambda
 	out.Cosmo.H100 = gh.HubbleParam
 
-	out.Origin, out.Width = boundingBox(xs, gh.BoxSize)
+	out.Origin, out.Width = bb.box()
 }
 
 func lgadgetParticleNum(
@@ -70,7 +69,7 @@ func readInt32(r io.Reader, order binary.ByteOrder) int32 {
 func readLGadget2Header(
 	path string, order binary.ByteOrder, out *lGadget2Header,
 ) error {
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
 		return err
 	}
@@ -84,11 +83,12 @@ func readLGadget2Header(
 func (buf *LGadget2Buffer) readLGadget2Particles(
 	path string,
 	order binary.ByteOrder,
+	fields Field,
 	xsBuf, vsBuf [][3]float32,
 	msBuf []float32,
 	idsBuf []int64,
 ) (xs, vs [][3]float32, ms []float32, ids []int64, err error) {
-	f, err := os.Open(path)
+	f, err := Open(path)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -102,28 +102,50 @@ func (buf *LGadget2Buffer) readLGadget2Particles(
 
 	count := lgadgetParticleNum(gh.NPart, gh, buf.context)
 
-	xsBuf = expandVectors(xsBuf[:0], int(count))
-	vsBuf = expandVectors(vsBuf[:0], int(count))
-	idsBuf = expandInts(idsBuf[:0], int(count))
-
-	_ = readInt32(f, order)
-	readVecAsByte(f, order, xsBuf)
+	// Blocks which weren't asked for are skipped over instead of read.
 	_ = readInt32(f, order)
+	if fields.Has(Positions) {
+		xsBuf = expandVectors(xsBuf[:0], int(count))
+		readVecAsByte(f, order, xsBuf)
+	} else {
+		xsBuf = nil
+		if err = skipBytes(f, 12*count); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
 	_ = readInt32(f, order)
-	readVecAsByte(f, order, vsBuf)
 	_ = readInt32(f, order)
+	if fields.Has(Velocities) {
+		vsBuf = expandVectors(vsBuf[:0], int(count))
+		readVecAsByte(f, order, vsBuf)
+	} else {
+		vsBuf = nil
+		if err = skipBytes(f, 12*count); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
 	_ = readInt32(f, order)
-	readInt64AsByte(f, order, idsBuf)
+	if fields.Has(IDs) {
+		_ = readInt32(f, order)
+		idsBuf = expandInts(idsBuf[:0], int(count))
+		readInt64AsByte(f, order, idsBuf)
+	} else {
+		idsBuf = nil
+	}
 
 	// Fix periodicity of particles and convert the units of our velocities.
 
 	rootA := float32(math.Sqrt(float64(gh.Time)))
 
+	for i := range vsBuf {
+		for j := 0; j < 3; j++ {
+			vsBuf[i][j] = vsBuf[i][j] * rootA
+		}
+	}
+
 	tw := float32(gh.BoxSize)
 	for i := range xsBuf {
 		for j := 0; j < 3; j++ {
-			vsBuf[i][j] = vsBuf[i][j] * rootA
-			
 			if xsBuf[i][j] < 0 {
 				xsBuf[i][j] += tw
 			} else if xsBuf[i][j] >= tw {
@@ -142,9 +164,13 @@ func (buf *LGadget2Buffer) readLGadget2Particles(
 		}
 	}
 
-	msBuf = expandScalars(msBuf, int(count))
-	for i := range msBuf {
-		msBuf[i] = buf.mass
+	if fields.Has(Masses) {
+		msBuf = expandScalars(msBuf, int(count))
+		for i := range msBuf {
+			msBuf[i] = buf.mass
+		}
+	} else {
+		msBuf = nil
 	}
 
 	return xsBuf, vsBuf, msBuf, idsBuf, nil
@@ -162,6 +188,18 @@ func expandVectors(vecs [][3]float32, n int) [][3]float32 {
 	}
 }
 
+func expandVectors64(vecs [][3]float64, n int) [][3]float64 {
+	switch {
+	case cap(vecs) >= n:
+		return vecs[:n]
+	case int(float64(cap(vecs))*1.5) > n:
+		return append(vecs[:cap(vecs)],
+			make([][3]float64, n-cap(vecs))...)
+	default:
+		return make([][3]float64, n)
+	}
+}
+
 func expandScalars(scalars []float32, n int) []float32 {
 	switch {
 	case cap(scalars) >= n:
@@ -232,17 +270,30 @@ func NewLGadget2Buffer(
 
 func (buf *LGadget2Buffer) Read(fname string) (
 	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+func (buf *LGadget2Buffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
 ) {
 	if buf.open {
 		panic("Buffer already open.")
 	}
 	buf.open = true
 
-	buf.xs, buf.vs, buf.ms, buf.ids, err = buf.readLGadget2Particles(
-		fname, buf.order, buf.xs, buf.vs, buf.ms, buf.ids,
+	xs, vs, ms, ids, err = buf.readLGadget2Particles(
+		fname, buf.order, fields, buf.xs, buf.vs, buf.ms, buf.ids,
 	)
 
-	return buf.xs, buf.vs, buf.ms, buf.ids, err
+	// Hold onto the old buffers when fields are skipped so they can be
+	// reused later.
+	if xs != nil { buf.xs = xs }
+	if vs != nil { buf.vs = vs }
+	if ms != nil { buf.ms = ms }
+	if ids != nil { buf.ids = ids }
+
+	return xs, vs, ms, ids, err
 }
 
 func (buf *LGadget2Buffer) Close() {
@@ -261,13 +312,24 @@ func (buf *LGadget2Buffer) ReadHeader(fname string, out *Header) error {
 	if err != nil {
 		return err
 	}
-	defer buf.Close()
-	xs, _, _, _, err := buf.Read(fname)
+
+	// The bounding box is built up a chunk at a time so that the whole file
+	// never needs to be in memory.
+	it, err := buf.ReadChunks(fname, Positions, headerChunkSize)
 	if err != nil {
 		return err
 	}
+	defer it.Close()
+
+	bb := &boundingBoxer{ totalWidth: buf.hd.BoxSize }
+	for {
+		xs, _, _, _, err := it.Next()
+		if err == io.EOF { break }
+		if err != nil { return err }
+		bb.add(xs)
+	}
 
-	buf.hd.postprocess(xs, buf.context, out)
+	buf.hd.postprocess(bb, buf.context, out)
 
 	return nil
 }
@@ -280,3 +342,128 @@ func (buf *LGadget2Buffer) TotalParticles(fname string) (int, error) {
 	if err != nil { return 0, err }
 	return int(lgadgetParticleNum(hd.NPartTotal, hd, buf.context)), nil
 }
+
+func (buf *LGadget2Buffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+
+	f, err := Open(fname)
+	if err != nil { return nil, err }
+
+	gh := &lGadget2Header{}
+	_ = readInt32(f, buf.order)
+	if err = binary.Read(f, binary.LittleEndian, gh); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	f.Close()
+
+	count := lgadgetParticleNum(gh.NPart, gh, buf.context)
+	xsStart := int64(binary.Size(gh)) + 4*3
+	vsStart := xsStart + 12*count + 4*2
+	idsStart := vsStart + 12*count + 4*2
+
+	it := &lGadget2Iterator{
+		buf: buf, path: fname, fields: fields,
+		count: count, chunkSize: int64(chunkSize),
+		starts: [3]int64{ xsStart, vsStart, idsStart },
+		rootA: float32(math.Sqrt(gh.Time)), tw: float32(gh.BoxSize),
+	}
+	if err = openFieldFiles(fname, fields, it.fs[:]); err != nil {
+		return nil, err
+	}
+	buf.open = true
+
+	return it, nil
+}
+
+// lGadget2Iterator reads an LGadget-2 file a chunk at a time by seeking to
+// the part of each requested block that holds the current chunk. Each block
+// is read with its own File.
+type lGadget2Iterator struct {
+	buf    *LGadget2Buffer
+	fs     [3]File
+	path   string
+	fields Field
+
+	count, next, chunkSize int64
+	starts                 [3]int64
+	rootA, tw              float32
+}
+
+func (it *lGadget2Iterator) Next() (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	n := it.count - it.next
+	if n <= 0 { return nil, nil, nil, nil, io.EOF }
+	if n > it.chunkSize { n = it.chunkSize }
+
+	buf, order := it.buf, it.buf.order
+
+	if it.fields.Has(Positions) {
+		buf.xs = expandVectors(buf.xs[:0], int(n))
+		xs = buf.xs
+		if _, err = it.fs[0].Seek(it.starts[0] + 12*it.next, 0); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		readVecAsByte(it.fs[0], order, xs)
+	}
+	if it.fields.Has(Velocities) {
+		buf.vs = expandVectors(buf.vs[:0], int(n))
+		vs = buf.vs
+		if _, err = it.fs[1].Seek(it.starts[1] + 12*it.next, 0); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		readVecAsByte(it.fs[1], order, vs)
+	}
+	if it.fields.Has(IDs) {
+		buf.ids = expandInts(buf.ids[:0], int(n))
+		ids = buf.ids
+		if _, err = it.fs[2].Seek(it.starts[2] + 8*it.next, 0); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		readInt64AsByte(it.fs[2], order, ids)
+	}
+	if it.fields.Has(Masses) {
+		buf.ms = expandScalars(buf.ms[:0], int(n))
+		ms = buf.ms
+		for i := range ms { ms[i] = buf.mass }
+	}
+
+	it.next += n
+
+	for i := range vs {
+		for j := 0; j < 3; j++ { vs[i][j] *= it.rootA }
+	}
+
+	for i := range xs {
+		for j := 0; j < 3; j++ {
+			if xs[i][j] < 0 {
+				xs[i][j] += it.tw
+			} else if xs[i][j] >= it.tw {
+				xs[i][j] -= it.tw
+			}
+
+			if math.IsNaN(float64(xs[i][j])) ||
+				math.IsInf(float64(xs[i][j]), 0) ||
+				xs[i][j] < -it.tw || xs[i][j] > 2*it.tw {
+
+				return nil, nil, nil, nil, fmt.Errorf(
+					"Corruption detected in the file %s. I can't analyze it.",
+					it.path,
+				)
+			}
+		}
+	}
+
+	return xs, vs, ms, ids, nil
+}
+
+func (it *lGadget2Iterator) Close() error {
+	it.buf.Close()
+	return closeFieldFiles(it.fs[:])
+}
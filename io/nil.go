@@ -19,6 +19,13 @@ func (buf *NilBuffer) Read(fname string) (
 		"Submit a bug report about this message.")
 }
 
+func (buf *NilBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	panic("Cannot call ReadFields() on a NilBuffer. " +
+		"Submit a bug report about this message.")
+}
+
 func (buf *NilBuffer) Close() {
 	panic("Cannot call Close() on a NilBuffer. " +
 		"Submit a bug report about this message.")
@@ -61,3 +68,10 @@ func (buf *NilBuffer) TotalParticles(fname string) (int, error) {
 	panic("Cannot call TotalParticles() on a NilBuffer. " +
 		"Submit a bug report about this message.")
 }
+
+func (buf *NilBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	panic("Cannot call ReadChunks() on a NilBuffer. " +
+		"Submit a bug report about this message.")
+}
@@ -0,0 +1,512 @@
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	npyMagic = []byte("\x93NUMPY")
+
+	npyDescrRegexp   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortranRegexp = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShapeRegexp   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+
+	// npyTypes maps NumPy type codes onto the element types used by raw
+	// files.
+	npyTypes = map[string]string{
+		"f4": "float32", "f8": "float64",
+		"i4": "int32", "i8": "int64",
+		"u4": "uint32", "u8": "uint64",
+	}
+)
+
+// npyArray is an array read from a .npy file.
+type npyArray struct {
+	fname   string
+	typ     string
+	order   binary.ByteOrder
+	shape   []int
+	fortran bool
+	data    []byte
+}
+
+// readNpy reads a .npy file. See
+// numpy.org/doc/stable/reference/generated/numpy.lib.format.html for the
+// format. If headerOnly is true, the array's data is left nil.
+func readNpy(rd io.Reader, fname string, headerOnly bool) (*npyArray, error) {
+	prefix := make([]byte, 8)
+	if _, err := io.ReadFull(rd, prefix); err != nil { return nil, err }
+	if !bytes.Equal(prefix[:6], npyMagic) {
+		return nil, fmt.Errorf("%s is not a .npy file.", fname)
+	}
+
+	var hdLen int
+	switch prefix[6] {
+	case 1:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(rd, b); err != nil { return nil, err }
+		hdLen = int(binary.LittleEndian.Uint16(b))
+	case 2, 3:
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(rd, b); err != nil { return nil, err }
+		hdLen = int(binary.LittleEndian.Uint32(b))
+	default:
+		return nil, fmt.Errorf("%s has unsupported .npy version %d.",
+			fname, prefix[6])
+	}
+
+	hdBytes := make([]byte, hdLen)
+	if _, err := io.ReadFull(rd, hdBytes); err != nil { return nil, err }
+	hd := string(hdBytes)
+
+	descr := npyDescrRegexp.FindStringSubmatch(hd)
+	fortran := npyFortranRegexp.FindStringSubmatch(hd)
+	shape := npyShapeRegexp.FindStringSubmatch(hd)
+	if descr == nil || fortran == nil || shape == nil {
+		return nil, fmt.Errorf("Could not parse the header of %s.", fname)
+	}
+
+	arr := &npyArray{
+		fname: fname, order: binary.LittleEndian,
+		fortran: fortran[1] == "True",
+	}
+
+	code := descr[1]
+	if len(code) == 3 {
+		switch code[0] {
+		case '<':
+		case '>': arr.order = binary.BigEndian
+		case '=':
+			if IsSysOrder(binary.BigEndian) { arr.order = binary.BigEndian }
+		default:
+			return nil, fmt.Errorf("%s has unsupported dtype '%s'.",
+				fname, code)
+		}
+		code = code[1:]
+	}
+	typ, ok := npyTypes[code]
+	if !ok {
+		return nil, fmt.Errorf("%s has unsupported dtype '%s'.", fname, code)
+	}
+	arr.typ = typ
+
+	n := 1
+	for _, tok := range strings.Split(shape[1], ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" { continue }
+		dim, err := strconv.Atoi(strings.TrimSuffix(tok, "L"))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the shape of %s.", fname)
+		}
+		arr.shape = append(arr.shape, dim)
+		n *= dim
+	}
+
+	if headerOnly { return arr, nil }
+	arr.data = make([]byte, int64(n)*RawTypeSizes[arr.typ])
+	if _, err := io.ReadFull(rd, arr.data); err != nil { return nil, err }
+
+	return arr, nil
+}
+
+// len returns the number of rows in the array.
+func (arr *npyArray) len() int {
+	if len(arr.shape) == 0 { return 1 }
+	return arr.shape[0]
+}
+
+// vectors reads an (N, 3) array into out.
+func (arr *npyArray) vectors(name string, out [][3]float64) error {
+	if len(arr.shape) != 2 || arr.shape[1] != 3 || arr.shape[0] != len(out) {
+		return fmt.Errorf("Array '%s' has shape %v, not (%d, 3).",
+			name, arr.shape, len(out))
+	}
+
+	size := RawTypeSizes[arr.typ]
+	n := int64(len(out))
+	for i := range out {
+		for k := 0; k < 3; k++ {
+			j := int64(i)*3 + int64(k)
+			if arr.fortran { j = int64(k)*n + int64(i) }
+			out[i][k] = rawFloat(arr.data[j*size:], arr.typ, arr.order)
+		}
+	}
+	return nil
+}
+
+// checkScalars returns an error if the array isn't a length-n array of
+// scalars.
+func (arr *npyArray) checkScalars(name string, n int) error {
+	if arr.len() != n || !(len(arr.shape) == 1 ||
+		(len(arr.shape) == 2 && arr.shape[1] == 1)) {
+		return fmt.Errorf("Array '%s' has shape %v, not (%d,).",
+			name, arr.shape, n)
+	}
+	return nil
+}
+
+// numpyMeta is the metadata stored in the JSON sidecar file of a NumPy
+// snapshot. Missing values are taken from the config file.
+type numpyMeta struct {
+	TotalWidth, OmegaM, OmegaL, H100, ScaleFactor *float64
+}
+
+// NumPyBuffer reads particles from NumPy .npy and .npz files. An .npz file
+// contains every array. A .npy file contains the positions, and the other
+// arrays are read from files with the same name, except that "_<array>" is
+// added before the extension, e.g. block_0.npy and block_0_vel.npy.
+// Velocities, masses, and IDs are optional. Arrays must be in comoving Mpc/h,
+// km/s, and Msun/h.
+type NumPyBuffer struct {
+	open    bool
+	context Context
+	mass    float32
+
+	xs, vs [][3]float32
+	xs64   [][3]float64
+	vs64   [][3]float64
+	ms     []float32
+	ids    []int64
+}
+
+func NewNumPyBuffer(paths []string, context Context) (VectorBuffer, error) {
+	buf := &NumPyBuffer{ context: context }
+
+	var err error
+	buf.mass, err = minMass(buf, paths)
+	if err != nil { return nil, err }
+
+	return buf, nil
+}
+
+// readNumPyArrays reads the arrays with the given names from a snapshot. The
+// first array must exist if it's named. Other missing arrays and arrays with
+// empty names are returned as nil. If headerOnly is true, only the arrays' headers are read.
+func readNumPyArrays(
+	fname string, names []string, headerOnly bool,
+) ([]*npyArray, error) {
+	arrs := make([]*npyArray, len(names))
+	base, ext := TrimCompressionExt(fname)
+
+	if strings.HasSuffix(base, ".npz") {
+		f, err := Open(fname)
+		if err != nil { return nil, err }
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil { return nil, err }
+		zf, err := zip.NewReader(f, info.Size())
+		if err != nil { return nil, err }
+
+		for _, file := range zf.File {
+			for i := range names {
+				if names[i] == "" || file.Name != names[i] + ".npy" {
+					continue
+				}
+
+				rd, err := file.Open()
+				if err != nil { return nil, err }
+				arrs[i], err = readNpy(rd, fname + ":" + file.Name, headerOnly)
+				rd.Close()
+				if err != nil { return nil, err }
+			}
+		}
+		return arrs, nil
+	}
+
+	for i := range names {
+		if names[i] == "" { continue }
+		path := fname
+		if i > 0 { path = numPySidecar(base, ext, names[i]) }
+
+		f, err := Open(path)
+		if os.IsNotExist(err) && i > 0 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		arrs[i], err = readNpy(f, path, headerOnly)
+		f.Close()
+		if err != nil { return nil, err }
+	}
+	return arrs, nil
+}
+
+// numPySidecar returns the name of the .npy file holding the named array of
+// the snapshot whose positions are in base + ext.
+func numPySidecar(base, ext, name string) string {
+	return strings.TrimSuffix(base, ".npy") + "_" + name + ".npy" + ext
+}
+
+// hasNumPyArray returns true if a snapshot contains the named array.
+func hasNumPyArray(fname, name string) (bool, error) {
+	base, ext := TrimCompressionExt(fname)
+	if !strings.HasSuffix(base, ".npz") {
+		_, err := os.Stat(numPySidecar(base, ext, name))
+		if os.IsNotExist(err) { return false, nil }
+		return err == nil, err
+	}
+
+	f, err := Open(fname)
+	if err != nil { return false, err }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { return false, err }
+	zf, err := zip.NewReader(f, info.Size())
+	if err != nil { return false, err }
+
+	for _, file := range zf.File {
+		if file.Name == name + ".npy" { return true, nil }
+	}
+	return false, nil
+}
+
+// meta returns the metadata of a snapshot, using the JSON sidecar file if it
+// exists and the config file otherwise.
+func (buf *NumPyBuffer) meta(fname string) (*Header, error) {
+	ctx := &buf.context
+	tw, a := ctx.NumPyTotalWidth, ctx.NumPyScaleFactor
+	omegaM, omegaL, h100 := ctx.NumPyOmegaM, ctx.NumPyOmegaL, ctx.NumPyH100
+
+	base, _ := TrimCompressionExt(fname)
+	path := strings.TrimSuffix(base, filepath.Ext(base)) + ".json"
+	text, err := ioutil.ReadFile(path)
+	if err == nil {
+		meta := &numpyMeta{}
+		if err = json.Unmarshal(text, meta); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s",
+				path, err.Error())
+		}
+
+		if meta.TotalWidth != nil { tw = *meta.TotalWidth }
+		if meta.OmegaM != nil { omegaM = *meta.OmegaM }
+		if meta.OmegaL != nil { omegaL = *meta.OmegaL }
+		if meta.H100 != nil { h100 = *meta.H100 }
+		if meta.ScaleFactor != nil { a = *meta.ScaleFactor }
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	switch {
+	case tw <= 0:
+		return nil, fmt.Errorf("No TotalWidth given for %s.", fname)
+	case omegaM < 0:
+		return nil, fmt.Errorf("No OmegaM given for %s.", fname)
+	case omegaL < 0:
+		return nil, fmt.Errorf("No OmegaL given for %s.", fname)
+	case h100 <= 0:
+		return nil, fmt.Errorf("No H100 given for %s.", fname)
+	case a <= 0:
+		return nil, fmt.Errorf("No ScaleFactor given for %s.", fname)
+	}
+
+	hd := &Header{ TotalWidth: tw }
+	hd.Cosmo = CosmologyHeader{
+		Z: 1/a - 1, OmegaM: omegaM, OmegaL: omegaL, H100: h100,
+	}
+	return hd, nil
+}
+
+func (buf *NumPyBuffer) Read(fname string) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a NumPy snapshot. Positions are
+// rounded to float32.
+func (buf *NumPyBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return readFields32(buf, fname, fields, &buf.xs)
+}
+
+// ReadFields64 reads the requested fields from a NumPy snapshot without
+// losing the precision of float64 positions. If there's no mass array, every
+// particle has the mass NumPyMass, and if there's no ID array, particles are
+// given their index in the file as an ID. Velocities don't have a default, so
+// asking for them when there's no velocity array is an error.
+func (buf *NumPyBuffer) ReadFields64(fname string, fields Field) (
+	xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+	buf.open = true
+
+	ctx := &buf.context
+	names := []string{ "", "", "", "" }
+	if fields.Has(Positions) { names[0] = ctx.NumPyPositionArray }
+	if fields.Has(Velocities) { names[1] = ctx.NumPyVelocityArray }
+	if fields.Has(Masses) { names[2] = ctx.NumPyMassArray }
+	if fields.Has(IDs) { names[3] = ctx.NumPyIDArray }
+
+	arrs, err := readNumPyArrays(fname, names, false)
+	if err != nil { return nil, nil, nil, nil, err }
+	xArr, vArr, mArr, idArr := arrs[0], arrs[1], arrs[2], arrs[3]
+
+	// The particle count comes from the position array, so its header is
+	// read even if positions weren't requested.
+	if xArr == nil {
+		hdArrs, err := readNumPyArrays(
+			fname, []string{ ctx.NumPyPositionArray }, true,
+		)
+		if err != nil { return nil, nil, nil, nil, err }
+		xArr = hdArrs[0]
+	}
+
+	if xArr == nil {
+		return nil, nil, nil, nil, fmt.Errorf("%s doesn't contain the "+
+			"array '%s'.", fname, ctx.NumPyPositionArray)
+	} else if fields.Has(Velocities) && vArr == nil {
+		return nil, nil, nil, nil, fmt.Errorf("Velocities are needed, but "+
+			"%s doesn't contain the array '%s'.", fname,
+			ctx.NumPyVelocityArray)
+	}
+	n := xArr.len()
+
+	if fields.Has(Positions) {
+		hd, err := buf.meta(fname)
+		if err != nil { return nil, nil, nil, nil, err }
+		tw := hd.TotalWidth
+
+		buf.xs64 = expandVectors64(buf.xs64[:0], n)
+		err = xArr.vectors(ctx.NumPyPositionArray, buf.xs64)
+		if err != nil { return nil, nil, nil, nil, err }
+
+		for i := range buf.xs64 {
+			for k := 0; k < 3; k++ {
+				x := math.Mod(buf.xs64[i][k], tw)
+				if x < 0 { x += tw }
+				buf.xs64[i][k] = x
+			}
+		}
+		xs = buf.xs64
+	}
+
+	if fields.Has(Velocities) {
+		buf.vs64 = expandVectors64(buf.vs64[:0], n)
+		err = vArr.vectors(ctx.NumPyVelocityArray, buf.vs64)
+		if err != nil { return nil, nil, nil, nil, err }
+
+		buf.vs = roundVectors(buf.vs, buf.vs64)
+		vs = buf.vs
+	}
+
+	if fields.Has(Masses) {
+		buf.ms = expandScalars(buf.ms[:0], n)
+		if mArr == nil {
+			if ctx.NumPyMass <= 0 {
+				return nil, nil, nil, nil, fmt.Errorf("%s doesn't contain "+
+					"the array '%s' and NumPyMass isn't set.",
+					fname, ctx.NumPyMassArray)
+			}
+			for i := range buf.ms { buf.ms[i] = float32(ctx.NumPyMass) }
+		} else {
+			err = mArr.checkScalars(ctx.NumPyMassArray, n)
+			if err != nil { return nil, nil, nil, nil, err }
+
+			size := RawTypeSizes[mArr.typ]
+			for i := range buf.ms {
+				b := mArr.data[int64(i)*size:]
+				buf.ms[i] = float32(rawFloat(b, mArr.typ, mArr.order))
+			}
+		}
+		ms = buf.ms
+	}
+
+	if fields.Has(IDs) {
+		buf.ids = expandInts(buf.ids[:0], n)
+		if idArr == nil {
+			for i := range buf.ids { buf.ids[i] = int64(i) }
+		} else {
+			err = idArr.checkScalars(ctx.NumPyIDArray, n)
+			if err != nil { return nil, nil, nil, nil, err }
+			if !isRawIntType(idArr.typ) {
+				return nil, nil, nil, nil, fmt.Errorf("The array '%s' in %s "+
+					"has type %s, but IDs must be integers.",
+					ctx.NumPyIDArray, idArr.fname, idArr.typ)
+			}
+
+			size := RawTypeSizes[idArr.typ]
+			for i := range buf.ids {
+				b := idArr.data[int64(i)*size:]
+				buf.ids[i] = rawInt(b, idArr.typ, idArr.order)
+			}
+		}
+		ids = buf.ids
+	}
+
+	return xs, vs, ms, ids, nil
+}
+
+// StoredFields returns the fields stored in fname. Masses and IDs are always
+// available, since they have default values.
+func (buf *NumPyBuffer) StoredFields(fname string) (Field, error) {
+	ok, err := hasNumPyArray(fname, buf.context.NumPyVelocityArray)
+	if err != nil { return 0, err }
+	if !ok { return AllFields &^ Velocities, nil }
+	return AllFields, nil
+}
+
+// Float64Positions returns true if the position array of fname is float64.
+func (buf *NumPyBuffer) Float64Positions(fname string) (bool, error) {
+	arrs, err := readNumPyArrays(
+		fname, []string{ buf.context.NumPyPositionArray }, true,
+	)
+	if err != nil { return false, err }
+	if arrs[0] == nil {
+		return false, fmt.Errorf("%s doesn't contain the array '%s'.",
+			fname, buf.context.NumPyPositionArray)
+	}
+	return arrs[0].typ == "float64", nil
+}
+
+func (buf *NumPyBuffer) Close() {
+	if !buf.open {
+		panic("Buffer not open.")
+	}
+	buf.open = false
+}
+
+func (buf *NumPyBuffer) IsOpen() bool {
+	return buf.open
+}
+
+func (buf *NumPyBuffer) ReadHeader(fname string, out *Header) error {
+	hd, err := buf.meta(fname)
+	if err != nil { return err }
+
+	xs, _, _, _, err := buf.ReadFields(fname, Positions)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { return err }
+
+	*out = *hd
+	out.N = int64(len(xs))
+	out.Origin, out.Width = boundingBox(xs, out.TotalWidth)
+
+	return nil
+}
+
+func (buf *NumPyBuffer) MinMass() float32 { return buf.mass }
+
+func (buf *NumPyBuffer) TotalParticles(fname string) (int, error) {
+	return -1, nil
+}
+
+func (buf *NumPyBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
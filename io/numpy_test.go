@@ -0,0 +1,193 @@
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// npyBytes returns the contents of a version 1.0 .npy file. data is written
+// in the given byte order and should already be laid out in the order given
+// by fortran.
+func npyBytes(
+	descr string, fortran bool, shape string,
+	order binary.ByteOrder, data interface{},
+) []byte {
+	fortranStr := "False"
+	if fortran { fortranStr = "True" }
+	hd := fmt.Sprintf("{'descr': '%s', 'fortran_order': %s, 'shape': (%s), }",
+		descr, fortranStr, shape)
+	// The header is padded so that the data starts on a 64-byte boundary.
+	pad := 64 - (len(npyMagic) + 4 + len(hd) + 1) % 64
+	hd += strings.Repeat(" ", pad % 64) + "\n"
+
+	buf := &bytes.Buffer{}
+	buf.Write(npyMagic)
+	buf.Write([]byte{ 1, 0 })
+	binary.Write(buf, binary.LittleEndian, uint16(len(hd)))
+	buf.WriteString(hd)
+	binary.Write(buf, order, data)
+	return buf.Bytes()
+}
+
+func writeTestFile(t *testing.T, fname string, data []byte) {
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func numPyTestContext() Context {
+	return Context{
+		NumPyPositionArray: "pos", NumPyVelocityArray: "vel",
+		NumPyMassArray: "mass", NumPyIDArray: "ids", NumPyMass: 2,
+		NumPyTotalWidth: 100, NumPyOmegaM: 0.3, NumPyOmegaL: 0.7,
+		NumPyH100: 0.7, NumPyScaleFactor: 1,
+	}
+}
+
+func TestNumPySidecars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_numpy")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	// The first block has no mass array, a big-endian Fortran-ordered
+	// velocity array, and a JSON file which overrides the box width and
+	// scale factor. The second block has the lightest particle.
+	paths := []string{
+		filepath.Join(dir, "block_0.npy"), filepath.Join(dir, "block_1.npy"),
+	}
+	writeTestFile(t, paths[0], npyBytes("<f8", false, "3, 3",
+		binary.LittleEndian, []float64{
+			1, 2, 3, -1, 25.123456789012, 50, 10, 20, 30,
+		}))
+	writeTestFile(t, filepath.Join(dir, "block_0_vel.npy"),
+		npyBytes(">f4", true, "3, 3", binary.BigEndian, []float32{
+			1, 2, 3, 4, 5, 6, 7, 8, 9,
+		}))
+	writeTestFile(t, filepath.Join(dir, "block_0_ids.npy"),
+		npyBytes("<i8", false, "3,", binary.LittleEndian, []int64{
+			1 << 40, 5, -1,
+		}))
+	writeTestFile(t, filepath.Join(dir, "block_0.json"),
+		[]byte(`{"TotalWidth": 50, "ScaleFactor": 0.5}`))
+
+	writeTestFile(t, paths[1], npyBytes("<f4", false, "1, 3",
+		binary.LittleEndian, []float32{ 1, 1, 1 }))
+	writeTestFile(t, filepath.Join(dir, "block_1_mass.npy"),
+		npyBytes("<f4", false, "1,", binary.LittleEndian, []float32{ 0.5 }))
+
+	buf, err := NewNumPyBuffer(paths, numPyTestContext())
+	if err != nil { t.Fatal(err.Error()) }
+	if buf.MinMass() != 0.5 {
+		t.Errorf("Expected a minimum mass of 0.5, got %g.", buf.MinMass())
+	}
+
+	xs, vs, ms, ids, err := buf.(Float64Buffer).ReadFields64(
+		paths[0], AllFields,
+	)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { t.Fatal(err.Error()) }
+
+	// Positions are wrapped into the 50 cMpc/h box given by the JSON file.
+	expXs := [][3]float64{ {1, 2, 3}, {49, 25.123456789012, 0}, {10, 20, 30} }
+	expVs := [][3]float32{ {1, 4, 7}, {2, 5, 8}, {3, 6, 9} }
+	expIDs := []int64{ 1 << 40, 5, -1 }
+	if len(xs) != 3 || len(vs) != 3 || len(ms) != 3 || len(ids) != 3 {
+		t.Fatalf("Expected three particles, got %d %d %d %d.",
+			len(xs), len(vs), len(ms), len(ids))
+	}
+	for i := range xs {
+		if xs[i] != expXs[i] || vs[i] != expVs[i] {
+			t.Errorf("Particle %d has position %v and velocity %v, " +
+				"expected %v and %v.", i, xs[i], vs[i], expXs[i], expVs[i])
+		}
+		if ms[i] != 2 || ids[i] != expIDs[i] {
+			t.Errorf("Particle %d has mass %g and ID %d, expected 2 and %d.",
+				i, ms[i], ids[i], expIDs[i])
+		}
+	}
+
+	hd := &Header{}
+	if err = buf.ReadHeader(paths[0], hd); err != nil { t.Fatal(err.Error()) }
+	if hd.TotalWidth != 50 || hd.N != 3 || hd.Cosmo.Z != 1 ||
+		hd.Cosmo.OmegaM != 0.3 {
+		t.Errorf("Header was read as %v.", hd)
+	}
+
+	// Masses can be read without reading positions.
+	xs32, vs32, ms, _, err := buf.ReadFields(paths[1], Masses)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { t.Fatal(err.Error()) }
+	if xs32 != nil || vs32 != nil || len(ms) != 1 || ms[0] != 0.5 {
+		t.Errorf("Expected only the mass 0.5, got %v, %v, and %v.",
+			xs32, vs32, ms)
+	}
+
+	_, _, _, _, err = buf.ReadFields(paths[1], Velocities)
+	if buf.IsOpen() { buf.Close() }
+	if err == nil {
+		t.Errorf("Expected an error when reading missing velocities.")
+	}
+}
+
+func TestNumPyNpz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_numpy")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	zipBuf := &bytes.Buffer{}
+	zw := zip.NewWriter(zipBuf)
+	for _, arr := range []struct {
+		name string
+		data []byte
+	}{
+		{"pos.npy", npyBytes("<f4", false, "2, 3", binary.LittleEndian,
+			[]float32{ 1, 2, 3, 4, 5, 6 })},
+		{"mass.npy", npyBytes("<f8", false, "2,", binary.LittleEndian,
+			[]float64{ 3, 4 })},
+		{"ids.npy", npyBytes("<f4", false, "2,", binary.LittleEndian,
+			[]float32{ 1, 2 })},
+	} {
+		w, err := zw.Create(arr.name)
+		if err != nil { t.Fatal(err.Error()) }
+		if _, err = w.Write(arr.data); err != nil { t.Fatal(err.Error()) }
+	}
+	if err = zw.Close(); err != nil { t.Fatal(err.Error()) }
+
+	fname := filepath.Join(dir, "snap.npz")
+	writeTestFile(t, fname, zipBuf.Bytes())
+
+	buf, err := NewNumPyBuffer([]string{ fname }, numPyTestContext())
+	if err != nil { t.Fatal(err.Error()) }
+	if buf.MinMass() != 3 {
+		t.Errorf("Expected a minimum mass of 3, got %g.", buf.MinMass())
+	}
+
+	fields, err := buf.(OptionalFieldBuffer).StoredFields(fname)
+	if err != nil { t.Fatal(err.Error()) }
+	if fields != AllFields &^ Velocities {
+		t.Errorf("Expected the stored fields to be %s, got %s.",
+			AllFields &^ Velocities, fields)
+	}
+
+	xs, _, ms, _, err := buf.ReadFields(fname, Positions | Masses)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { t.Fatal(err.Error()) }
+	if len(xs) != 2 || xs[1] != [3]float32{ 4, 5, 6 } || ms[1] != 4 {
+		t.Errorf("Expected the second particle at [4 5 6] with mass 4, " +
+			"got %v and %v.", xs, ms)
+	}
+
+	// IDs must be integers.
+	_, _, _, _, err = buf.ReadFields(fname, IDs)
+	if buf.IsOpen() { buf.Close() }
+	if err == nil || !strings.Contains(err.Error(), "must be integers") {
+		t.Errorf("Expected an error about float IDs, got %v.", err)
+	}
+}
@@ -0,0 +1,114 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PhaseData contains a set of 2D phase-space histograms which all share the
+// same dimensions. Xs[i] and Ys[i] are the bin centers of the ith histogram
+// and Rhos[i] is a dense, row-major array with len(Xs[i]) rows and
+// len(Ys[i]) columns.
+type PhaseData struct {
+	Snaps []int64
+	IDs   []int64
+	Xs    [][]float32
+	Ys    [][]float32
+	Rhos  [][]float32
+}
+
+// WritePhase writes a PhaseData to wr. The file starts with an int32
+// endianness flag (0 -> little endian, -1 -> big endian), followed by the
+// int32 values HaloCount, XBins and YBins. Each halo is then written as
+// int64 ID and Snap values followed by float32 arrays of x bins, y bins, and
+// the histogram.
+func WritePhase(wr io.Writer, orderFlag string, data PhaseData) error {
+	order := flagToOrder(orderFlag)
+
+	nx, ny := 0, 0
+	if len(data.IDs) > 0 { nx, ny = len(data.Xs[0]), len(data.Ys[0]) }
+
+	flag := int32(0)
+	if order == binary.BigEndian { flag = -1 }
+	hd := []int32{ flag, int32(len(data.IDs)), int32(nx), int32(ny) }
+	if err := binary.Write(wr, order, hd); err != nil { return err }
+
+	for i := range data.IDs {
+		if len(data.Xs[i]) != nx || len(data.Ys[i]) != ny ||
+			len(data.Rhos[i]) != nx*ny {
+			return fmt.Errorf("Histogram %d has different dimensions than " +
+				"histogram 0.", i)
+		}
+
+		ids := []int64{ data.IDs[i], data.Snaps[i] }
+		if err := binary.Write(wr, order, ids); err != nil { return err }
+		if err := binary.Write(wr, order, data.Xs[i]); err != nil {
+			return err
+		}
+		if err := binary.Write(wr, order, data.Ys[i]); err != nil {
+			return err
+		}
+		if err := binary.Write(wr, order, data.Rhos[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadPhase reads a PhaseData written by WritePhase from rd.
+func ReadPhase(rd io.Reader) (PhaseData, error) {
+	var orderFlag int32
+	if err := binary.Read(rd, binary.LittleEndian, &orderFlag); err != nil {
+		return PhaseData{}, err
+	}
+
+	var order binary.ByteOrder
+	switch orderFlag {
+	case 0:
+		order = binary.LittleEndian
+	case -1:
+		order = binary.BigEndian
+	default:
+		return PhaseData{}, fmt.Errorf(
+			"Unknown endianness flag at start of file.")
+	}
+
+	hd := make([]int32, 3)
+	if err := binary.Read(rd, order, hd); err != nil {
+		return PhaseData{}, err
+	}
+	n, nx, ny := hd[0], hd[1], hd[2]
+
+	data := PhaseData{
+		Snaps: make([]int64, n),
+		IDs: make([]int64, n),
+		Xs: make([][]float32, n),
+		Ys: make([][]float32, n),
+		Rhos: make([][]float32, n),
+	}
+
+	for i := range data.IDs {
+		ids := make([]int64, 2)
+		data.Xs[i] = make([]float32, nx)
+		data.Ys[i] = make([]float32, ny)
+		data.Rhos[i] = make([]float32, nx*ny)
+
+		if err := binary.Read(rd, order, ids); err != nil {
+			return PhaseData{}, err
+		}
+		if err := binary.Read(rd, order, data.Xs[i]); err != nil {
+			return PhaseData{}, err
+		}
+		if err := binary.Read(rd, order, data.Ys[i]); err != nil {
+			return PhaseData{}, err
+		}
+		if err := binary.Read(rd, order, data.Rhos[i]); err != nil {
+			return PhaseData{}, err
+		}
+		data.IDs[i], data.Snaps[i] = ids[0], ids[1]
+	}
+
+	return data, nil
+}
@@ -0,0 +1,115 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// PotentialData contains the potentials and binding energies of the
+// particles around a set of halos. Potentials and energies are in physical
+// (km/s)^2 and energies are measured relative to each halo's bulk velocity.
+type PotentialData struct {
+	Snaps     []int64
+	IDs       []int64
+	Particles [][]int64
+	Phis      [][]float32
+	Energies  [][]float32
+}
+
+// WritePotential writes a PotentialData to wr. The layout is the same as the
+// one used by WriteFilter, except that each halo's particle IDs are followed
+// by a float32 array of potentials and a float32 array of energies.
+func WritePotential(wr io.Writer, orderFlag string, data PotentialData) error {
+	order := flagToOrder(orderFlag)
+
+	flag := int32(0)
+	if order == binary.BigEndian { flag = -1 }
+	if err := binary.Write(wr, order, flag); err != nil { return err }
+	err := binary.Write(wr, order, int32(len(data.Snaps)))
+	if err != nil { return err }
+
+	info := make([]haloInfo, len(data.Snaps))
+	baseOffset := int64(4 + 4 + len(info)*int(unsafe.Sizeof(haloInfo{})))
+
+	offset := baseOffset
+	for i := range info {
+		info[i].Snap = data.Snaps[i]
+		info[i].ID = data.IDs[i]
+		info[i].StartByte = offset
+		info[i].Len = int64(len(data.Particles[i]))
+		offset += info[i].Len * (8 + 4 + 4)
+	}
+	if err := binary.Write(wr, order, info); err != nil { return err }
+
+	for i := range info {
+		if err := binary.Write(wr, order, data.Particles[i]); err != nil {
+			return err
+		}
+		if err := binary.Write(wr, order, data.Phis[i]); err != nil {
+			return err
+		}
+		if err := binary.Write(wr, order, data.Energies[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadPotential reads a PotentialData written by WritePotential from rd.
+func ReadPotential(rd io.Reader) (PotentialData, error) {
+	var orderFlag int32
+	if err := binary.Read(rd, binary.LittleEndian, &orderFlag); err != nil {
+		return PotentialData{}, err
+	}
+
+	var order binary.ByteOrder
+	switch orderFlag {
+	case 0:
+		order = binary.LittleEndian
+	case -1:
+		order = binary.BigEndian
+	default:
+		return PotentialData{}, fmt.Errorf(
+			"Unknown endianness flag at start of file.")
+	}
+
+	var haloNum int32
+	if err := binary.Read(rd, order, &haloNum); err != nil {
+		return PotentialData{}, err
+	}
+
+	info := make([]haloInfo, haloNum)
+	if err := binary.Read(rd, order, info); err != nil {
+		return PotentialData{}, err
+	}
+
+	data := PotentialData{
+		Snaps: make([]int64, haloNum),
+		IDs: make([]int64, haloNum),
+		Particles: make([][]int64, haloNum),
+		Phis: make([][]float32, haloNum),
+		Energies: make([][]float32, haloNum),
+	}
+
+	for i := range info {
+		data.Snaps[i] = info[i].Snap
+		data.IDs[i] = info[i].ID
+		data.Particles[i] = make([]int64, info[i].Len)
+		data.Phis[i] = make([]float32, info[i].Len)
+		data.Energies[i] = make([]float32, info[i].Len)
+		if err := binary.Read(rd, order, data.Particles[i]); err != nil {
+			return PotentialData{}, err
+		}
+		if err := binary.Read(rd, order, data.Phis[i]); err != nil {
+			return PotentialData{}, err
+		}
+		if err := binary.Read(rd, order, data.Energies[i]); err != nil {
+			return PotentialData{}, err
+		}
+	}
+
+	return data, nil
+}
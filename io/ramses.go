@@ -0,0 +1,429 @@
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/phil-mansfield/shellfish/cosmo"
+)
+
+// ramsesDMFamily is the value of the family field for dark matter particles.
+// Only RAMSES versions from 2017 onwards write this field.
+const ramsesDMFamily = 1
+
+// RAMSESBuffer reads the dark matter particles in RAMSES
+// output_XXXXX/part_XXXXX.outNNNNN files. Code units are converted using the
+// info_XXXXX.txt file in the same directory.
+type RAMSESBuffer struct {
+	open  bool
+	order binary.ByteOrder
+	mass  float32
+
+	infoPath string
+	info     ramsesInfo
+
+	xs, vs [][3]float32
+	xs64   [][3]float64
+	ms     []float32
+	ids    []int64
+	dm     []bool
+	f64    []float64
+}
+
+// ramsesInfo contains the fields of an info_XXXXX.txt file which are needed
+// to convert code units.
+type ramsesInfo struct {
+	boxlen, aexp, h0, omegaM, omegaL float64
+	unitL, unitD, unitT              float64
+}
+
+// lengthUnit converts code lengths to cMpc/h.
+func (info *ramsesInfo) lengthUnit() float64 {
+	return info.unitL / info.aexp / (cosmo.MpcMks * 100) * (info.h0 / 100)
+}
+
+// massUnit converts code masses to Msun/h.
+func (info *ramsesInfo) massUnit() float64 {
+	return info.unitD * info.unitL * info.unitL * info.unitL /
+		(cosmo.MSunMks * 1000) * (info.h0 / 100)
+}
+
+// velocityUnit converts code velocities to peculiar km/s.
+func (info *ramsesInfo) velocityUnit() float64 {
+	return info.unitL / info.unitT / 1e5
+}
+
+func NewRAMSESBuffer(
+	paths []string, orderFlag string, context Context,
+) (VectorBuffer, error) {
+
+	var order binary.ByteOrder = binary.LittleEndian
+	switch orderFlag {
+	case "LittleEndian":
+	case "BigEndian":
+		order = binary.BigEndian
+	case "SystemOrder":
+		if !IsSysOrder(order) {
+			order = binary.BigEndian
+		}
+	}
+
+	buf := &RAMSESBuffer{ order: order }
+
+	var err error
+	buf.mass, err = minMass(buf, paths)
+	if err != nil { return nil, err }
+
+	return buf, nil
+}
+
+// ramsesInfoPath returns the path to the info file for the particle file
+// fname.
+func ramsesInfoPath(fname string) (string, error) {
+	base := filepath.Base(fname)
+	end := strings.Index(base, ".out")
+	if !strings.HasPrefix(base, "part_") || end == -1 {
+		return "", fmt.Errorf("'%s' is not the name of a RAMSES particle " +
+			"file. These are named like part_XXXXX.outNNNNN.", fname)
+	}
+
+	num := base[len("part_"): end]
+	return filepath.Join(filepath.Dir(fname), "info_" + num + ".txt"), nil
+}
+
+func readRAMSESInfo(path string, out *ramsesInfo) error {
+	f, err := Open(path)
+	if err != nil { return err }
+	defer f.Close()
+
+	vals := map[string]*float64{
+		"boxlen": &out.boxlen, "aexp": &out.aexp, "H0": &out.h0,
+		"omega_m": &out.omegaM, "omega_l": &out.omegaL,
+		"unit_l": &out.unitL, "unit_d": &out.unitD, "unit_t": &out.unitT,
+	}
+	found := map[string]bool{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tokens := strings.SplitN(scanner.Text(), "=", 2)
+		if len(tokens) != 2 { continue }
+		key := strings.TrimSpace(tokens[0])
+
+		ptr, ok := vals[key]
+		if !ok { continue }
+		*ptr, err = strconv.ParseFloat(strings.TrimSpace(tokens[1]), 64)
+		if err != nil {
+			return fmt.Errorf("Could not parse the value of '%s' in the " +
+				"RAMSES info file %s.", key, path)
+		}
+		found[key] = true
+	}
+	if err = scanner.Err(); err != nil { return err }
+
+	for key := range vals {
+		if !found[key] {
+			return fmt.Errorf("The RAMSES info file %s does not contain " +
+				"the field '%s'.", path, key)
+		}
+	}
+
+	return nil
+}
+
+// readRAMSESFloat64s reads a Fortran record containing len(buf) float64s.
+func readRAMSESFloat64s(
+	rd io.Reader, order binary.ByteOrder, buf []float64, path string,
+) error {
+	size := readInt32(rd, order)
+	if int(size) != 8*len(buf) {
+		return fmt.Errorf("Expected a record of %d float64s in the RAMSES " +
+			"file %s, but it has %d bytes.", len(buf), path, size)
+	}
+	if err := readFloat64AsByte(rd, order, buf); err != nil { return err }
+	_ = readInt32(rd, order)
+	return nil
+}
+
+// readRAMSESIDs reads a Fortran record containing 32-bit or 64-bit IDs.
+func readRAMSESIDs(
+	rd io.Reader, order binary.ByteOrder, buf []int64, path string,
+) error {
+	size := readInt32(rd, order)
+	switch int(size) {
+	case 8*len(buf):
+		if err := readInt64AsByte(rd, order, buf); err != nil { return err }
+	case 4*len(buf):
+		i32Buf := make([]int32, len(buf))
+		if err := readInt32AsByte(rd, order, i32Buf); err != nil {
+			return err
+		}
+		for i := range i32Buf { buf[i] = int64(i32Buf[i]) }
+	default:
+		return fmt.Errorf("Expected a record of %d IDs in the RAMSES file " +
+			"%s, but it has %d bytes.", len(buf), path, size)
+	}
+	_ = readInt32(rd, order)
+	return nil
+}
+
+// flagRAMSESDM sets dm[i] to true if the ith particle in the file is a dark
+// matter particle. rd must be just past the level record. Newer files have a
+// family record, which is used if it exists. Otherwise, stars are found
+// from their birth epochs and sinks from their negative IDs.
+func flagRAMSESDM(
+	rd io.ReadSeeker, order binary.ByteOrder, ids []int64, dm []bool,
+	path string,
+) error {
+	n := len(ids)
+	var size int32
+	err := binary.Read(rd, order, &size)
+
+	switch {
+	case err == io.EOF:
+		// No stars and no family field.
+		for i := range dm { dm[i] = ids[i] > 0 }
+	case err != nil:
+		return err
+	case int(size) == n:
+		family := make([]int8, n)
+		if err = binary.Read(rd, order, family); err != nil { return err }
+		for i := range dm { dm[i] = family[i] == ramsesDMFamily }
+	case int(size) == 8*n:
+		birth := make([]float64, n)
+		if err = readFloat64AsByte(rd, order, birth); err != nil {
+			return err
+		}
+		for i := range dm { dm[i] = ids[i] > 0 && birth[i] == 0 }
+	default:
+		return fmt.Errorf("The RAMSES file %s has an unrecognized record " +
+			"of %d bytes after its level record.", path, size)
+	}
+
+	return nil
+}
+
+// read reads the requested fields of the dark matter particles in fname
+// into buf's buffers and converts them to Shellfish's units. Positions are
+// stored in xs64.
+func (buf *RAMSESBuffer) read(fname string, fields Field) (int, error) {
+	infoPath, err := ramsesInfoPath(fname)
+	if err != nil { return 0, err }
+	if infoPath != buf.infoPath {
+		if err = readRAMSESInfo(infoPath, &buf.info); err != nil {
+			return 0, err
+		}
+		buf.infoPath = infoPath
+	}
+
+	f, err := Open(fname)
+	if err != nil { return 0, err }
+	defer f.Close()
+
+	var ncpu, ndim, npart int32
+	fortranRead(f, buf.order, &ncpu)
+	fortranRead(f, buf.order, &ndim)
+	fortranRead(f, buf.order, &npart)
+	if ndim != 3 {
+		return 0, fmt.Errorf("The RAMSES file %s has %d dimensions, but " +
+			"Shellfish can only analyze 3D simulations.", fname, ndim)
+	}
+
+	// localseed, nstar_tot, mstar_tot, mstar_lost, and nsink.
+	for i := 0; i < 5; i++ {
+		if err = skipFortranBlock(f, buf.order); err != nil { return 0, err }
+	}
+
+	n := int(npart)
+	buf.f64 = expandFloat64s(buf.f64, n)
+
+	if fields.Has(Positions) {
+		buf.xs64 = expandVectors64(buf.xs64[:0], n)
+		for k := 0; k < 3; k++ {
+			err = readRAMSESFloat64s(f, buf.order, buf.f64, fname)
+			if err != nil { return 0, err }
+			for i := range buf.xs64 { buf.xs64[i][k] = buf.f64[i] }
+		}
+	} else {
+		for k := 0; k < 3; k++ {
+			err = skipFortranBlock(f, buf.order)
+			if err != nil { return 0, err }
+		}
+	}
+
+	if fields.Has(Velocities) {
+		buf.vs = expandVectors(buf.vs[:0], n)
+		for k := 0; k < 3; k++ {
+			err = readRAMSESFloat64s(f, buf.order, buf.f64, fname)
+			if err != nil { return 0, err }
+			for i := range buf.vs { buf.vs[i][k] = float32(buf.f64[i]) }
+		}
+	} else {
+		for k := 0; k < 3; k++ {
+			err = skipFortranBlock(f, buf.order)
+			if err != nil { return 0, err }
+		}
+	}
+
+	if fields.Has(Masses) {
+		buf.ms = expandScalars(buf.ms[:0], n)
+		err = readRAMSESFloat64s(f, buf.order, buf.f64, fname)
+		if err != nil { return 0, err }
+		for i := range buf.ms { buf.ms[i] = float32(buf.f64[i]) }
+	} else if err = skipFortranBlock(f, buf.order); err != nil {
+		return 0, err
+	}
+
+	// IDs are always read, since older files need them to find sinks.
+	buf.ids = expandInts(buf.ids[:0], n)
+	if err = readRAMSESIDs(f, buf.order, buf.ids, fname); err != nil {
+		return 0, err
+	}
+
+	// Levels.
+	if err = skipFortranBlock(f, buf.order); err != nil { return 0, err }
+
+	buf.dm = expandBools(buf.dm, n)
+	err = flagRAMSESDM(f, buf.order, buf.ids, buf.dm, fname)
+	if err != nil { return 0, err }
+
+	// Remove non-DM particles and convert units.
+
+	info := &buf.info
+	xUnit, vUnit := info.lengthUnit(), info.velocityUnit()
+	mUnit := info.massUnit()
+	j := 0
+	for i := 0; i < n; i++ {
+		if !buf.dm[i] { continue }
+
+		if fields.Has(Positions) {
+			for k := 0; k < 3; k++ {
+				x := buf.xs64[i][k]
+				if x < 0 {
+					x += info.boxlen
+				} else if x >= info.boxlen {
+					x -= info.boxlen
+				}
+
+				if math.IsNaN(x) || math.IsInf(x, 0) ||
+					x < 0 || x > info.boxlen {
+					return 0, fmt.Errorf("Corruption detected in the file " +
+						"%s. I can't analyze it.", fname)
+				}
+
+				buf.xs64[j][k] = x * xUnit
+			}
+		}
+		if fields.Has(Velocities) {
+			for k := 0; k < 3; k++ {
+				buf.vs[j][k] = buf.vs[i][k] * float32(vUnit)
+			}
+		}
+		if fields.Has(Masses) { buf.ms[j] = buf.ms[i] * float32(mUnit) }
+		buf.ids[j] = buf.ids[i]
+
+		j++
+	}
+
+	return j, nil
+}
+
+func expandFloat64s(scalars []float64, n int) []float64 {
+	if cap(scalars) >= n { return scalars[:n] }
+	return make([]float64, n)
+}
+
+func expandBools(bools []bool, n int) []bool {
+	if cap(bools) >= n { return bools[:n] }
+	return make([]bool, n)
+}
+
+func (buf *RAMSESBuffer) Read(fname string) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a RAMSES particle file.
+// Positions are stored in double precision and are rounded to float32.
+func (buf *RAMSESBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return readFields32(buf, fname, fields, &buf.xs)
+}
+
+// ReadFields64 reads the requested fields from a RAMSES particle file
+// without losing the precision of the positions.
+func (buf *RAMSESBuffer) ReadFields64(fname string, fields Field) (
+	xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+	buf.open = true
+
+	n, err := buf.read(fname, fields)
+	if err != nil { return nil, nil, nil, nil, err }
+
+	if fields.Has(Positions) { xs = buf.xs64[:n] }
+	if fields.Has(Velocities) { vs = buf.vs[:n] }
+	if fields.Has(Masses) { ms = buf.ms[:n] }
+	if fields.Has(IDs) { ids = buf.ids[:n] }
+
+	return xs, vs, ms, ids, nil
+}
+
+// Float64Positions always returns true, since RAMSES stores positions in
+// double precision.
+func (buf *RAMSESBuffer) Float64Positions(fname string) (bool, error) {
+	return true, nil
+}
+
+func (buf *RAMSESBuffer) Close() {
+	if !buf.open {
+		panic("Buffer not open.")
+	}
+	buf.open = false
+}
+
+func (buf *RAMSESBuffer) IsOpen() bool {
+	return buf.open
+}
+
+func (buf *RAMSESBuffer) ReadHeader(fname string, out *Header) error {
+	xs, _, _, _, err := buf.ReadFields(fname, Positions)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { return err }
+
+	info := &buf.info
+	out.TotalWidth = info.boxlen * info.lengthUnit()
+	out.N = int64(len(xs))
+	out.Origin, out.Width = boundingBox(xs, out.TotalWidth)
+
+	out.Cosmo.Z = 1/info.aexp - 1
+	out.Cosmo.OmegaM = info.omegaM
+	out.Cosmo.OmegaL = info.omegaL
+	out.Cosmo.H100 = info.h0 / 100
+
+	return nil
+}
+
+func (buf *RAMSESBuffer) MinMass() float32 { return buf.mass }
+
+func (buf *RAMSESBuffer) TotalParticles(fname string) (int, error) {
+	return -1, nil
+}
+
+// ReadChunks returns an iterator over the particles in fname. Particles
+// can't be identified as dark matter until the end of a RAMSES file has been
+// read, so the whole file is read into memory first.
+func (buf *RAMSESBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
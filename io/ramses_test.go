@@ -0,0 +1,171 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/phil-mansfield/shellfish/cosmo"
+)
+
+// writeRecord writes data to buf as a Fortran record.
+func writeRecord(buf *bytes.Buffer, order binary.ByteOrder, data interface{}) {
+	binary.Write(buf, order, uint32(binary.Size(data)))
+	binary.Write(buf, order, data)
+	binary.Write(buf, order, uint32(binary.Size(data)))
+}
+
+// ramsesTestParticles are the particles written to a RAMSES test file, in
+// code units.
+type ramsesTestParticles struct {
+	xs, vs [][3]float64
+	ms     []float64
+	ids    interface{}
+	family []int8
+}
+
+// writeRAMSES writes a RAMSES particle file. If family is nil, the file
+// doesn't have a family record.
+func writeRAMSES(
+	t *testing.T, fname string, order binary.ByteOrder, p *ramsesTestParticles,
+) {
+	n := len(p.xs)
+	buf := &bytes.Buffer{}
+	writeRecord(buf, order, int32(1))
+	writeRecord(buf, order, int32(3))
+	writeRecord(buf, order, int32(n))
+	writeRecord(buf, order, make([]int32, 4))
+	writeRecord(buf, order, int32(0))
+	writeRecord(buf, order, float64(0))
+	writeRecord(buf, order, float64(0))
+	writeRecord(buf, order, int32(0))
+
+	for _, vecs := range [][][3]float64{ p.xs, p.vs } {
+		for k := 0; k < 3; k++ {
+			col := make([]float64, n)
+			for i := range col { col[i] = vecs[i][k] }
+			writeRecord(buf, order, col)
+		}
+	}
+	writeRecord(buf, order, p.ms)
+	writeRecord(buf, order, p.ids)
+	writeRecord(buf, order, make([]int32, n))
+	if p.family != nil { writeRecord(buf, order, p.family) }
+
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRAMSES(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_ramses")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	// Code units are chosen so that the box is 100 cMpc/h wide, velocities
+	// are in units of 1000 km/s, and masses are in units of 1e10 Msun/h.
+	unitL := 0.5 * cosmo.MpcMks * 100 * 100
+	unitT := unitL / 1e5 / 1000
+	unitD := 1e10 * cosmo.MSunMks * 1000 / (unitL * unitL * unitL)
+	info := fmt.Sprintf(`ncpu        =          2
+ndim        =          3
+boxlen      =  0.100000000000000E+01
+time        = -0.210000000000000E+01
+aexp        =  0.500000000000000E+00
+H0          =  0.100000000000000E+03
+omega_m     =  0.300000000000000E+00
+omega_l     =  0.700000000000000E+00
+omega_k     =  0.000000000000000E+00
+omega_b     =  0.450000000000000E-01
+unit_l      =  %.15E
+unit_d      =  %.15E
+unit_t      =  %.15E
+`, unitL, unitD, unitT)
+	err = ioutil.WriteFile(filepath.Join(dir, "info_00001.txt"),
+		[]byte(info), 0644)
+	if err != nil { t.Fatal(err.Error()) }
+
+	// The second particle in the first file is a star and the second
+	// particle in the second file is a sink. The first file has 32-bit IDs
+	// and the second has 64-bit IDs.
+	paths := []string{
+		filepath.Join(dir, "part_00001.out00001"),
+		filepath.Join(dir, "part_00001.out00002"),
+	}
+	writeRAMSES(t, paths[0], binary.LittleEndian, &ramsesTestParticles{
+		xs: [][3]float64{ {0.1, 0.2, 0.3}, {0.5, 0.5, 0.5},
+			{0.123456789012, 1.0, -0.25} },
+		vs: [][3]float64{ {1, -1, 0.5}, {0, 0, 0}, {0.25, 0, -2} },
+		ms: []float64{ 2, 1, 2 },
+		ids: []int32{ 1, 2, 3 },
+		family: []int8{ ramsesDMFamily, 2, ramsesDMFamily },
+	})
+	writeRAMSES(t, paths[1], binary.LittleEndian, &ramsesTestParticles{
+		xs: [][3]float64{ {0.9, 0.8, 0.7}, {0.5, 0.5, 0.5} },
+		vs: [][3]float64{ {0, 0, 1}, {0, 0, 0} },
+		ms: []float64{ 1.5, 0.1 },
+		ids: []int64{ 1 << 40, -4 },
+	})
+
+	buf, err := NewRAMSESBuffer(paths, "LittleEndian", Context{})
+	if err != nil { t.Fatal(err.Error()) }
+	if m := buf.MinMass(); !almostEqual(float64(m), 1.5e10, 1e-6) {
+		t.Errorf("Expected a minimum mass of 1.5e10, got %g.", m)
+	}
+
+	f64Buf := buf.(Float64Buffer)
+	xs, vs, ms, ids, err := f64Buf.ReadFields64(paths[0], AllFields)
+	if err != nil { t.Fatal(err.Error()) }
+
+	expXs := [][3]float64{ {10, 20, 30}, {12.3456789012, 0, 75} }
+	expVs := [][3]float32{ {1000, -1000, 500}, {250, 0, -2000} }
+	expMs := []float32{ 2e10, 2e10 }
+	expIDs := []int64{ 1, 3 }
+	if len(xs) != 2 || len(vs) != 2 || len(ms) != 2 || len(ids) != 2 {
+		t.Fatalf("Expected two dark matter particles, got %d %d %d %d.",
+			len(xs), len(vs), len(ms), len(ids))
+	}
+	for i := range xs {
+		for k := 0; k < 3; k++ {
+			if !almostEqual(xs[i][k], expXs[i][k], 1e-12) ||
+				!almostEqual(float64(vs[i][k]), float64(expVs[i][k]), 1e-6) {
+				t.Errorf("Particle %d has position %v and velocity %v, " +
+					"expected %v and %v.", i, xs[i], vs[i], expXs[i], expVs[i])
+			}
+		}
+		if !almostEqual(float64(ms[i]), float64(expMs[i]), 1e-6) ||
+			ids[i] != expIDs[i] {
+			t.Errorf("Particle %d has mass %g and ID %d, expected %g and %d.",
+				i, ms[i], ids[i], expMs[i], expIDs[i])
+		}
+	}
+	buf.Close()
+
+	xs32, _, _, ids, err := buf.ReadFields(paths[1], Positions | IDs)
+	buf.Close()
+	if err != nil { t.Fatal(err.Error()) }
+	if len(xs32) != 1 || ids[0] != 1 << 40 ||
+		!almostEqual(float64(xs32[0][0]), 90, 1e-6) {
+		t.Errorf("Expected the particle %d at 90 cMpc/h, got %v and %v.",
+			int64(1 << 40), ids, xs32)
+	}
+
+	hd := &Header{}
+	if err = buf.ReadHeader(paths[0], hd); err != nil { t.Fatal(err.Error()) }
+	if !almostEqual(hd.TotalWidth, 100, 1e-9) || hd.N != 2 ||
+		hd.Cosmo.Z != 1 || hd.Cosmo.H100 != 1 || hd.Cosmo.OmegaM != 0.3 {
+		t.Errorf("Header was read as %v.", hd)
+	}
+}
+
+// almostEqual returns true if x and y are equal to within a fraction eps of
+// y, or to within eps if y is zero.
+func almostEqual(x, y, eps float64) bool {
+	if y == 0 { return math.Abs(x) <= eps }
+	return math.Abs(x - y) <= eps*math.Abs(y)
+}
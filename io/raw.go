@@ -0,0 +1,337 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// RawField describes where a particle field is stored in a raw binary file.
+// The field of the i-th particle starts Offset + i*Stride bytes after the
+// end of the header. A Stride of zero means that the field is stored as a
+// contiguous array. Stored values are multiplied by Units. A Type of "none"
+// means that the field isn't in the file.
+type RawField struct {
+	Type           string
+	Offset, Stride int64
+	Units          float64
+}
+
+// RawTypeSizes gives the size in bytes of each element type that can be used
+// in a raw binary file.
+var RawTypeSizes = map[string]int64{
+	"float32": 4, "float64": 8,
+	"int32": 4, "int64": 8,
+	"uint32": 4, "uint64": 8,
+}
+
+// stride returns the number of bytes between consecutive particles for a
+// field with dim elements.
+func (field *RawField) stride(dim int64) int64 {
+	if field.Stride > 0 { return field.Stride }
+	return RawTypeSizes[field.Type] * dim
+}
+
+// RawBuffer reads particles from binary files whose layout is described in
+// the config file. This allows simple one-off formats to be read without
+// writing a new VectorBuffer.
+type RawBuffer struct {
+	open    bool
+	order   binary.ByteOrder
+	context Context
+	mass    float32
+
+	bytes  []byte
+	xs, vs [][3]float32
+	xs64   [][3]float64
+	vs64   [][3]float64
+	ms     []float32
+	ids    []int64
+}
+
+func NewRawBuffer(
+	paths []string, orderFlag string, context Context,
+) (VectorBuffer, error) {
+	buf := &RawBuffer{ order: flagToOrder(orderFlag), context: context }
+
+	if context.RawMasses.Type == "none" {
+		buf.mass = float32(context.RawMass)
+		return buf, nil
+	}
+
+	var err error
+	buf.mass, err = minMass(buf, paths)
+	if err != nil { return nil, err }
+
+	return buf, nil
+}
+
+// rawFloat decodes a single element of type typ.
+func rawFloat(b []byte, typ string, order binary.ByteOrder) float64 {
+	switch typ {
+	case "float32": return float64(math.Float32frombits(order.Uint32(b)))
+	case "float64": return math.Float64frombits(order.Uint64(b))
+	}
+	return float64(rawInt(b, typ, order))
+}
+
+// rawInt decodes a single element of type typ.
+func rawInt(b []byte, typ string, order binary.ByteOrder) int64 {
+	switch typ {
+	case "int32": return int64(int32(order.Uint32(b)))
+	case "int64": return int64(order.Uint64(b))
+	case "uint32": return int64(order.Uint32(b))
+	case "uint64": return int64(order.Uint64(b))
+	}
+	panic(fmt.Sprintf("Unknown raw element type '%s'.", typ))
+}
+
+// isRawIntType returns true if typ is one of the integer element types.
+func isRawIntType(typ string) bool {
+	switch typ {
+	case "int32", "int64", "uint32", "uint64": return true
+	}
+	return false
+}
+
+// count returns the number of particles in f. If the header doesn't contain
+// the count, it's the largest number of particles which fits in the file.
+func (buf *RawBuffer) count(f File, fname string) (int64, error) {
+	ctx := &buf.context
+	if ctx.RawCountOffset >= 0 {
+		b := make([]byte, RawTypeSizes[ctx.RawCountType])
+		if _, err := f.ReadAt(b, ctx.RawCountOffset); err != nil {
+			return 0, err
+		}
+		return rawInt(b, ctx.RawCountType, buf.order), nil
+	}
+
+	info, err := f.Stat()
+	if err != nil { return 0, err }
+	body := info.Size() - ctx.RawHeaderSize
+
+	n := int64(-1)
+	for _, field := range []struct{ *RawField; dim int64 }{
+		{&ctx.RawPositions, 3}, {&ctx.RawVelocities, 3},
+		{&ctx.RawMasses, 1}, {&ctx.RawIDs, 1},
+	} {
+		if field.Type == "none" { continue }
+
+		end := field.Offset + RawTypeSizes[field.Type]*field.dim
+		nField := int64(0)
+		if body >= end { nField = (body - end) / field.stride(field.dim) + 1 }
+		if n == -1 || nField < n { n = nField }
+	}
+
+	if n == -1 {
+		return 0, fmt.Errorf("No fields are stored in %s.", fname)
+	}
+	return n, nil
+}
+
+// readField reads the bytes spanned by a field of n particles into buf.bytes.
+func (buf *RawBuffer) readField(
+	f File, field *RawField, dim, n int64,
+) error {
+	span := int64(0)
+	if n > 0 {
+		span = (n - 1)*field.stride(dim) + RawTypeSizes[field.Type]*dim
+	}
+	if int64(cap(buf.bytes)) < span { buf.bytes = make([]byte, span) }
+	buf.bytes = buf.bytes[:span]
+
+	_, err := f.ReadAt(buf.bytes, buf.context.RawHeaderSize + field.Offset)
+	return err
+}
+
+// readVectors reads a three-dimensional field into out.
+func (buf *RawBuffer) readVectors(
+	f File, field *RawField, out [][3]float64,
+) error {
+	if err := buf.readField(f, field, 3, int64(len(out))); err != nil {
+		return err
+	}
+
+	stride, size := field.stride(3), RawTypeSizes[field.Type]
+	for i := range out {
+		b := buf.bytes[int64(i)*stride:]
+		for k := int64(0); k < 3; k++ {
+			out[i][k] = rawFloat(b[k*size:], field.Type, buf.order) *
+				field.Units
+		}
+	}
+	return nil
+}
+
+func (buf *RawBuffer) Read(fname string) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields from a raw binary file. Positions
+// are rounded to float32.
+func (buf *RawBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return readFields32(buf, fname, fields, &buf.xs)
+}
+
+// ReadFields64 reads the requested fields from a raw binary file without
+// losing the precision of double-precision positions. Fields which aren't
+// stored in the file are given default values: masses are set to RawMass and
+// IDs are set to each particle's index in the file. Velocities don't have a
+// default, so asking for them when RawVelocityType = none is an error.
+func (buf *RawBuffer) ReadFields64(fname string, fields Field) (
+	xs [][3]float64, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+	if fields.Has(Velocities) && buf.context.RawVelocities.Type == "none" {
+		return nil, nil, nil, nil, fmt.Errorf("Velocities are needed, but "+
+			"RawVelocityType = none, so %s doesn't have any.", fname)
+	}
+	buf.open = true
+
+	f, err := Open(fname)
+	if err != nil { return nil, nil, nil, nil, err }
+	defer f.Close()
+
+	ctx := &buf.context
+	n64, err := buf.count(f, fname)
+	if err != nil { return nil, nil, nil, nil, err }
+	n := int(n64)
+
+	if fields.Has(Positions) {
+		buf.xs64 = expandVectors64(buf.xs64[:0], n)
+		err = buf.readVectors(f, &ctx.RawPositions, buf.xs64)
+		if err != nil { return nil, nil, nil, nil, err }
+
+		tw := ctx.RawTotalWidth
+		for i := range buf.xs64 {
+			for k := 0; k < 3; k++ {
+				x := math.Mod(buf.xs64[i][k], tw)
+				if x < 0 { x += tw }
+				buf.xs64[i][k] = x
+			}
+		}
+		xs = buf.xs64
+	}
+
+	if fields.Has(Velocities) {
+		buf.vs64 = expandVectors64(buf.vs64[:0], n)
+		err = buf.readVectors(f, &ctx.RawVelocities, buf.vs64)
+		if err != nil { return nil, nil, nil, nil, err }
+
+		buf.vs = roundVectors(buf.vs, buf.vs64)
+		vs = buf.vs
+	}
+
+	if fields.Has(Masses) {
+		buf.ms = expandScalars(buf.ms[:0], n)
+		field := &ctx.RawMasses
+		if field.Type == "none" {
+			for i := range buf.ms { buf.ms[i] = float32(ctx.RawMass) }
+		} else {
+			if err = buf.readField(f, field, 1, n64); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			stride := field.stride(1)
+			for i := range buf.ms {
+				b := buf.bytes[int64(i)*stride:]
+				buf.ms[i] = float32(rawFloat(b, field.Type, buf.order) *
+					field.Units)
+			}
+		}
+		ms = buf.ms
+	}
+
+	if fields.Has(IDs) {
+		buf.ids = expandInts(buf.ids[:0], n)
+		field := &ctx.RawIDs
+		if field.Type == "none" {
+			for i := range buf.ids { buf.ids[i] = int64(i) }
+		} else {
+			if err = buf.readField(f, field, 1, n64); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			stride := field.stride(1)
+			for i := range buf.ids {
+				buf.ids[i] = rawInt(
+					buf.bytes[int64(i)*stride:], field.Type, buf.order,
+				)
+			}
+		}
+		ids = buf.ids
+	}
+
+	return xs, vs, ms, ids, nil
+}
+
+// Float64Positions returns true if RawPositionType is float64.
+func (buf *RawBuffer) Float64Positions(fname string) (bool, error) {
+	return buf.context.RawPositions.Type == "float64", nil
+}
+
+// StoredFields returns the fields stored in fname. Masses and IDs are always
+// available, since they have default values.
+func (buf *RawBuffer) StoredFields(fname string) (Field, error) {
+	if buf.context.RawVelocities.Type == "none" {
+		return AllFields &^ Velocities, nil
+	}
+	return AllFields, nil
+}
+
+func (buf *RawBuffer) Close() {
+	if !buf.open {
+		panic("Buffer not open.")
+	}
+	buf.open = false
+}
+
+func (buf *RawBuffer) IsOpen() bool {
+	return buf.open
+}
+
+func (buf *RawBuffer) ReadHeader(fname string, out *Header) error {
+	ctx := &buf.context
+
+	a := ctx.RawScaleFactor
+	if ctx.RawScaleFactorOffset >= 0 {
+		f, err := Open(fname)
+		if err != nil { return err }
+		b := make([]byte, RawTypeSizes[ctx.RawScaleFactorType])
+		_, err = f.ReadAt(b, ctx.RawScaleFactorOffset)
+		f.Close()
+		if err != nil { return err }
+		a = rawFloat(b, ctx.RawScaleFactorType, buf.order)
+	}
+
+	xs, _, _, _, err := buf.ReadFields(fname, Positions)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { return err }
+
+	out.TotalWidth = ctx.RawTotalWidth
+	out.N = int64(len(xs))
+	out.Origin, out.Width = boundingBox(xs, out.TotalWidth)
+
+	out.Cosmo.Z = 1/a - 1
+	out.Cosmo.OmegaM = ctx.RawOmegaM
+	out.Cosmo.OmegaL = ctx.RawOmegaL
+	out.Cosmo.H100 = ctx.RawH100
+
+	return nil
+}
+
+func (buf *RawBuffer) MinMass() float32 { return buf.mass }
+
+func (buf *RawBuffer) TotalParticles(fname string) (int, error) {
+	return -1, nil
+}
+
+func (buf *RawBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
@@ -0,0 +1,162 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rawTestParticle is the particle struct written to interleaved raw test
+// files.
+type rawTestParticle struct {
+	X  [3]float64
+	V  [3]float32
+	M  float32
+	ID uint32
+}
+
+// writeRawFile writes a header followed by data to fname.
+func writeRawFile(
+	t *testing.T, fname string, order binary.ByteOrder, hd, data interface{},
+) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, order, hd)
+	binary.Write(buf, order, data)
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRawInterleaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_raw")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	// Files start with an int64 particle count and a float64 scale factor.
+	// Positions are in kpc/h and velocities are in units of 2 km/s. The
+	// lightest particle is in the second file.
+	type header struct {
+		N int64
+		A float64
+	}
+	paths := []string{
+		filepath.Join(dir, "block.0"), filepath.Join(dir, "block.1"),
+	}
+	writeRawFile(t, paths[0], binary.BigEndian, &header{3, 0.25},
+		[]rawTestParticle{
+			{[3]float64{1000, 2000, 3000}, [3]float32{1, 2, 3}, 4, 7},
+			{[3]float64{-1000, 100000, 12345.6789012}, [3]float32{}, 2, 8},
+			{[3]float64{}, [3]float32{}, 2, 9},
+		})
+	writeRawFile(t, paths[1], binary.BigEndian, &header{1, 0.25},
+		[]rawTestParticle{ {[3]float64{}, [3]float32{}, 0.5, 10} })
+
+	context := Context{
+		RawHeaderSize: 16, RawCountOffset: 0, RawCountType: "int64",
+		RawScaleFactorOffset: 8, RawScaleFactorType: "float64",
+		RawTotalWidth: 100, RawOmegaM: 0.3, RawOmegaL: 0.7, RawH100: 0.7,
+		RawPositions: RawField{ "float64", 0, 44, 1e-3 },
+		RawVelocities: RawField{ "float32", 24, 44, 2 },
+		RawMasses: RawField{ "float32", 36, 44, 1e10 },
+		RawIDs: RawField{ "uint32", 40, 44, 1 },
+	}
+	buf, err := NewRawBuffer(paths, "BigEndian", context)
+	if err != nil { t.Fatal(err.Error()) }
+	if m := buf.MinMass(); m != 0.5e10 {
+		t.Errorf("Expected a minimum mass of 5e9, got %g.", m)
+	}
+
+	xs, vs, ms, ids, err := buf.(Float64Buffer).ReadFields64(
+		paths[0], AllFields,
+	)
+	if err != nil { t.Fatal(err.Error()) }
+
+	// Positions outside the box are wrapped back into it.
+	expXs := [][3]float64{ {1, 2, 3}, {99, 0, 12.3456789012}, {0, 0, 0} }
+	expVs := [][3]float32{ {2, 4, 6}, {0, 0, 0}, {0, 0, 0} }
+	expMs := []float32{ 4e10, 2e10, 2e10 }
+	expIDs := []int64{ 7, 8, 9 }
+	if len(xs) != 3 || len(vs) != 3 || len(ms) != 3 || len(ids) != 3 {
+		t.Fatalf("Expected three particles, got %d %d %d %d.",
+			len(xs), len(vs), len(ms), len(ids))
+	}
+	for i := range xs {
+		for k := 0; k < 3; k++ {
+			if !almostEqual(xs[i][k], expXs[i][k], 1e-12) ||
+				vs[i][k] != expVs[i][k] {
+				t.Errorf("Particle %d has position %v and velocity %v, " +
+					"expected %v and %v.", i, xs[i], vs[i], expXs[i], expVs[i])
+			}
+		}
+		if ms[i] != expMs[i] || ids[i] != expIDs[i] {
+			t.Errorf("Particle %d has mass %g and ID %d, expected %g and %d.",
+				i, ms[i], ids[i], expMs[i], expIDs[i])
+		}
+	}
+	buf.Close()
+
+	hd := &Header{}
+	if err = buf.ReadHeader(paths[0], hd); err != nil { t.Fatal(err.Error()) }
+	if hd.TotalWidth != 100 || hd.N != 3 || hd.Cosmo.Z != 3 ||
+		hd.Cosmo.H100 != 0.7 {
+		t.Errorf("Header was read as %v.", hd)
+	}
+}
+
+func TestRawContiguous(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_raw")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	// The file is an 8-byte header followed by an array of float32
+	// positions. The count isn't stored, so it comes from the file size.
+	// Masses, velocities, and IDs aren't stored.
+	fname := filepath.Join(dir, "block.0")
+	writeRawFile(t, fname, binary.LittleEndian, int64(0), []float32{
+		1, 2, 3, 4, 5, 6, 101, -1, 50,
+	})
+
+	context := Context{
+		RawHeaderSize: 8, RawCountOffset: -1, RawScaleFactorOffset: -1,
+		RawScaleFactor: 1, RawTotalWidth: 100, RawMass: 3,
+		RawPositions: RawField{ "float32", 0, 0, 1 },
+		RawVelocities: RawField{ Type: "none" },
+		RawMasses: RawField{ Type: "none" },
+		RawIDs: RawField{ Type: "none" },
+	}
+	buf, err := NewRawBuffer([]string{fname}, "LittleEndian", context)
+	if err != nil { t.Fatal(err.Error()) }
+	if buf.MinMass() != 3 {
+		t.Errorf("Expected a minimum mass of 3, got %g.", buf.MinMass())
+	}
+
+	fields, err := buf.(OptionalFieldBuffer).StoredFields(fname)
+	if err != nil { t.Fatal(err.Error()) }
+	if fields != Positions | Masses | IDs {
+		t.Errorf("Expected the stored fields to be %s, got %s.",
+			Positions | Masses | IDs, fields)
+	}
+
+	_, _, _, _, err = buf.ReadFields(fname, Velocities)
+	if err == nil { t.Errorf("Expected an error when reading velocities.") }
+
+	xs, _, ms, ids, err := buf.ReadFields(fname, Positions | Masses | IDs)
+	buf.Close()
+	if err != nil { t.Fatal(err.Error()) }
+
+	expXs := [][3]float32{ {1, 2, 3}, {4, 5, 6}, {1, 99, 50} }
+	if len(xs) != 3 || len(ms) != 3 || len(ids) != 3 {
+		t.Fatalf("Expected three particles, got %d %d %d.",
+			len(xs), len(ms), len(ids))
+	}
+	for i := range xs {
+		if xs[i] != expXs[i] || ms[i] != 3 || ids[i] != int64(i) {
+			t.Errorf("Particle %d has position %v, mass %g, and ID %d, " +
+				"expected %v, 3, and %d.", i, xs[i], ms[i], ids[i],
+				expXs[i], i)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/phil-mansfield/shellfish/cosmo"
+)
+
+// tipsyHeader is the header of a TIPSY file. Files written in XDR format
+// (the default for PKDGRAV and ChaNGa) pad this to 32 bytes, but some native
+// files don't.
+type tipsyHeader struct {
+	Time                     float64
+	NBodies, NDim            int32
+	NSph, NDark, NStar       int32
+}
+
+// Sizes of the gas, dark, and star particle structs in float32s. Each starts
+// with the mass, position, and velocity of the particle.
+var tipsyWords = [3]int{ 12, 9, 11 }
+
+// Particle types, in the order they appear in TIPSY files.
+const (
+	tipsyGas = iota
+	tipsyDark
+	tipsyStar
+)
+
+// TipsyBuffer reads particles from TIPSY binaries. TIPSY files don't store
+// the box size or cosmology, so these are given in the config file. Units
+// are assumed to be the standard PKDGRAV/ChaNGa cosmological units: G = 1,
+// the box has width 1 and is centered on the origin, and the critical
+// density is 1.
+type TipsyBuffer struct {
+	open    bool
+	context Context
+	types   [3]bool
+	mass    float32
+
+	raw    []float32
+	xs, vs [][3]float32
+	ms     []float32
+	ids    []int64
+}
+
+func NewTipsyBuffer(paths []string, context Context) (VectorBuffer, error) {
+	buf := &TipsyBuffer{ context: context }
+	for _, typ := range context.TipsyParticleTypes {
+		switch typ {
+		case "gas": buf.types[tipsyGas] = true
+		case "dark": buf.types[tipsyDark] = true
+		case "star": buf.types[tipsyStar] = true
+		default:
+			return nil, fmt.Errorf("Unrecognized TIPSY particle type '%s'.",
+				typ)
+		}
+	}
+
+	var err error
+	buf.mass, err = minMass(buf, paths)
+	if err != nil { return nil, err }
+
+	return buf, nil
+}
+
+// readTipsyHeader reads the header of a TIPSY file. The byte order and the
+// size of the header are found by checking which of them are consistent with
+// the file.
+func readTipsyHeader(
+	f File, path string,
+) (hd *tipsyHeader, order binary.ByteOrder, size int64, err error) {
+	info, err := f.Stat()
+	if err != nil { return nil, nil, 0, err }
+
+	for _, order = range []binary.ByteOrder{
+		binary.BigEndian, binary.LittleEndian,
+	} {
+		hd = &tipsyHeader{}
+		if _, err = f.Seek(0, 0); err != nil { return nil, nil, 0, err }
+		if err = binary.Read(f, order, hd); err != nil {
+			return nil, nil, 0, err
+		}
+		if hd.NDim != 3 || hd.NBodies != hd.NSph + hd.NDark + hd.NStar {
+			continue
+		}
+
+		body := 4 * (int64(tipsyWords[tipsyGas])*int64(hd.NSph) +
+			int64(tipsyWords[tipsyDark])*int64(hd.NDark) +
+			int64(tipsyWords[tipsyStar])*int64(hd.NStar))
+		size = info.Size() - body
+		if size == 28 || size == 32 { return hd, order, size, nil }
+	}
+
+	return nil, nil, 0, fmt.Errorf("%s is not a 3D TIPSY file.", path)
+}
+
+func (buf *TipsyBuffer) Read(fname string) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	return buf.ReadFields(fname, AllFields)
+}
+
+// ReadFields reads the requested fields of the particle types listed in
+// TipsyParticleTypes. TIPSY files don't contain IDs, so particles are
+// given their index in the file as an ID.
+func (buf *TipsyBuffer) ReadFields(fname string, fields Field) (
+	xs, vs [][3]float32, ms []float32, ids []int64, err error,
+) {
+	if buf.open {
+		panic("Buffer already open.")
+	}
+	buf.open = true
+
+	f, err := Open(fname)
+	if err != nil { return nil, nil, nil, nil, err }
+	defer f.Close()
+
+	hd, order, offset, err := readTipsyHeader(f, fname)
+	if err != nil { return nil, nil, nil, nil, err }
+
+	ctx := &buf.context
+	a := hd.Time
+	width := ctx.TipsyTotalWidth
+	mUnit := cosmo.RhoCritical(
+		ctx.TipsyH100*100, ctx.TipsyOmegaM, ctx.TipsyOmegaL, 0,
+	) * width*width*width
+	vUnit := width * 100 / math.Sqrt(8*math.Pi/3) / a
+
+	counts := [3]int32{ hd.NSph, hd.NDark, hd.NStar }
+	buf.xs, buf.vs = buf.xs[:0], buf.vs[:0]
+	buf.ms, buf.ids = buf.ms[:0], buf.ids[:0]
+
+	firstID := int64(0)
+	for typ := range counts {
+		n, words := int(counts[typ]), tipsyWords[typ]
+		start, id0 := offset, firstID
+
+		offset += 4 * int64(words) * int64(n)
+		firstID += int64(n)
+		if !buf.types[typ] || n == 0 { continue }
+
+		if _, err = f.Seek(start, 0); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		buf.raw = expandScalars(buf.raw[:0], n*words)
+		if err = readFloat32AsByte(f, order, buf.raw); err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		for i := 0; i < n; i++ {
+			p := buf.raw[i*words: (i + 1)*words]
+			if fields.Has(Positions) {
+				var x [3]float32
+				for k := 0; k < 3; k++ {
+					x[k] = float32((float64(p[1 + k]) + 0.5) * width)
+					if x[k] < 0 {
+						x[k] += float32(width)
+					} else if x[k] >= float32(width) {
+						x[k] -= float32(width)
+					}
+				}
+				buf.xs = append(buf.xs, x)
+			}
+			if fields.Has(Velocities) {
+				buf.vs = append(buf.vs, [3]float32{
+					float32(float64(p[4]) * vUnit),
+					float32(float64(p[5]) * vUnit),
+					float32(float64(p[6]) * vUnit),
+				})
+			}
+			if fields.Has(Masses) {
+				buf.ms = append(buf.ms, float32(float64(p[0]) * mUnit))
+			}
+			if fields.Has(IDs) {
+				buf.ids = append(buf.ids, id0 + int64(i))
+			}
+		}
+	}
+
+	if fields.Has(Positions) { xs = buf.xs }
+	if fields.Has(Velocities) { vs = buf.vs }
+	if fields.Has(Masses) { ms = buf.ms }
+	if fields.Has(IDs) { ids = buf.ids }
+
+	return xs, vs, ms, ids, nil
+}
+
+func (buf *TipsyBuffer) Close() {
+	if !buf.open {
+		panic("Buffer not open.")
+	}
+	buf.open = false
+}
+
+func (buf *TipsyBuffer) IsOpen() bool {
+	return buf.open
+}
+
+func (buf *TipsyBuffer) ReadHeader(fname string, out *Header) error {
+	f, err := Open(fname)
+	if err != nil { return err }
+	hd, _, _, err := readTipsyHeader(f, fname)
+	f.Close()
+	if err != nil { return err }
+
+	xs, _, _, _, err := buf.ReadFields(fname, Positions)
+	if buf.IsOpen() { buf.Close() }
+	if err != nil { return err }
+
+	ctx := &buf.context
+	out.TotalWidth = ctx.TipsyTotalWidth
+	out.N = int64(len(xs))
+	out.Origin, out.Width = boundingBox(xs, out.TotalWidth)
+
+	out.Cosmo.Z = 1/hd.Time - 1
+	out.Cosmo.OmegaM = ctx.TipsyOmegaM
+	out.Cosmo.OmegaL = ctx.TipsyOmegaL
+	out.Cosmo.H100 = ctx.TipsyH100
+
+	return nil
+}
+
+func (buf *TipsyBuffer) MinMass() float32 { return buf.mass }
+
+func (buf *TipsyBuffer) TotalParticles(fname string) (int, error) {
+	return -1, nil
+}
+
+func (buf *TipsyBuffer) ReadChunks(
+	fname string, fields Field, chunkSize int,
+) (ChunkIterator, error) {
+	return newSliceIterator(buf, fname, fields, chunkSize)
+}
@@ -0,0 +1,134 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTipsy writes a TIPSY file with a padded 32-byte header. ps are the
+// raw particle structs of each type, in code units.
+func writeTipsy(
+	t *testing.T, fname string, order binary.ByteOrder, a float64,
+	ps [3][][]float32,
+) {
+	hd := &tipsyHeader{
+		Time: a, NDim: 3, NSph: int32(len(ps[tipsyGas])),
+		NDark: int32(len(ps[tipsyDark])), NStar: int32(len(ps[tipsyStar])),
+	}
+	hd.NBodies = hd.NSph + hd.NDark + hd.NStar
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, order, hd)
+	binary.Write(buf, order, int32(0))
+	for typ := range ps {
+		for _, p := range ps[typ] {
+			if len(p) != tipsyWords[typ] {
+				t.Fatalf("Type %d particle has %d words.", typ, len(p))
+			}
+			binary.Write(buf, order, p)
+		}
+	}
+
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// tipsyParticle returns the raw struct of a TIPSY particle with the given
+// mass, position, and velocity and the given number of words.
+func tipsyParticle(m float32, x, v [3]float32, words int) []float32 {
+	p := make([]float32, words)
+	p[0] = m
+	copy(p[1:4], x[:])
+	copy(p[4:7], v[:])
+	return p
+}
+
+func TestTipsy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shellfish_tipsy")
+	if err != nil { t.Fatal(err.Error()) }
+	defer os.RemoveAll(dir)
+
+	gas, dark, star := tipsyWords[tipsyGas], tipsyWords[tipsyDark],
+		tipsyWords[tipsyStar]
+	paths := []string{
+		filepath.Join(dir, "snap.0"), filepath.Join(dir, "snap.1"),
+	}
+
+	// The second file holds the lightest dark matter particle and is
+	// little-endian.
+	a := 0.5
+	writeTipsy(t, paths[0], binary.BigEndian, a, [3][][]float32{
+		{ tipsyParticle(1e-9, [3]float32{0, 0, 0}, [3]float32{}, gas) },
+		{
+			tipsyParticle(2e-6, [3]float32{-0.5, 0, 0.25},
+				[3]float32{1, 0, -1}, dark),
+			tipsyParticle(2e-6, [3]float32{0.4, -0.3, 0.5},
+				[3]float32{0, 0.5, 0}, dark),
+		},
+		{ tipsyParticle(4e-6, [3]float32{0.1, 0.1, 0.1}, [3]float32{}, star) },
+	})
+	writeTipsy(t, paths[1], binary.LittleEndian, a, [3][][]float32{
+		nil,
+		{ tipsyParticle(1e-6, [3]float32{0, 0, 0}, [3]float32{}, dark) },
+		nil,
+	})
+
+	context := Context{
+		TipsyTotalWidth: 100, TipsyOmegaM: 0.3, TipsyOmegaL: 0.7,
+		TipsyH100: 0.7, TipsyParticleTypes: []string{"dark", "star"},
+	}
+	buf, err := NewTipsyBuffer(paths, context)
+	if err != nil { t.Fatal(err.Error()) }
+
+	// The critical density is 2.775e11 h^2 Msun/Mpc^3 and the box is 100
+	// cMpc/h wide, so the mass unit is 2.775e17 Msun/h.
+	mUnit := 2.775e11 * 1e6
+	vUnit := 100 * 100 / math.Sqrt(8*math.Pi/3) / a
+	if m := buf.MinMass(); !almostEqual(float64(m), 1e-6*mUnit, 1e-3) {
+		t.Errorf("Expected a minimum mass of %g, got %g.", 1e-6*mUnit, m)
+	}
+
+	xs, vs, ms, ids, err := buf.Read(paths[0])
+	buf.Close()
+	if err != nil { t.Fatal(err.Error()) }
+
+	expXs := [][3]float32{ {0, 50, 75}, {90, 20, 0}, {60, 60, 60} }
+	expVs := [][3]float64{ {vUnit, 0, -vUnit}, {0, vUnit/2, 0}, {0, 0, 0} }
+	expMs := []float64{ 2e-6*mUnit, 2e-6*mUnit, 4e-6*mUnit }
+	expIDs := []int64{ 1, 2, 3 }
+	if len(xs) != 3 || len(vs) != 3 || len(ms) != 3 || len(ids) != 3 {
+		t.Fatalf("Expected three dark matter and star particles, got " +
+			"%d %d %d %d.", len(xs), len(vs), len(ms), len(ids))
+	}
+	for i := range xs {
+		for k := 0; k < 3; k++ {
+			if !almostEqual(float64(xs[i][k]), float64(expXs[i][k]), 1e-5) ||
+				!almostEqual(float64(vs[i][k]), expVs[i][k], 1e-5) {
+				t.Errorf("Particle %d has position %v and velocity %v, " +
+					"expected %v and %v.", i, xs[i], vs[i], expXs[i], expVs[i])
+			}
+		}
+		if !almostEqual(float64(ms[i]), expMs[i], 1e-3) || ids[i] != expIDs[i] {
+			t.Errorf("Particle %d has mass %g and ID %d, expected %g and %d.",
+				i, ms[i], ids[i], expMs[i], expIDs[i])
+		}
+	}
+
+	hd := &Header{}
+	if err = buf.ReadHeader(paths[0], hd); err != nil { t.Fatal(err.Error()) }
+	if hd.TotalWidth != 100 || hd.N != 3 || hd.Cosmo.Z != 1 ||
+		hd.Cosmo.H100 != 0.7 {
+		t.Errorf("Header was read as %v.", hd)
+	}
+
+	context.TipsyParticleTypes = []string{"dm"}
+	if _, err = NewTipsyBuffer(paths, context); err == nil {
+		t.Errorf("Expected an error for an unrecognized particle type.")
+	}
+}
@@ -3,11 +3,10 @@ package main
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"strings"
-	"time"
 
 	"github.com/phil-mansfield/shellfish/los/analyze"
+	"github.com/phil-mansfield/shellfish/math/rand"
 )
 
 const (
@@ -38,7 +37,7 @@ func formatGrid(nx, ny int, vals []float64) string {
 }
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	gen := rand.NewTimeSeed(rand.Xorshift)
 
 	aLow, aHigh := 0.2, 1.0
 	bLow, bHigh := 0.2, 1.0
@@ -63,7 +62,7 @@ func main() {
 			}
 
 			shell := ellipsoid(1, a, b)
-			oc, ob, oa := shell.Axes(samples)
+			oc, ob, oa, _ := shell.Axes(samples, gen)
 
 
 
@@ -3,8 +3,6 @@ package los
 import (
 	"math/rand"
 	"testing"
-
-	"github.com/phil-mansfield/shellfish/los/geom"
 )
 
 func BenchmarkHalfAngularWidth(b *testing.B) {
@@ -25,17 +23,17 @@ func BenchmarkOneValIntrDist(b *testing.B) {
 	}
 }
 
-func randomNorms(n int) []geom.Vec {
-	norms := make([]geom.Vec, n)
+func randomNorms(n int) [][3]float32 {
+	norms := make([][3]float32, n)
 	for i := range norms {
 		x := float32(rand.Float64()*2 - 1)
 		y := float32(rand.Float64()*2 - 1)
 		z := float32(rand.Float64()*2 - 1)
 		sum := x + y + z
 		if sum == 0 {
-			norms[i] = geom.Vec{0, 0, 1}
+			norms[i] = [3]float32{0, 0, 1}
 		} else {
-			norms[i] = geom.Vec{x, y, z}
+			norms[i] = [3]float32{x, y, z}
 		}
 	}
 	return norms
@@ -48,7 +46,7 @@ func BenchmarkSphereIntersectHalo100(b *testing.B) {
 	h.Init(norms, [3]float64{0, 0, 0}, 1, 2, 1, 1, 0)
 
 	b.ResetTimer()
-	v := geom.Vec{0, 0, 0.5}
+	v := [3]float32{0, 0, 0.5}
 	for i := 0; i < b.N; i++ {
 		for ring := 0; ring < rings; ring++ {
 			h.sphereIntersectRing(v, 0.1, ring)
@@ -3,8 +3,6 @@ package los
 import (
 	"math"
 	"testing"
-
-	"github.com/phil-mansfield/shellfish/los/geom"
 )
 
 func almostEq(x, y float64) bool {
@@ -71,7 +69,7 @@ func TestOneValIntrDist(t *testing.T) {
 }
 
 func TestIdxRange(t *testing.T) {
-	norms := []geom.Vec{{0, 0, 1}}
+	norms := [][3]float32{{0, 0, 1}}
 	origin := [3]float64{0, 0, 0}
 	rMin, rMax := 1.0, 2.0
 	bins, n := 10, 12
@@ -103,25 +101,25 @@ func TestIdxRange(t *testing.T) {
 
 func TestSphereIntersectRing(t *testing.T) {
 	tests := []struct {
-		ringNorm, c geom.Vec
+		ringNorm, c [3]float32
 		r           float64
 
 		res bool
 	}{
-		{geom.Vec{0, 0, 1}, geom.Vec{0, 0, 2}, 1, false},
-		{geom.Vec{0, 0, 1}, geom.Vec{0, 0, 1}, 1, false},
-		{geom.Vec{0, 0, 1}, geom.Vec{0, 0, 0.5}, 1, true},
+		{[3]float32{0, 0, 1}, [3]float32{0, 0, 2}, 1, false},
+		{[3]float32{0, 0, 1}, [3]float32{0, 0, 1}, 1, false},
+		{[3]float32{0, 0, 1}, [3]float32{0, 0, 0.5}, 1, true},
 
-		{geom.Vec{0, 1, 0}, geom.Vec{0, 2, 0}, 1, false},
-		{geom.Vec{0, 1, 0}, geom.Vec{0, 0.5, 0}, 1, true},
+		{[3]float32{0, 1, 0}, [3]float32{0, 2, 0}, 1, false},
+		{[3]float32{0, 1, 0}, [3]float32{0, 0.5, 0}, 1, true},
 
-		{geom.Vec{1, 0, 0}, geom.Vec{2, 0, 0}, 1, false},
-		{geom.Vec{1, 0, 0}, geom.Vec{0.5, 0, 0}, 1, true},
+		{[3]float32{1, 0, 0}, [3]float32{2, 0, 0}, 1, false},
+		{[3]float32{1, 0, 0}, [3]float32{0.5, 0, 0}, 1, true},
 	}
 
 	for i, test := range tests {
 		h := Halo{}
-		norms := []geom.Vec{test.ringNorm}
+		norms := [][3]float32{test.ringNorm}
 		h.Init(norms, [3]float64{0, 0, 0}, 1, 2, 1, 1, 0)
 		res := h.sphereIntersectRing(test.c, test.r, 0)
 		if res != test.res {
@@ -163,6 +163,25 @@ func (h *Halo) Intersect(vecs [][3]float32, r float64, intr []bool) {
 	}
 }
 
+// IntersectLocal is identical to Intersect, except that the vectors must
+// already be in halo-centric coordinates (see LocalCoords).
+func (h *Halo) IntersectLocal(vecs [][3]float32, r float64, intr []bool) {
+	rMin, rMax := h.rMin-r, h.rMax+r
+	if rMin < 0 {
+		rMin = 0
+	}
+	rMin2, rMax2 := float32(rMin*rMin), float32(rMax*rMax)
+
+	if len(intr) != len(vecs) {
+		panic("len(intr) != len(vecs)")
+	}
+
+	for i, vec := range vecs {
+		r2 := vec[0]*vec[0] + vec[1]*vec[1] + vec[2]*vec[2]
+		intr[i] = r2 > rMin2 && r2 < rMax2
+	}
+}
+
 // Transform translates all the given vectors so that they are in the local
 // coordinate system of the halo.
 func (h *Halo) Transform(vecs [][3]float32, totalWidth float64) {
@@ -196,6 +215,31 @@ func (h *Halo) Transform(vecs [][3]float32, totalWidth float64) {
 	}
 }
 
+// LocalCoords writes the halo-centric coordinates of vecs to out. The
+// displacements are found in float64 before being converted to float32, so
+// no precision is lost in large boxes. Use IntersectLocal and InsertLocal on
+// the results.
+func (h *Halo) LocalCoords(
+	vecs [][3]float64, totalWidth float64, out [][3]float32,
+) {
+	if len(out) != len(vecs) {
+		panic("len(out) != len(vecs)")
+	}
+
+	tw2 := totalWidth / 2
+	for i, vec := range vecs {
+		for j := 0; j < 3; j++ {
+			dx := vec[j] - h.origin[j]
+			if dx > tw2 {
+				dx -= totalWidth
+			} else if dx < -tw2 {
+				dx += totalWidth
+			}
+			out[i][j] = float32(dx)
+		}
+	}
+}
+
 // Insert insreats a sphere with the given center and radius to all the rings
 // of the halo.
 func (h *Halo) Insert(vec [3]float32, radius, rho float64) {
@@ -204,6 +248,12 @@ func (h *Halo) Insert(vec [3]float32, radius, rho float64) {
 	vec[1] -= float32(h.origin[1])
 	vec[2] -= float32(h.origin[2])
 
+	h.InsertLocal(vec, radius, rho)
+}
+
+// InsertLocal is identical to Insert, except that vec must already be in
+// halo-centric coordinates (see LocalCoords).
+func (h *Halo) InsertLocal(vec [3]float32, radius, rho float64) {
 	for ring := 0; ring < h.rings; ring++ {
 		// If this intersection check is the chief cost, we can throw some
 		// more computational feometry at it until it's fixed. (3D spatial
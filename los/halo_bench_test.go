@@ -4,14 +4,12 @@ import (
 	"math"
 	"math/rand"
 	"testing"
-
-	"github.com/phil-mansfield/shellfish/los/geom"
 )
 
 func BenchmarkSplitJoin16(b *testing.B) {
-	norms := make([]geom.Vec, 100)
+	norms := make([][3]float32, 100)
 	for i := range norms {
-		norms[i] = geom.Vec{0, 0, 1}
+		norms[i] = [3]float32{0, 0, 1}
 	}
 	h := Halo{}
 	h.Init(norms, [3]float64{1, 1, 1}, 0.5, 5.0, 200, 256, 0)
@@ -25,9 +23,9 @@ func BenchmarkSplitJoin16(b *testing.B) {
 }
 
 func BenchmarkSplit16(b *testing.B) {
-	norms := make([]geom.Vec, 100)
+	norms := make([][3]float32, 100)
 	for i := range norms {
-		norms[i] = geom.Vec{0, 0, 1}
+		norms[i] = [3]float32{0, 0, 1}
 	}
 	h := Halo{}
 	h.Init(norms, [3]float64{1, 1, 1}, 0.5, 5.0, 200, 256, 0)
@@ -40,9 +38,9 @@ func BenchmarkSplit16(b *testing.B) {
 }
 
 func BenchmarkJoin16(b *testing.B) {
-	norms := make([]geom.Vec, 100)
+	norms := make([][3]float32, 100)
 	for i := range norms {
-		norms[i] = geom.Vec{0, 0, 1}
+		norms[i] = [3]float32{0, 0, 1}
 	}
 	h := Halo{}
 	h.Init(norms, [3]float64{1, 1, 1}, 0.5, 5.0, 200, 256, 0)
@@ -56,9 +54,9 @@ func BenchmarkJoin16(b *testing.B) {
 
 func BenchmarkGetRhos(b *testing.B) {
 	h := Halo{}
-	norms := make([]geom.Vec, 100)
+	norms := make([][3]float32, 100)
 	for i := range norms {
-		norms[i] = geom.Vec{0, 0, 1}
+		norms[i] = [3]float32{0, 0, 1}
 	}
 	h.Init(norms, [3]float64{0, 0, 0}, 0.5, 5.0, 200, 256, 0)
 	buf := make([]float64, 200)
@@ -71,9 +69,9 @@ func BenchmarkGetRhos(b *testing.B) {
 
 func BenchmarkGetRhosFull(b *testing.B) {
 	h := Halo{}
-	norms := make([]geom.Vec, 100)
+	norms := make([][3]float32, 100)
 	for i := range norms {
-		norms[i] = geom.Vec{0, 0, 1}
+		norms[i] = [3]float32{0, 0, 1}
 	}
 	h.Init(norms, [3]float64{0, 0, 0}, 0.5, 5.0, 200, 256, 0)
 	bufs := make([][][]float64, 100)
@@ -94,8 +92,8 @@ func BenchmarkGetRhosFull(b *testing.B) {
 	}
 }
 
-func randomDirs(n int) []geom.Vec {
-	vecs := make([]geom.Vec, n)
+func randomDirs(n int) [][3]float32 {
+	vecs := make([][3]float32, n)
 	for i := range vecs {
 		for {
 			x := rand.Float64()*2 - 1
@@ -105,7 +103,7 @@ func randomDirs(n int) []geom.Vec {
 			if r > 1 {
 				continue
 			}
-			vecs[i] = geom.Vec{
+			vecs[i] = [3]float32{
 				float32(x / r),
 				float32(y / r),
 				float32(z / r),
@@ -3,8 +3,6 @@ package los
 import (
 	"math"
 	"testing"
-
-	"github.com/phil-mansfield/shellfish/los/geom"
 )
 
 func Float64SliceEq(xs, ys []float64) bool {
@@ -27,25 +25,25 @@ func TestInsertToRing(t *testing.T) {
 
 	tests := []struct {
 		los, n int
-		vec    geom.Vec
+		vec    [3]float32
 		radius float64
 		res    []float64
 	}{
-		{0, 8, geom.Vec{0, 0, 0}, 1, []float64{1, 1, 1, 1, 0, 0, 0, 0}},
-		{0, 8, geom.Vec{0.25, 0, 0}, 0.75, []float64{1, 1, 1, 1, 0, 0, 0, 0}},
-		{4, 8, geom.Vec{0.25, 0, 0}, 0.75, []float64{1, 1, 0.79588, 0, 0, 0, 0, 0}},
-		{0, 8, geom.Vec{float32(edges[4]+edges[3]) / 2, 0, 0},
+		{0, 8, [3]float32{0, 0, 0}, 1, []float64{1, 1, 1, 1, 0, 0, 0, 0}},
+		{0, 8, [3]float32{0.25, 0, 0}, 0.75, []float64{1, 1, 1, 1, 0, 0, 0, 0}},
+		{4, 8, [3]float32{0.25, 0, 0}, 0.75, []float64{1, 1, 0.79588, 0, 0, 0, 0, 0}},
+		{0, 8, [3]float32{float32(edges[4]+edges[3]) / 2, 0, 0},
 			(edges[4] - edges[3]) / 2, []float64{0, 0, 0, 0, 1, 0, 0, 0}},
-		{0, 8, geom.Vec{float32(edges[4]+edges[3]) / 2, 0, 0},
+		{0, 8, [3]float32{float32(edges[4]+edges[3]) / 2, 0, 0},
 			(edges[4] - edges[3]) / 2, []float64{0, 0, 0, 0, 1, 0, 0, 0}},
-		{0, 8, geom.Vec{float32(edges[8]+edges[0]) / 2, 0, 0},
+		{0, 8, [3]float32{float32(edges[8]+edges[0]) / 2, 0, 0},
 			(edges[8] - edges[0]) / 2, []float64{1, 1, 1, 1, 1, 1, 1, 1}},
 	}
 
 	buf := make([]float64, 8)
 	for i, test := range tests {
 		h := Halo{}
-		h.Init([]geom.Vec{{0, 0, 1}}, [3]float64{1, 1, 1}, 0.1, 10, 8, test.n, 0)
+		h.Init([][3]float32{{0, 0, 1}}, [3]float64{1, 1, 1}, 0.1, 10, 8, test.n, 0)
 		h.insertToRing(test.vec, test.radius, 1, 0)
 		h.GetRhos(0, test.los, buf)
 		if !Float64SliceEq(buf, test.res) {
@@ -54,3 +52,37 @@ func TestInsertToRing(t *testing.T) {
 		}
 	}
 }
+
+func TestLocalCoords(t *testing.T) {
+	tw := 4000.0
+	origin := [3]float64{3999.9999, 1234.56789, 0.0001}
+
+	tests := []struct {
+		vec [3]float64
+		res [3]float32
+	}{
+		{[3]float64{3999.9999, 1234.56789, 0.0001}, [3]float32{0, 0, 0}},
+		{[3]float64{3999.9998, 1234.56799, 0.0002},
+			[3]float32{-1e-4, 1e-4, 1e-4}},
+		{[3]float64{0.0001, 1234.56689, 3999.9999},
+			[3]float32{2e-4, -1e-3, -2e-4}},
+	}
+
+	vecs := make([][3]float64, len(tests))
+	for i := range tests { vecs[i] = tests[i].vec }
+	out := make([][3]float32, len(tests))
+
+	h := Halo{}
+	h.Init([][3]float32{{0, 0, 1}}, origin, 0.1, 10, 8, 8, 0)
+	h.LocalCoords(vecs, tw, out)
+
+	for i, test := range tests {
+		for j := 0; j < 3; j++ {
+			if math.Abs(float64(out[i][j] - test.res[j])) > 1e-8 {
+				t.Errorf("%d) h.LocalCoords() -> %g, but expected %g",
+					i, out[i], test.res)
+				break
+			}
+		}
+	}
+}
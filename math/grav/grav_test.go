@@ -0,0 +1,115 @@
+package grav
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randParticles(n int) ([][3]float32, []float32) {
+	xs := make([][3]float32, n)
+	ms := make([]float32, n)
+	for i := range xs {
+		for k := 0; k < 3; k++ { xs[i][k] = rand.Float32() }
+		ms[i] = 1 + rand.Float32()
+	}
+	return xs, ms
+}
+
+func directPotentials(xs [][3]float32, ms []float32, eps float64) []float64 {
+	phi := make([]float64, len(xs))
+	for i := range xs {
+		for j := range xs {
+			if i == j { continue }
+			r2 := eps*eps
+			for k := 0; k < 3; k++ {
+				dx := float64(xs[i][k]) - float64(xs[j][k])
+				r2 += dx*dx
+			}
+			phi[i] -= float64(ms[j]) / math.Sqrt(r2)
+		}
+	}
+	return phi
+}
+
+func TestTreePotentials(t *testing.T) {
+	xs, ms := randParticles(500)
+	eps := 0.01
+	exact := directPotentials(xs, ms, eps)
+
+	table := []struct{
+		theta, tol float64
+	}{
+		{ 0, 1e-10 },
+		{ 0.5, 1e-2 },
+		{ 0.7, 2e-2 },
+	}
+
+	for _, test := range table {
+		phi := make([]float64, len(xs))
+		NewTree(xs, ms, eps, test.theta).Potentials(phi)
+		for i := range phi {
+			if math.Abs((phi[i] - exact[i]) / exact[i]) > test.tol {
+				t.Errorf(
+					"theta = %g: phi[%d] = %g, but direct sum gives %g.",
+					test.theta, i, phi[i], exact[i],
+				)
+				break
+			}
+		}
+	}
+}
+
+func TestTreeZeroMass(t *testing.T) {
+	xs, ms := randParticles(100)
+	ms[10], ms[20] = 0, 0
+
+	tree := NewTree(xs, ms, 0, 0)
+	if tree.Len() != 98 {
+		t.Errorf("Expected 98 particles in tree, got %d.", tree.Len())
+	}
+
+	phi := make([]float64, len(xs))
+	tree.Potentials(phi)
+	exact := directPotentials(xs, ms, 0)
+	for i := range phi {
+		if ms[i] == 0 { continue }
+		if math.Abs((phi[i] - exact[i]) / exact[i]) > 1e-10 {
+			t.Errorf("phi[%d] = %g, but direct sum gives %g.",
+				i, phi[i], exact[i])
+		}
+	}
+}
+
+func TestUnbind(t *testing.T) {
+	n := 1000
+	xs, ms := randParticles(n)
+	vs := make([][3]float32, n)
+	for i := range xs {
+		for k := 0; k < 3; k++ { xs[i][k] -= 0.5 }
+	}
+
+	// Cold particles moving with a bulk velocity are all bound, except for
+	// a handful of fast interlopers.
+	fast := map[int]bool{ 3: true, 50: true, 700: true }
+	for i := range vs {
+		vs[i] = [3]float32{ 100, -50, 20 }
+		if fast[i] { vs[i][0] += 1e4 }
+	}
+
+	bound := Unbind(xs, vs, ms, 1, 0.01, 0.5, 1, 10)
+	for i := range bound {
+		if bound[i] == fast[i] {
+			t.Errorf("Particle %d has bound = %v.", i, bound[i])
+		}
+	}
+
+	// With no gravity, nothing is bound.
+	bound = Unbind(xs, vs, ms, 0, 0.01, 0.5, 1, 10)
+	for i := range bound {
+		if bound[i] {
+			t.Errorf("Particle %d bound when G = 0.", i)
+			break
+		}
+	}
+}
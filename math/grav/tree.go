@@ -0,0 +1,224 @@
+/*package grav contains routines for computing the gravitational potentials of
+collections of particles and for using those potentials to find which
+particles are gravitationally bound to a halo.
+
+Potentials are computed with a Barnes-Hut octree. Everything in this package
+uses G = 1 unless otherwise stated: callers are responsible for multiplying
+by G (and for any unit conversions).
+*/
+package grav
+
+import (
+	"math"
+)
+
+const (
+	// leafSize is the maximum number of particles stored in a leaf node.
+	leafSize = 8
+	// maxDepth prevents infinite recursion when many particles sit at the
+	// same location.
+	maxDepth = 40
+)
+
+// Tree is a Barnes-Hut octree which can be used to quickly evaluate the
+// gravitational potential of a set of particles.
+type Tree struct {
+	nodes []node
+	xs    [][3]float64
+	ms    []float64
+	// idxs maps the internal particle ordering onto the input ordering.
+	idxs  []int
+
+	eps2, theta2 float64
+}
+
+type node struct {
+	center     [3]float64
+	width      float64
+	com        [3]float64
+	mass       float64
+	start, end int
+	children   [8]int32
+	leaf       bool
+}
+
+// NewTree creates a tree from the particles with positions xs and masses ms.
+// eps is the Plummer softening length of every particle and theta is the
+// opening angle used when walking the tree. theta = 0 will give the exact
+// (and very slow) direct summation result. Particles with zero mass are
+// ignored.
+func NewTree(xs [][3]float32, ms []float32, eps, theta float64) *Tree {
+	t := &Tree{ eps2: eps*eps, theta2: theta*theta }
+
+	for i := range xs {
+		if ms[i] == 0 { continue }
+		t.xs = append(t.xs, [3]float64{
+			float64(xs[i][0]), float64(xs[i][1]), float64(xs[i][2]),
+		})
+		t.ms = append(t.ms, float64(ms[i]))
+		t.idxs = append(t.idxs, i)
+	}
+
+	if len(t.xs) == 0 { return t }
+
+	min, max := t.xs[0], t.xs[0]
+	for i := range t.xs {
+		for k := 0; k < 3; k++ {
+			if t.xs[i][k] < min[k] { min[k] = t.xs[i][k] }
+			if t.xs[i][k] > max[k] { max[k] = t.xs[i][k] }
+		}
+	}
+
+	width := 0.0
+	for k := 0; k < 3; k++ {
+		if max[k] - min[k] > width { width = max[k] - min[k] }
+	}
+	// Prevents particles on the upper edge from falling out of the box.
+	width *= 1 + 1e-6
+	if width == 0 { width = 1 }
+
+	center := [3]float64{}
+	for k := 0; k < 3; k++ { center[k] = (max[k] + min[k]) / 2 }
+
+	t.nodes = append(t.nodes, node{})
+	t.build(0, center, width, 0, len(t.xs), 0)
+
+	return t
+}
+
+// build recursively constructs node ni out of particles start through end.
+func (t *Tree) build(ni int, center [3]float64, width float64, start, end, depth int) {
+	n := node{ center: center, width: width, start: start, end: end }
+	for i := range n.children { n.children[i] = -1 }
+
+	for i := start; i < end; i++ {
+		m := t.ms[i]
+		n.mass += m
+		for k := 0; k < 3; k++ { n.com[k] += m * t.xs[i][k] }
+	}
+	for k := 0; k < 3; k++ { n.com[k] /= n.mass }
+
+	if end - start <= leafSize || depth >= maxDepth {
+		n.leaf = true
+		t.nodes[ni] = n
+		return
+	}
+
+	// Sort particles into octants with a counting sort.
+	counts := [9]int{}
+	oct := make([]uint8, end - start)
+	for i := start; i < end; i++ {
+		o := octant(t.xs[i], center)
+		oct[i - start] = o
+		counts[o + 1]++
+	}
+	for o := 0; o < 8; o++ { counts[o + 1] += counts[o] }
+
+	xs := make([][3]float64, end - start)
+	ms := make([]float64, end - start)
+	idxs := make([]int, end - start)
+	offsets := counts // Arrays are copied by value.
+	for i := start; i < end; i++ {
+		o := oct[i - start]
+		j := offsets[o]
+		offsets[o]++
+		xs[j], ms[j], idxs[j] = t.xs[i], t.ms[i], t.idxs[i]
+	}
+	copy(t.xs[start:end], xs)
+	copy(t.ms[start:end], ms)
+	copy(t.idxs[start:end], idxs)
+
+	t.nodes[ni] = n
+
+	for o := 0; o < 8; o++ {
+		lo, hi := start + counts[o], start + counts[o + 1]
+		if hi == lo { continue }
+
+		childCenter := center
+		for k := 0; k < 3; k++ {
+			if o & (1 << uint(k)) != 0 {
+				childCenter[k] += width / 4
+			} else {
+				childCenter[k] -= width / 4
+			}
+		}
+
+		ci := len(t.nodes)
+		t.nodes = append(t.nodes, node{})
+		t.nodes[ni].children[o] = int32(ci)
+		t.build(ci, childCenter, width / 2, lo, hi, depth + 1)
+	}
+}
+
+func octant(x, center [3]float64) uint8 {
+	o := uint8(0)
+	for k := 0; k < 3; k++ {
+		if x[k] >= center[k] { o |= 1 << uint(k) }
+	}
+	return o
+}
+
+// Len returns the number of particles in the tree.
+func (t *Tree) Len() int { return len(t.xs) }
+
+// Potential returns the (G = 1) potential at the point x.
+func (t *Tree) Potential(x [3]float32) float64 {
+	return t.potential(
+		[3]float64{ float64(x[0]), float64(x[1]), float64(x[2]) }, -1,
+	)
+}
+
+// Potentials writes the potential at every particle used to construct the
+// tree into out, excluding each particle's contribution to its own
+// potential. out is indexed in the same way as the slices passed to NewTree
+// and must have the same length. Zero-mass particles are left untouched.
+func (t *Tree) Potentials(out []float64) {
+	for i := range t.xs {
+		out[t.idxs[i]] = t.potential(t.xs[i], i)
+	}
+}
+
+// potential computes the potential at x while skipping the particle with
+// the internal index skip.
+func (t *Tree) potential(x [3]float64, skip int) float64 {
+	if len(t.nodes) == 0 { return 0 }
+
+	phi := 0.0
+	stack := make([]int32, 1, 64)
+	stack[0] = 0
+
+	for len(stack) > 0 {
+		n := &t.nodes[stack[len(stack) - 1]]
+		stack = stack[:len(stack) - 1]
+
+		dx := n.com[0] - x[0]
+		dy := n.com[1] - x[1]
+		dz := n.com[2] - x[2]
+		r2 := dx*dx + dy*dy + dz*dz
+
+		containsSkip := skip >= n.start && skip < n.end
+		if !containsSkip && n.width*n.width < t.theta2*r2 {
+			phi -= n.mass / math.Sqrt(r2 + t.eps2)
+			continue
+		}
+
+		if n.leaf {
+			for i := n.start; i < n.end; i++ {
+				if i == skip { continue }
+				dx := t.xs[i][0] - x[0]
+				dy := t.xs[i][1] - x[1]
+				dz := t.xs[i][2] - x[2]
+				r2 := dx*dx + dy*dy + dz*dz + t.eps2
+				if r2 == 0 { continue }
+				phi -= t.ms[i] / math.Sqrt(r2)
+			}
+			continue
+		}
+
+		for _, c := range n.children {
+			if c >= 0 { stack = append(stack, c) }
+		}
+	}
+
+	return phi
+}
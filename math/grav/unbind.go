@@ -0,0 +1,79 @@
+package grav
+
+// Unbind iteratively removes gravitationally unbound particles from a halo
+// and returns a slice indicating which particles remain bound.
+//
+// xs and vs are the positions and velocities of the particles relative to
+// the halo's center and must already be in consistent physical units: any
+// scale factors and Hubble flow should be applied by the caller. g is the
+// gravitational constant in those units, and eps and theta are passed to
+// NewTree.
+//
+// On each iteration the potential is recomputed using only the particles
+// which are still bound and the halo's bulk velocity is recomputed as the
+// mass-weighted mean velocity of the bound particles within rBulk of the
+// center. Particles whose kinetic energy relative to the bulk velocity
+// exceeds their potential energy are removed. Unbinding stops once an
+// iteration removes no particles or after iters iterations, whichever comes
+// first. Particles are never rebound once they have been removed.
+func Unbind(
+	xs, vs [][3]float32, ms []float32,
+	g, eps, theta, rBulk float64, iters int,
+) []bool {
+	bound := make([]bool, len(xs))
+	for i := range bound { bound[i] = ms[i] > 0 }
+
+	boundMs := make([]float32, len(ms))
+	phi := make([]float64, len(xs))
+	rBulk2 := float32(rBulk * rBulk)
+
+	for it := 0; it < iters; it++ {
+		for i := range ms {
+			if bound[i] {
+				boundMs[i] = ms[i]
+			} else {
+				boundMs[i] = 0
+			}
+		}
+
+		t := NewTree(xs, boundMs, eps, theta)
+		if t.Len() == 0 { break }
+		t.Potentials(phi)
+
+		vBulk := BulkVelocity(xs, vs, boundMs, rBulk2)
+
+		removed := 0
+		for i := range xs {
+			if !bound[i] { continue }
+			dvx := float64(vs[i][0]) - vBulk[0]
+			dvy := float64(vs[i][1]) - vBulk[1]
+			dvz := float64(vs[i][2]) - vBulk[2]
+			ke := (dvx*dvx + dvy*dvy + dvz*dvz) / 2
+			if ke + g*phi[i] >= 0 {
+				bound[i] = false
+				removed++
+			}
+		}
+
+		if removed == 0 { break }
+	}
+
+	return bound
+}
+
+// BulkVelocity returns the mass-weighted mean velocity of all the particles
+// within sqrt(r2) of the origin. Zero is returned if there are no such
+// particles.
+func BulkVelocity(xs, vs [][3]float32, ms []float32, r2 float32) [3]float64 {
+	v, mSum := [3]float64{}, 0.0
+	for i := range xs {
+		x := xs[i]
+		if x[0]*x[0] + x[1]*x[1] + x[2]*x[2] > r2 { continue }
+		m := float64(ms[i])
+		mSum += m
+		for k := 0; k < 3; k++ { v[k] += m * float64(vs[i][k]) }
+	}
+	if mSum == 0 { return [3]float64{} }
+	for k := 0; k < 3; k++ { v[k] /= mSum }
+	return v
+}
@@ -51,6 +51,21 @@ does not consider the config file missing one or more variables an error. This
 will be annoying in some cases, but is usually the desired behavior. You will
 need to explicitly check for variables that have not been set.
 
+A single file can hold several config files, each under its own title, and
+ReadConfig will only read the section whose title matches the ConfigVars.
+Files can also include other files and refer to other variables or
+environment variables:
+
+    [owner_info]
+    include = shared.config # Relative to this file's directory.
+    Name = Phil
+    Home = $HOME/cats
+
+    [cat_info]
+    CatName = Bob
+    Owner = ${Name} # Looked up in this section, then in other sections.
+    Price = $$20    # $$ is a literal $.
+
 For additional examples, see the usage in config_test.go
 */
 package parse
@@ -58,6 +73,8 @@ package parse
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -108,6 +125,9 @@ type ConfigVars struct {
 	varNames        []string
 	varTypes        []varType
 	conversionFuncs []conversionFunc
+	ptrs            []interface{}
+	// schema holds the constraints and documentation of each variable.
+	schema          []*Var
 }
 
 func intConv(ptr *int64) conversionFunc {
@@ -218,60 +238,77 @@ func NewConfigVars(name string) *ConfigVars {
 	return &ConfigVars{name: name}
 }
 
-func (vars *ConfigVars) Int(ptr *int64, name string, value int64) {
-	*ptr = value
+// add registers a variable. The Int, Float, String, etc. methods should be
+// used instead.
+func (vars *ConfigVars) add(
+	ptr interface{}, name string, value interface{}, typ varType,
+	conv conversionFunc,
+) *Var {
+	v := &Var{ name: name, typ: typ, ptr: ptr, value: value }
 	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, intConv(ptr))
-	vars.varTypes = append(vars.varTypes, intVar)
+	vars.conversionFuncs = append(vars.conversionFuncs, conv)
+	vars.varTypes = append(vars.varTypes, typ)
+	vars.ptrs = append(vars.ptrs, ptr)
+	vars.schema = append(vars.schema, v)
+	return v
 }
 
-func (vars *ConfigVars) Float(ptr *float64, name string, value float64) {
+func (vars *ConfigVars) Int(ptr *int64, name string, value int64) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, floatConv(ptr))
-	vars.varTypes = append(vars.varTypes, floatVar)
+	return vars.add(ptr, name, value, intVar, intConv(ptr))
 }
 
-func (vars *ConfigVars) String(ptr *string, name string, value string) {
+func (vars *ConfigVars) Float(ptr *float64, name string, value float64) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, stringConv(ptr))
-	vars.varTypes = append(vars.varTypes, stringVar)
+	return vars.add(ptr, name, value, floatVar, floatConv(ptr))
 }
 
-func (vars *ConfigVars) Bool(ptr *bool, name string, value bool) {
+func (vars *ConfigVars) String(ptr *string, name string, value string) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, boolConv(ptr))
-	vars.varTypes = append(vars.varTypes, boolVar)
+	return vars.add(ptr, name, value, stringVar, stringConv(ptr))
 }
 
-func (vars *ConfigVars) Ints(ptr *[]int64, name string, value []int64) {
+func (vars *ConfigVars) Bool(ptr *bool, name string, value bool) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, intsConv(ptr))
-	vars.varTypes = append(vars.varTypes, intsVar)
+	return vars.add(ptr, name, value, boolVar, boolConv(ptr))
 }
 
-func (vars *ConfigVars) Floats(ptr *[]float64, name string, value []float64) {
+func (vars *ConfigVars) Ints(ptr *[]int64, name string, value []int64) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, floatsConv(ptr))
-	vars.varTypes = append(vars.varTypes, floatsVar)
+	return vars.add(ptr, name, value, intsVar, intsConv(ptr))
 }
 
-func (vars *ConfigVars) Strings(ptr *[]string, name string, value []string) {
+func (vars *ConfigVars) Floats(ptr *[]float64, name string, value []float64) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, stringsConv(ptr))
-	vars.varTypes = append(vars.varTypes, stringsVar)
+	return vars.add(ptr, name, value, floatsVar, floatsConv(ptr))
 }
 
-func (vars *ConfigVars) Bools(ptr *[]bool, name string, value []bool) {
+func (vars *ConfigVars) Strings(ptr *[]string, name string, value []string) *Var {
 	*ptr = value
-	vars.varNames = append(vars.varNames, name)
-	vars.conversionFuncs = append(vars.conversionFuncs, boolsConv(ptr))
-	vars.varTypes = append(vars.varTypes, boolsVar)
+	return vars.add(ptr, name, value, stringsVar, stringsConv(ptr))
+}
+
+func (vars *ConfigVars) Bools(ptr *[]bool, name string, value []bool) *Var {
+	*ptr = value
+	return vars.add(ptr, name, value, boolsVar, boolsConv(ptr))
+}
+
+// Values returns the current values of every registered variable.
+func (vars *ConfigVars) Values() map[string]interface{} {
+	out := map[string]interface{}{}
+	for i, name := range vars.varNames {
+		switch ptr := vars.ptrs[i].(type) {
+		case *int64: out[name] = *ptr
+		case *float64: out[name] = *ptr
+		case *string: out[name] = *ptr
+		case *bool: out[name] = *ptr
+		case *[]int64: out[name] = *ptr
+		case *[]float64: out[name] = *ptr
+		case *[]string: out[name] = *ptr
+		case *[]bool: out[name] = *ptr
+		}
+	}
+	return out
 }
 
 //////////////////
@@ -281,81 +318,332 @@ func (vars *ConfigVars) Bools(ptr *[]bool, name string, value []bool) {
 // ReadConfig parses the config file specified by fname using the set of
 // variables vars. If successful nil is returned, otherwise an error is
 // returned.
+//
+// A config file can contain several sections, each starting with a title
+// like [config] or [shell.config]. Only the section whose title matches vars
+// is read. A line of the form
+//
+//     include = other.config
+//
+// reads the lines of other.config as if they were written in place of the
+// include line. Relative paths are relative to the directory of the file
+// containing the include. Variables set in a file take priority over
+// variables set in the files it includes, so a file can include a shared
+// config file and then change only the variables that differ.
+//
+// Values can refer to other variables as ${Name}. Name is looked up first in
+// the same section, then in the other sections of the file, and finally in
+// the environment. $NAME always refers to an environment variable and $$ is a
+// literal $.
 func ReadConfig(fname string, vars *ConfigVars) error {
-	// I/O
+	sections, err := readSections(fname)
+	if err != nil { return err }
 
-	bs, err := ioutil.ReadFile(fname)
-	if err != nil {
-		return err
+	assigns, ok := sections.assignments[vars.name]
+	if !ok {
+		return fmt.Errorf(
+			"I expected the config file %s to have the header "+
+				"[%s], but didn't find it.", fname, vars.name,
+		)
+	}
+
+	for _, a := range assigns {
+		if !vars.has(a.name) {
+			return fmt.Errorf(
+				"Line %d of the config file %s assigns a value to the "+
+					"variable '%s', but config files of type %s don't have "+
+					"that variable.", a.line, a.file, a.name, vars.name,
+			)
+		}
 	}
 
-	// Begin tokenization; remember line numbers for better errors.
+	resolved, err := resolveAssignments(assigns)
+	if err != nil { return err }
 
-	lines := strings.Split(string(bs), "\n")
-	lines, lineNums := removeComments(lines)
-	for i := range lineNums {
-		lineNums[i]++
+	for _, a := range resolved {
+		val, err := sections.interpolate(vars.name, a, nil)
+		if err != nil { return err }
+
+		j := vars.index(a.name)
+		if !vars.conversionFuncs[j](val) {
+			typeName := vars.varTypes[j].String()
+			article := "a"
+			if typeName[0] == 'i' {
+				article = "an"
+			}
+			return fmt.Errorf(
+				"I could not parse line %d of the config file %s because "+
+					"'%s' expects values of type %s and '%s' cannnot be "+
+					"converted to %s %s.", a.line, a.file, vars.varNames[j],
+				typeName, val, article, typeName,
+			)
+		}
+		vars.schema[j].set = true
+		vars.schema[j].source = fmt.Sprintf("on line %d of %s", a.line, a.file)
 	}
 
-	if len(lines) == 0 || lines[0] != fmt.Sprintf("[%s]", vars.name) {
-		return fmt.Errorf(
-			"I expected the config file %s to have the header "+
-				"[%s] at the top, but didn't find it.", fname, vars.name,
-		)
+	return nil
+}
+
+// has returns true if vars has a variable with the given name.
+func (vars *ConfigVars) has(name string) bool {
+	return vars.index(name) != -1
+}
+
+// index returns the index of the variable with the given name, or -1 if
+// there isn't one. Names are case-insensitive.
+func (vars *ConfigVars) index(name string) int {
+	for j := range vars.varNames {
+		if strings.ToLower(vars.varNames[j]) == strings.ToLower(name) {
+			return j
+		}
 	}
-	lines = lines[1:]
+	return -1
+}
 
-	// Create association list and check for name-based errors
+// assignment is a single "Name = Value" line of a config file.
+type assignment struct {
+	name, value string
+	file        string
+	line        int
+	// depth is the number of includes between the file that was passed to
+	// ReadConfig and the file containing the assignment.
+	depth int
+}
 
-	names, vals, errLine := associationList(lines)
-	if errLine != -1 {
-		return fmt.Errorf(
-			"I could not parse line %d of the config file %s because it "+
-				"did not take the form of a variable assignment.",
-			lineNums[errLine+1], fname,
-		)
+// configSections contains the assignments in every section of a config file
+// and the files it includes.
+type configSections struct {
+	assignments map[string][]assignment
+	// titles lists the section titles in the order they first appear.
+	titles []string
+}
+
+// readSections reads every section of a config file and the files it
+// includes.
+func readSections(fname string) (*configSections, error) {
+	sections := &configSections{ assignments: map[string][]assignment{} }
+	_, err := sections.readFile(fname, "", 0, nil)
+	if err != nil { return nil, err }
+	return sections, nil
+}
+
+// Sections returns the titles of the sections in a config file and the files
+// it includes, in the order that they first appear.
+func Sections(fname string) ([]string, error) {
+	sections, err := readSections(fname)
+	if err != nil { return nil, err }
+	return sections.titles, nil
+}
+
+// readFile adds the assignments in a file to the sections. title is the
+// title of the section that the file starts in, or "" if it isn't in a
+// section, and stack lists the files that included it. The title of the
+// section that the file ends in is returned.
+func (sections *configSections) readFile(
+	fname, title string, depth int, stack []string,
+) (string, error) {
+	fname = filepath.Clean(fname)
+	for _, prev := range stack {
+		if prev == fname {
+			return "", fmt.Errorf(
+				"The config file %s includes itself through the files %s.",
+				fname, strings.Join(stack, ", "),
+			)
+		}
 	}
+	stack = append(stack, fname)
 
-	if errLine = checkValidNames(names, vars); errLine != -1 {
-		return fmt.Errorf(
-			"Line %d of the config file %s assigns a value to the "+
-				"variable '%s', but config files of type %s don't have that "+
-				"variable.", lineNums[errLine+1], fname, names[errLine], vars.name,
-		)
+	bs, err := ioutil.ReadFile(fname)
+	if err != nil { return "", err }
+
+	lines, lineNums := removeComments(strings.Split(string(bs), "\n"))
+	for i, line := range lines {
+		lineNum := lineNums[i] + 1
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			title = line[1:len(line)-1]
+			if _, ok := sections.assignments[title]; !ok {
+				sections.assignments[title] = []assignment{}
+				sections.titles = append(sections.titles, title)
+			}
+			continue
+		}
+
+		names, vals, errLine := associationList([]string{line})
+		if errLine != -1 {
+			return "", fmt.Errorf(
+				"I could not parse line %d of the config file %s because it "+
+					"did not take the form of a variable assignment.",
+				lineNum, fname,
+			)
+		}
+
+		if strings.ToLower(names[0]) == "include" {
+			include := vals[0]
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(fname), include)
+			}
+			_, err = sections.readFile(include, title, depth + 1, stack)
+			if err != nil { return "", err }
+			continue
+		}
+
+		if title == "" {
+			return "", fmt.Errorf(
+				"Line %d of the config file %s assigns a value to the "+
+					"variable '%s' before any [section] header.",
+				lineNum, fname, names[0],
+			)
+		}
+
+		sections.assignments[title] = append(sections.assignments[title],
+			assignment{names[0], vals[0], fname, lineNum, depth})
 	}
 
-	if errLine1, errLine2 := checkDuplicateNames(names); errLine1 != -1 {
-		return fmt.Errorf(
-			"Lines %d and %d of the config file %s both assign a value to "+
-				"the variable '%s'.", lineNums[errLine1+1], lineNums[errLine2+1],
-			fname, names[errLine1],
-		)
+	return title, nil
+}
+
+// resolveAssignments returns the assignment which sets each variable in a
+// section. Assignments in a file take priority over assignments in the files
+// it includes. If two included files set the same variable, the one that
+// was included last is used. Setting a variable twice in the same file is an
+// error.
+func resolveAssignments(assigns []assignment) ([]assignment, error) {
+	out := []assignment{}
+	idx := map[string]int{}
+
+	for _, a := range assigns {
+		name := strings.ToLower(a.name)
+		i, ok := idx[name]
+		if !ok {
+			idx[name] = len(out)
+			out = append(out, a)
+			continue
+		}
+
+		prev := out[i]
+		switch {
+		case prev.file == a.file:
+			return nil, fmt.Errorf(
+				"Lines %d and %d of the config file %s both assign a value "+
+					"to the variable '%s'.", prev.line, a.line, a.file, a.name,
+			)
+		case a.depth <= prev.depth:
+			out[i] = a
+		}
 	}
 
-	// Convert every variable in the associate list.
+	return out, nil
+}
 
-	if errLine = convertAssoc(names, vals, vars); errLine != -1 {
-		j := 0
-		for ; j < len(vars.varNames); j++ {
-			if strings.ToLower(vars.varNames[j]) ==
-				strings.ToLower(names[errLine]) {
-				break
+// lookup returns the assignment which sets a variable in the given section,
+// or false if the variable isn't set.
+func (sections *configSections) lookup(
+	title, name string,
+) (assignment, bool, error) {
+	resolved, err := resolveAssignments(sections.assignments[title])
+	if err != nil { return assignment{}, false, err }
+	for _, a := range resolved {
+		if strings.ToLower(a.name) == strings.ToLower(name) {
+			return a, true, nil
+		}
+	}
+	return assignment{}, false, nil
+}
+
+// interpolate returns the value of an assignment in the given section after
+// replacing every ${Name}, $NAME, and $$. stack lists the variables which are
+// currently being interpolated and is used to find cycles.
+func (sections *configSections) interpolate(
+	title string, a assignment, stack []string,
+) (string, error) {
+	for _, prev := range stack {
+		if strings.ToLower(prev) == strings.ToLower(a.name) {
+			return "", fmt.Errorf(
+				"Line %d of the config file %s refers to the variable '%s', "+
+					"but the value of '%s' depends on itself.",
+				a.line, a.file, a.name, a.name,
+			)
+		}
+	}
+	stack = append(stack, a.name)
+
+	out := []byte{}
+	val := a.value
+	for i := 0; i < len(val); i++ {
+		if val[i] != '$' {
+			out = append(out, val[i])
+			continue
+		}
+
+		var name string
+		braces := i+1 < len(val) && val[i+1] == '{'
+		switch {
+		case i+1 < len(val) && val[i+1] == '$':
+			out = append(out, '$')
+			i++
+			continue
+		case braces:
+			end := strings.IndexByte(val[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf(
+					"Line %d of the config file %s has a '${' without a "+
+						"matching '}'.", a.line, a.file,
+				)
 			}
+			name = val[i+2: i+end]
+			i += end
+		default:
+			end := i + 1
+			for end < len(val) && isNameChar(val[end]) { end++ }
+			name = val[i+1: end]
+			i = end - 1
 		}
-		typeName := vars.varTypes[j].String()
-		a := "a"
-		if typeName[0] == 'i' {
-			a = "an"
+
+		if name == "" {
+			return "", fmt.Errorf(
+				"Line %d of the config file %s has a '$' which isn't "+
+					"followed by a variable name. Use '$$' for a literal '$'.",
+				a.line, a.file,
+			)
 		}
-		return fmt.Errorf(
-			"I could not parse line %d of the config file %s because '%s' "+
-				"expects values of type %s and '%s' cannnot be converted to "+
-				"%s %s.", lineNums[errLine+1], fname, vars.varNames[j], typeName,
-			vals[j], a, typeName,
-		)
+
+		sub, err := sections.variable(title, name, braces, a, stack)
+		if err != nil { return "", err }
+		out = append(out, sub...)
 	}
 
-	return nil
+	return string(out), nil
+}
+
+// variable returns the value of a variable referred to by the assignment a.
+// If configVar is true, config variables are searched before the
+// environment.
+func (sections *configSections) variable(
+	title, name string, configVar bool, a assignment, stack []string,
+) (string, error) {
+	if configVar {
+		titles := append([]string{title}, sections.titles...)
+		for _, t := range titles {
+			ref, ok, err := sections.lookup(t, name)
+			if err != nil { return "", err }
+			if ok { return sections.interpolate(t, ref, stack) }
+		}
+	}
+
+	if env, ok := os.LookupEnv(name); ok { return env, nil }
+
+	return "", fmt.Errorf(
+		"Line %d of the config file %s refers to '%s', but there's no "+
+			"config variable or environment variable with that name.",
+		a.line, a.file, name,
+	)
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
 }
 
 func ReadFlags(args []string, vars *ConfigVars) error {
@@ -448,10 +736,15 @@ func ReadFlags(args []string, vars *ConfigVars) error {
 		return fmt.Errorf(
 			"I could not parse the flag '%s', because it "+
 			"expects values of type %s and '%s' cannnot be converted to "+
-			"%s %s.", vars.varNames[j], typeName, vals[j], a, typeName,
+			"%s %s.", vars.varNames[j], typeName, vals[errLine], a, typeName,
 		)
 	}
 
+	for _, name := range names {
+		v := vars.schema[vars.index(name)]
+		v.set, v.source = true, fmt.Sprintf("by the flag --%s", name)
+	}
+
 	return nil
 }
 
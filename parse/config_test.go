@@ -3,6 +3,8 @@ package parse
 import (
 	"fmt"
 	"math"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -390,6 +392,10 @@ func TestInvalidConfig(t *testing.T) {
 		"config_test_files/dupicates.config",
 		"config_test_files/invalid_var.config",
 		"config_test_files/invalid_type.config",
+		"config_test_files/include_cycle.config",
+		"config_test_files/interpolation_cycle.config",
+		"config_test_files/undefined_var.config",
+		"config_test_files/no_section.config",
 	}
 
 	for i := range fnames {
@@ -404,6 +410,40 @@ func TestInvalidConfig(t *testing.T) {
 	}
 }
 
+func TestMultiSectionConfig(t *testing.T) {
+	os.Setenv("SHELLFISH_TEST_ENV", "woof")
+	defer os.Unsetenv("SHELLFISH_TEST_ENV")
+
+	config, vars := makeTestConfig()
+	err := ReadConfig("config_test_files/multi_section.config", vars)
+	if err != nil {
+		t.Fatalf("Expected successful read of config file, but got "+
+			"error:\n %s", err.Error())
+	}
+
+	if config.num != 3 {
+		t.Errorf("Expected num = %d, but got %d", 3, config.num)
+	}
+	if !config.okay {
+		t.Errorf("Expected okay = %v, but got %v", true, config.okay)
+	}
+	if len(config.nums) != 0 {
+		t.Errorf("Expected nums from another section to be ignored, but "+
+			"got %v", config.nums)
+	}
+	words := []string{"meow", "woof", "$5", "3_x"}
+	if !stringsEq(words, config.words) {
+		t.Errorf("Expected words = %v, but got %v", words, config.words)
+	}
+
+	_, vars = makeTestConfig()
+	os.Unsetenv("SHELLFISH_TEST_ENV")
+	err = ReadConfig("config_test_files/multi_section.config", vars)
+	if err == nil || !strings.Contains(err.Error(), "Line 14 ") {
+		t.Errorf("Expected an error on line 14, but got %v", err)
+	}
+}
+
 func TestValidFlags(t *testing.T) {
 	config, vars := makeTestConfig()
 	flags := []string{
@@ -434,3 +474,19 @@ func TestValidFlags(t *testing.T) {
 		t.Errorf("Flag Okay not set.")
 	}
 }
+
+func TestValues(t *testing.T) {
+	config, vars := makeTestConfig()
+	config.num, config.okay = 16, true
+	config.nums = []int64{1, 2}
+
+	values := vars.Values()
+	switch {
+	case values["num"] != int64(16):
+		t.Errorf("Values gave num = %v.", values["num"])
+	case values["okay"] != true:
+		t.Errorf("Values gave okay = %v.", values["okay"])
+	case !int64sEq(values["nums"].([]int64), []int64{1, 2}):
+		t.Errorf("Values gave nums = %v.", values["nums"])
+	}
+}
@@ -0,0 +1,290 @@
+package parse
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Var is a variable registered with a ConfigVars. Its methods add
+// constraints and documentation to the variable and can be chained:
+//
+//     vars.Int(&config.Window, "Window", 121).Min(5).Odd().Doc("...")
+//
+// Constraints are checked by ConfigVars.Validate. Default values are checked
+// too, except for variables registered with RequiredIf, whose defaults are
+// often placeholders that are never used unless the variable is set.
+type Var struct {
+	name  string
+	typ   varType
+	ptr   interface{}
+	value interface{}
+
+	// set is true if the variable was assigned to in a config file or flag.
+	// source describes where, e.g. "on line 3 of shell.config".
+	set    bool
+	source string
+
+	doc, example string
+
+	min, max       *float64
+	positive, odd  bool
+	enum           []string
+	required       bool
+	requiredIfName string
+	requiredIfVals []string
+}
+
+// Doc sets the documentation string of the variable. It's written as a
+// comment above the variable in ConfigVars.ExampleConfig.
+func (v *Var) Doc(doc string) *Var {
+	v.doc = doc
+	return v
+}
+
+// Example sets the value shown for the variable in ConfigVars.ExampleConfig.
+// By default, its default value is shown.
+func (v *Var) Example(example string) *Var {
+	v.example = example
+	return v
+}
+
+// Min requires a numeric variable to be at least x. Every item of a list
+// must be at least x.
+func (v *Var) Min(x float64) *Var {
+	v.checkNumeric("Min")
+	v.min = &x
+	return v
+}
+
+// Max requires a numeric variable to be at most x. Every item of a list must
+// be at most x.
+func (v *Var) Max(x float64) *Var {
+	v.checkNumeric("Max")
+	v.max = &x
+	return v
+}
+
+// Positive requires a numeric variable to be larger than zero.
+func (v *Var) Positive() *Var {
+	v.checkNumeric("Positive")
+	v.positive = true
+	return v
+}
+
+// Odd requires an integer variable to be odd.
+func (v *Var) Odd() *Var {
+	if v.typ != intVar && v.typ != intsVar {
+		panic(fmt.Sprintf("Odd() called on the %s variable '%s'.",
+			v.typ, v.name))
+	}
+	v.odd = true
+	return v
+}
+
+// Enum requires a string variable to be one of vals. Every item of a list
+// must be one of vals.
+func (v *Var) Enum(vals ...string) *Var {
+	if v.typ != stringVar && v.typ != stringsVar {
+		panic(fmt.Sprintf("Enum() called on the %s variable '%s'.",
+			v.typ, v.name))
+	}
+	v.enum = vals
+	return v
+}
+
+// Required requires the variable to be set by the config file or a flag.
+func (v *Var) Required() *Var {
+	v.required = true
+	return v
+}
+
+// RequiredIf requires the variable to be set by the config file or a flag
+// whenever the string variable called name is set to one of vals.
+func (v *Var) RequiredIf(name string, vals ...string) *Var {
+	v.requiredIfName, v.requiredIfVals = name, vals
+	return v
+}
+
+func (v *Var) checkNumeric(method string) {
+	switch v.typ {
+	case intVar, intsVar, floatVar, floatsVar:
+	default:
+		panic(fmt.Sprintf("%s() called on the %s variable '%s'.",
+			method, v.typ, v.name))
+	}
+}
+
+// Validate checks that every variable satisfies its constraints and returns
+// an error describing the first one that doesn't, including the line or flag
+// which set it. It should be called after ReadConfig and ReadFlags.
+func (vars *ConfigVars) Validate() error {
+	for _, v := range vars.schema {
+		if err := vars.validateRequired(v); err != nil { return err }
+		if !v.set && v.requiredIfName != "" { continue }
+		if err := v.validateValue(); err != nil { return err }
+	}
+	return nil
+}
+
+func (vars *ConfigVars) validateRequired(v *Var) error {
+	if v.set { return nil }
+	if v.required {
+		return fmt.Errorf("The variable '%s' isn't set.", v.name)
+	}
+	if v.requiredIfName == "" { return nil }
+
+	j := vars.index(v.requiredIfName)
+	if j == -1 {
+		panic(fmt.Sprintf("The variable '%s' depends on the unregistered "+
+			"variable '%s'.", v.name, v.requiredIfName))
+	}
+	ptr, ok := vars.ptrs[j].(*string)
+	if !ok {
+		panic(fmt.Sprintf("The variable '%s' depends on the non-string "+
+			"variable '%s'.", v.name, v.requiredIfName))
+	}
+
+	for _, val := range v.requiredIfVals {
+		if *ptr != val { continue }
+		return fmt.Errorf("The variable '%s' isn't set, but it must be set "+
+			"when '%s' = %s.", v.name, vars.varNames[j], val)
+	}
+	return nil
+}
+
+// validateValue checks the value of a variable against its constraints.
+func (v *Var) validateValue() error {
+	items := v.items()
+	for i, item := range items {
+		msg := v.violation(item)
+		if msg == "" { continue }
+
+		desc := fmt.Sprintf("the variable '%s'", v.name)
+		switch v.typ {
+		case intsVar, floatsVar, stringsVar, boolsVar:
+			desc = fmt.Sprintf("Item %d of %s", i, desc)
+		default:
+			desc = strings.ToUpper(desc[:1]) + desc[1:]
+		}
+
+		if !v.set {
+			return fmt.Errorf("%s has the default value %s, but it must "+
+				"be %s.", desc, itemString(item), msg)
+		}
+		return fmt.Errorf("%s is set to %s %s, but it must be %s.",
+			desc, itemString(item), v.source, msg)
+	}
+	return nil
+}
+
+// violation returns a description of the constraint that a single value
+// violates, or "" if it doesn't violate any.
+func (v *Var) violation(item interface{}) string {
+	switch x := item.(type) {
+	case int64:
+		if v.odd && x % 2 == 0 { return "odd" }
+		return v.numericViolation(float64(x))
+	case float64:
+		return v.numericViolation(x)
+	case string:
+		if v.enum == nil { return "" }
+		for _, val := range v.enum {
+			if x == val { return "" }
+		}
+		return "one of " + orList(v.enum)
+	}
+	return ""
+}
+
+func (v *Var) numericViolation(x float64) string {
+	switch {
+	case v.positive && x <= 0:
+		return "positive"
+	case v.min != nil && x < *v.min:
+		return fmt.Sprintf("at least %g", *v.min)
+	case v.max != nil && x > *v.max:
+		return fmt.Sprintf("at most %g", *v.max)
+	}
+	return ""
+}
+
+// items returns the current value of the variable as a list, with one item
+// per list element.
+func (v *Var) items() []interface{} {
+	val := reflect.ValueOf(v.ptr).Elem()
+	if val.Kind() != reflect.Slice { return []interface{}{ val.Interface() } }
+
+	out := make([]interface{}, val.Len())
+	for i := range out { out[i] = val.Index(i).Interface() }
+	return out
+}
+
+func itemString(item interface{}) string {
+	if s, ok := item.(string); ok { return fmt.Sprintf("'%s'", s) }
+	return fmt.Sprint(item)
+}
+
+// orList returns a human-readable list of alternatives, e.g. "a, b, or c".
+func orList(vals []string) string {
+	switch len(vals) {
+	case 1:
+		return vals[0]
+	case 2:
+		return vals[0] + " or " + vals[1]
+	}
+	return strings.Join(vals[:len(vals)-1], ", ") + ", or " + vals[len(vals)-1]
+}
+
+// ExampleConfig returns a documented example config file containing every
+// registered variable. Required variables are set to their example values and
+// optional variables are commented out.
+func (vars *ConfigVars) ExampleConfig() string {
+	required, optional := []string{}, []string{}
+	for _, v := range vars.schema {
+		doc := []string{}
+		if v.doc != "" {
+			for _, line := range strings.Split(v.doc, "\n") {
+				doc = append(doc, strings.TrimRight("# " + line, " "))
+			}
+		}
+
+		if v.required || v.requiredIfName != "" {
+			if v.requiredIfName != "" {
+				if len(doc) > 0 { doc = append(doc, "#") }
+				doc = append(doc, fmt.Sprintf("# Only required if %s = %s.",
+					v.requiredIfName, orList(v.requiredIfVals)))
+			}
+			doc = append(doc, fmt.Sprintf("%s = %s", v.name, v.exampleValue()))
+			required = append(required, strings.Join(doc, "\n"))
+		} else {
+			if len(doc) > 0 { doc = append(doc, "#") }
+			doc = append(doc, fmt.Sprintf("# %s = %s", v.name, v.exampleValue()))
+			optional = append(optional, strings.Join(doc, "\n"))
+		}
+	}
+
+	out := []string{fmt.Sprintf("[%s]", vars.name)}
+	if len(required) > 0 {
+		out = append(out, "#####################\n## Required Fields ##\n" +
+			"#####################")
+		out = append(out, required...)
+	}
+	if len(optional) > 0 {
+		out = append(out, "#####################\n## Optional Fields ##\n" +
+			"#####################")
+		out = append(out, optional...)
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// exampleValue returns the value of the variable shown by ExampleConfig.
+func (v *Var) exampleValue() string {
+	if v.example != "" { return v.example }
+
+	val := reflect.ValueOf(v.value)
+	if val.Kind() != reflect.Slice { return fmt.Sprint(v.value) }
+	strs := make([]string, val.Len())
+	for i := range strs { strs[i] = fmt.Sprint(val.Index(i).Interface()) }
+	return strings.Join(strs, ", ")
+}
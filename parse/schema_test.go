@@ -0,0 +1,141 @@
+package parse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type schemaConfig struct {
+	window, bins int64
+	frac         float64
+	fracs        []float64
+	action, file string
+	types        []string
+}
+
+func makeSchemaConfig() (*schemaConfig, *ConfigVars) {
+	config := &schemaConfig{}
+	vars := NewConfigVars("schema.config")
+	vars.Int(&config.window, "Window", 5).Min(5).Odd().
+		Doc("Window is the width of the smoothing window.")
+	vars.Int(&config.bins, "Bins", 0).Required().Example("100")
+	vars.Float(&config.frac, "Frac", 1).Positive().Max(1)
+	vars.Floats(&config.fracs, "Fracs", []float64{}).Min(0)
+	vars.String(&config.action, "Action", "print").Enum("print", "rerun")
+	vars.String(&config.file, "File", "").RequiredIf("Action", "rerun")
+	vars.Strings(&config.types, "Types", []string{"dark"}).
+		Enum("dark", "star", "gas")
+	return config, vars
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		flags []string
+		err   string
+	}{
+		{[]string{"--Bins", "10"}, ""},
+		{[]string{"--Bins", "10", "--Window", "7", "--Frac", "0.5",
+			"--Fracs", "0,1", "--Action", "rerun", "--File", "a.txt",
+			"--Types", "gas,star"}, ""},
+		{[]string{"--Window", "7"}, "'Bins' isn't set"},
+		{[]string{"--Bins", "10", "--Window", "3"}, "at least 5"},
+		{[]string{"--Bins", "10", "--Window", "6"}, "must be odd"},
+		{[]string{"--Bins", "10", "--Frac", "0"}, "must be positive"},
+		{[]string{"--Bins", "10", "--Frac", "1.5"}, "at most 1"},
+		{[]string{"--Bins", "10", "--Fracs", "1,-1"}, "Item 1"},
+		{[]string{"--Bins", "10", "--Action", "run"}, "print or rerun"},
+		{[]string{"--Bins", "10", "--Action", "rerun"},
+			"'File' isn't set, but it must be set when 'Action' = rerun"},
+		{[]string{"--Bins", "10", "--Types", "dark,dust"},
+			"'dust' by the flag --Types, but it must be one of dark, star, " +
+				"or gas"},
+	}
+
+	for i := range tests {
+		_, vars := makeSchemaConfig()
+		if err := ReadFlags(tests[i].flags, vars); err != nil {
+			t.Fatalf("%d) Could not read flags: %s", i, err.Error())
+		}
+
+		err := vars.Validate()
+		switch {
+		case tests[i].err == "" && err != nil:
+			t.Errorf("%d) Expected no error, got '%s'.", i, err.Error())
+		case tests[i].err != "" && err == nil:
+			t.Errorf("%d) Expected an error containing '%s', got nil.",
+				i, tests[i].err)
+		case err != nil && !strings.Contains(err.Error(), tests[i].err):
+			t.Errorf("%d) Expected an error containing '%s', got '%s'.",
+				i, tests[i].err, err.Error())
+		}
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	text := "[schema.config]\nBins = 10\n\n# Comment\nWindow = 4\n"
+	f := writeTempConfig(t, text)
+	defer os.Remove(f)
+
+	_, vars := makeSchemaConfig()
+	if err := ReadConfig(f, vars); err != nil { t.Fatal(err.Error()) }
+	err := vars.Validate()
+	exp := fmt.Sprintf("set to 4 on line 5 of %s, but it must be odd", f)
+	if err == nil || !strings.Contains(err.Error(), exp) {
+		t.Errorf("Expected an error containing '%s', got %v.", exp, err)
+	}
+
+	// Invalid defaults are caught unless they're placeholders for variables
+	// which are only required sometimes.
+	var width, frac float64
+	var action string
+	vars = NewConfigVars("schema.config")
+	vars.String(&action, "Action", "print")
+	vars.Float(&width, "Width", -1).Positive().RequiredIf("Action", "rerun")
+	if err = vars.Validate(); err != nil {
+		t.Errorf("Expected no error for a placeholder default, got '%s'.",
+			err.Error())
+	}
+	vars.Float(&frac, "Frac", 2).Max(1)
+	err = vars.Validate()
+	exp = "'Frac' has the default value 2, but it must be at most 1"
+	if err == nil || !strings.Contains(err.Error(), exp) {
+		t.Errorf("Expected an error containing '%s', got %v.", exp, err)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	_, vars := makeSchemaConfig()
+	text := vars.ExampleConfig()
+
+	for _, line := range []string{
+		"[schema.config]", "Bins = 100", "# Window is the width of the " +
+			"smoothing window.", "# Window = 5", "# Types = dark",
+		"# Only required if Action = rerun.",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected example config to contain '%s', got:\n%s",
+				line, text)
+		}
+	}
+
+	f := writeTempConfig(t, text)
+	defer os.Remove(f)
+	config, vars := makeSchemaConfig()
+	if err := ReadConfig(f, vars); err != nil {
+		t.Fatalf("Could not read example config: %s", err.Error())
+	}
+	if config.bins != 100 {
+		t.Errorf("Expected Bins = 100, got %d.", config.bins)
+	}
+}
+
+func writeTempConfig(t *testing.T, text string) string {
+	f, err := ioutil.TempFile("", "shellfish_schema_test")
+	if err != nil { t.Fatal(err.Error()) }
+	defer f.Close()
+	if _, err = f.Write([]byte(text)); err != nil { t.Fatal(err.Error()) }
+	return f.Name()
+}
@@ -10,8 +10,10 @@ import (
 	"os"
 	"path"
 	"bytes"
+	"strings"
 
 	"github.com/phil-mansfield/shellfish/cmd"
+	"github.com/phil-mansfield/shellfish/cmd/catalog"
 	"github.com/phil-mansfield/shellfish/cmd/env"
 	"github.com/phil-mansfield/shellfish/version"
 	"github.com/phil-mansfield/shellfish/logging"
@@ -30,6 +32,33 @@ For a documented example of an check config file, type:
      shellfish help check.config
 
 The check tool takes no input from stdin.`,
+// cache mode
+	"cache": `Type "shellfish help" for basic information on invoking the cache tool.
+
+The cache tool manages the files that Shellfish caches in MemoDir. It can list
+them, verify that they're still valid for the current config file and
+snapshot and halo files, delete them, and build them ahead of time for a range
+of snapshots so that the first job which needs them doesn't have to.
+
+For a documented example of a cache config file, type:
+
+     shellfish help cache.config
+
+The cache tool takes no input from stdin.`,
+// provenance mode
+	"provenance": `Type "shellfish help" for basic information on invoking the provenance tool.
+
+Every catalog written by Shellfish records its provenance: the command, config
+files, and Shellfish version that made it, along with the snapshots and random
+seed it used and a hash of the catalog it read from stdin. The provenance tool
+prints this record, or remakes the catalog by rerunning the same mode with the
+same variables and random seed.
+
+For a documented example of a provenance config file, type:
+
+     shellfish help provenance.config
+
+The provenance tool takes a catalog written by any other tool as input.`,
 // id mode
 	"id":    `Type "shellfish help" for basic information on invoking the id tool.
 
@@ -246,6 +275,8 @@ Column 9 to 11 - A: The x, y, and z components of the major axis of the
 	"phase.config": cmd.ModeNames["phase"].ExampleConfig(),
 	"potential.config": cmd.ModeNames["potential"].ExampleConfig(),
 	"check.config": cmd.ModeNames["check"].ExampleConfig(),
+	"cache.config": cmd.ModeNames["cache"].ExampleConfig(),
+	"provenance.config": cmd.ModeNames["provenance"].ExampleConfig(),
 }
 
 var modeDescriptions = `The best way to learn how to use shellfish is the tutorial on its github page:
@@ -264,9 +295,27 @@ The different tools in the Shellfish toolchain are:
     shellfish stats     [____.stats.config]     [flags]
     shellfish phase     [____.stats.config]     [flags]
     shellfish potential [____.potential.config] [flags]
+    shellfish cache     [____.cache.config]     [flags]
+    shellfish provenance [____.provenance.config] [flags]
 
 (Arguments in brackets are optional.)
 
+You can check config files without running a tool by typing
+
+    shellfish config check my.config my.shell.config ...
+
+This checks the global config file and every tool's config file in the given
+files and reports the first invalid variable in each one.
+
+You can generate a global config file for your simulation by typing
+
+    shellfish init path/to/snapshots [path/to/halos] > my.config
+
+This finds the format of your particle files, their endianness, and the ranges
+of snapshots and blocks from the snapshot directory and reads the halo catalog
+columns from the headers of the catalogs in the halo directory. Anything it
+had to guess is printed to stderr.
+
 Each tool takes the name of a tool-specific config file. Without them, a
 default set of variables will be used. You can also specify config variables
 through command line flags of the form
@@ -276,11 +325,17 @@ through command line flags of the form
 If you supply both a config file and flags and the two give different values to
 the same variable, the command line value will be used.
 
+Every tool also reads the global config file named by $SHELLFISH_GLOBAL_CONFIG.
+You can use a different global config file with the --global-config flag and
+override its variables with flags that start with --global., e.g.
+
+    shellfish shell my.shell.config --global-config sim2.config --global.Threads 8
+
 For documented example config files, type any of:
 
     shellfish help [ check.config | id.config | prof.config |shell.config |
                      stats.config | tree.config | phase.config |
-                     potenial.config ]
+                     potenial.config | cache.config | provenance.config ]
 
 In addition to any arguments passed at the command line, before calling
 Shellfish rountines you will need to specify a "global" config file (it
@@ -300,7 +355,7 @@ For more information on the input and output that a given tool expects, type
 any of:
 
     shellfish help [ check | id | tree | coord | prof | shell | stats | phase |
-                     potential ]`
+                     potential | cache ]`
 
 func main() {
 	args := os.Args
@@ -335,6 +390,39 @@ func main() {
 	case "hello":
 		fmt.Printf("Hello back at you! Installation was successful.\n")
 		os.Exit(0)
+	case "config":
+		if len(args) < 4 || args[2] != "check" {
+			fmt.Fprintf(os.Stderr, "The config command is used as "+
+				"'shellfish config check <files>'.\n")
+			os.Exit(1)
+		}
+		out, err := cmd.CheckConfigFiles(args[3:])
+		for i := range out {
+			fmt.Println(out[i])
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "init":
+		if len(args) < 3 || len(args) > 4 {
+			fmt.Fprintf(os.Stderr, "The init command is used as "+
+				"'shellfish init <snapshot dir> [halo dir]'.\n")
+			os.Exit(1)
+		}
+		haloDir := ""
+		if len(args) == 4 { haloDir = args[3] }
+		text, notes, err := cmd.InitConfig(args[2], haloDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(text)
+		for i := range notes {
+			fmt.Fprintln(os.Stderr, "Note:", notes[i])
+		}
+		os.Exit(0)
 	}
 
 	mode, ok := cmd.ModeNames[args[1]]
@@ -350,7 +438,8 @@ func main() {
 
 	var stdinData []byte
 	switch args[1] {
-	case "tree", "coord", "prof", "shell", "stats", "phase", "potential":
+	case "tree", "coord", "prof", "shell", "stats", "phase", "potential",
+		"provenance":
 		var err error
 		stdinData, err = ioutil.ReadAll(os.Stdin)
 		if err != nil {
@@ -372,9 +461,14 @@ func main() {
 		}
 	}
 	
-	flags := getFlags(args[2:])
+	flags, gFlags, gConfigName, err := splitGlobalFlags(getFlags(args[2:]))
+	if err != nil {
+		log.Printf("Error running mode %s:\n%s\n", args[1], err.Error())
+		fmt.Println("Shellfish terminating.")
+		os.Exit(1)
+	}
 	config, ok := getConfig(args[2:])
-	gConfigName, gConfig, err := getGlobalConfig(args[:2])
+	gConfigName, gConfig, err := getGlobalConfig(gConfigName, gFlags)
 	if err != nil {
 		log.Printf("Error running mode %s:\n%s\n", args[1], err.Error())
 		fmt.Println("Shellfish terminating.")
@@ -395,7 +489,7 @@ func main() {
 		}
 	}
 
-	if err = checkMemoDir(gConfig.MemoDir, gConfigName); err != nil {
+	if err = checkMemoDir(gConfig.MemoDir, gConfigName, gFlags); err != nil {
 		log.Printf("Error running mode %s:\n%s\n", args[1], err.Error())
 		fmt.Println("Shellfish terminating.")
 		os.Exit(1)
@@ -410,7 +504,11 @@ func main() {
 		}
 	}
 
-	e := &env.Environment{MemoDir: gConfig.MemoDir}
+	snapKey, haloKey := gConfig.CacheKeys()
+	e := &env.Environment{
+		MemoDir: gConfig.MemoDir,
+		SnapshotCacheKey: snapKey, HaloCacheKey: haloKey,
+	}
 	err = initCatalogs(gConfig, e)
 	if err != nil {
 		log.Printf("Error running mode %s:\n%s\n", args[1], err.Error())
@@ -437,6 +535,8 @@ func main() {
 		os.Exit(1)
 	}
 	
+	gConfig.RecordProvenance(gConfigName, args[1:], config, stdinData, e)
+
 	out, err := mode.Run(gConfig, e, stdinData)
 	if err != nil {
 		log.Printf("Error running mode %s:\n%s\n", args[1], err.Error())
@@ -444,6 +544,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(out) == 1 && catalog.IsBinary([]byte(out[0])) {
+		os.Stdout.Write([]byte(out[0]))
+		return
+	}
+
 	for i := range out {
 		fmt.Println(out[i])
 	}
@@ -458,16 +563,64 @@ func getFlags(args []string) []string {
 	}
 }
 
-// getGlobalConfig returns the name of the base config file from the command
-// line arguments.
-func getGlobalConfig(args []string) (string, *cmd.GlobalConfig, error) {
-	name := os.Getenv("SHELLFISH_GLOBAL_CONFIG")
+// globalFlagPrefix starts flags which set global config variables, e.g.
+// --global.Threads 4.
+const globalFlagPrefix = "--global."
+
+// splitGlobalFlags splits the flag tokens from the command line arguments into
+// flags for the mode and flags for the global config file. The prefix of
+// global flags is removed, so both can be passed to parse.ReadFlags. The value
+// of the --global-config flag, if any, is also returned.
+func splitGlobalFlags(args []string) (
+	flags, gFlags []string, gConfigName string, err error,
+) {
+	flags, gFlags = []string{}, []string{}
+	isGlobal := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--global-config":
+			if i+1 == len(args) || strings.HasPrefix(args[i+1], "--") {
+				return nil, nil, "", fmt.Errorf("The flag '--global-config' " +
+					"was supplied, but wasn't set to a file.")
+			} else if gConfigName != "" {
+				return nil, nil, "", fmt.Errorf("The flag '--global-config' " +
+					"was supplied twice.")
+			}
+			gConfigName = args[i+1]
+			i++
+			continue
+		case strings.HasPrefix(args[i], globalFlagPrefix):
+			isGlobal = true
+			gFlags = append(gFlags,
+				"--" + strings.TrimPrefix(args[i], globalFlagPrefix))
+			continue
+		case strings.HasPrefix(args[i], "--"):
+			isGlobal = false
+		}
+
+		if isGlobal {
+			gFlags = append(gFlags, args[i])
+		} else {
+			flags = append(flags, args[i])
+		}
+	}
+
+	return flags, gFlags, gConfigName, nil
+}
+
+// getGlobalConfig reads the global config file and applies any flags to it.
+// If name is "", the file named by $SHELLFISH_GLOBAL_CONFIG is used.
+func getGlobalConfig(
+	name string, flags []string,
+) (string, *cmd.GlobalConfig, error) {
+	if name == "" { name = os.Getenv("SHELLFISH_GLOBAL_CONFIG") }
 	if name == "" {
-		return "", nil, fmt.Errorf("$SHELLFISH_GLOBAL_CONFIG has not been set.")
+		return "", nil, fmt.Errorf("No global config file was given. Either " +
+			"set $SHELLFISH_GLOBAL_CONFIG or use the --global-config flag.")
 	}
 	
 	config := &cmd.GlobalConfig{}
-	err := config.ReadConfig(name, []string{})
+	err := config.ReadConfig(name, flags)
 	if err != nil {
 		return "", nil, err
 	}
@@ -484,40 +637,24 @@ func getConfig(args []string) (string, bool) {
 	return args[0], true
 }
 
-// cehckMemoDir checks whether the given MemoDir corresponds to a GlobalConfig
-// file with the exact same variables. If not, a non-nil error is returned.
-// If the MemoDir does not have an associated GlobalConfig file, the current
-// one will be copied in.
-func checkMemoDir(memoDir, configFile string) error {
-	memoConfigFile := path.Join(memoDir, "memo.config")
-
-	if _, err := os.Stat(memoConfigFile); err != nil {
-		// File doesn't exist, directory is clean.
-		err = copyFile(memoConfigFile, configFile)
-		return err
-	}
-
-	config, memoConfig := &cmd.GlobalConfig{}, &cmd.GlobalConfig{}
-	if err := config.ReadConfig(configFile, []string{}); err != nil {
-		return err
-	}
-	if err := memoConfig.ReadConfig(memoConfigFile, []string{}); err != nil {
-		return err
-	}
-
-	if !configEqual(config, memoConfig) {
-		return fmt.Errorf(`You've changed the variables in the config file %s in a way that would invlalidate the files Shellfish cached in %s (i.e. MemoDir) to speed up performance. Maybe you wanted this (e.g. there was a mistake in the old config file), but maybe you didn't.
-
-If you wanted to make the change and you're SURE there's nothing that you care about in MemoDir, type the command
-    $ rm -r %s/*
-and rerun shellfish. (Shellfish could do this for you automatically, but I don't want to accidentally delete something you care about.)
-
-If you want to check what the change is, or if you lost the old config file and want it back, you can find a copy in %s
-
-If you accidentally wrote down the wrong path in the MemoDir variable in %s, you should change it.
-` , configFile, memoDir, memoDir, memoConfigFile, configFile)
-	}
-	return nil
+// checkMemoDir copies the current GlobalConfig file into MemoDir so that
+// users can see which variables the cached files were last built with. Any
+// global flags are appended as comments. Files in MemoDir which were built
+// from different variables are detected and rebuilt by the cache package,
+// which only compares the variables that change cached files, so there's no
+// need to compare the two.
+func checkMemoDir(memoDir, configFile string, flags []string) error {
+	if _, err := os.Stat(memoDir); err != nil { return err }
+	memoFile := path.Join(memoDir, "memo.config")
+	if err := copyFile(memoFile, configFile); err != nil { return err }
+	if len(flags) == 0 { return nil }
+
+	f, err := os.OpenFile(memoFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil { return err }
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "\n# Overridden from the command line with:\n# %s\n",
+		strings.Join(flags, " "))
+	return err
 }
 
 // copyFile copies a file from src to dst.
@@ -540,60 +677,16 @@ func copyFile(dst, src string) error {
 	return dstFile.Sync()
 }
 
-func configEqual(m, c *cmd.GlobalConfig) bool {
-	// Well, equal up to the variables that actually matter.
-	// (i.e. changing something like Threads shouldn't flush the memoization
-	// buffer. Otherwise, I'd just use reflection.)
-	return c.Version == m.Version &&
-		c.SnapshotFormat == m.SnapshotFormat &&
-		c.SnapshotType == m.SnapshotType &&
-		c.HaloDir == m.HaloDir &&
-		c.HaloType == m.HaloType &&
-		c.TreeDir == m.TreeDir &&
-		int64sEqual(c.BlockMins, m.BlockMins) &&
-		int64sEqual(c.BlockMaxes, m.BlockMaxes) &&
-		c.SnapMin == m.SnapMin &&
-		c.SnapMax == m.SnapMax &&
-		stringsEqual(c.SnapshotFormatMeanings, m.SnapshotFormatMeanings) &&
-		stringsEqual(c.HaloValueNames, m.HaloValueNames) &&
-		int64sEqual(c.HaloValueColumns, m.HaloValueColumns) &&
-		c.HaloPositionUnits == m.HaloPositionUnits &&
-		c.HaloMassUnits == m.HaloMassUnits &&
-		int64sEqual(c.HaloValueColumns, m.HaloValueColumns) &&
-		stringsEqual(c.HaloValueNames, m.HaloValueNames) &&
-		c.Endianness == m.Endianness
-}
-
-func int64sEqual(xs, ys []int64) bool {
-	if len(xs) != len(ys) {
-		return false
-	}
-	for i := range xs {
-		if xs[i] != ys[i] {
-			return false
-		}
-	}
-	return true
-}
-
-func stringsEqual(xs, ys []string) bool {
-	if len(xs) != len(ys) {
-		return false
-	}
-	for i := range xs {
-		if xs[i] != ys[i] {
-			return false
-		}
-	}
-	return true
-}
-
 func initHalos(
 	mode string, gConfig *cmd.GlobalConfig, e *env.Environment,
 ) error {
 	switch mode {
-	case "shell", "stats", "prof", "check", "phase", "potential":
+	case "shell", "stats", "prof", "check", "phase", "potential",
+		"provenance":
 		return nil
+	case "cache":
+		// Halo catalogs are only needed to build rockstar files.
+		if gConfig.HaloType == "nil" { return nil }
 	}
 
 	switch gConfig.HaloType {
@@ -629,6 +722,14 @@ func initCatalogs(gConfig *cmd.GlobalConfig, e *env.Environment) error {
 		return e.InitBolshoi(&gConfig.ParticleInfo, gConfig.ValidateFormats)
 	case "BolshoiP":
 		return e.InitBolshoiP(&gConfig.ParticleInfo, gConfig.ValidateFormats)
+	case "RAMSES":
+		return e.InitRAMSES(&gConfig.ParticleInfo, gConfig.ValidateFormats)
+	case "TIPSY":
+		return e.InitTipsy(&gConfig.ParticleInfo, gConfig.ValidateFormats)
+	case "raw":
+		return e.InitRaw(&gConfig.ParticleInfo, gConfig.ValidateFormats)
+	case "NumPy":
+		return e.InitNumPy(&gConfig.ParticleInfo, gConfig.ValidateFormats)
 	case "nil":
 		return e.InitNil(&gConfig.ParticleInfo, gConfig.ValidateFormats)
 	}
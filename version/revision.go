@@ -0,0 +1,17 @@
+package version
+
+// GitRevision is the git revision that Shellfish was built from. It can be
+// set at build time with
+//
+//     go build -ldflags "-X github.com/phil-mansfield/shellfish/version.GitRevision=$(git rev-parse HEAD)"
+//
+// If it isn't set, the revision recorded by the Go toolchain is used instead.
+var GitRevision = ""
+
+// Revision returns the git revision that Shellfish was built from, or "" if
+// it isn't known. A "-dirty" suffix means that the source had uncommitted
+// changes.
+func Revision() string {
+	if GitRevision != "" { return GitRevision }
+	return buildRevision()
+}
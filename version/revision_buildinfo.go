@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package version
+
+import (
+	"runtime/debug"
+)
+
+// buildRevision returns the VCS revision that the Go toolchain embedded in
+// the binary.
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok { return "" }
+
+	revision, dirty := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision": revision = setting.Value
+		case "vcs.modified": dirty = setting.Value == "true"
+		}
+	}
+	if revision != "" && dirty { revision += "-dirty" }
+	return revision
+}
@@ -0,0 +1,10 @@
+//go:build !go1.18
+// +build !go1.18
+
+package version
+
+// buildRevision returns "", since Go toolchains older than 1.18 don't embed
+// VCS revisions in binaries.
+func buildRevision() string {
+	return ""
+}